@@ -5,19 +5,25 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ardanlabs/conf/v3"
 	"github.com/rschio/rinha/internal/core/client"
 	"github.com/rschio/rinha/internal/core/client/store/clientdb"
+	"github.com/rschio/rinha/internal/core/client/store/eventstore"
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
 	db "github.com/rschio/rinha/internal/data/dbsql/pgx"
 	"github.com/rschio/rinha/internal/handlers"
 	"github.com/rschio/rinha/internal/logger"
+	"github.com/rschio/rinha/internal/publisher"
 	"github.com/rschio/rinha/internal/trace"
+	"github.com/rschio/rinha/internal/worker"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 )
@@ -27,6 +33,14 @@ var build = "develop"
 func main() {
 	log := logger.New("Rinha")
 
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := reconcile(log, os.Args[2:]); err != nil {
+			log.Error("reconcile", "ERROR", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(log); err != nil {
 		log.Error("startup", "ERROR", err)
 		os.Exit(1)
@@ -41,10 +55,12 @@ func run(log *slog.Logger) error {
 
 	cfg := struct {
 		conf.Version
-		Env string `conf:"default:DEV"`
-		Web struct {
+		Env   string `conf:"default:DEV"`
+		Store string `conf:"default:postgres,help:postgres, events or memory"`
+		Web   struct {
 			Port            int           `conf:"default:8080"`
 			ShutdownTimeout time.Duration `conf:"default:20s"`
+			AdminToken      string        `conf:"mask,help:bearer token of the /admin routes, empty disables them"`
 		}
 		DB struct {
 			User       string `conf:"default:postgres"`
@@ -53,6 +69,60 @@ func run(log *slog.Logger) error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:true"`
 		}
+		Idempotency struct {
+			TTL           time.Duration `conf:"default:24h"`
+			PurgeInterval time.Duration `conf:"default:1m"`
+		}
+		Holds struct {
+			TTL            time.Duration `conf:"default:168h"`
+			ExpireInterval time.Duration `conf:"default:1m"`
+		}
+		Scheduler struct {
+			Interval time.Duration `conf:"default:10s"`
+		}
+		Interest struct {
+			DailyRate float64       `conf:"default:0,help:daily overdraft interest rate where 0.001 is 0.1%"`
+			Interval  time.Duration `conf:"default:1h"`
+		}
+		Invoices struct {
+			DueDays       int           `conf:"default:10"`
+			CloseInterval time.Duration `conf:"default:1h"`
+		}
+		Rules struct {
+			File string `conf:"help:JSON file with the transaction approval rules"`
+		}
+		Events struct {
+			SnapshotInterval time.Duration `conf:"default:10m"`
+		}
+		Outbox struct {
+			Publisher     string        `conf:"help:log, file or http, empty disables the outbox"`
+			File          string        `conf:"default:outbox.jsonl"`
+			URL           string        `conf:"help:endpoint of the http publisher"`
+			MaxAttempts   int           `conf:"default:10"`
+			Interval      time.Duration `conf:"default:1s"`
+			Retention     time.Duration `conf:"default:168h"`
+			PurgeInterval time.Duration `conf:"default:1h"`
+		}
+		Updates struct {
+			Poll          time.Duration `conf:"default:15s"`
+			Retention     time.Duration `conf:"default:168h"`
+			PurgeInterval time.Duration `conf:"default:1h"`
+		}
+		Ledger struct {
+			Interval time.Duration `conf:"default:1s"`
+		}
+		Accounts struct {
+			FrozenCredits bool `conf:"default:true,help:frozen accounts receive credits"`
+		}
+		Webhooks struct {
+			Timeout       time.Duration `conf:"default:10s"`
+			MaxAttempts   int           `conf:"default:8"`
+			Backoff       time.Duration `conf:"default:10s"`
+			Interval      time.Duration `conf:"default:1s"`
+			Retention     time.Duration `conf:"default:168h"`
+			PurgeInterval time.Duration `conf:"default:1h"`
+			AllowPrivate  bool          `conf:"default:false,help:webhooks can notify private hosts"`
+		}
 		OTEL struct {
 			Endpoint            string  `conf:"default:otel-collector:4317"`
 			ServiceName         string  `conf:"default:Rinha"`
@@ -107,30 +177,94 @@ func run(log *slog.Logger) error {
 	tracer := otel.GetTracerProvider().Tracer("service")
 
 	// =========================================================================
-	// Database Support
+	// Store Support
 
-	log.Info("startup", "status", "initializing database support", "host", cfg.DB.Host)
+	// The elector chooses the instance that runs the jobs which must run
+	// only once when many instances share the database. The listener
+	// notifies the updates committed by all of them.
+	var (
+		store      client.Store
+		elector    worker.Elector
+		listener   client.Listener
+		events     *eventstore.Store
+		eventsLock *db.AdvisoryLock
+	)
+	switch cfg.Store {
+	case "memory":
+		log.Info("startup", "status", "initializing in-memory store")
+		mem := memstore.NewStore(memstore.DefaultClients()...)
+		store = mem
+		elector = worker.Always{}
+		listener = mem
 
-	dbCfg := db.Config{
-		User:       cfg.DB.User,
-		Password:   cfg.DB.Password,
-		Host:       cfg.DB.Host,
-		Name:       cfg.DB.Name,
-		DisableTLS: cfg.DB.DisableTLS,
-	}
-	database, err := db.Open(ctx, dbCfg)
-	if err != nil {
-		return fmt.Errorf("connecting to db: %w", err)
-	}
-	defer func() {
-		log.Info("shutdown", "status", "stopping database support", "host", cfg.DB.Host)
-		database.Close()
-	}()
+	case "postgres", "events":
+		log.Info("startup", "status", "initializing database support", "host", cfg.DB.Host)
+
+		dbCfg := db.Config{
+			User:       cfg.DB.User,
+			Password:   cfg.DB.Password,
+			Host:       cfg.DB.Host,
+			Name:       cfg.DB.Name,
+			DisableTLS: cfg.DB.DisableTLS,
+		}
+		database, err := db.Open(ctx, dbCfg)
+		if err != nil {
+			return fmt.Errorf("connecting to db: %w", err)
+		}
+		defer func() {
+			log.Info("shutdown", "status", "stopping database support", "host", cfg.DB.Host)
+			database.Close()
+		}()
+
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := db.StatusCheck(ctxWithTimeout, database); err != nil {
+			return fmt.Errorf("database not health: %w", err)
+		}
+
+		if cfg.Store == "events" {
+			// The projections are held by this instance, it must be the only
+			// one appending to the log.
+			eventsLock = db.NewAdvisoryLock(database, "rinha-events")
+			ok, err := eventsLock.IsLeader(ctx)
+			if err != nil {
+				return fmt.Errorf("locking event store: %w", err)
+			}
+			if !ok {
+				return errors.New("event store is used by another instance")
+			}
+			defer func() {
+				if err := eventsLock.Release(context.Background()); err != nil {
+					log.Error("shutdown", "status", "releasing event store lock", "ERROR", err)
+				}
+			}()
+
+			log.Info("startup", "status", "replaying events")
+
+			events, err = eventstore.NewStore(ctx, eventstore.NewDBLog(log, database), memstore.DefaultClients()...)
+			if err != nil {
+				return fmt.Errorf("initializing event store: %w", err)
+			}
+
+			store = events
+			elector = worker.Always{}
+			listener = events
+			break
+		}
+
+		store = clientdb.NewStore(log, database)
+		listener = clientdb.NewListener(log, database)
+
+		lock := db.NewAdvisoryLock(database, "rinha-scheduler")
+		defer func() {
+			if err := lock.Release(context.Background()); err != nil {
+				log.Error("shutdown", "status", "releasing scheduler lock", "ERROR", err)
+			}
+		}()
+		elector = lock
 
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := db.StatusCheck(ctxWithTimeout, database); err != nil {
-		return fmt.Errorf("database not health: %w", err)
+	default:
+		return fmt.Errorf("unknown store %q", cfg.Store)
 	}
 
 	// =========================================================================
@@ -141,15 +275,317 @@ func run(log *slog.Logger) error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
-	core := client.NewCore(clientdb.NewStore(log, database))
+	rules := client.DefaultRules()
+	if cfg.Rules.File != "" {
+		data, err := os.ReadFile(cfg.Rules.File)
+		if err != nil {
+			return fmt.Errorf("reading rules file: %w", err)
+		}
+		if rules, err = client.ParseRules(data); err != nil {
+			return fmt.Errorf("parsing rules file: %w", err)
+		}
+		log.Info("startup", "status", "rules loaded", "file", cfg.Rules.File, "rules", len(rules))
+	}
+
+	var pub client.Publisher
+	switch cfg.Outbox.Publisher {
+	case "":
+	case "log":
+		pub = publisher.NewLog(log)
+	case "file":
+		f, err := publisher.NewFile(cfg.Outbox.File)
+		if err != nil {
+			return fmt.Errorf("opening outbox file: %w", err)
+		}
+		defer f.Close()
+		pub = f
+	case "http":
+		if cfg.Outbox.URL == "" {
+			return errors.New("outbox url is required by the http publisher")
+		}
+		pub = publisher.NewHTTP(cfg.Outbox.URL, nil)
+	default:
+		return fmt.Errorf("unknown outbox publisher %q", cfg.Outbox.Publisher)
+	}
+
+	// The default webhook client refuses private hosts.
+	webhookClient := client.NewWebhookClient(cfg.Webhooks.Timeout)
+	if cfg.Webhooks.AllowPrivate {
+		webhookClient = &http.Client{Timeout: cfg.Webhooks.Timeout}
+	}
+
+	core := client.NewCore(store,
+		client.WithIdempotencyTTL(cfg.Idempotency.TTL),
+		client.WithHoldTTL(cfg.Holds.TTL),
+		client.WithInterestRate(int(math.Round(cfg.Interest.DailyRate*client.RateScale))),
+		client.WithInvoiceDueDays(cfg.Invoices.DueDays),
+		client.WithRules(rules...),
+		client.WithPublisher(pub),
+		client.WithOutboxMaxAttempts(cfg.Outbox.MaxAttempts),
+		client.WithOutboxRetention(cfg.Outbox.Retention),
+		client.WithWebhookClient(webhookClient),
+		client.WithWebhookRetry(cfg.Webhooks.MaxAttempts, cfg.Webhooks.Backoff),
+		client.WithWebhookRetention(cfg.Webhooks.Retention),
+		client.WithPrivateWebhooks(cfg.Webhooks.AllowPrivate),
+		client.WithListener(listener),
+		client.WithUpdatesPoll(cfg.Updates.Poll),
+		client.WithUpdatesRetention(cfg.Updates.Retention),
+		client.WithFrozenCredits(cfg.Accounts.FrozenCredits),
+	)
 	srv := handlers.NewServer(log, core)
-	mux := handlers.APIMux(srv, tracer)
+	mux := handlers.APIMux(srv, tracer, cfg.Web.AdminToken)
+
+	// =========================================================================
+	// Start Workers
+
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	var workers sync.WaitGroup
+	defer func() {
+		log.Info("shutdown", "status", "stopping workers")
+		stopWorkers()
+		workers.Wait()
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "idempotency-purge", cfg.Idempotency.PurgeInterval,
+			func(ctx context.Context) error {
+				n, err := core.PurgeIdempotencyKeys(ctx)
+				if err != nil {
+					return fmt.Errorf("purging idempotency keys: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "idempotency-purge", "deleted", n)
+				}
+				return nil
+			},
+		)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "hold-expiry", cfg.Holds.ExpireInterval,
+			func(ctx context.Context) error {
+				n, err := core.ExpireHolds(ctx)
+				if err != nil {
+					return fmt.Errorf("expiring holds: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "hold-expiry", "expired", n)
+				}
+				return nil
+			},
+		)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "scheduled-transactions", cfg.Scheduler.Interval,
+			worker.Leader(elector, func(ctx context.Context) error {
+				n, err := core.ExecuteScheduled(ctx)
+				if err != nil {
+					return fmt.Errorf("executing scheduled transactions: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "scheduled-transactions", "executed", n)
+				}
+				return nil
+			}),
+		)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "interest-accrual", cfg.Interest.Interval,
+			worker.Leader(elector, func(ctx context.Context) error {
+				n, err := core.AccrueInterest(ctx)
+				if err != nil {
+					return fmt.Errorf("accruing interest: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "interest-accrual", "charged", n)
+				}
+				return nil
+			}),
+		)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "invoice-closing", cfg.Invoices.CloseInterval,
+			worker.Leader(elector, func(ctx context.Context) error {
+				n, err := core.CloseInvoices(ctx)
+				if err != nil {
+					return fmt.Errorf("closing invoices: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "invoice-closing", "closed", n)
+				}
+				return nil
+			}),
+		)
+	}()
+
+	if pub != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker.Run(workerCtx, log, "outbox-dispatcher", cfg.Outbox.Interval,
+				worker.Leader(elector, func(ctx context.Context) error {
+					n, err := core.DispatchOutbox(ctx)
+					if err != nil {
+						return fmt.Errorf("dispatching outbox: %w", err)
+					}
+					if n > 0 {
+						log.Info("worker", "name", "outbox-dispatcher", "published", n)
+					}
+					return nil
+				}),
+			)
+		}()
+	}
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "outbox-purge", cfg.Outbox.PurgeInterval,
+			func(ctx context.Context) error {
+				n, err := core.PurgeOutbox(ctx)
+				if err != nil {
+					return fmt.Errorf("purging outbox: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "outbox-purge", "deleted", n)
+				}
+				return nil
+			},
+		)
+	}()
+
+	// Every instance listens, its subscribers may be notified about updates
+	// committed by the others. The listener only returns if it fails.
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "updates-listener", time.Second, core.ListenUpdates)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "updates-purge", cfg.Updates.PurgeInterval,
+			func(ctx context.Context) error {
+				n, err := core.PurgeClientUpdates(ctx)
+				if err != nil {
+					return fmt.Errorf("purging client updates: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "updates-purge", "deleted", n)
+				}
+				return nil
+			},
+		)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "webhook-delivery", cfg.Webhooks.Interval,
+			worker.Leader(elector, func(ctx context.Context) error {
+				n, err := core.DeliverWebhooks(ctx)
+				if err != nil {
+					return fmt.Errorf("delivering webhooks: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "webhook-delivery", "delivered", n)
+				}
+				return nil
+			}),
+		)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "webhook-purge", cfg.Webhooks.PurgeInterval,
+			func(ctx context.Context) error {
+				n, err := core.PurgeWebhookDeliveries(ctx)
+				if err != nil {
+					return fmt.Errorf("purging webhook deliveries: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "webhook-purge", "deleted", n)
+				}
+				return nil
+			},
+		)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker.Run(workerCtx, log, "ledger-sequencer", cfg.Ledger.Interval,
+			worker.Leader(elector, func(ctx context.Context) error {
+				n, err := core.SequenceLedger(ctx)
+				if err != nil {
+					return fmt.Errorf("sequencing ledger: %w", err)
+				}
+				if n > 0 {
+					log.Info("worker", "name", "ledger-sequencer", "sequenced", n)
+				}
+				return nil
+			}),
+		)
+	}()
+
+	// Losing the lock lets another instance append to the log, so this one
+	// stops serving with projections that may become stale.
+	eventsLockErrors := make(chan error, 1)
+	if eventsLock != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker.Run(workerCtx, log, "event-store-lock", time.Second,
+				func(ctx context.Context) error {
+					if err := eventsLock.Check(ctx); err != nil {
+						select {
+						case eventsLockErrors <- err:
+						default:
+						}
+						return err
+					}
+					return nil
+				},
+			)
+		}()
+	}
+
+	if events != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker.Run(workerCtx, log, "event-snapshot", cfg.Events.SnapshotInterval,
+				func(ctx context.Context) error {
+					if err := events.Snapshot(ctx); err != nil {
+						return fmt.Errorf("taking snapshot: %w", err)
+					}
+					return nil
+				},
+			)
+		}()
+	}
 
 	api := http.Server{
 		Addr:     fmt.Sprintf(":%d", cfg.Web.Port),
 		Handler:  mux,
 		ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelInfo),
 	}
+	api.RegisterOnShutdown(srv.CloseStreams)
 
 	serverErrors := make(chan error, 1)
 	go func() {
@@ -164,6 +600,10 @@ func run(log *slog.Logger) error {
 	case err := <-serverErrors:
 		return fmt.Errorf("server error: %w", err)
 
+	case err := <-eventsLockErrors:
+		api.Close()
+		return fmt.Errorf("event store lock: %w", err)
+
 	case sig := <-shutdown:
 		log.Info("shutdown", "status", "shutdown started", "signal", sig)
 		defer log.Info("shutdown", "status", "shutdown complete", "signal", sig)
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/ardanlabs/conf/v3"
+	"github.com/rschio/rinha/internal/core/client"
+	"github.com/rschio/rinha/internal/core/client/store/clientdb"
+	db "github.com/rschio/rinha/internal/data/dbsql/pgx"
+)
+
+// errInconsistent is returned by reconcile when inconsistencies are found, so
+// the command exits with a non-zero code.
+var errInconsistent = errors.New("inconsistencies found")
+
+// reconcile compares the balance of each client with the sum of its
+// transactions and reports the drifts and the clients below the limit. With
+// --fix the drifted balances are repaired.
+//
+//	rinha reconcile [--fix]
+func reconcile(log *slog.Logger, args []string) error {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "repair the drifted balances")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	// The database is configured the same way the service is. The command
+	// line arguments are parsed above.
+	cfg := struct {
+		Args conf.Args
+		DB   struct {
+			User       string `conf:"default:postgres"`
+			Password   string `conf:"default:postgres,mask"`
+			Host       string `conf:"default:0.0.0.0:5432"`
+			Name       string `conf:"default:postgres"`
+			DisableTLS bool   `conf:"default:true"`
+		}
+	}{}
+
+	const prefix = "RINHA"
+	if _, err := conf.Parse(prefix, &cfg); err != nil {
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	dbCfg := db.Config{
+		User:       cfg.DB.User,
+		Password:   cfg.DB.Password,
+		Host:       cfg.DB.Host,
+		Name:       cfg.DB.Name,
+		DisableTLS: cfg.DB.DisableTLS,
+	}
+	database, err := db.Open(ctx, dbCfg)
+	if err != nil {
+		return fmt.Errorf("connecting to db: %w", err)
+	}
+	defer database.Close()
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := db.StatusCheck(ctxWithTimeout, database); err != nil {
+		return fmt.Errorf("database not health: %w", err)
+	}
+
+	core := client.NewCore(clientdb.NewStore(log, database))
+
+	rs, err := core.Reconcile(ctx, *fix)
+	if err != nil {
+		return fmt.Errorf("reconciling: %w", err)
+	}
+
+	printReconciliations(os.Stdout, rs)
+	if len(rs) > 0 {
+		return fmt.Errorf("%d clients: %w", len(rs), errInconsistent)
+	}
+
+	return nil
+}
+
+// printReconciliations writes a line for each inconsistency.
+func printReconciliations(w io.Writer, rs []client.Reconciliation) {
+	for _, r := range rs {
+		if d := r.Drift(); d != 0 {
+			status := "not fixed"
+			if r.Fixed {
+				status = "fixed"
+			}
+			fmt.Fprintf(w, "client[%d]: balance %d, transactions sum %d, drift %d, %s\n", r.ClientID, r.Balance, r.Computed, d, status)
+		}
+		if r.OverLimit() {
+			fmt.Fprintf(w, "client[%d]: balance %d below the limit %d\n", r.ClientID, r.Computed, -r.Limit)
+		}
+	}
+}
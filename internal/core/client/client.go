@@ -2,8 +2,13 @@ package client
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,6 +21,32 @@ var (
 	ErrInvalidArgument   = errors.New("client invalid argument")
 	ErrInternal          = errors.New("client internal error")
 	ErrTransactionDenied = errors.New("client transaction denied")
+	ErrAlreadyExists     = errors.New("client already exists")
+	ErrDocumentExists    = errors.New("client document already registered")
+	ErrLimitDenied       = errors.New("client limit change denied")
+
+	ErrAccountStatus    = errors.New("client account status does not allow the operation")
+	ErrStatusTransition = errors.New("client account status transition not allowed")
+
+	ErrTransactionNotFound = errors.New("client transaction not found")
+	ErrTransactionReversed = errors.New("client transaction already reversed")
+
+	ErrHoldNotFound = errors.New("client hold not found")
+	ErrHoldClosed   = errors.New("client hold is not active")
+
+	ErrFXRateNotFound = errors.New("client fx rate not found")
+
+	ErrAccrualNotFound = errors.New("client interest accrual not found")
+	ErrInvoiceNotFound = errors.New("client invoice not found")
+
+	ErrScheduledNotFound = errors.New("client scheduled transaction not found")
+	ErrScheduledClosed   = errors.New("client scheduled transaction is not pending")
+
+	ErrWebhookNotFound  = errors.New("client webhook not found")
+	ErrDeliveryNotFound = errors.New("client webhook delivery not found")
+
+	ErrIdempotencyKeyNotFound = errors.New("client idempotency key not found")
+	ErrIdempotencyConflict    = errors.New("client idempotency key reused with a different request")
 )
 
 // Store is used to persist client's data.
@@ -24,34 +55,419 @@ type Store interface {
 	// an error the transaction is rolled back and the error is returned.
 	ExecUnderTx(ctx context.Context, fn func(tx Store) error) error
 
+	// CreateClient creates a new client. It returns ErrAlreadyExists if a
+	// client with the same ID exists and ErrDocumentExists if a client with
+	// the same non-empty document exists.
+	CreateClient(ctx context.Context, c Client) error
+
 	// QueryByID returns information about a client.
 	QueryByID(ctx context.Context, clientID int) (Client, error)
 
-	// QueryTransactions returns the most recent client's transactions.
-	QueryTransactions(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]Transaction, error)
+	// QueryByDocument returns the client with the document.
+	QueryByDocument(ctx context.Context, document string) (Client, error)
+
+	// QueryClients returns the clients ordered by ID.
+	QueryClients(ctx context.Context, pageNumber, rowsPerPage int) ([]Client, error)
+
+	// QueryTransactions returns the most recent client's transactions that
+	// match the filter.
+	QueryTransactions(ctx context.Context, clientID int, filter TransactionFilter, pageNumber, rowsPerPage int) ([]Transaction, error)
+
+	// QueryTransactionsAfter returns up to limit client's transactions that
+	// match the filter and come after the cursor, the most recent first.
+	// The zero cursor starts from the most recent transaction.
+	QueryTransactionsAfter(ctx context.Context, clientID int, filter TransactionFilter, after Cursor, limit int) ([]Transaction, error)
+
+	// StreamTransactions calls fn for each client's transaction that match
+	// the filter, the oldest first. If fn returns an error the iteration
+	// stops and the error is returned.
+	StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) error
 
-	// AddTransaction add a transaction associated with a client.
+	// QueryTransactionByID returns a client's transaction and locks it until
+	// the end of the transaction.
+	QueryTransactionByID(ctx context.Context, clientID int, transactionID uuid.UUID) (Transaction, error)
+
+	// AddTransaction add a transaction associated with a client. The
+	// transaction is added to the ledger by AddLedgerEntries after it
+	// commits.
 	AddTransaction(ctx context.Context, t Transaction) error
 
+	// UpdateTransactionReversedBy marks a transaction as reversed by the
+	// reversalID transaction.
+	UpdateTransactionReversedBy(ctx context.Context, transactionID, reversalID uuid.UUID) error
+
 	UpdateClientBalance(ctx context.Context, clientID, balance int) (Client, error)
+
+	// UpdateClientReserved changes the amount reserved by the client's
+	// active holds.
+	UpdateClientReserved(ctx context.Context, clientID, reserved int) (Client, error)
+
+	// UpdateClientLimit changes the credit limit of a client.
+	UpdateClientLimit(ctx context.Context, clientID, limit int) (Client, error)
+
+	// AddLimitChange records a change of a client's credit limit.
+	AddLimitChange(ctx context.Context, lc LimitChange) error
+
+	// QueryLimitChanges returns the most recent changes of a client's
+	// credit limit.
+	QueryLimitChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]LimitChange, error)
+
+	// UpdateClientStatus changes the account status of a client.
+	UpdateClientStatus(ctx context.Context, clientID int, status string) (Client, error)
+
+	// AddStatusChange records a transition of a client's account status.
+	AddStatusChange(ctx context.Context, sc StatusChange) error
+
+	// QueryStatusChanges returns the most recent transitions of a client's
+	// account status.
+	QueryStatusChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]StatusChange, error)
+
+	// AddHold adds a hold associated with a client.
+	AddHold(ctx context.Context, h Hold) error
+
+	// QueryHoldByID returns a client's hold and locks it until the end of
+	// the transaction.
+	QueryHoldByID(ctx context.Context, clientID int, holdID uuid.UUID) (Hold, error)
+
+	// QueryExpiredHolds returns up to limit active holds that expired
+	// before the date.
+	QueryExpiredHolds(ctx context.Context, date time.Time, limit int) ([]Hold, error)
+
+	// UpdateHold updates the status, the captured value and the
+	// transaction of a hold.
+	UpdateHold(ctx context.Context, h Hold) error
+
+	// AddScheduled adds a scheduled transaction associated with a client.
+	AddScheduled(ctx context.Context, st ScheduledTransaction) error
+
+	// QueryScheduledByID returns a client's scheduled transaction and locks
+	// it until the end of the transaction.
+	QueryScheduledByID(ctx context.Context, clientID int, scheduledID uuid.UUID) (ScheduledTransaction, error)
+
+	// QueryScheduled returns the client's scheduled transactions, the most
+	// recently scheduled first.
+	QueryScheduled(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]ScheduledTransaction, error)
+
+	// QueryDueScheduled returns up to limit pending scheduled transactions
+	// of all clients scheduled up to the date, the oldest first.
+	QueryDueScheduled(ctx context.Context, date time.Time, limit int) ([]ScheduledTransaction, error)
+
+	// UpdateScheduled updates the status, the transaction and the reason of
+	// a scheduled transaction.
+	UpdateScheduled(ctx context.Context, st ScheduledTransaction) error
+
+	// AddFXRate records a rate to convert between two currencies.
+	AddFXRate(ctx context.Context, r FXRate) error
+
+	// QueryFXRate returns the most recent rate to convert from one currency
+	// to another.
+	QueryFXRate(ctx context.Context, from, to string) (FXRate, error)
+
+	// QueryFXRates returns the most recent rate of each pair of currencies
+	// ordered by the currencies.
+	QueryFXRates(ctx context.Context) ([]FXRate, error)
+
+	// QueryOverdrawnClients returns up to limit clients with a negative
+	// balance and an ID greater than after, ordered by ID.
+	QueryOverdrawnClients(ctx context.Context, after, limit int) ([]Client, error)
+
+	// AddInterestAccrual records the interest charged to a client on a day.
+	AddInterestAccrual(ctx context.Context, a InterestAccrual) error
+
+	// QueryInterestAccrual returns the interest charged to a client on the
+	// day.
+	QueryInterestAccrual(ctx context.Context, clientID int, day time.Time) (InterestAccrual, error)
+
+	// AddInvoice adds a closed invoice with its transactions.
+	AddInvoice(ctx context.Context, inv Invoice) error
+
+	// QueryLastInvoice returns the client's invoice with the most recent
+	// period.
+	QueryLastInvoice(ctx context.Context, clientID int) (Invoice, error)
+
+	// QueryInvoiceByID returns a client's invoice with its transactions.
+	QueryInvoiceByID(ctx context.Context, clientID int, invoiceID uuid.UUID) (Invoice, error)
+
+	// QueryInvoices returns the client's invoices, the most recent first,
+	// without their transactions.
+	QueryInvoices(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]Invoice, error)
+
+	// QueryBalanceAt returns the client's balance after the last transaction
+	// made up to the date. It returns zero if there is no such transaction.
+	QueryBalanceAt(ctx context.Context, clientID int, date time.Time) (int, error)
+
+	// QuerySpendingLimits returns the client's spending limits. Clients
+	// without limits have zero limits.
+	QuerySpendingLimits(ctx context.Context, clientID int) (SpendingLimits, error)
+
+	// SaveSpendingLimits saves the client's spending limits, replacing the
+	// existing ones.
+	SaveSpendingLimits(ctx context.Context, l SpendingLimits) error
+
+	// QueryDebitTotals returns the number and the total value of the
+	// client's debits made at or after the date.
+	QueryDebitTotals(ctx context.Context, clientID int, since time.Time) (DebitTotals, error)
+
+	// SumTransactions returns the sum of the client's transactions, with the
+	// debits negative.
+	SumTransactions(ctx context.Context, clientID int) (int, error)
+
+	// AddBalanceAdjustment records a change of a client's balance made by
+	// the reconciliation.
+	AddBalanceAdjustment(ctx context.Context, a BalanceAdjustment) error
+
+	// AddTransactionFlag records a transaction flagged by a rule.
+	AddTransactionFlag(ctx context.Context, f TransactionFlag) error
+
+	// QueryTransactionFlags returns the client's flagged transactions, the
+	// most recent first.
+	QueryTransactionFlags(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]TransactionFlag, error)
+
+	// QueryIdempotencyKey returns a client's idempotency key. It returns
+	// ErrIdempotencyKeyNotFound if the key doesn't exist.
+	QueryIdempotencyKey(ctx context.Context, clientID int, key string) (IdempotencyKey, error)
+
+	// SaveIdempotencyKey saves an idempotency key, replacing the existing
+	// key with the same client and key.
+	SaveIdempotencyKey(ctx context.Context, k IdempotencyKey) error
+
+	// DeleteIdempotencyKeys deletes the keys that expired before the date
+	// and returns how many were deleted.
+	DeleteIdempotencyKeys(ctx context.Context, date time.Time) (int, error)
+
+	// AddOutboxEvent adds an event to the outbox. The store assigns the
+	// event's Seq.
+	AddOutboxEvent(ctx context.Context, e OutboxEvent) error
+
+	// QueryPendingOutbox returns up to limit pending outbox events with a
+	// Seq greater than after, in Seq order.
+	QueryPendingOutbox(ctx context.Context, after int64, limit int) ([]OutboxEvent, error)
+
+	// UpdateOutboxEvent updates the status, the attempts, the last error
+	// and the next attempt of an outbox event.
+	UpdateOutboxEvent(ctx context.Context, e OutboxEvent) error
+
+	// DeleteOutboxEvents deletes the published and dead outbox events last
+	// updated before the date and returns how many were deleted.
+	DeleteOutboxEvents(ctx context.Context, date time.Time) (int, error)
+
+	// AddWebhook registers a webhook of a client.
+	AddWebhook(ctx context.Context, w Webhook) error
+
+	// QueryWebhooks returns the client's webhooks, the oldest first.
+	QueryWebhooks(ctx context.Context, clientID int) ([]Webhook, error)
+
+	// QueryWebhookByID returns a client's webhook.
+	QueryWebhookByID(ctx context.Context, clientID int, webhookID uuid.UUID) (Webhook, error)
+
+	// AddWebhookDelivery adds a delivery of a webhook.
+	AddWebhookDelivery(ctx context.Context, d WebhookDelivery) error
+
+	// QueryWebhookDeliveryByID returns a client's webhook delivery.
+	QueryWebhookDeliveryByID(ctx context.Context, clientID int, deliveryID uuid.UUID) (WebhookDelivery, error)
+
+	// QueryWebhookDeliveries returns the deliveries of a client's webhook,
+	// the most recent first.
+	QueryWebhookDeliveries(ctx context.Context, clientID int, webhookID uuid.UUID, pageNumber, rowsPerPage int) ([]WebhookDelivery, error)
+
+	// QueryDueWebhookDeliveries returns up to limit pending deliveries of
+	// all clients with the next attempt up to the date, the oldest first.
+	QueryDueWebhookDeliveries(ctx context.Context, date time.Time, limit int) ([]WebhookDelivery, error)
+
+	// UpdateWebhookDelivery updates the status, the attempts, the response
+	// status, the last error and the next attempt of a delivery.
+	UpdateWebhookDelivery(ctx context.Context, d WebhookDelivery) error
+
+	// DeleteWebhookDeliveries deletes the delivered and failed deliveries
+	// last updated before the date and returns how many were deleted.
+	DeleteWebhookDeliveries(ctx context.Context, date time.Time) (int, error)
+
+	// AddClientUpdate records an update of a client with the next Seq of
+	// the client. The subscribers of the client are notified when the
+	// update is committed.
+	AddClientUpdate(ctx context.Context, u ClientUpdate) error
+
+	// QueryClientUpdates returns up to limit updates of the client with a
+	// Seq greater than after, ordered by Seq.
+	QueryClientUpdates(ctx context.Context, clientID int, after int64, limit int) ([]ClientUpdate, error)
+
+	// QueryClientUpdateSeq returns the Seq of the client's last update. It
+	// returns zero if the client has no updates.
+	QueryClientUpdateSeq(ctx context.Context, clientID int) (int64, error)
+
+	// DeleteClientUpdates deletes the client updates created before the
+	// date and returns how many were deleted. The Seqs of the deleted
+	// updates are not reused.
+	DeleteClientUpdates(ctx context.Context, date time.Time) (int, error)
+
+	// QueryLedgerSeq returns the Seq of the last ledger entry and locks the
+	// ledger until the end of the transaction. It returns zero if the ledger
+	// is empty.
+	QueryLedgerSeq(ctx context.Context) (int64, error)
+
+	// QueryUnsequencedTransactions returns up to limit committed
+	// transactions of all clients not yet in the ledger, the first added
+	// first.
+	QueryUnsequencedTransactions(ctx context.Context, limit int) ([]Transaction, error)
+
+	// AddLedgerEntries appends the transactions of the entries to the
+	// ledger with their Seqs.
+	AddLedgerEntries(ctx context.Context, es []LedgerEntry) error
+
+	// QueryLedger returns up to limit ledger entries with a Seq greater than
+	// after, ordered by Seq.
+	QueryLedger(ctx context.Context, after int64, limit int) ([]LedgerEntry, error)
 }
 
 // Core deals with client's business logic.
 type Core struct {
-	store Store
+	store          Store
+	idempotencyTTL time.Duration
+	holdTTL        time.Duration
+	interestRate   int
+	invoiceDueDays int
+	rules          []Rule
+	publisher      Publisher
+	outboxAttempts int
+	outboxRetain   time.Duration
+
+	webhookClient   *http.Client
+	webhookAttempts int
+	webhookBackoff  time.Duration
+	webhookRetain   time.Duration
+	webhookPrivate  bool
+
+	listener      Listener
+	updatesPoll   time.Duration
+	updatesRetain time.Duration
+	updates       *hub
+
+	frozenCredits bool
 }
 
-func NewCore(s Store) *Core {
-	return &Core{store: s}
+// Option configures the Core.
+type Option func(*Core)
+
+// WithIdempotencyTTL sets for how long idempotency keys are kept. The default
+// is 24 hours.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(c *Core) {
+		c.idempotencyTTL = ttl
+	}
+}
+
+// WithHoldTTL sets for how long holds reserve funds before they expire. The
+// default is 7 days.
+func WithHoldTTL(ttl time.Duration) Option {
+	return func(c *Core) {
+		c.holdTTL = ttl
+	}
+}
+
+func NewCore(s Store, opts ...Option) *Core {
+	c := Core{
+		store:          s,
+		idempotencyTTL: 24 * time.Hour,
+		holdTTL:        7 * 24 * time.Hour,
+		invoiceDueDays: 10,
+		rules:          DefaultRules(),
+		outboxAttempts: 10,
+		outboxRetain:   7 * 24 * time.Hour,
+
+		webhookClient:   NewWebhookClient(10 * time.Second),
+		webhookAttempts: 8,
+		webhookBackoff:  10 * time.Second,
+		webhookRetain:   7 * 24 * time.Hour,
+
+		updatesPoll:   15 * time.Second,
+		updatesRetain: 7 * 24 * time.Hour,
+		updates:       newHub(),
+
+		frozenCredits: true,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
 }
 
 func (c *Core) QueryByID(ctx context.Context, clientID int) (Client, error) {
 	return c.store.QueryByID(ctx, clientID)
 }
 
-// Billing returns info about a client and the 10 most recent transactions of
-// this client.
-func (c *Core) Billing(ctx context.Context, clientID int) (Billing, error) {
+// CreateClient creates a new client with zero balance. It returns
+// ErrDocumentExists if another client has the same document.
+func (c *Core) CreateClient(ctx context.Context, nc NewClient) (Client, error) {
+	if err := nc.validate(); err != nil {
+		return Client{}, err
+	}
+
+	cl := Client{
+		ID:          nc.ID,
+		Currency:    nc.Currency,
+		Limit:       nc.Limit,
+		ClosingDay:  nc.ClosingDay,
+		Status:      StatusActive,
+		Name:        strings.TrimSpace(nc.Name),
+		Email:       nc.Email,
+		DateCreated: time.Now().UTC().Round(time.Microsecond),
+	}
+	if nc.Document != "" {
+		doc, err := normalizeDocument(nc.Document)
+		if err != nil {
+			return Client{}, err
+		}
+		cl.Document = doc
+	}
+	if cl.Currency == "" {
+		cl.Currency = DefaultCurrency
+	}
+	if cl.ClosingDay == 0 {
+		cl.ClosingDay = DefaultClosingDay
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.CreateClient")
+	defer span.End()
+
+	if err := c.store.CreateClient(ctx, cl); err != nil {
+		return Client{}, err
+	}
+
+	return cl, nil
+}
+
+// QueryByDocument returns the client with the CPF or CNPJ, which may be
+// formatted.
+func (c *Core) QueryByDocument(ctx context.Context, document string) (Client, error) {
+	doc, err := normalizeDocument(document)
+	if err != nil {
+		return Client{}, err
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.QueryByDocument")
+	defer span.End()
+
+	return c.store.QueryByDocument(ctx, doc)
+}
+
+// ListClients returns a page of clients ordered by ID.
+func (c *Core) ListClients(ctx context.Context, pageNumber, rowsPerPage int) ([]Client, error) {
+	if pageNumber < 1 || rowsPerPage < 1 {
+		return nil, ErrInvalidArgument
+	}
+
+	return c.store.QueryClients(ctx, pageNumber, rowsPerPage)
+}
+
+// Billing returns info about a client, the 10 most recent transactions that
+// match the filter and the 10 most recent credit limit changes of this
+// client.
+func (c *Core) Billing(ctx context.Context, clientID int, filter TransactionFilter) (Billing, error) {
+	if err := filter.Validate(); err != nil {
+		return Billing{}, err
+	}
+
 	var b Billing
 	fn := func(tx Store) error {
 		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Billing.Tx.Inside")
@@ -64,16 +480,24 @@ func (c *Core) Billing(ctx context.Context, clientID int) (Billing, error) {
 
 		page := 1
 		rows := 10
-		transactions, err := tx.QueryTransactions(ctx, clientID, page, rows)
+		transactions, err := tx.QueryTransactions(ctx, clientID, filter, page, rows)
+		if err != nil {
+			return err
+		}
+
+		limitChanges, err := tx.QueryLimitChanges(ctx, clientID, page, rows)
 		if err != nil {
 			return err
 		}
 
 		b.Balance = c.Balance
+		b.Currency = c.Currency
+		b.Reserved = c.Reserved
 		b.Limit = c.Limit
 		//b.Date = web.GetTime(ctx)
 		b.Date = time.Now().UTC().Round(time.Microsecond)
 		b.LastTransactions = transactions
+		b.LimitChanges = limitChanges
 
 		return nil
 	}
@@ -88,16 +512,124 @@ func (c *Core) Billing(ctx context.Context, clientID int) (Billing, error) {
 	return b, nil
 }
 
-func (c *Core) AddTransaction(ctx context.Context, clientID int, nt NewTransaction) (Client, error) {
-	t := Transaction{
-		ID:          uuid.New(),
-		ClientID:    clientID,
-		Value:       nt.Value,
-		Type:        nt.Type,
-		Description: nt.Description,
-		//		Date:        web.GetTime(ctx).Round(time.Microsecond),
+// StreamBilling calls fn for each client's transaction that match the
+// filter, the oldest first, and returns the client's billing without the
+// last transactions.
+//
+// The client is only locked while the billing is read, the transactions are
+// streamed after, so a slow reader doesn't block the client. The stream
+// stops at the billing Date, the transactions posted after the lock are
+// dated after it, so the billing balance matches the streamed transactions.
+// If the filter ends before, the billing Date is the filter end date and
+// the Balance is the balance at it. Holds are not kept by date, so the
+// Reserved of such billing is zero.
+func (c *Core) StreamBilling(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) (Billing, error) {
+	if err := filter.Validate(); err != nil {
+		return Billing{}, err
 	}
-	if err := t.validate(); err != nil {
+
+	var b Billing
+	txFn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.StreamBilling.Tx.Inside")
+		defer span.End()
+
+		c, err := tx.QueryByID(ctx, clientID)
+		if err != nil {
+			return err
+		}
+
+		b.Balance = c.Balance
+		b.Currency = c.Currency
+		b.Reserved = c.Reserved
+		b.Limit = c.Limit
+		b.Date = time.Now().UTC().Round(time.Microsecond)
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.StreamBilling.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, txFn); err != nil {
+		return Billing{}, err
+	}
+
+	if filter.EndDate == nil || b.Date.Before(*filter.EndDate) {
+		filter.EndDate = &b.Date
+	} else {
+		// The end date is exclusive and the dates are rounded to
+		// microseconds.
+		balance, err := c.store.QueryBalanceAt(ctx, clientID, filter.EndDate.UTC().Add(-time.Microsecond))
+		if err != nil {
+			return Billing{}, fmt.Errorf("failed to query balance at end date: %w", err)
+		}
+		b.Balance = balance
+		b.Reserved = 0
+		b.Date = filter.EndDate.UTC()
+	}
+	if err := c.store.StreamTransactions(ctx, clientID, filter, fn); err != nil {
+		return Billing{}, err
+	}
+
+	return b, nil
+}
+
+// Statement returns a page of the client's transactions that match the
+// filter, the most recent first, starting after the cursor. The returned
+// NextCursor is empty on the last page.
+func (c *Core) Statement(ctx context.Context, clientID int, filter TransactionFilter, cursor string, limit int) (Statement, error) {
+	const maxLimit = 100
+	if limit < 1 || limit > maxLimit {
+		return Statement{}, ErrInvalidArgument
+	}
+	if err := filter.Validate(); err != nil {
+		return Statement{}, err
+	}
+
+	after, err := ParseCursor(cursor)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Statement")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return Statement{}, err
+	}
+
+	// Query one more transaction to know if there is a next page.
+	ts, err := c.store.QueryTransactionsAfter(ctx, clientID, filter, after, limit+1)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	var s Statement
+	if len(ts) > limit {
+		ts = ts[:limit]
+		s.NextCursor = cursorOf(ts[limit-1]).String()
+	}
+	s.Transactions = ts
+
+	return s, nil
+}
+
+// BalanceAt returns the client's balance at the date, the balance after the
+// last transaction made up to the date.
+func (c *Core) BalanceAt(ctx context.Context, clientID int, date time.Time) (int, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.BalanceAt")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return 0, err
+	}
+
+	return c.store.QueryBalanceAt(ctx, clientID, date.UTC())
+}
+
+func (c *Core) AddTransaction(ctx context.Context, clientID int, nt NewTransaction) (Client, error) {
+	t, err := newTransaction(clientID, nt)
+	if err != nil {
 		return Client{}, err
 	}
 
@@ -106,10 +638,46 @@ func (c *Core) AddTransaction(ctx context.Context, clientID int, nt NewTransacti
 		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.AddTransaction.Tx.Inside")
 		defer span.End()
 
-		// Set time only when inside the transaction.
-		// This is necessary to ensure the Date is set when the transaction
-		// is processed, not when it was received.
-		t.Date = time.Now().UTC().Round(time.Microsecond)
+		var err error
+		client, err = tx.QueryByID(ctx, clientID)
+		if err != nil {
+			return err
+		}
+
+		client, t, err = c.addTransaction(ctx, tx, client, t, nt.Currency)
+		return err
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.AddTransaction.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Client{}, c.notifyDenied(ctx, t, err)
+	}
+
+	return client, nil
+}
+
+// ChangeLimit changes the credit limit of a client and records the change.
+// The limit can't be lowered below the client's current negative balance.
+func (c *Core) ChangeLimit(ctx context.Context, clientID, newLimit int, reason string) (Client, error) {
+	lc := LimitChange{
+		ID:       uuid.New(),
+		ClientID: clientID,
+		NewLimit: newLimit,
+		Reason:   reason,
+		TraceID:  web.GetTraceID(ctx),
+	}
+	if err := lc.validate(); err != nil {
+		return Client{}, err
+	}
+
+	var client Client
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ChangeLimit.Tx.Inside")
+		defer span.End()
+
+		lc.Date = time.Now().UTC().Round(time.Microsecond)
 
 		var err error
 		client, err = tx.QueryByID(ctx, clientID)
@@ -117,29 +685,199 @@ func (c *Core) AddTransaction(ctx context.Context, clientID int, nt NewTransacti
 			return err
 		}
 
-		value := t.Value
-		if t.Type == "d" {
-			value = -value
+		if client.Status == StatusClosed {
+			return &StatusError{ClientID: clientID, Status: client.Status}
+		}
+		if client.Available() < -newLimit {
+			return ErrLimitDenied
+		}
+		lc.OldLimit = client.Limit
+
+		if err := tx.AddLimitChange(ctx, lc); err != nil {
+			return fmt.Errorf("failed to add limit change: %w", err)
+		}
+
+		client, err = tx.UpdateClientLimit(ctx, client.ID, newLimit)
+		if err != nil {
+			return fmt.Errorf("failed to update limit: %w", err)
+		}
+
+		return addBalanceUpdate(ctx, tx, UpdateLimit, client)
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ChangeLimit.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// AddTransactionIdempotent adds a transaction like AddTransaction, but it
+// stores the key and the response created by render in the same database
+// transaction. Retrying with the same key and the same transaction returns
+// the stored response, retrying with a different transaction returns
+// ErrIdempotencyConflict.
+func (c *Core) AddTransactionIdempotent(ctx context.Context, clientID int, key string, nt NewTransaction, render func(Client) ([]byte, error)) ([]byte, error) {
+	if len(key) < 1 || len(key) > 255 {
+		return nil, ErrInvalidArgument
+	}
+
+	t, err := newTransaction(clientID, nt)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := requestHash(nt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash request: %w", err)
+	}
+
+	var resp []byte
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.AddTransactionIdempotent.Tx.Inside")
+		defer span.End()
+
+		// Locking the client serializes the requests with the same key.
+		client, err := tx.QueryByID(ctx, clientID)
+		if err != nil {
+			return err
 		}
 
-		newBalance := client.Balance + value
-		if newBalance < -client.Limit {
-			return ErrTransactionDenied
+		now := time.Now().UTC().Round(time.Microsecond)
+
+		k, err := tx.QueryIdempotencyKey(ctx, clientID, key)
+		switch {
+		case err == nil && k.ExpiresAt.After(now):
+			if k.RequestHash != hash {
+				return ErrIdempotencyConflict
+			}
+			resp = k.Response
+			return nil
+
+		case err != nil && !errors.Is(err, ErrIdempotencyKeyNotFound):
+			return fmt.Errorf("failed to query idempotency key: %w", err)
 		}
 
-		if err := tx.AddTransaction(ctx, t); err != nil {
-			return fmt.Errorf("failed to add transaction: %w", err)
+		client, t, err = c.addTransaction(ctx, tx, client, t, nt.Currency)
+		if err != nil {
+			return err
 		}
 
-		client, err = tx.UpdateClientBalance(ctx, client.ID, newBalance)
+		resp, err = render(client)
 		if err != nil {
-			return fmt.Errorf("failed to update balance: %w", err)
+			return fmt.Errorf("failed to render response: %w", err)
+		}
+
+		k = IdempotencyKey{
+			ClientID:    clientID,
+			Key:         key,
+			RequestHash: hash,
+			Response:    resp,
+			Date:        now,
+			ExpiresAt:   now.Add(c.idempotencyTTL),
+		}
+		if err := tx.SaveIdempotencyKey(ctx, k); err != nil {
+			return fmt.Errorf("failed to save idempotency key: %w", err)
 		}
 
 		return nil
 	}
 
-	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.AddTransaction.Tx")
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.AddTransactionIdempotent.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return nil, c.notifyDenied(ctx, t, err)
+	}
+
+	return resp, nil
+}
+
+// PurgeIdempotencyKeys deletes the expired idempotency keys and returns how
+// many were deleted.
+func (c *Core) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.PurgeIdempotencyKeys")
+	defer span.End()
+
+	return c.store.DeleteIdempotencyKeys(ctx, time.Now().UTC())
+}
+
+// requestHash identifies the transaction requested with an idempotency key.
+func requestHash(nt NewTransaction) (string, error) {
+	bs, err := json.Marshal(nt)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ReverseTransaction undoes a transaction by posting a compensating
+// transaction that references it. A transaction can only be reversed once,
+// reversals can't be reversed and the transactions of transfers can't be
+// reversed, only one of their legs would be undone.
+func (c *Core) ReverseTransaction(ctx context.Context, clientID int, transactionID uuid.UUID) (Client, error) {
+	var client Client
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ReverseTransaction.Tx.Inside")
+		defer span.End()
+
+		var err error
+		client, err = tx.QueryByID(ctx, clientID)
+		if err != nil {
+			return err
+		}
+
+		orig, err := tx.QueryTransactionByID(ctx, clientID, transactionID)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case orig.ReversedBy != uuid.Nil:
+			return ErrTransactionReversed
+		case orig.ReversalOf != uuid.Nil:
+			return fmt.Errorf("reversal can't be reversed: %w", ErrInvalidArgument)
+		case orig.TransferID != uuid.Nil:
+			return fmt.Errorf("transfer can't be reversed: %w", ErrInvalidArgument)
+		}
+
+		t := Transaction{
+			ID:          uuid.New(),
+			ClientID:    clientID,
+			Value:       orig.Value,
+			Type:        "c",
+			Description: "estorno",
+			Date:        time.Now().UTC().Round(time.Microsecond),
+			ReversalOf:  orig.ID,
+
+			// The reversal returns the converted value, it is not
+			// converted again.
+			Currency:      orig.Currency,
+			OriginalValue: orig.OriginalValue,
+			FXRate:        orig.FXRate,
+		}
+		if orig.Type == "c" {
+			t.Type = "d"
+		}
+
+		client, err = c.post(ctx, tx, client, t)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.UpdateTransactionReversedBy(ctx, orig.ID, t.ID); err != nil {
+			return fmt.Errorf("failed to mark transaction as reversed: %w", err)
+		}
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ReverseTransaction.Tx")
 	defer span.End()
 
 	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
@@ -149,6 +887,228 @@ func (c *Core) AddTransaction(ctx context.Context, clientID int, nt NewTransacti
 	return client, nil
 }
 
+// Transfer moves money between two clients. The debit and the credit share
+// the transfer ID and only the debited client has its limit checked.
+func (c *Core) Transfer(ctx context.Context, ntr NewTransfer) (Transfer, error) {
+	tr := Transfer{ID: uuid.New()}
+	debit := Transaction{
+		ID:          uuid.New(),
+		ClientID:    ntr.FromID,
+		Value:       ntr.Value,
+		Type:        "d",
+		Description: ntr.Description,
+		TransferID:  tr.ID,
+	}
+	credit := Transaction{
+		ID:          uuid.New(),
+		ClientID:    ntr.ToID,
+		Value:       ntr.Value,
+		Type:        "c",
+		Description: ntr.Description,
+		TransferID:  tr.ID,
+	}
+
+	switch {
+	case ntr.FromID == ntr.ToID:
+		return Transfer{}, ErrInvalidArgument
+	case ntr.Value < 1:
+		return Transfer{}, ErrInvalidArgument
+	}
+	if err := debit.validate(); err != nil {
+		return Transfer{}, err
+	}
+	if err := credit.validate(); err != nil {
+		return Transfer{}, err
+	}
+
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Transfer.Tx.Inside")
+		defer span.End()
+
+		// Lock the clients in ID order, so concurrent transfers in
+		// opposite directions can't deadlock.
+		clients := make(map[int]Client, 2)
+		for _, id := range []int{min(ntr.FromID, ntr.ToID), max(ntr.FromID, ntr.ToID)} {
+			c, err := tx.QueryByID(ctx, id)
+			if err != nil {
+				return err
+			}
+			clients[id] = c
+		}
+
+		// Set time only after both clients are locked, so their
+		// transactions are dated in the order of their balances.
+		tr.Date = time.Now().UTC().Round(time.Microsecond)
+		debit.Date = tr.Date
+		credit.Date = tr.Date
+
+		// The value is in the debited client's currency.
+		var err error
+		credit, err = exchange(ctx, tx, clients[ntr.ToID], credit, clients[ntr.FromID].Currency)
+		if err != nil {
+			return err
+		}
+
+		tr.From, err = c.post(ctx, tx, clients[ntr.FromID], debit)
+		if err != nil {
+			return err
+		}
+
+		tr.To, err = c.post(ctx, tx, clients[ntr.ToID], credit)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Transfer.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Transfer{}, err
+	}
+
+	return tr, nil
+}
+
+// newTransaction returns the transaction requested by nt. It is dated when
+// it is posted.
+func newTransaction(clientID int, nt NewTransaction) (Transaction, error) {
+	t := Transaction{
+		ID:          uuid.New(),
+		ClientID:    clientID,
+		Value:       nt.Value,
+		Type:        nt.Type,
+		Description: nt.Description,
+		//		Date:        web.GetTime(ctx).Round(time.Microsecond),
+	}
+	if err := t.validate(); err != nil {
+		return Transaction{}, err
+	}
+
+	return t, nil
+}
+
+// addTransaction dates the transaction t, converts it from the currency and
+// posts it to the client locked by tx, enqueueing the notifications of the
+// posting. The transaction is returned dated and converted even if it is
+// denied, so the callers can notify the denial after tx is rolled back.
+func (c *Core) addTransaction(ctx context.Context, tx Store, client Client, t Transaction, currency string) (Client, Transaction, error) {
+	// Set time only when inside the transaction, after the client is
+	// locked. This is necessary to ensure the Date is set when the
+	// transaction is processed, not when it was received, and that the
+	// client's transactions are dated in the order of their balances.
+	t.Date = time.Now().UTC().Round(time.Microsecond)
+
+	converted, err := exchange(ctx, tx, client, t, currency)
+	if err != nil {
+		return Client{}, t, err
+	}
+	t = converted
+
+	client, err = c.post(ctx, tx, client, t)
+	if err != nil {
+		return Client{}, t, err
+	}
+
+	if err := c.notifyPosted(ctx, tx, client, t); err != nil {
+		return Client{}, t, err
+	}
+
+	return client, t, nil
+}
+
+// post adds the transaction t to the client and updates its balance if the
+// account status and the rules allow it. The rules named in skip are not
+// evaluated. The client must be locked by tx, which serializes the evaluation
+// of the client's transactions. Denials are returned as a StatusError or a
+// RuleError and flags are recorded with the transaction.
+func (c *Core) post(ctx context.Context, tx Store, client Client, t Transaction, skip ...string) (Client, error) {
+	if err := c.checkStatus(client, t.Type); err != nil {
+		return Client{}, err
+	}
+
+	flags, err := c.evaluate(ctx, tx, client, t, skip...)
+	if err != nil {
+		return Client{}, err
+	}
+
+	client, err = c.book(ctx, tx, client, t)
+	if err != nil {
+		return Client{}, err
+	}
+
+	for _, f := range flags {
+		if err := tx.AddTransactionFlag(ctx, f); err != nil {
+			return Client{}, fmt.Errorf("failed to add transaction flag: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// book adds the transaction t to the client and updates its balance without
+// checking the client's limit. The new balance is recorded in the
+// transaction. The outbox event is only added if there is a publisher.
+func (c *Core) book(ctx context.Context, tx Store, client Client, t Transaction) (Client, error) {
+	newBalance := client.Balance + t.SignedValue()
+	t.BalanceAfter = newBalance
+
+	if err := tx.AddTransaction(ctx, t); err != nil {
+		return Client{}, fmt.Errorf("failed to add transaction: %w", err)
+	}
+
+	if c.publisher != nil {
+		e, err := transactionCreated(t)
+		if err != nil {
+			return Client{}, err
+		}
+		if err := tx.AddOutboxEvent(ctx, e); err != nil {
+			return Client{}, fmt.Errorf("failed to add outbox event: %w", err)
+		}
+	}
+
+	client, err := tx.UpdateClientBalance(ctx, client.ID, newBalance)
+	if err != nil {
+		return Client{}, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	if err := addUpdate(ctx, tx, UpdateTransaction, client.ID, toTransactionPayload(t)); err != nil {
+		return Client{}, err
+	}
+	if err := addBalanceUpdate(ctx, tx, UpdateBalance, client); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// SignedValue returns the transaction value, negative for debits.
+func (t Transaction) SignedValue() int {
+	if t.Type == "d" {
+		return -t.Value
+	}
+	return t.Value
+}
+
+func (nc NewClient) validate() error {
+	switch {
+	case nc.ID < 1:
+		return ErrInvalidArgument
+	case nc.Limit < 0:
+		return ErrInvalidArgument
+	case nc.Currency != "" && !validCurrency(nc.Currency):
+		return ErrInvalidArgument
+	case nc.ClosingDay < 0 || nc.ClosingDay > 28:
+		return ErrInvalidArgument
+	case nc.Email != "" && !validEmail(nc.Email):
+		return ErrInvalidArgument
+	}
+
+	return nil
+}
+
 func (t Transaction) validate() error {
 	switch {
 	case t.ID.Variant() == uuid.Invalid:
@@ -161,6 +1121,21 @@ func (t Transaction) validate() error {
 		return ErrInvalidArgument
 	case len(t.Description) < 1 || len(t.Description) > 10:
 		return ErrInvalidArgument
+	case t.Description == InterestDescription:
+		return fmt.Errorf("reserved description: %w", ErrInvalidArgument)
+	}
+
+	return nil
+}
+
+func (lc LimitChange) validate() error {
+	switch {
+	case lc.ClientID < 1:
+		return ErrNotFound
+	case lc.NewLimit < 0:
+		return ErrInvalidArgument
+	case len(lc.Reason) < 1 || len(lc.Reason) > 100:
+		return ErrInvalidArgument
 	}
 
 	return nil
@@ -2,123 +2,2014 @@ package client_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
 	"github.com/rschio/rinha/internal/core/client"
-	"github.com/rschio/rinha/internal/core/client/store/clientdb"
-	"github.com/rschio/rinha/internal/data/dbtest"
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
+	"github.com/rschio/rinha/internal/core/client/store/storetest"
 )
 
 func TestAddTransaction(t *testing.T) {
-	ctx := context.Background()
-	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
-	t.Cleanup(teardown)
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
 
-	core := client.NewCore(clientdb.NewStore(log, database))
+		clientID := 2
+		c, err := core.QueryByID(ctx, clientID)
+		if err != nil {
+			t.Fatalf("failed to query clientID[%d]: %v", clientID, err)
+		}
+
+		nt := client.NewTransaction{
+			Value:       100,
+			Type:        "d",
+			Description: "hello",
+		}
+
+		cret, err := core.AddTransaction(ctx, clientID, nt)
+		if err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+
+		c, err = core.QueryByID(ctx, clientID)
+		if err != nil {
+			t.Fatalf("failed to query 2nd time clientID[%d]: %v", clientID, err)
+		}
+
+		if diff := cmp.Diff(cret, c); diff != "" {
+			t.Fatalf("got diferent clients: %s", diff)
+		}
+
+		if c.Balance != -100 {
+			t.Fatalf("got %d balance want %d", c.Balance, -100)
+		}
+	})
+}
+
+func TestCreateClient(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		nc := client.NewClient{ID: 6, Limit: 5000, Name: " Maria ", Document: "529.982.247-25", Email: "maria@example.com"}
+		c, err := core.CreateClient(ctx, nc)
+		if err != nil {
+			t.Fatalf("creating client: %v", err)
+		}
+		if c.DateCreated.IsZero() {
+			t.Fatal("got zero creation date")
+		}
+
+		want := client.Client{
+			ID:          6,
+			Currency:    client.DefaultCurrency,
+			Limit:       5000,
+			Balance:     0,
+			ClosingDay:  client.DefaultClosingDay,
+			Status:      client.StatusActive,
+			Name:        "Maria",
+			Document:    "52998224725",
+			Email:       "maria@example.com",
+			DateCreated: c.DateCreated,
+		}
+		if diff := cmp.Diff(want, c); diff != "" {
+			t.Fatalf("got diferent clients: %s", diff)
+		}
+
+		if _, err := core.CreateClient(ctx, nc); !errors.Is(err, client.ErrAlreadyExists) {
+			t.Fatalf("got err %v want %v", err, client.ErrAlreadyExists)
+		}
+		dup := client.NewClient{ID: 7, Document: "52998224725"}
+		if _, err := core.CreateClient(ctx, dup); !errors.Is(err, client.ErrDocumentExists) {
+			t.Fatalf("got err %v want %v", err, client.ErrDocumentExists)
+		}
+
+		got, err := core.QueryByDocument(ctx, "52998224725")
+		if err != nil {
+			t.Fatalf("querying by document: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("got diferent clients: %s", diff)
+		}
+		if _, err := core.QueryByDocument(ctx, "11.222.333/0001-81"); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrNotFound)
+		}
+
+		invalid := []client.NewClient{
+			{ID: 0, Limit: 10},
+			{ID: 7, Limit: -1},
+			{ID: 7, Currency: "brl"},
+			{ID: 7, Document: "529.982.247-26"},
+			{ID: 7, Document: "111.111.111-11"},
+			{ID: 7, Document: "11.222.333/0001-82"},
+			{ID: 7, Document: "5299822472"},
+			{ID: 7, Document: "529 982 247 25"},
+			{ID: 7, Email: "maria"},
+			{ID: 7, Email: "Maria <maria@example.com>"},
+		}
+		for _, nc := range invalid {
+			if _, err := core.CreateClient(ctx, nc); !errors.Is(err, client.ErrInvalidArgument) {
+				t.Errorf("%+v: got err %v want %v", nc, err, client.ErrInvalidArgument)
+			}
+		}
+
+		cs, err := core.ListClients(ctx, 2, 4)
+		if err != nil {
+			t.Fatalf("listing clients: %v", err)
+		}
+		if len(cs) != 2 || cs[0].ID != 5 || cs[1].ID != 6 {
+			t.Fatalf("got wrong page of clients: %+v", cs)
+		}
+	})
+}
+
+func TestChangeLimit(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 2
+		nt := client.NewTransaction{Value: 50000, Type: "d", Description: "hello"}
+		if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+
+		if _, err := core.ChangeLimit(ctx, clientID, 40000, "lower"); !errors.Is(err, client.ErrLimitDenied) {
+			t.Fatalf("got err %v want %v", err, client.ErrLimitDenied)
+		}
+		if _, err := core.ChangeLimit(ctx, clientID, 60000, ""); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvalidArgument)
+		}
+
+		c, err := core.ChangeLimit(ctx, clientID, 60000, "lower")
+		if err != nil {
+			t.Fatalf("changing limit: %v", err)
+		}
+		if c.Limit != 60000 || c.Balance != -50000 {
+			t.Fatalf("got wrong client after limit change: %+v", c)
+		}
+
+		b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("billing: %v", err)
+		}
+		if len(b.LimitChanges) != 1 {
+			t.Fatalf("got %d limit changes want %d", len(b.LimitChanges), 1)
+		}
+		lc := b.LimitChanges[0]
+		if lc.OldLimit != 80000 || lc.NewLimit != 60000 || lc.Reason != "lower" {
+			t.Errorf("got wrong limit change: %+v", lc)
+		}
+	})
+}
+
+func TestChangeStatus(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t, memstore.DefaultClients()...)
+		core := client.NewCore(store)
+
+		credit := client.NewTransaction{Value: 10, Type: "c", Description: "credit"}
+		debit := client.NewTransaction{Value: 10, Type: "d", Description: "debit"}
+
+		c, err := core.ChangeStatus(ctx, 1, client.StatusFrozen, "fraud")
+		if err != nil {
+			t.Fatalf("freezing: %v", err)
+		}
+		if c.Status != client.StatusFrozen {
+			t.Fatalf("got status %q want %q", c.Status, client.StatusFrozen)
+		}
+
+		// Frozen accounts reject debits, and credits if configured.
+		_, err = core.AddTransaction(ctx, 1, debit)
+		var se *client.StatusError
+		if !errors.As(err, &se) || se.Status != client.StatusFrozen || !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("got err %v want a StatusError of a frozen account", err)
+		}
+		if _, err := core.AddTransaction(ctx, 1, credit); err != nil {
+			t.Fatalf("adding credit to frozen account: %v", err)
+		}
+		strict := client.NewCore(store, client.WithFrozenCredits(false))
+		if _, err := strict.AddTransaction(ctx, 1, credit); !errors.Is(err, client.ErrAccountStatus) {
+			t.Fatalf("got err %v want %v", err, client.ErrAccountStatus)
+		}
+
+		if _, err := core.ChangeStatus(ctx, 1, client.StatusFrozen, "again"); !errors.Is(err, client.ErrStatusTransition) {
+			t.Fatalf("got err %v want %v", err, client.ErrStatusTransition)
+		}
+		if _, err := core.ChangeStatus(ctx, 1, client.StatusClosed, "close"); !errors.Is(err, client.ErrStatusTransition) {
+			t.Fatalf("closing frozen account: got err %v want %v", err, client.ErrStatusTransition)
+		}
+		if _, err := core.ChangeStatus(ctx, 1, client.StatusActive, "cleared"); err != nil {
+			t.Fatalf("activating: %v", err)
+		}
+
+		// Accounts are only closed without balance.
+		if _, err := core.ChangeStatus(ctx, 1, client.StatusClosed, "close"); !errors.Is(err, client.ErrStatusTransition) {
+			t.Fatalf("closing with balance: got err %v want %v", err, client.ErrStatusTransition)
+		}
+		if _, err := core.AddTransaction(ctx, 1, debit); err != nil {
+			t.Fatalf("adding debit: %v", err)
+		}
+		if _, err := core.ChangeStatus(ctx, 1, client.StatusClosed, "close"); err != nil {
+			t.Fatalf("closing: %v", err)
+		}
+
+		// Closed accounts reject everything and are final.
+		if _, err := core.AddTransaction(ctx, 1, credit); !errors.Is(err, client.ErrAccountStatus) {
+			t.Fatalf("got err %v want %v", err, client.ErrAccountStatus)
+		}
+		if _, err := core.ChangeLimit(ctx, 1, 0, "closed"); !errors.Is(err, client.ErrAccountStatus) {
+			t.Fatalf("got err %v want %v", err, client.ErrAccountStatus)
+		}
+		if _, _, err := core.Authorize(ctx, 1, client.NewHold{Value: 1, Description: "hold"}); !errors.Is(err, client.ErrAccountStatus) {
+			t.Fatalf("got err %v want %v", err, client.ErrAccountStatus)
+		}
+		if _, err := core.ChangeStatus(ctx, 1, client.StatusActive, "reopen"); !errors.Is(err, client.ErrStatusTransition) {
+			t.Fatalf("got err %v want %v", err, client.ErrStatusTransition)
+		}
+
+		scs, err := core.ListStatusChanges(ctx, 1, 1, 10)
+		if err != nil {
+			t.Fatalf("listing status changes: %v", err)
+		}
+		var got []string
+		for _, sc := range scs {
+			got = append(got, sc.OldStatus+">"+sc.NewStatus)
+		}
+		want := []string{"active>closed", "frozen>active", "active>frozen"}
+		if !slices.Equal(got, want) {
+			t.Fatalf("got status changes %v want %v", got, want)
+		}
+
+		if _, err := core.ChangeStatus(ctx, 2, "blocked", "invalid"); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvalidArgument)
+		}
+	})
+}
+
+func TestReverseTransaction(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 2
+		nts := []client.NewTransaction{
+			{Value: 10000, Type: "c", Description: "credit"},
+			{Value: 85000, Type: "d", Description: "debit"},
+		}
+		for _, nt := range nts {
+			if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+
+		b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("billing: %v", err)
+		}
+		debit, credit := b.LastTransactions[0], b.LastTransactions[1]
+
+		if _, err := core.ReverseTransaction(ctx, clientID, credit.ID); !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("reversing credit: got err %v want %v", err, client.ErrTransactionDenied)
+		}
+
+		c, err := core.ReverseTransaction(ctx, clientID, debit.ID)
+		if err != nil {
+			t.Fatalf("reversing debit: %v", err)
+		}
+		if c.Balance != 10000 {
+			t.Fatalf("got %d balance want %d", c.Balance, 10000)
+		}
+
+		if _, err := core.ReverseTransaction(ctx, clientID, debit.ID); !errors.Is(err, client.ErrTransactionReversed) {
+			t.Fatalf("reversing twice: got err %v want %v", err, client.ErrTransactionReversed)
+		}
+		if _, err := core.ReverseTransaction(ctx, 1, credit.ID); !errors.Is(err, client.ErrTransactionNotFound) {
+			t.Fatalf("reversing other client transaction: got err %v want %v", err, client.ErrTransactionNotFound)
+		}
+
+		b, err = core.Billing(ctx, clientID, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("billing: %v", err)
+		}
+		reversal := b.LastTransactions[0]
+		if reversal.ReversalOf != debit.ID || reversal.Type != "c" || reversal.Value != debit.Value {
+			t.Fatalf("got wrong reversal: %+v", reversal)
+		}
+		if b.LastTransactions[1].ReversedBy != reversal.ID {
+			t.Fatalf("debit should be marked as reversed: %+v", b.LastTransactions[1])
+		}
+
+		if _, err := core.ReverseTransaction(ctx, clientID, reversal.ID); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("reversing reversal: got err %v want %v", err, client.ErrInvalidArgument)
+		}
+	})
+}
+
+func TestAddTransactionIdempotent(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t, memstore.DefaultClients()...)
+		core := client.NewCore(store, client.WithIdempotencyTTL(time.Hour))
+
+		clientID := 1
+		nt := client.NewTransaction{Value: 100, Type: "d", Description: "hello"}
+		render := func(c client.Client) ([]byte, error) {
+			return []byte(fmt.Sprint(c.Balance)), nil
+		}
+
+		for range 3 {
+			resp, err := core.AddTransactionIdempotent(ctx, clientID, "key", nt, render)
+			if err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+			if string(resp) != "-100" {
+				t.Fatalf("got response %q want %q", resp, "-100")
+			}
+		}
+
+		nt.Value = 200
+		if _, err := core.AddTransactionIdempotent(ctx, clientID, "key", nt, render); !errors.Is(err, client.ErrIdempotencyConflict) {
+			t.Fatalf("got err %v want %v", err, client.ErrIdempotencyConflict)
+		}
+
+		resp, err := core.AddTransactionIdempotent(ctx, clientID, "other", nt, render)
+		if err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		if string(resp) != "-300" {
+			t.Fatalf("got response %q want %q", resp, "-300")
+		}
+
+		n, err := core.PurgeIdempotencyKeys(ctx)
+		if err != nil {
+			t.Fatalf("purging keys: %v", err)
+		}
+		if n != 0 {
+			t.Fatalf("got %d purged keys want %d", n, 0)
+		}
+
+		n, err = store.DeleteIdempotencyKeys(ctx, time.Now().Add(2*time.Hour))
+		if err != nil {
+			t.Fatalf("deleting keys: %v", err)
+		}
+		if n != 2 {
+			t.Fatalf("got %d deleted keys want %d", n, 2)
+		}
+	})
+}
+
+func TestTransfer(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		ntr := client.NewTransfer{FromID: 2, ToID: 1, Value: 80000, Description: "transfer"}
+		tr, err := core.Transfer(ctx, ntr)
+		if err != nil {
+			t.Fatalf("transfering: %v", err)
+		}
+		if tr.From.Balance != -80000 || tr.To.Balance != 80000 {
+			t.Fatalf("got wrong balances: %+v", tr)
+		}
+
+		ntr.Value = 1
+		if _, err := core.Transfer(ctx, ntr); !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("got err %v want %v", err, client.ErrTransactionDenied)
+		}
+		if _, err := core.Transfer(ctx, client.NewTransfer{FromID: 1, ToID: 1, Value: 1, Description: "x"}); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvalidArgument)
+		}
+		if _, err := core.Transfer(ctx, client.NewTransfer{FromID: 1, ToID: 9, Value: 1, Description: "x"}); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrNotFound)
+		}
+
+		for _, clientID := range []int{1, 2} {
+			b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+			if err != nil {
+				t.Fatalf("billing: %v", err)
+			}
+			if len(b.LastTransactions) != 1 || b.LastTransactions[0].TransferID != tr.ID {
+				t.Fatalf("clientID[%d] should have the transfer transaction: %+v", clientID, b.LastTransactions)
+			}
+
+			// A leg of the transfer can't be reversed alone.
+			if _, err := core.ReverseTransaction(ctx, clientID, b.LastTransactions[0].ID); !errors.Is(err, client.ErrInvalidArgument) {
+				t.Fatalf("reversing transfer: got err %v want %v", err, client.ErrInvalidArgument)
+			}
+		}
+
+		// Opposite transfers must not deadlock.
+		var wg sync.WaitGroup
+		for i := range 200 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ntr := client.NewTransfer{FromID: 1, ToID: 2, Value: 10, Description: "transfer"}
+				if i%2 == 0 {
+					ntr.FromID, ntr.ToID = ntr.ToID, ntr.FromID
+				}
+				if _, err := core.Transfer(ctx, ntr); err != nil && !errors.Is(err, client.ErrTransactionDenied) {
+					t.Errorf("transfering: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestHolds(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t, memstore.DefaultClients()...)
+		core := client.NewCore(store)
+
+		clientID := 1
+		h, c, err := core.Authorize(ctx, clientID, client.NewHold{Value: 60000, Description: "hold"})
+		if err != nil {
+			t.Fatalf("authorizing: %v", err)
+		}
+		if c.Balance != 0 || c.Reserved != 60000 || c.Available() != -60000 {
+			t.Fatalf("got wrong client after authorize: %+v", c)
+		}
+
+		nt := client.NewTransaction{Value: 50000, Type: "d", Description: "debit"}
+		if _, err := core.AddTransaction(ctx, clientID, nt); !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("debiting reserved funds: got err %v want %v", err, client.ErrTransactionDenied)
+		}
+		if _, _, err := core.Authorize(ctx, clientID, client.NewHold{Value: 50000, Description: "hold"}); !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("authorizing reserved funds: got err %v want %v", err, client.ErrTransactionDenied)
+		}
+		if _, err := core.Capture(ctx, clientID, h.ID, 70000); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("capturing more than hold: got err %v want %v", err, client.ErrInvalidArgument)
+		}
+
+		c, err = core.Capture(ctx, clientID, h.ID, 20000)
+		if err != nil {
+			t.Fatalf("capturing: %v", err)
+		}
+		if c.Balance != -20000 || c.Reserved != 0 {
+			t.Fatalf("got wrong client after capture: %+v", c)
+		}
+		if _, err := core.Capture(ctx, clientID, h.ID, 0); !errors.Is(err, client.ErrHoldClosed) {
+			t.Fatalf("capturing twice: got err %v want %v", err, client.ErrHoldClosed)
+		}
+
+		b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("billing: %v", err)
+		}
+		if len(b.LastTransactions) != 1 || b.LastTransactions[0].Value != 20000 || b.LastTransactions[0].Description != "hold" {
+			t.Fatalf("got wrong transactions after capture: %+v", b.LastTransactions)
+		}
+
+		h, _, err = core.Authorize(ctx, clientID, client.NewHold{Value: 30000, Description: "hold"})
+		if err != nil {
+			t.Fatalf("authorizing: %v", err)
+		}
+		if _, err := core.Void(ctx, 2, h.ID); !errors.Is(err, client.ErrHoldNotFound) {
+			t.Fatalf("voiding other client hold: got err %v want %v", err, client.ErrHoldNotFound)
+		}
+		c, err = core.Void(ctx, clientID, h.ID)
+		if err != nil {
+			t.Fatalf("voiding: %v", err)
+		}
+		if c.Balance != -20000 || c.Reserved != 0 {
+			t.Fatalf("got wrong client after void: %+v", c)
+		}
+		if _, err := core.Void(ctx, clientID, h.ID); !errors.Is(err, client.ErrHoldClosed) {
+			t.Fatalf("voiding twice: got err %v want %v", err, client.ErrHoldClosed)
+		}
+
+		// Holds of a negative TTL are expired as soon as they are created.
+		expiring := client.NewCore(store, client.WithHoldTTL(-time.Second))
+		h, _, err = expiring.Authorize(ctx, clientID, client.NewHold{Value: 30000, Description: "hold"})
+		if err != nil {
+			t.Fatalf("authorizing: %v", err)
+		}
+		if _, err := core.Capture(ctx, clientID, h.ID, 0); !errors.Is(err, client.ErrHoldClosed) {
+			t.Fatalf("capturing expired hold: got err %v want %v", err, client.ErrHoldClosed)
+		}
+
+		n, err := core.ExpireHolds(ctx)
+		if err != nil {
+			t.Fatalf("expiring holds: %v", err)
+		}
+		if n != 1 {
+			t.Fatalf("got %d expired holds want %d", n, 1)
+		}
+
+		c, err = core.QueryByID(ctx, clientID)
+		if err != nil {
+			t.Fatalf("querying client: %v", err)
+		}
+		if c.Reserved != 0 {
+			t.Fatalf("got %d reserved want %d", c.Reserved, 0)
+		}
+	})
+}
+
+func TestScheduled(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 1
+		past := client.NewScheduledTransaction{Value: 1000, Type: "d", Description: "past", ScheduledFor: time.Now().Add(-time.Minute)}
+		if _, err := core.Schedule(ctx, clientID, past); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("scheduling in the past: got err %v want %v", err, client.ErrInvalidArgument)
+		}
+		invalid := client.NewScheduledTransaction{Value: 1000, Type: "d", Description: "invalid", Currency: "usd", ScheduledFor: time.Now().Add(time.Minute)}
+		if _, err := core.Schedule(ctx, clientID, invalid); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("scheduling invalid currency: got err %v want %v", err, client.ErrInvalidArgument)
+		}
+
+		if _, err := core.SetFXRate(ctx, client.NewFXRate{From: "USD", To: "BRL", Rate: 5 * client.RateScale}); err != nil {
+			t.Fatalf("setting fx rate: %v", err)
+		}
+		wh, err := core.RegisterWebhook(ctx, clientID, client.NewWebhook{URL: "https://example.com"})
+		if err != nil {
+			t.Fatalf("registering webhook: %v", err)
+		}
+
+		due := time.Now().Add(50 * time.Millisecond)
+		nss := []client.NewScheduledTransaction{
+			{Value: 1000, Type: "d", Description: "posted", ScheduledFor: due},
+			{Value: 200000, Type: "d", Description: "denied", ScheduledFor: due},
+			{Value: 1000, Type: "d", Description: "canceled", ScheduledFor: due},
+			{Value: 1000, Type: "d", Description: "later", ScheduledFor: due.Add(time.Hour)},
+			{Value: 100, Type: "d", Description: "usd", Currency: "USD", ScheduledFor: due},
+			{Value: 100, Type: "d", Description: "eur", Currency: "EUR", ScheduledFor: due},
+		}
+		sts := make([]client.ScheduledTransaction, len(nss))
+		for i, ns := range nss {
+			var err error
+			if sts[i], err = core.Schedule(ctx, clientID, ns); err != nil {
+				t.Fatalf("scheduling %s: %v", ns.Description, err)
+			}
+		}
+
+		if _, err := core.CancelScheduled(ctx, clientID, sts[2].ID); err != nil {
+			t.Fatalf("canceling: %v", err)
+		}
+		if _, err := core.CancelScheduled(ctx, clientID, sts[2].ID); !errors.Is(err, client.ErrScheduledClosed) {
+			t.Fatalf("canceling twice: got err %v want %v", err, client.ErrScheduledClosed)
+		}
+		if _, err := core.CancelScheduled(ctx, 2, sts[3].ID); !errors.Is(err, client.ErrScheduledNotFound) {
+			t.Fatalf("canceling other client scheduled: got err %v want %v", err, client.ErrScheduledNotFound)
+		}
+
+		time.Sleep(time.Until(due))
+
+		n, err := core.ExecuteScheduled(ctx)
+		if err != nil {
+			t.Fatalf("executing scheduled: %v", err)
+		}
+		if n != 4 {
+			t.Fatalf("got %d executed want %d", n, 4)
+		}
+		if n, _ := core.ExecuteScheduled(ctx); n != 0 {
+			t.Fatalf("got %d executed on second run want %d", n, 0)
+		}
+
+		got, err := core.ListScheduled(ctx, clientID, 1, 10)
+		if err != nil {
+			t.Fatalf("listing scheduled: %v", err)
+		}
+		status := make(map[string]client.ScheduledTransaction)
+		for _, st := range got {
+			status[st.Description] = st
+		}
+
+		want := map[string]string{
+			"posted":   client.ScheduledPosted,
+			"denied":   client.ScheduledDenied,
+			"canceled": client.ScheduledCanceled,
+			"later":    client.ScheduledPending,
+			"usd":      client.ScheduledPosted,
+			"eur":      client.ScheduledDenied,
+		}
+		for desc, st := range want {
+			if status[desc].Status != st {
+				t.Errorf("%s: got status %q want %q", desc, status[desc].Status, st)
+			}
+		}
+		if status["denied"].Reason == "" {
+			t.Errorf("denied scheduled transaction should have a reason")
+		}
+
+		b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("billing: %v", err)
+		}
+		if b.Balance != -1500 || len(b.LastTransactions) != 2 {
+			t.Fatalf("got wrong billing after execution: %+v", b)
+		}
+		for _, tr := range b.LastTransactions {
+			if tr.ID == status["usd"].TransactionID && (tr.Value != 500 || tr.Currency != "USD" || tr.OriginalValue != 100) {
+				t.Fatalf("got wrong converted transaction: %+v", tr)
+			}
+		}
+
+		// The postings and the denial by the rules are notified.
+		ds, err := core.ListWebhookDeliveries(ctx, clientID, wh.ID, 1, 10)
+		if err != nil {
+			t.Fatalf("listing deliveries: %v", err)
+		}
+		if len(ds) != 3 {
+			t.Fatalf("got %d deliveries want %d", len(ds), 3)
+		}
+	})
+}
+
+func TestFXRates(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t,
+			client.Client{ID: 1, Limit: 100000},
+			client.Client{ID: 2, Currency: "USD", Limit: 1000},
+		))
+
+		invalid := []client.NewFXRate{
+			{From: "usd", To: "BRL", Rate: 1},
+			{From: "BRL", To: "BRL", Rate: 1},
+			{From: "USD", To: "BRL", Rate: 0},
+		}
+		for _, nr := range invalid {
+			if _, err := core.SetFXRate(ctx, nr); !errors.Is(err, client.ErrInvalidArgument) {
+				t.Fatalf("setting %+v: got err %v want %v", nr, err, client.ErrInvalidArgument)
+			}
+		}
+
+		for _, rate := range []int{4 * client.RateScale, 5_123_456} {
+			if _, err := core.SetFXRate(ctx, client.NewFXRate{From: "USD", To: "BRL", Rate: rate}); err != nil {
+				t.Fatalf("setting rate: %v", err)
+			}
+		}
+		rs, err := core.ListFXRates(ctx)
+		if err != nil {
+			t.Fatalf("listing rates: %v", err)
+		}
+		if len(rs) != 1 || rs[0].Rate != 5_123_456 {
+			t.Fatalf("got wrong rates: %+v", rs)
+		}
+
+		nt := client.NewTransaction{Value: 300, Type: "d", Description: "usd", Currency: "USD"}
+		c, err := core.AddTransaction(ctx, 1, nt)
+		if err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		if c.Balance != -1537 {
+			t.Fatalf("got %d balance want %d", c.Balance, -1537)
+		}
+
+		b, err := core.Billing(ctx, 1, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("billing: %v", err)
+		}
+		tr := b.LastTransactions[0]
+		if tr.Value != 1537 || tr.Currency != "USD" || tr.OriginalValue != 300 || tr.FXRate != 5_123_456 {
+			t.Fatalf("got wrong converted transaction: %+v", tr)
+		}
+
+		nt.Currency = "EUR"
+		if _, err := core.AddTransaction(ctx, 1, nt); !errors.Is(err, client.ErrFXRateNotFound) {
+			t.Fatalf("adding transaction without rate: got err %v want %v", err, client.ErrFXRateNotFound)
+		}
+
+		tr2, err := core.Transfer(ctx, client.NewTransfer{FromID: 2, ToID: 1, Value: 100, Description: "usd"})
+		if err != nil {
+			t.Fatalf("transfering: %v", err)
+		}
+		if tr2.From.Balance != -100 || tr2.To.Balance != -1537+512 {
+			t.Fatalf("got wrong balances after transfer: from %+v to %+v", tr2.From, tr2.To)
+		}
+
+		if _, err := core.Transfer(ctx, client.NewTransfer{FromID: 1, ToID: 2, Value: 100, Description: "brl"}); !errors.Is(err, client.ErrFXRateNotFound) {
+			t.Fatalf("transfering without rate: got err %v want %v", err, client.ErrFXRateNotFound)
+		}
+	})
+}
+
+func TestAccrueInterest(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t,
+			client.Client{ID: 1, Limit: 100000},
+			client.Client{ID: 2, Limit: 1000},
+			client.Client{ID: 3, Limit: 1000},
+			client.Client{ID: 4, Limit: 1000},
+			client.Client{ID: 5, Limit: 1000},
+		)
+		core := client.NewCore(store, client.WithInterestRate(client.RateScale/100))
+
+		nt := client.NewTransaction{Value: 1000, Type: "d", Description: client.InterestDescription}
+		if _, err := core.AddTransaction(ctx, 1, nt); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("using reserved description: got err %v want %v", err, client.ErrInvalidArgument)
+		}
+
+		debits := map[int]int{1: 50000, 2: 1000, 4: 1000, 5: 1000}
+		for id, value := range debits {
+			nt := client.NewTransaction{Value: value, Type: "d", Description: "debit"}
+			if _, err := core.AddTransaction(ctx, id, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+
+		// The interest doesn't override the account status.
+		if _, err := core.ChangeStatus(ctx, 4, client.StatusFrozen, "fraud"); err != nil {
+			t.Fatalf("changing status: %v", err)
+		}
+
+		// The interest doesn't override the spending limits.
+		if _, err := core.SetSpendingLimits(ctx, 5, client.SpendingLimits{MaxTransactionValue: 5}); err != nil {
+			t.Fatalf("setting spending limits: %v", err)
+		}
+
+		for range 2 {
+			if _, err := core.AccrueInterest(ctx); err != nil {
+				t.Fatalf("accruing interest: %v", err)
+			}
+		}
+
+		want := map[int]int{1: -50500, 2: -1010, 3: 0, 4: -1000, 5: -1000}
+		for id, balance := range want {
+			c, err := core.QueryByID(ctx, id)
+			if err != nil {
+				t.Fatalf("querying client: %v", err)
+			}
+			if c.Balance != balance {
+				t.Errorf("client[%d]: got %d balance want %d", id, c.Balance, balance)
+			}
+		}
+
+		day := time.Now().UTC().Truncate(24 * time.Hour)
+		a, err := store.QueryInterestAccrual(ctx, 2, day)
+		if err != nil {
+			t.Fatalf("querying accrual: %v", err)
+		}
+		if !a.Override || a.Value != 10 || a.Balance != -1000 {
+			t.Fatalf("got wrong accrual: %+v", a)
+		}
+
+		b, err := core.Billing(ctx, 1, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("billing: %v", err)
+		}
+		if tr := b.LastTransactions[0]; tr.Description != client.InterestDescription || tr.Value != 500 {
+			t.Fatalf("got wrong interest transaction: %+v", tr)
+		}
+
+		// Credits are accepted below the limit.
+		c, err := core.AddTransaction(ctx, 2, client.NewTransaction{Value: 5, Type: "c", Description: "deposit"})
+		if err != nil {
+			t.Fatalf("adding credit below the limit: %v", err)
+		}
+		if c.Balance != -1005 {
+			t.Fatalf("got %d balance want %d", c.Balance, -1005)
+		}
+	})
+}
+
+func TestCloseInvoices(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t,
+			client.Client{ID: 1, Limit: 1000, ClosingDay: 1},
+			client.Client{ID: 2, Limit: 1000, ClosingDay: 1},
+		)
+		core := client.NewCore(store)
+
+		// The last cycle of the clients closed at the start of the month.
+		now := time.Now().UTC()
+		last := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		ts := []client.Transaction{
+			{Value: 1000, Type: "c", Date: last.AddDate(0, -1, -1)},
+			{Value: 300, Type: "d", Date: last.AddDate(0, 0, -10)},
+			{Value: 200, Type: "d", Date: last},
+		}
+		for i := range ts {
+			ts[i].ID, ts[i].ClientID, ts[i].Description = uuid.New(), 1, "tr"
+			if err := store.AddTransaction(ctx, ts[i]); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+		if _, err := store.UpdateClientBalance(ctx, 1, 500); err != nil {
+			t.Fatalf("updating balance: %v", err)
+		}
+
+		// The client 2 missed three cycles.
+		old := client.Invoice{
+			ID:             uuid.New(),
+			ClientID:       2,
+			PeriodStart:    last.AddDate(0, -4, 0),
+			PeriodEnd:      last.AddDate(0, -3, 0),
+			OpeningBalance: 0,
+			ClosingBalance: 0,
+			DueDate:        last.AddDate(0, -3, 10),
+			Transactions:   []client.Transaction{},
+			Date:           last.AddDate(0, -3, 0),
+		}
+		if err := store.AddInvoice(ctx, old); err != nil {
+			t.Fatalf("adding invoice: %v", err)
+		}
+
+		n, err := core.CloseInvoices(ctx)
+		if err != nil {
+			t.Fatalf("closing invoices: %v", err)
+		}
+		if n != 4 {
+			t.Fatalf("got %d closed invoices want %d", n, 4)
+		}
+
+		if n, err := core.CloseInvoices(ctx); err != nil || n != 0 {
+			t.Fatalf("closing invoices again: got %d, %v want 0, nil", n, err)
+		}
+
+		invs, err := core.ListInvoices(ctx, 1, 1, 10)
+		if err != nil {
+			t.Fatalf("listing invoices: %v", err)
+		}
+		if len(invs) != 1 {
+			t.Fatalf("got %d invoices want %d", len(invs), 1)
+		}
+
+		inv, err := core.QueryInvoice(ctx, 1, invs[0].ID)
+		if err != nil {
+			t.Fatalf("querying invoice: %v", err)
+		}
+		switch {
+		case !inv.PeriodStart.Equal(last.AddDate(0, -1, 0)), !inv.PeriodEnd.Equal(last):
+			t.Errorf("got period [%s, %s)", inv.PeriodStart, inv.PeriodEnd)
+		case inv.OpeningBalance != 1000, inv.ClosingBalance != 700:
+			t.Errorf("got balances %d, %d want %d, %d", inv.OpeningBalance, inv.ClosingBalance, 1000, 700)
+		case !inv.DueDate.Equal(last.AddDate(0, 0, 10)):
+			t.Errorf("got due date %s", inv.DueDate)
+		case len(inv.Transactions) != 1 || inv.Transactions[0].ID != ts[1].ID:
+			t.Errorf("got wrong transactions: %+v", inv.Transactions)
+		}
+
+		invs, err = core.ListInvoices(ctx, 2, 1, 10)
+		if err != nil {
+			t.Fatalf("listing invoices: %v", err)
+		}
+		if len(invs) != 4 || !invs[0].PeriodEnd.Equal(last) || !invs[2].PeriodStart.Equal(old.PeriodEnd) {
+			t.Fatalf("got wrong invoices: %+v", invs)
+		}
+
+		if _, err := core.QueryInvoice(ctx, 2, inv.ID); !errors.Is(err, client.ErrInvoiceNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvoiceNotFound)
+		}
+	})
+}
+
+func TestSpendingLimits(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 4
+		if _, err := core.SetSpendingLimits(ctx, clientID, client.SpendingLimits{MaxDailyDebit: -1}); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvalidArgument)
+		}
+		if _, err := core.SetSpendingLimits(ctx, 99, client.SpendingLimits{}); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrNotFound)
+		}
+
+		l := client.SpendingLimits{MaxDebitsPerMinute: 5, MaxDailyDebit: 1000, MaxTransactionValue: 300}
+		if _, err := core.SetSpendingLimits(ctx, clientID, l); err != nil {
+			t.Fatalf("setting spending limits: %v", err)
+		}
+
+		got, err := core.QuerySpendingLimits(ctx, clientID)
+		if err != nil {
+			t.Fatalf("querying spending limits: %v", err)
+		}
+		if got.MaxDebitsPerMinute != 5 || got.MaxDailyDebit != 1000 || got.MaxTransactionValue != 300 {
+			t.Fatalf("got wrong spending limits: %+v", got)
+		}
+
+		wantRule := func(err error, rule string) {
+			t.Helper()
+			var re *client.RuleError
+			if !errors.As(err, &re) || re.Rule != rule {
+				t.Fatalf("got err %v want rule %s", err, rule)
+			}
+			if !errors.Is(err, client.ErrTransactionDenied) {
+				t.Fatalf("got err %v want %v", err, client.ErrTransactionDenied)
+			}
+		}
+
+		nt := client.NewTransaction{Value: 301, Type: "d", Description: "big"}
+		_, err = core.AddTransaction(ctx, clientID, nt)
+		wantRule(err, client.RuleMaxTransactionValue)
+
+		// Only 5 of the concurrent debits fit in the minute.
+		var wg sync.WaitGroup
+		errs := make([]error, 10)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				nt := client.NewTransaction{Value: 100, Type: "d", Description: "small"}
+				_, errs[i] = core.AddTransaction(ctx, clientID, nt)
+			}()
+		}
+		wg.Wait()
+
+		var posted int
+		for _, err := range errs {
+			if err != nil {
+				wantRule(err, client.RuleDebitsPerMinute)
+				continue
+			}
+			posted++
+		}
+		if posted != 5 {
+			t.Fatalf("got %d posted debits want %d", posted, 5)
+		}
+
+		l.MaxDebitsPerMinute = 0
+		if _, err := core.SetSpendingLimits(ctx, clientID, l); err != nil {
+			t.Fatalf("setting spending limits: %v", err)
+		}
+
+		nt = client.NewTransaction{Value: 300, Type: "d", Description: "small"}
+		if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		nt = client.NewTransaction{Value: 201, Type: "d", Description: "small"}
+		_, err = core.AddTransaction(ctx, clientID, nt)
+		wantRule(err, client.RuleDailyDebit)
+
+		// Credits are not limited.
+		nt = client.NewTransaction{Value: 5000, Type: "c", Description: "credit"}
+		if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+			t.Fatalf("adding credit: %v", err)
+		}
+
+		_, err = core.AddTransaction(ctx, 2, client.NewTransaction{Value: 80001, Type: "d", Description: "limit"})
+		wantRule(err, client.RuleCreditLimit)
+	})
+}
+
+// ruleFunc adapts a function to the client.Rule interface.
+type ruleFunc func(client.Client, client.Transaction) client.Verdict
+
+func (ruleFunc) Name() string { return "func" }
+
+func (f ruleFunc) Evaluate(ctx context.Context, s client.RuleStore, c client.Client, t client.Transaction) (client.Verdict, error) {
+	return f(c, t), nil
+}
+
+func TestRules(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+
+		var evaluated []string
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...), client.WithRules(
+			client.CreditLimitRule{},
+			client.BlockedDescriptionsRule{Descriptions: []string{"Golpe"}},
+			client.ValueCapRule{Max: 500, Action: client.Flag},
+			ruleFunc(func(c client.Client, t client.Transaction) client.Verdict {
+				evaluated = append(evaluated, t.Description)
+				return client.Approved
+			}),
+		))
+
+		clientID := 1
+		_, err := core.AddTransaction(ctx, clientID, client.NewTransaction{Value: 10, Type: "c", Description: "golpe"})
+		var re *client.RuleError
+		if !errors.As(err, &re) || re.Rule != client.RuleBlockedDescription || !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("got err %v want rule %s", err, client.RuleBlockedDescription)
+		}
+
+		_, err = core.AddTransaction(ctx, clientID, client.NewTransaction{Value: 100001, Type: "d", Description: "limit"})
+		if !errors.As(err, &re) || re.Rule != client.RuleCreditLimit {
+			t.Fatalf("got err %v want rule %s", err, client.RuleCreditLimit)
+		}
+
+		c, err := core.AddTransaction(ctx, clientID, client.NewTransaction{Value: 501, Type: "d", Description: "flagged"})
+		if err != nil {
+			t.Fatalf("adding flagged transaction: %v", err)
+		}
+		if c.Balance != -501 {
+			t.Fatalf("got %d balance want %d", c.Balance, -501)
+		}
+		if _, err := core.AddTransaction(ctx, clientID, client.NewTransaction{Value: 500, Type: "c", Description: "ok"}); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+
+		// The rules after a denial are not evaluated.
+		if want := []string{"flagged", "ok"}; !cmp.Equal(evaluated, want) {
+			t.Fatalf("got evaluated %v want %v", evaluated, want)
+		}
+
+		fs, err := core.ListTransactionFlags(ctx, clientID, 1, 10)
+		if err != nil {
+			t.Fatalf("listing flags: %v", err)
+		}
+		if len(fs) != 1 || fs[0].Rule != client.RuleValueCap || fs[0].Reason == "" {
+			t.Fatalf("got wrong flags: %+v", fs)
+		}
+	})
+}
+
+func TestParseRules(t *testing.T) {
+	data := []byte(`{"rules": [
+		{"type": "credit_limit"},
+		{"type": "daily_debit"},
+		{"type": "blocked_description", "descriptions": ["golpe"]},
+		{"type": "value_cap", "max": 1000, "action": "flag"},
+		{"type": "debits_per_minute"},
+		{"type": "max_transaction_value"}
+	]}`)
+
+	rules, err := client.ParseRules(data)
+	if err != nil {
+		t.Fatalf("parsing rules: %v", err)
+	}
+
+	want := []client.Rule{
+		client.CreditLimitRule{},
+		client.DailyDebitRule{},
+		client.BlockedDescriptionsRule{Descriptions: []string{"golpe"}, Action: client.Deny},
+		client.ValueCapRule{Max: 1000, Action: client.Flag},
+		client.DebitsPerMinuteRule{},
+		client.MaxTransactionValueRule{},
+	}
+	if diff := cmp.Diff(want, rules); diff != "" {
+		t.Fatalf("got different rules: %s", diff)
+	}
+
+	invalid := []string{
+		`{"rules": [{"type": "unknown"}]}`,
+		`{"rules": [{"type": "credit_limit", "action": "flag"}]}`,
+		`{"rules": [{"type": "value_cap", "max": 0}]}`,
+		`{"rules": [{"type": "value_cap", "max": 10, "action": "approve"}]}`,
+		`{"rules": [{"type": "blocked_description"}]}`,
+		`{"rules": {}}`,
+		`{"rules": []}`,
+		`{"rules": [{"type": "credit_limit"}, {"type": "daily_debit"}, {"type": "debits_per_minute"}]}`,
+		`{"rules": [{"type": "daily_debit"}, {"type": "debits_per_minute"}, {"type": "max_transaction_value"}]}`,
+	}
+	for _, data := range invalid {
+		if _, err := client.ParseRules([]byte(data)); err == nil {
+			t.Errorf("%s: parsed invalid rules", data)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t, memstore.DefaultClients()...)
+		core := client.NewCore(store)
+
+		for id := 1; id <= 2; id++ {
+			nt := client.NewTransaction{Value: 1000, Type: "d", Description: "debit"}
+			if _, err := core.AddTransaction(ctx, id, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+
+		// Client 1 drifted and client 3 has a transaction below the limit
+		// without its balance.
+		if _, err := store.UpdateClientBalance(ctx, 1, -900); err != nil {
+			t.Fatalf("updating balance: %v", err)
+		}
+		tr := client.Transaction{ID: uuid.New(), ClientID: 3, Value: 1000001, Type: "d", Description: "over", Date: time.Now()}
+		if err := store.AddTransaction(ctx, tr); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+
+		want := []client.Reconciliation{
+			{ClientID: 1, Balance: -900, Computed: -1000, Limit: 100000},
+			{ClientID: 3, Balance: 0, Computed: -1000001, Limit: 1000000},
+		}
+		rs, err := core.Reconcile(ctx, false)
+		if err != nil {
+			t.Fatalf("reconciling: %v", err)
+		}
+		if diff := cmp.Diff(want, rs); diff != "" {
+			t.Fatalf("got different reconciliations: %s", diff)
+		}
+		if c, _ := core.QueryByID(ctx, 1); c.Balance != -900 {
+			t.Fatalf("got %d balance want %d", c.Balance, -900)
+		}
+
+		rs, err = core.Reconcile(ctx, true)
+		if err != nil {
+			t.Fatalf("reconciling: %v", err)
+		}
+		want[0].Fixed, want[1].Fixed = true, true
+		if diff := cmp.Diff(want, rs); diff != "" {
+			t.Fatalf("got different reconciliations: %s", diff)
+		}
+
+		// The balances are fixed, client 3 is still below the limit.
+		rs, err = core.Reconcile(ctx, false)
+		if err != nil {
+			t.Fatalf("reconciling: %v", err)
+		}
+		if len(rs) != 1 || rs[0].ClientID != 3 || rs[0].Drift() != 0 || !rs[0].OverLimit() {
+			t.Fatalf("got wrong reconciliations: %+v", rs)
+		}
+	})
+}
+
+func TestBalanceAt(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 2
+		before := time.Now().UTC()
+
+		nts := []client.NewTransaction{
+			{Value: 1000, Type: "c", Description: "credit"},
+			{Value: 300, Type: "d", Description: "debit"},
+			{Value: 50, Type: "d", Description: "debit"},
+		}
+		want := []int{1000, 700, 650}
+		for _, nt := range nts {
+			if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+
+		b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+		if err != nil {
+			t.Fatalf("querying billing: %v", err)
+		}
+		ts := b.LastTransactions
+		slices.Reverse(ts)
+		for i, tr := range ts {
+			if tr.BalanceAfter != want[i] {
+				t.Fatalf("transaction[%d]: got balance after %d want %d", i, tr.BalanceAfter, want[i])
+			}
+		}
 
-	clientID := 2
-	c, err := core.QueryByID(ctx, clientID)
-	if err != nil {
-		t.Fatalf("failed to query clientID[%d]: %v", clientID, err)
-	}
+		tests := []struct {
+			name string
+			date time.Time
+			want int
+		}{
+			{"before", before, 0},
+			{"first", ts[0].Date, 1000},
+			{"second", ts[1].Date, 700},
+			{"now", time.Now(), 650},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := core.BalanceAt(ctx, clientID, tt.date)
+				if err != nil {
+					t.Fatalf("querying balance: %v", err)
+				}
+				if got != tt.want {
+					t.Fatalf("got balance %d want %d", got, tt.want)
+				}
+			})
+		}
 
-	nt := client.NewTransaction{
-		Value:       100,
-		Type:        "d",
-		Description: "hello",
-	}
+		if _, err := core.BalanceAt(ctx, 99, time.Now()); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrNotFound)
+		}
+	})
+}
 
-	cret, err := core.AddTransaction(ctx, clientID, nt)
-	if err != nil {
-		t.Fatalf("adding transaction: %v", err)
-	}
+// publisherFunc adapts a function to the client.Publisher interface.
+type publisherFunc func(client.OutboxEvent) error
 
-	c, err = core.QueryByID(ctx, clientID)
-	if err != nil {
-		t.Fatalf("failed to query 2nd time clientID[%d]: %v", clientID, err)
+func (f publisherFunc) Publish(ctx context.Context, e client.OutboxEvent) error {
+	return f(e)
+}
+
+func TestDispatchOutbox(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+
+		var (
+			published []string
+			fail      = map[string]bool{}
+		)
+		pub := publisherFunc(func(e client.OutboxEvent) error {
+			var p struct {
+				Description string `json:"descricao"`
+			}
+			if err := json.Unmarshal(e.Payload, &p); err != nil {
+				return err
+			}
+			if fail[p.Description] {
+				return errors.New("unavailable")
+			}
+			published = append(published, p.Description)
+			return nil
+		})
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...),
+			client.WithPublisher(pub),
+			client.WithOutboxMaxAttempts(2),
+		)
+
+		for _, d := range []string{"a", "b", "c"} {
+			nt := client.NewTransaction{Value: 10, Type: "c", Description: d}
+			if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+
+		nt := client.NewTransaction{Value: 10, Type: "c", Description: "d"}
+		if _, err := core.AddTransaction(ctx, 2, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+
+		// Denied transactions are not written to the outbox.
+		nt = client.NewTransaction{Value: 1000000, Type: "d", Description: "denied"}
+		if _, err := core.AddTransaction(ctx, 1, nt); !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("got err %v want %v", err, client.ErrTransactionDenied)
+		}
+
+		// The client's events after a failed one wait for its retry, the
+		// other clients' events don't.
+		fail["b"] = true
+		n, err := core.DispatchOutbox(ctx)
+		if err != nil {
+			t.Fatalf("dispatching outbox: %v", err)
+		}
+		if n != 2 || !slices.Equal(published, []string{"a", "d"}) {
+			t.Fatalf("got %d published %v want %v", n, published, []string{"a", "d"})
+		}
+		if n, err := core.DispatchOutbox(ctx); err != nil || n != 0 {
+			t.Fatalf("dispatching before the backoff: got %d, %v", n, err)
+		}
+
+		// The next attempt is the last one, the event is dead-lettered.
+		time.Sleep(1100 * time.Millisecond)
+		n, err = core.DispatchOutbox(ctx)
+		if err != nil {
+			t.Fatalf("dispatching outbox: %v", err)
+		}
+		if n != 1 || !slices.Equal(published, []string{"a", "d", "c"}) {
+			t.Fatalf("got %d published %v want %v", n, published, []string{"a", "d", "c"})
+		}
+
+		if n, err := core.DispatchOutbox(ctx); err != nil || n != 0 {
+			t.Fatalf("dispatching empty outbox: got %d, %v", n, err)
+		}
+	})
+}
+
+func TestPurge(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+
+		wr := webhookReceiver{status: http.StatusOK}
+		srv := httptest.NewServer(&wr)
+		defer srv.Close()
+
+		// Without a publisher no events are added to the outbox.
+		store := newStore(t, memstore.DefaultClients()...)
+		core := client.NewCore(store)
+		nt := client.NewTransaction{Value: 10, Type: "c", Description: "no outbox"}
+		if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		if es, err := store.QueryPendingOutbox(ctx, 0, 10); err != nil || len(es) != 0 {
+			t.Fatalf("got %d pending events, %v want 0", len(es), err)
+		}
+
+		core = client.NewCore(store,
+			client.WithPublisher(publisherFunc(func(client.OutboxEvent) error { return nil })),
+			client.WithOutboxRetention(0),
+			client.WithWebhookClient(srv.Client()),
+			client.WithPrivateWebhooks(true),
+			client.WithWebhookRetention(0),
+			client.WithUpdatesRetention(0),
+		)
+		wh, err := core.RegisterWebhook(ctx, 1, client.NewWebhook{URL: srv.URL})
+		if err != nil {
+			t.Fatalf("registering webhook: %v", err)
+		}
+		wr.secret = wh.Secret
+
+		for _, d := range []string{"a", "b"} {
+			nt := client.NewTransaction{Value: 10, Type: "c", Description: d}
+			if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+
+		// The pending events and deliveries are kept.
+		if n, err := core.PurgeOutbox(ctx); err != nil || n != 0 {
+			t.Fatalf("purging pending outbox: got %d, %v", n, err)
+		}
+		if n, err := core.PurgeWebhookDeliveries(ctx); err != nil || n != 0 {
+			t.Fatalf("purging pending deliveries: got %d, %v", n, err)
+		}
+
+		if _, err := core.DispatchOutbox(ctx); err != nil {
+			t.Fatalf("dispatching outbox: %v", err)
+		}
+		if _, err := core.DeliverWebhooks(ctx); err != nil {
+			t.Fatalf("delivering webhooks: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+
+		if n, err := core.PurgeOutbox(ctx); err != nil || n != 2 {
+			t.Fatalf("purging outbox: got %d, %v want 2", n, err)
+		}
+		if n, err := core.PurgeWebhookDeliveries(ctx); err != nil || n != 2 {
+			t.Fatalf("purging deliveries: got %d, %v want 2", n, err)
+		}
+
+		seq, err := store.QueryClientUpdateSeq(ctx, 1)
+		if err != nil {
+			t.Fatalf("querying update seq: %v", err)
+		}
+		if n, err := core.PurgeClientUpdates(ctx); err != nil || n != int(seq) {
+			t.Fatalf("purging client updates: got %d, %v want %d", n, err, seq)
+		}
+
+		// The Seqs of the purged updates are not reused.
+		if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		us, err := store.QueryClientUpdates(ctx, 1, 0, 10)
+		if err != nil {
+			t.Fatalf("querying client updates: %v", err)
+		}
+		if len(us) == 0 || us[0].Seq != seq+1 {
+			t.Fatalf("got updates %+v want the first with seq %d", us, seq+1)
+		}
+	})
+}
+
+// webhookReceiver is an endpoint that checks the signature of the webhook
+// notifications and records the valid ones.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []webhookNotification
+	before   func()
+}
+
+type webhookNotification struct {
+	ID          uuid.UUID `json:"id"`
+	Event       string    `json:"evento"`
+	ClientID    int       `json:"cliente_id"`
+	Rule        string    `json:"regra"`
+	Transaction struct {
+		Description  string `json:"descricao"`
+		BalanceAfter *int   `json:"saldo_apos"`
+	} `json:"transacao"`
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The before hook runs once, outside the lock.
+	wr.mu.Lock()
+	before := wr.before
+	wr.before = nil
+	wr.mu.Unlock()
+	if before != nil {
+		before()
 	}
 
-	if diff := cmp.Diff(cret, c); diff != "" {
-		t.Fatalf("got diferent clients: %s", diff)
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	var ts int64
+	var sig string
+	fmt.Sscanf(strings.Replace(r.Header.Get(client.WebhookSignatureHeader), ",", " ", 1), "t=%d v1=%s", &ts, &sig)
+	if sig != client.SignWebhook(wr.secret, ts, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
 	}
 
-	if c.Balance != -100 {
-		t.Fatalf("got %d balance want %d", c.Balance, -100)
+	var n webhookNotification
+	if err := json.Unmarshal(body, &n); err != nil || n.Event != r.Header.Get(client.WebhookEventHeader) {
+		http.Error(w, "invalid notification", http.StatusBadRequest)
+		return
 	}
+	if wr.status != http.StatusOK {
+		w.WriteHeader(wr.status)
+		return
+	}
+	wr.received = append(wr.received, n)
+}
 
+func (wr *webhookReceiver) set(status int) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.status = status
 }
 
-func TestConsistency(t *testing.T) {
-	ctx := context.Background()
-	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
-	t.Cleanup(teardown)
+func (wr *webhookReceiver) setBefore(fn func()) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.before = fn
+}
 
-	store := clientdb.NewStore(log, database)
-	core := client.NewCore(store)
+func (wr *webhookReceiver) events() []webhookNotification {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return slices.Clone(wr.received)
+}
 
-	n := 1000
-	nts := make([]testNT, n)
-	for i := 0; i < n; i++ {
-		nts[i] = randomNewTransaction()
-	}
+func TestWebhooks(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+
+		wr := webhookReceiver{status: http.StatusOK}
+		srv := httptest.NewServer(&wr)
+		defer srv.Close()
+
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...),
+			client.WithWebhookClient(srv.Client()),
+			client.WithWebhookRetry(2, time.Millisecond),
+			client.WithPrivateWebhooks(true),
+		)
+
+		if _, err := core.RegisterWebhook(ctx, 1, client.NewWebhook{URL: "ftp://example.com"}); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvalidArgument)
+		}
+		if _, err := core.RegisterWebhook(ctx, 99, client.NewWebhook{URL: srv.URL}); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrNotFound)
+		}
+
+		wh, err := core.RegisterWebhook(ctx, 1, client.NewWebhook{URL: srv.URL})
+		if err != nil {
+			t.Fatalf("registering webhook: %v", err)
+		}
+		wr.secret = wh.Secret
+
+		nt := client.NewTransaction{Value: 10, Type: "c", Description: "posted"}
+		if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		nt = client.NewTransaction{Value: 1000000, Type: "d", Description: "denied"}
+		if _, err := core.AddTransaction(ctx, 1, nt); !errors.Is(err, client.ErrTransactionDenied) {
+			t.Fatalf("got err %v want %v", err, client.ErrTransactionDenied)
+		}
+
+		n, err := core.DeliverWebhooks(ctx)
+		if err != nil {
+			t.Fatalf("delivering webhooks: %v", err)
+		}
+		got := wr.events()
+		if n != 2 || len(got) != 2 {
+			t.Fatalf("got %d delivered %d received want 2", n, len(got))
+		}
+		if e := got[0]; e.Event != client.WebhookTransactionPosted || e.ClientID != 1 || e.Transaction.BalanceAfter == nil || *e.Transaction.BalanceAfter != 10 {
+			t.Fatalf("got posted notification %+v", e)
+		}
+		if e := got[1]; e.Event != client.WebhookTransactionDenied || e.Rule != client.RuleCreditLimit || e.Transaction.Description != "denied" {
+			t.Fatalf("got denied notification %+v", e)
+		}
+
+		// A failing endpoint is retried until the maximum attempts.
+		wr.set(http.StatusServiceUnavailable)
+		nt = client.NewTransaction{Value: 10, Type: "c", Description: "failed"}
+		if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		for range 2 {
+			time.Sleep(5 * time.Millisecond)
+			if n, err := core.DeliverWebhooks(ctx); err != nil || n != 0 {
+				t.Fatalf("delivering to failing endpoint: got %d, %v", n, err)
+			}
+		}
+
+		ds, err := core.ListWebhookDeliveries(ctx, 1, wh.ID, 1, 10)
+		if err != nil {
+			t.Fatalf("listing deliveries: %v", err)
+		}
+		if len(ds) != 3 {
+			t.Fatalf("got %d deliveries want 3", len(ds))
+		}
+		failed := ds[0]
+		if failed.Status != client.DeliveryFailed || failed.Attempts != 2 || failed.ResponseStatus != http.StatusServiceUnavailable {
+			t.Fatalf("got failed delivery %+v", failed)
+		}
+		if n, err := core.DeliverWebhooks(ctx); err != nil || n != 0 {
+			t.Fatalf("delivering failed delivery: got %d, %v", n, err)
+		}
+
+		// The redelivery keeps the event ID.
+		if _, err := core.RedeliverWebhook(ctx, 1, uuid.New(), failed.ID); !errors.Is(err, client.ErrDeliveryNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrDeliveryNotFound)
+		}
+		wr.set(http.StatusOK)
+
+		// The worker doesn't attempt the redelivery while it's attempted
+		// by RedeliverWebhook.
+		wr.setBefore(func() {
+			time.Sleep(5 * time.Millisecond)
+			if n, err := core.DeliverWebhooks(ctx); err != nil || n != 0 {
+				t.Errorf("delivering during redelivery: got %d, %v", n, err)
+			}
+		})
+		d, err := core.RedeliverWebhook(ctx, 1, wh.ID, failed.ID)
+		if err != nil {
+			t.Fatalf("redelivering: %v", err)
+		}
+		if d.ID == failed.ID || d.EventID != failed.EventID || d.Status != client.DeliveryDelivered {
+			t.Fatalf("got redelivery %+v of %+v", d, failed)
+		}
+		got = wr.events()
+		if len(got) != 3 || got[2].ID != failed.EventID || got[2].Transaction.Description != "failed" {
+			t.Fatalf("got notifications %+v", got)
+		}
+
+		ws, err := core.ListWebhooks(ctx, 1)
+		if err != nil {
+			t.Fatalf("listing webhooks: %v", err)
+		}
+		if len(ws) != 1 || ws[0].ID != wh.ID {
+			t.Fatalf("got webhooks %+v want %+v", ws, wh)
+		}
+	})
+}
+
+func TestPrivateWebhooks(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		urls := []string{
+			"http://localhost:8080",
+			"http://api.localhost",
+			"http://127.0.0.1",
+			"http://[::1]:8080",
+			"http://10.0.0.1",
+			"http://192.168.0.1",
+			"http://169.254.169.254/latest/meta-data",
+			"http://[fe80::1]",
+			"http://0.0.0.0",
+			"http://[::ffff:127.0.0.1]",
+		}
+		for _, u := range urls {
+			if _, err := core.RegisterWebhook(ctx, 1, client.NewWebhook{URL: u}); !errors.Is(err, client.ErrInvalidArgument) {
+				t.Errorf("registering %s: got err %v want %v", u, err, client.ErrInvalidArgument)
+			}
+		}
+		if _, err := core.RegisterWebhook(ctx, 1, client.NewWebhook{URL: "https://203.0.113.10/hook"}); err != nil {
+			t.Fatalf("registering public webhook: %v", err)
+		}
+
+		// The addresses are also checked when they are dialed.
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+		resp, err := client.NewWebhookClient(time.Second).Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if err == nil || !strings.Contains(err.Error(), "not public") {
+			t.Fatalf("dialing a private address: got err %v", err)
+		}
+	})
+}
+
+// listenerFunc adapts a function to the client.Listener interface.
+type listenerFunc func(ctx context.Context, fn func(clientID int)) error
 
-	for _, tt := range nts {
-		t.Run(fmt.Sprint(tt), func(t *testing.T) {
-			t.Parallel()
+func (f listenerFunc) Listen(ctx context.Context, fn func(clientID int)) error {
+	return f(ctx, fn)
+}
+
+func TestSubscribe(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// The test notifies the subscribers, and they never poll.
+		notifiers := make(chan func(clientID int))
+		listener := listenerFunc(func(ctx context.Context, fn func(clientID int)) error {
+			notifiers <- fn
+			<-ctx.Done()
+			return nil
+		})
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...),
+			client.WithListener(listener),
+			client.WithUpdatesPoll(time.Hour),
+		)
+		go core.ListenUpdates(ctx)
+		notify := <-notifiers
 
-			out := make(chan billingErr)
+		subscribe := func(ctx context.Context, after int64) (<-chan []client.ClientUpdate, <-chan error) {
+			batches := make(chan []client.ClientUpdate)
+			done := make(chan error, 1)
 			go func() {
-				b, err := core.Billing(ctx, tt.clientID)
-				out <- billingErr{b, err}
+				done <- core.Subscribe(ctx, 1, after, func(us []client.ClientUpdate) error {
+					batches <- us
+					return nil
+				})
 			}()
+			return batches, done
+		}
 
-			c, err := core.AddTransaction(ctx, tt.clientID, tt.nt)
-			if err != nil {
-				if !errors.Is(err, client.ErrTransactionDenied) {
-					t.Fatalf("transaction err: %v", err)
+		nt := client.NewTransaction{Value: 10, Type: "c", Description: "before"}
+		if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+
+		// New subscribers only receive the updates committed after they
+		// subscribe.
+		subCtx, stop := context.WithCancel(ctx)
+		batches, done := subscribe(subCtx, -1)
+		if us := <-batches; len(us) != 0 {
+			t.Fatalf("got %d updates committed before subscribing", len(us))
+		}
+
+		nt = client.NewTransaction{Value: 5, Type: "d", Description: "after"}
+		if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		if _, err := core.ChangeLimit(ctx, 1, 200000, "raise"); err != nil {
+			t.Fatalf("changing limit: %v", err)
+		}
+		notify(1)
+
+		us := <-batches
+		var types []string
+		for _, u := range us {
+			types = append(types, u.Type)
+		}
+		want := []string{client.UpdateTransaction, client.UpdateBalance, client.UpdateLimit}
+		if !slices.Equal(types, want) || us[0].Seq != 3 || us[2].Seq != 5 {
+			t.Fatalf("got updates %v from seq %d want %v from seq 3", types, us[0].Seq, want)
+		}
+		var b struct {
+			Balance int `json:"saldo"`
+			Limit   int `json:"limite"`
+		}
+		if err := json.Unmarshal(us[2].Data, &b); err != nil || b.Balance != 5 || b.Limit != 200000 {
+			t.Fatalf("got limit update %s, %v", us[2].Data, err)
+		}
+
+		stop()
+		if err := <-done; err != nil {
+			t.Fatalf("subscribing: %v", err)
+		}
+
+		// Resuming from the last update received replays the missed ones.
+		batches, done = subscribe(ctx, 4)
+		if us := <-batches; len(us) != 1 || us[0].Seq != 5 {
+			t.Fatalf("got resumed updates %+v", us)
+		}
+
+		if err := core.Subscribe(ctx, 99, -1, nil); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrNotFound)
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("subscribing: %v", err)
+		}
+	})
+}
+
+func TestFeed(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t, memstore.DefaultClients()...)
+		core := client.NewCore(store)
+
+		// A transaction that commits after a later one was sequenced.
+		added, commit, done := make(chan struct{}), make(chan struct{}), make(chan error, 1)
+		late := client.Transaction{
+			ID:          uuid.New(),
+			ClientID:    1,
+			Value:       7,
+			Type:        "c",
+			Description: "late",
+			Date:        time.Now().UTC().Round(time.Microsecond),
+		}
+		go func() {
+			done <- store.ExecUnderTx(ctx, func(tx client.Store) error {
+				if err := tx.AddTransaction(ctx, late); err != nil {
+					return err
 				}
+				close(added)
+				<-commit
+				return nil
+			})
+		}()
+		<-added
+
+		nt := client.NewTransaction{Value: 10, Type: "c", Description: "early"}
+		if _, err := core.AddTransaction(ctx, 2, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+		if n, err := core.SequenceLedger(ctx); err != nil || n != 1 {
+			t.Fatalf("sequencing ledger: got %d, %v want 1", n, err)
+		}
+
+		f, err := core.Feed(ctx, 0, 10)
+		if err != nil {
+			t.Fatalf("querying feed: %v", err)
+		}
+		if len(f.Entries) != 1 || f.Entries[0].Transaction.Description != "early" || f.Next != 1 {
+			t.Fatalf("got feed %+v want the early transaction", f)
+		}
+
+		close(commit)
+		if err := <-done; err != nil {
+			t.Fatalf("committing late transaction: %v", err)
+		}
+
+		// The reader that passed the early transaction still gets the late
+		// one.
+		if f, err = core.Feed(ctx, f.Next, 10); err != nil || len(f.Entries) != 0 || f.Next != 1 {
+			t.Fatalf("got feed %+v, %v before sequencing", f, err)
+		}
+		if n, err := core.SequenceLedger(ctx); err != nil || n != 1 {
+			t.Fatalf("sequencing ledger: got %d, %v want 1", n, err)
+		}
+		f, err = core.Feed(ctx, f.Next, 10)
+		if err != nil {
+			t.Fatalf("querying feed: %v", err)
+		}
+		if len(f.Entries) != 1 || f.Entries[0].Seq != 2 || f.Entries[0].Transaction.ID != late.ID {
+			t.Fatalf("got feed %+v want the late transaction with seq 2", f)
+		}
+
+		if _, err := core.Feed(ctx, -1, 10); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvalidArgument)
+		}
+	})
+}
+
+func TestStreamBilling(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 1
+		for range 2 {
+			nt := client.NewTransaction{Value: 100, Type: "c", Description: "credit"}
+			if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+
+		// The client is not locked while the transactions are streamed.
+		var streamed int
+		b, err := core.StreamBilling(ctx, clientID, client.TransactionFilter{}, func(tr client.Transaction) error {
+			streamed++
+			if streamed > 1 {
+				return nil
 			}
 
-			if c.Balance < -c.Limit {
-				t.Errorf("insconsistency found on AddTransaction: %+v", c)
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+
+			nt := client.NewTransaction{Value: 50, Type: "d", Description: "debit"}
+			_, err := core.AddTransaction(ctx, clientID, nt)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("streaming billing: %v", err)
+		}
+		if streamed != 2 || b.Balance != 200 {
+			t.Fatalf("got %d transactions and %d balance want %d and %d", streamed, b.Balance, 2, 200)
+		}
+
+		// A billing ending before now has the balance at the end date.
+		end := b.Date
+		time.Sleep(time.Millisecond)
+		nt := client.NewTransaction{Value: 30, Type: "d", Description: "debit"}
+		if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+			t.Fatalf("adding transaction: %v", err)
+		}
+
+		streamed = 0
+		b, err = core.StreamBilling(ctx, clientID, client.TransactionFilter{EndDate: &end}, func(client.Transaction) error {
+			streamed++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("streaming billing: %v", err)
+		}
+		if streamed != 2 || b.Balance != 200 || !b.Date.Equal(end) {
+			t.Fatalf("got %d transactions, %d balance and date %v want %d, %d and %v", streamed, b.Balance, b.Date, 2, 200, end)
+		}
+	})
+}
+
+func TestStatement(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 3
+		n := 25
+		for i := range n {
+			nt := client.NewTransaction{Value: i + 1, Type: "c", Description: "credit"}
+			if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
 			}
+		}
 
-			ret := <-out
-			if ret.err != nil {
-				t.Fatalf("billing error: %v", err)
+		var got []client.Transaction
+		var pages int
+		cursor := ""
+		for {
+			st, err := core.Statement(ctx, clientID, client.TransactionFilter{}, cursor, 10)
+			if err != nil {
+				t.Fatalf("statement: %v", err)
 			}
-			if ret.billing.Balance < -ret.billing.Limit {
-				b, err := core.Billing(ctx, tt.clientID)
-				if err != nil {
-					t.Fatalf("retrying billing: %v", err)
+			pages++
+
+			got = append(got, st.Transactions...)
+
+			// A transaction added while paginating must not
+			// change the next pages.
+			if pages == 1 {
+				nt := client.NewTransaction{Value: 1000, Type: "c", Description: "new"}
+				if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+					t.Fatalf("adding transaction: %v", err)
 				}
-				t.Errorf("insconsistency found on Billing:\n%+v\nbilling retried:\n%v\n", ret.billing, b)
 			}
-		})
-	}
 
-	clientIDs := []int{1, 2, 3, 4, 5}
-	for _, clientID := range clientIDs {
-		b, err := core.Billing(ctx, clientID)
-		if err != nil {
-			t.Fatalf("failed to get billing from clientID[%d]: %v", clientID, err)
+			if st.NextCursor == "" {
+				break
+			}
+			cursor = st.NextCursor
 		}
 
-		ts, err := store.QueryTransactions(ctx, clientID, 1, n)
-		if err != nil {
-			t.Fatalf("failed to get tranasctions from clientID[%d]: %v", clientID, err)
+		if pages != 3 {
+			t.Errorf("got %d pages want %d", pages, 3)
+		}
+		if len(got) != n {
+			t.Fatalf("got %d transactions want %d", len(got), n)
+		}
+		for i, tr := range got {
+			if tr.Value != n-i {
+				t.Fatalf("transaction[%d]: got value %d want %d", i, tr.Value, n-i)
+			}
 		}
-		total := sumTransactions(ts)
 
-		if b.Balance != total {
-			t.Fatalf("inconsistency between balance and trasactions: balance[%d]\ncalculated total[%d]\nbilling[%+v]\ntransactions[%+v]", b.Balance, total, b, ts)
+		if _, err := core.Statement(ctx, clientID, client.TransactionFilter{}, "invalid", 10); !errors.Is(err, client.ErrInvalidArgument) {
+			t.Fatalf("got err %v want %v", err, client.ErrInvalidArgument)
 		}
-	}
+		if _, err := core.Statement(ctx, 9, client.TransactionFilter{}, "", 10); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("got err %v want %v", err, client.ErrNotFound)
+		}
+	})
+}
+
+func TestTransactionFilter(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+
+		clientID := 3
+		start := time.Now()
+		nts := []client.NewTransaction{
+			{Value: 100, Type: "c", Description: "a"},
+			{Value: 200, Type: "d", Description: "b"},
+			{Value: 300, Type: "c", Description: "c"},
+			{Value: 400, Type: "d", Description: "d"},
+		}
+		for _, nt := range nts {
+			if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+		end := time.Now()
 
+		debit := "d"
+		minValue, maxValue := 150, 350
+		tests := []struct {
+			name   string
+			filter client.TransactionFilter
+			want   []string
+		}{
+			{"no filter", client.TransactionFilter{}, []string{"d", "c", "b", "a"}},
+			{"debits", client.TransactionFilter{Type: &debit}, []string{"d", "b"}},
+			{"value range", client.TransactionFilter{MinValue: &minValue, MaxValue: &maxValue}, []string{"c", "b"}},
+			{"date range", client.TransactionFilter{StartDate: &start, EndDate: &end}, []string{"d", "c", "b", "a"}},
+			{"before start", client.TransactionFilter{EndDate: &start}, nil},
+		}
+		for _, tt := range tests {
+			st, err := core.Statement(ctx, clientID, tt.filter, "", 10)
+			if err != nil {
+				t.Fatalf("%s: statement: %v", tt.name, err)
+			}
+
+			var got []string
+			for _, tr := range st.Transactions {
+				got = append(got, tr.Description)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("%s: got different transactions: %s", tt.name, diff)
+			}
+		}
+
+		credit := "x"
+		invalid := []client.TransactionFilter{
+			{StartDate: &end, EndDate: &start},
+			{MinValue: &maxValue, MaxValue: &minValue},
+			{Type: &credit},
+		}
+		for _, filter := range invalid {
+			if _, err := core.Billing(ctx, clientID, filter); !errors.Is(err, client.ErrInvalidArgument) {
+				t.Errorf("%+v: got err %v want %v", filter, err, client.ErrInvalidArgument)
+			}
+		}
+	})
+}
+
+func TestConsistency(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		store := newStore(t, memstore.DefaultClients()...)
+		core := client.NewCore(store)
+
+		n := 1000
+		nts := make([]testNT, n)
+		for i := 0; i < n; i++ {
+			nts[i] = randomNewTransaction()
+		}
+
+		// The parallel subtests are grouped, so they finish before the
+		// balances are checked.
+		t.Run("transactions", func(t *testing.T) {
+			for _, tt := range nts {
+				t.Run(fmt.Sprint(tt), func(t *testing.T) {
+					t.Parallel()
+
+					out := make(chan billingErr)
+					go func() {
+						b, err := core.Billing(ctx, tt.clientID, client.TransactionFilter{})
+						out <- billingErr{b, err}
+					}()
+
+					c, err := core.AddTransaction(ctx, tt.clientID, tt.nt)
+					if err != nil {
+						if !errors.Is(err, client.ErrTransactionDenied) {
+							t.Fatalf("transaction err: %v", err)
+						}
+					}
+
+					if c.Balance < -c.Limit {
+						t.Errorf("insconsistency found on AddTransaction: %+v", c)
+					}
+
+					ret := <-out
+					if ret.err != nil {
+						t.Fatalf("billing error: %v", err)
+					}
+					if ret.billing.Balance < -ret.billing.Limit {
+						b, err := core.Billing(ctx, tt.clientID, client.TransactionFilter{})
+						if err != nil {
+							t.Fatalf("retrying billing: %v", err)
+						}
+						t.Errorf("insconsistency found on Billing:\n%+v\nbilling retried:\n%v\n", ret.billing, b)
+					}
+				})
+			}
+		})
+
+		clientIDs := []int{1, 2, 3, 4, 5}
+		for _, clientID := range clientIDs {
+			b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+			if err != nil {
+				t.Fatalf("failed to get billing from clientID[%d]: %v", clientID, err)
+			}
+
+			ts, err := store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, n)
+			if err != nil {
+				t.Fatalf("failed to get tranasctions from clientID[%d]: %v", clientID, err)
+			}
+			total := sumTransactions(ts)
+
+			if b.Balance != total {
+				t.Fatalf("inconsistency between balance and trasactions: balance[%d]\ncalculated total[%d]\nbilling[%+v]\ntransactions[%+v]", b.Balance, total, b, ts)
+			}
+		}
+	})
 }
 
 func sumTransactions(ts []client.Transaction) int {
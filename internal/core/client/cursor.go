@@ -0,0 +1,73 @@
+package client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cursor points to a transaction in a client's history. Transactions are
+// ordered by date and ID, so a cursor is stable while new transactions are
+// added.
+type Cursor struct {
+	Date time.Time
+	ID   uuid.UUID
+}
+
+// IsZero reports whether the cursor points to the start of the history.
+func (c Cursor) IsZero() bool {
+	return c.Date.IsZero() && c.ID == uuid.Nil
+}
+
+// Precedes reports whether the cursor comes before the transaction in the
+// most recent first order, that is, the transaction is older.
+func (c Cursor) Precedes(t Transaction) bool {
+	if !t.Date.Equal(c.Date) {
+		return t.Date.Before(c.Date)
+	}
+	return t.ID.String() < c.ID.String()
+}
+
+// String encodes the cursor as an opaque string.
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	s := c.Date.UTC().Format(time.RFC3339Nano) + "," + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// ParseCursor decodes a cursor created by Cursor.String. The empty string is
+// the zero cursor.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", ErrInvalidArgument)
+	}
+
+	date, id, ok := strings.Cut(string(bs), ",")
+	if !ok {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", ErrInvalidArgument)
+	}
+
+	var c Cursor
+	if c.Date, err = time.Parse(time.RFC3339Nano, date); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor date: %w", ErrInvalidArgument)
+	}
+	if c.ID, err = uuid.Parse(id); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor id: %w", ErrInvalidArgument)
+	}
+
+	return c, nil
+}
+
+func cursorOf(t Transaction) Cursor {
+	return Cursor{Date: t.Date, ID: t.ID}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// Weights of the check digits of the CPF and the CNPJ. The first check digit
+// uses the weights after the first one.
+var (
+	cpfWeights  = []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2}
+	cnpjWeights = []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}
+)
+
+// documentDigits returns the digits of a CPF or CNPJ. It returns false if the
+// document has characters other than digits and the punctuation of the
+// formatted numbers, like 123.456.789-09 and 12.345.678/0001-95.
+func documentDigits(doc string) (string, bool) {
+	var b strings.Builder
+	for _, r := range doc {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '.' || r == '-' || r == '/':
+		default:
+			return "", false
+		}
+	}
+
+	return b.String(), true
+}
+
+// normalizeDocument returns the digits of a valid CPF or CNPJ.
+func normalizeDocument(doc string) (string, error) {
+	digits, ok := documentDigits(doc)
+	if !ok {
+		return "", ErrInvalidArgument
+	}
+
+	ds := make([]int, len(digits))
+	for i, r := range digits {
+		ds[i] = int(r - '0')
+	}
+	if allEqual(ds) {
+		return "", ErrInvalidArgument
+	}
+
+	var weights []int
+	switch len(ds) {
+	case 11:
+		weights = cpfWeights
+	case 14:
+		weights = cnpjWeights
+	default:
+		return "", ErrInvalidArgument
+	}
+
+	// The last two digits check the ones before them.
+	n := len(ds) - 2
+	if checkDigit(ds[:n], weights[1:]) != ds[n] || checkDigit(ds[:n+1], weights) != ds[n+1] {
+		return "", ErrInvalidArgument
+	}
+
+	return digits, nil
+}
+
+// checkDigit returns the modulo 11 check digit of the digits with the
+// weights.
+func checkDigit(ds []int, weights []int) int {
+	var sum int
+	for i, d := range ds {
+		sum += d * weights[i]
+	}
+
+	r := sum % 11
+	if r < 2 {
+		return 0
+	}
+	return 11 - r
+}
+
+// allEqual reports whether all the digits are equal. Such documents pass the
+// check digits but are not valid.
+func allEqual(ds []int) bool {
+	for _, d := range ds {
+		if d != ds[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// validEmail reports whether email is a bare address, without a display
+// name.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
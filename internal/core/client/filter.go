@@ -0,0 +1,56 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransactionFilter selects transactions. Nil fields are not used. The date
+// range includes the StartDate and excludes the EndDate, the value range
+// includes both ends.
+type TransactionFilter struct {
+	Type      *string
+	StartDate *time.Time
+	EndDate   *time.Time
+	MinValue  *int
+	MaxValue  *int
+}
+
+// Validate checks if the filter is valid.
+func (f TransactionFilter) Validate() error {
+	if f.Type != nil && *f.Type != "c" && *f.Type != "d" {
+		return fmt.Errorf("invalid type %q: %w", *f.Type, ErrInvalidArgument)
+	}
+	if f.StartDate != nil && f.EndDate != nil && !f.StartDate.Before(*f.EndDate) {
+		return fmt.Errorf("start date must be before end date: %w", ErrInvalidArgument)
+	}
+	if f.MinValue != nil && *f.MinValue < 0 {
+		return fmt.Errorf("negative min value: %w", ErrInvalidArgument)
+	}
+	if f.MaxValue != nil && *f.MaxValue < 0 {
+		return fmt.Errorf("negative max value: %w", ErrInvalidArgument)
+	}
+	if f.MinValue != nil && f.MaxValue != nil && *f.MinValue > *f.MaxValue {
+		return fmt.Errorf("min value must not be greater than max value: %w", ErrInvalidArgument)
+	}
+
+	return nil
+}
+
+// Match reports whether the transaction is selected by the filter.
+func (f TransactionFilter) Match(t Transaction) bool {
+	switch {
+	case f.Type != nil && t.Type != *f.Type:
+		return false
+	case f.StartDate != nil && t.Date.Before(*f.StartDate):
+		return false
+	case f.EndDate != nil && !t.Date.Before(*f.EndDate):
+		return false
+	case f.MinValue != nil && t.Value < *f.MinValue:
+		return false
+	case f.MaxValue != nil && t.Value > *f.MaxValue:
+		return false
+	}
+
+	return true
+}
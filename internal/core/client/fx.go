@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// DefaultCurrency is the currency of the clients created without one.
+const DefaultCurrency = "BRL"
+
+// SetFXRate records a new rate to convert amounts between two currencies.
+// The most recent rate is used by the following conversions.
+func (c *Core) SetFXRate(ctx context.Context, nr NewFXRate) (FXRate, error) {
+	r := FXRate{
+		ID:   uuid.New(),
+		From: nr.From,
+		To:   nr.To,
+		Rate: nr.Rate,
+		Date: time.Now().UTC().Round(time.Microsecond),
+	}
+
+	switch {
+	case !validCurrency(r.From), !validCurrency(r.To):
+		return FXRate{}, ErrInvalidArgument
+	case r.From == r.To:
+		return FXRate{}, ErrInvalidArgument
+	case r.Rate < 1:
+		return FXRate{}, ErrInvalidArgument
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.SetFXRate")
+	defer span.End()
+
+	if err := c.store.AddFXRate(ctx, r); err != nil {
+		return FXRate{}, err
+	}
+
+	return r, nil
+}
+
+// ListFXRates returns the most recent rate of each pair of currencies.
+func (c *Core) ListFXRates(ctx context.Context) ([]FXRate, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ListFXRates")
+	defer span.End()
+
+	return c.store.QueryFXRates(ctx)
+}
+
+// exchange converts the value of t from the currency to the client's
+// currency using the most recent rate. Transactions in the client's currency
+// are returned unchanged.
+func exchange(ctx context.Context, tx Store, client Client, t Transaction, currency string) (Transaction, error) {
+	if currency == "" || currency == client.Currency {
+		return t, nil
+	}
+	if !validCurrency(currency) {
+		return Transaction{}, ErrInvalidArgument
+	}
+
+	r, err := tx.QueryFXRate(ctx, currency, client.Currency)
+	if err != nil {
+		if errors.Is(err, ErrFXRateNotFound) {
+			return Transaction{}, fmt.Errorf("%s to %s: %w", currency, client.Currency, err)
+		}
+		return Transaction{}, fmt.Errorf("failed to query fx rate: %w", err)
+	}
+
+	value := convert(t.Value, r.Rate)
+	if value < 1 {
+		return Transaction{}, fmt.Errorf("converted value is zero: %w", ErrInvalidArgument)
+	}
+
+	t.Currency = currency
+	t.OriginalValue = t.Value
+	t.FXRate = r.Rate
+	t.Value = value
+
+	return t, nil
+}
+
+// convert converts the value by the rate, rounding half away from zero.
+func convert(value, rate int) int {
+	v := new(big.Int).Mul(big.NewInt(int64(value)), big.NewInt(int64(rate)))
+	q, m := v.QuoRem(v, big.NewInt(RateScale), new(big.Int))
+	if m.Int64()*2 >= RateScale {
+		q.Add(q, big.NewInt(1))
+	}
+	return int(q.Int64())
+}
+
+// validCurrency reports whether the currency is an ISO 4217 like code, three
+// upper case letters.
+func validCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// Authorize reserves funds of the client without posting a transaction.
+// The reserved value reduces the available balance until the hold is
+// captured, voided or expires.
+func (c *Core) Authorize(ctx context.Context, clientID int, nh NewHold) (Hold, Client, error) {
+	h := Hold{
+		ID:          uuid.New(),
+		ClientID:    clientID,
+		Value:       nh.Value,
+		Description: nh.Description,
+		Status:      HoldActive,
+	}
+	if err := h.validate(); err != nil {
+		return Hold{}, Client{}, err
+	}
+
+	var client Client
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Authorize.Tx.Inside")
+		defer span.End()
+
+		h.Date = time.Now().UTC().Round(time.Microsecond)
+		h.DateUpdated = h.Date
+		h.ExpiresAt = h.Date.Add(c.holdTTL)
+
+		var err error
+		client, err = tx.QueryByID(ctx, clientID)
+		if err != nil {
+			return err
+		}
+
+		if err := c.checkStatus(client, "d"); err != nil {
+			return err
+		}
+		if client.Available()-h.Value < -client.Limit {
+			return &RuleError{Rule: RuleCreditLimit}
+		}
+
+		if err := tx.AddHold(ctx, h); err != nil {
+			return fmt.Errorf("failed to add hold: %w", err)
+		}
+
+		client, err = tx.UpdateClientReserved(ctx, clientID, client.Reserved+h.Value)
+		if err != nil {
+			return fmt.Errorf("failed to update reserved: %w", err)
+		}
+
+		return addBalanceUpdate(ctx, tx, UpdateBalance, client)
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Authorize.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Hold{}, Client{}, err
+	}
+
+	return h, client, nil
+}
+
+// Capture posts a debit of value reserved by an active hold and releases the
+// rest of the hold. A zero value captures the whole hold.
+func (c *Core) Capture(ctx context.Context, clientID int, holdID uuid.UUID, value int) (Client, error) {
+	if value < 0 {
+		return Client{}, ErrInvalidArgument
+	}
+
+	var client Client
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Capture.Tx.Inside")
+		defer span.End()
+
+		var (
+			h   Hold
+			err error
+		)
+		client, h, err = lockActiveHold(ctx, tx, clientID, holdID)
+		if err != nil {
+			return err
+		}
+
+		// Set time only after the hold is locked.
+		now := time.Now().UTC().Round(time.Microsecond)
+		if !h.ExpiresAt.After(now) {
+			return ErrHoldClosed
+		}
+
+		if value == 0 {
+			value = h.Value
+		}
+		if value > h.Value {
+			return fmt.Errorf("capture greater than hold: %w", ErrInvalidArgument)
+		}
+
+		t := Transaction{
+			ID:          uuid.New(),
+			ClientID:    clientID,
+			Value:       value,
+			Type:        "d",
+			Description: h.Description,
+			Date:        now,
+		}
+
+		// The hold already reserved the value, release it before
+		// posting the debit.
+		reserved := client.Reserved - h.Value
+		client.Reserved = reserved
+
+		if client, err = c.post(ctx, tx, client, t); err != nil {
+			return err
+		}
+
+		h.Status = HoldCaptured
+		h.CapturedValue = value
+		h.TransactionID = t.ID
+		h.DateUpdated = now
+
+		client, err = closeHold(ctx, tx, h, reserved)
+		return err
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Capture.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// Void releases the funds reserved by an active hold.
+func (c *Core) Void(ctx context.Context, clientID int, holdID uuid.UUID) (Client, error) {
+	var client Client
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Void.Tx.Inside")
+		defer span.End()
+
+		var (
+			h   Hold
+			err error
+		)
+		client, h, err = lockActiveHold(ctx, tx, clientID, holdID)
+		if err != nil {
+			return err
+		}
+
+		// Set time only after the hold is locked.
+		now := time.Now().UTC().Round(time.Microsecond)
+		if !h.ExpiresAt.After(now) {
+			return ErrHoldClosed
+		}
+
+		h.Status = HoldVoided
+		h.DateUpdated = now
+
+		client, err = closeHold(ctx, tx, h, client.Reserved-h.Value)
+		return err
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Void.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// ExpireHolds releases the funds reserved by the holds that expired and
+// returns how many holds expired.
+func (c *Core) ExpireHolds(ctx context.Context) (int, error) {
+	const batch = 100
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ExpireHolds")
+	defer span.End()
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	hs, err := c.store.QueryExpiredHolds(ctx, now, batch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query expired holds: %w", err)
+	}
+
+	var n int
+	for _, h := range hs {
+		fn := func(tx Store) error {
+			client, h, err := lockActiveHold(ctx, tx, h.ClientID, h.ID)
+			if err != nil {
+				return err
+			}
+
+			h.Status = HoldExpired
+			h.DateUpdated = now
+
+			_, err = closeHold(ctx, tx, h, client.Reserved-h.Value)
+			return err
+		}
+
+		err := c.store.ExecUnderTx(ctx, fn)
+		switch {
+		case err == nil:
+			n++
+		case errors.Is(err, ErrHoldClosed):
+			// Captured or voided after the query.
+		default:
+			return n, fmt.Errorf("failed to expire hold[%s]: %w", h.ID, err)
+		}
+	}
+
+	return n, nil
+}
+
+// lockActiveHold locks the client and the hold. It returns ErrHoldClosed if
+// the hold is not active, the callers check the expiration.
+func lockActiveHold(ctx context.Context, tx Store, clientID int, holdID uuid.UUID) (Client, Hold, error) {
+	client, err := tx.QueryByID(ctx, clientID)
+	if err != nil {
+		return Client{}, Hold{}, err
+	}
+
+	h, err := tx.QueryHoldByID(ctx, clientID, holdID)
+	if err != nil {
+		return Client{}, Hold{}, err
+	}
+
+	if h.Status != HoldActive {
+		return Client{}, Hold{}, ErrHoldClosed
+	}
+
+	return client, h, nil
+}
+
+// closeHold updates the hold and the client's reserved value.
+func closeHold(ctx context.Context, tx Store, h Hold, reserved int) (Client, error) {
+	if err := tx.UpdateHold(ctx, h); err != nil {
+		return Client{}, fmt.Errorf("failed to update hold: %w", err)
+	}
+
+	client, err := tx.UpdateClientReserved(ctx, h.ClientID, reserved)
+	if err != nil {
+		return Client{}, fmt.Errorf("failed to update reserved: %w", err)
+	}
+
+	if err := addBalanceUpdate(ctx, tx, UpdateBalance, client); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+func (h Hold) validate() error {
+	switch {
+	case h.ClientID < 1:
+		return ErrNotFound
+	case h.Value < 1:
+		return ErrInvalidArgument
+	case len(h.Description) < 1 || len(h.Description) > 10:
+		return ErrInvalidArgument
+	case h.Description == InterestDescription:
+		return fmt.Errorf("reserved description: %w", ErrInvalidArgument)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// InterestDescription is the description of the overdraft interest
+// transactions. It is reserved, clients can't use it.
+const InterestDescription = "juros"
+
+// WithInterestRate sets the daily overdraft interest rate, scaled by
+// RateScale. The default is zero, no interest is charged.
+func WithInterestRate(rate int) Option {
+	return func(c *Core) {
+		c.interestRate = rate
+	}
+}
+
+// AccrueInterest charges the daily overdraft interest of the clients with a
+// negative balance and returns how many were charged. Each client is charged
+// at most once a day, so it is safe to call it again after a failure. The
+// interest is posted even if it exceeds the client's limit, in which case the
+// accrual records the override. The clients the interest is denied to by the
+// account status or the other rules are skipped.
+func (c *Core) AccrueInterest(ctx context.Context) (int, error) {
+	const batch = 100
+
+	if c.interestRate <= 0 {
+		return 0, nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.AccrueInterest")
+	defer span.End()
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	var n, after int
+	for {
+		cs, err := c.store.QueryOverdrawnClients(ctx, after, batch)
+		if err != nil {
+			return n, fmt.Errorf("failed to query overdrawn clients: %w", err)
+		}
+
+		for _, cl := range cs {
+			var charged bool
+			err := c.store.ExecUnderTx(ctx, func(tx Store) error {
+				var err error
+				charged, err = c.accrue(ctx, tx, cl.ID, day)
+				return err
+			})
+			switch {
+			case errors.Is(err, ErrTransactionDenied):
+				// Retried in the next run.
+			case err != nil:
+				return n, fmt.Errorf("failed to accrue interest of client[%d]: %w", cl.ID, err)
+			case charged:
+				n++
+			}
+		}
+
+		if len(cs) < batch {
+			return n, nil
+		}
+		after = cs[len(cs)-1].ID
+	}
+}
+
+// accrue charges the client's interest of the day, if it was not charged yet.
+func (c *Core) accrue(ctx context.Context, tx Store, clientID int, day time.Time) (bool, error) {
+	client, err := tx.QueryByID(ctx, clientID)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.QueryInterestAccrual(ctx, clientID, day)
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.Is(err, ErrAccrualNotFound):
+		return false, fmt.Errorf("failed to query interest accrual: %w", err)
+	}
+
+	if client.Balance >= 0 {
+		return false, nil
+	}
+
+	value := convert(-client.Balance, c.interestRate)
+	if value < 1 {
+		return false, nil
+	}
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	t := Transaction{
+		ID:          uuid.New(),
+		ClientID:    clientID,
+		Value:       value,
+		Type:        "d",
+		Description: InterestDescription,
+		Date:        now,
+	}
+	a := InterestAccrual{
+		ClientID:      clientID,
+		Day:           day,
+		Balance:       client.Balance,
+		Rate:          c.interestRate,
+		Value:         value,
+		TransactionID: t.ID,
+		Date:          now,
+	}
+
+	// Only the credit limit is overridden, the interest is still denied by
+	// the account status and the other rules. The denied post writes
+	// nothing, so it's posted again without the credit limit rule.
+	var re *RuleError
+	_, err = c.post(ctx, tx, client, t)
+	if errors.As(err, &re) && re.Rule == RuleCreditLimit {
+		a.Override = true
+		_, err = c.post(ctx, tx, client, t, RuleCreditLimit)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.AddInterestAccrual(ctx, a); err != nil {
+		return false, fmt.Errorf("failed to add interest accrual: %w", err)
+	}
+
+	return true, nil
+}
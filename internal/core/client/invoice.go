@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// DefaultClosingDay is the day of the month the billing cycles of the
+// clients created without one close.
+const DefaultClosingDay = 1
+
+// WithInvoiceDueDays sets how many days after the closing date an invoice
+// is due. The default is 10 days.
+func WithInvoiceDueDays(days int) Option {
+	return func(c *Core) {
+		c.invoiceDueDays = days
+	}
+}
+
+// CloseInvoices closes the billing cycles that ended and returns how many
+// invoices were closed. Cycles missed while the job was not running are
+// closed one invoice each.
+func (c *Core) CloseInvoices(ctx context.Context) (int, error) {
+	const batch = 100
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.CloseInvoices")
+	defer span.End()
+
+	now := time.Now().UTC()
+
+	var n int
+	for page := 1; ; page++ {
+		cs, err := c.store.QueryClients(ctx, page, batch)
+		if err != nil {
+			return n, fmt.Errorf("failed to query clients: %w", err)
+		}
+
+		for _, cl := range cs {
+			var closed int
+			err := c.store.ExecUnderTx(ctx, func(tx Store) error {
+				var err error
+				closed, err = c.closeInvoices(ctx, tx, cl.ID, now)
+				return err
+			})
+			if err != nil {
+				return n, fmt.Errorf("failed to close invoices of client[%d]: %w", cl.ID, err)
+			}
+			n += closed
+		}
+
+		if len(cs) < batch {
+			return n, nil
+		}
+	}
+}
+
+// closeInvoices closes the client's billing cycles that ended before now.
+// The first invoice of a client covers the last cycle.
+func (c *Core) closeInvoices(ctx context.Context, tx Store, clientID int, now time.Time) (int, error) {
+	client, err := tx.QueryByID(ctx, clientID)
+	if err != nil {
+		return 0, err
+	}
+
+	last := closingDate(now, client.ClosingDay)
+
+	var (
+		start   time.Time
+		opening int
+		first   bool
+	)
+	inv, err := tx.QueryLastInvoice(ctx, clientID)
+	switch {
+	case err == nil:
+		start, opening = inv.PeriodEnd, inv.ClosingBalance
+	case errors.Is(err, ErrInvoiceNotFound):
+		start, first = last.AddDate(0, -1, 0), true
+	default:
+		return 0, fmt.Errorf("failed to query last invoice: %w", err)
+	}
+	if !start.Before(last) {
+		return 0, nil
+	}
+
+	var ts []Transaction
+	filter := TransactionFilter{StartDate: &start}
+	err = tx.StreamTransactions(ctx, clientID, filter, func(t Transaction) error {
+		ts = append(ts, t)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to stream transactions: %w", err)
+	}
+
+	// The opening balance of the first invoice is the balance before the
+	// transactions of the period.
+	if first {
+		opening = client.Balance
+		for _, t := range ts {
+			opening -= t.SignedValue()
+		}
+	}
+
+	var n int
+	for end := closingDate(start, client.ClosingDay).AddDate(0, 1, 0); !end.After(last); end = end.AddDate(0, 1, 0) {
+		inv := Invoice{
+			ID:             uuid.New(),
+			ClientID:       clientID,
+			PeriodStart:    start,
+			PeriodEnd:      end,
+			OpeningBalance: opening,
+			ClosingBalance: opening,
+			DueDate:        end.AddDate(0, 0, c.invoiceDueDays),
+			Transactions:   []Transaction{},
+			Date:           now.Round(time.Microsecond),
+		}
+		for len(ts) > 0 && ts[0].Date.Before(end) {
+			inv.Transactions = append(inv.Transactions, ts[0])
+			inv.ClosingBalance += ts[0].SignedValue()
+			ts = ts[1:]
+		}
+
+		if err := tx.AddInvoice(ctx, inv); err != nil {
+			return n, fmt.Errorf("failed to add invoice: %w", err)
+		}
+		n++
+
+		start, opening = end, inv.ClosingBalance
+	}
+
+	return n, nil
+}
+
+// ListInvoices returns a page of the client's invoices without their
+// transactions.
+func (c *Core) ListInvoices(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]Invoice, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ListInvoices")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return nil, err
+	}
+
+	return c.store.QueryInvoices(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+// QueryInvoice returns a client's invoice with its transactions.
+func (c *Core) QueryInvoice(ctx context.Context, clientID int, invoiceID uuid.UUID) (Invoice, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.QueryInvoice")
+	defer span.End()
+
+	return c.store.QueryInvoiceByID(ctx, clientID, invoiceID)
+}
+
+// closingDate returns the most recent closing date of a cycle that closes
+// on the day of the month, up to t.
+func closingDate(t time.Time, day int) time.Time {
+	t = t.UTC()
+	c := time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, time.UTC)
+	if c.After(t) {
+		c = c.AddDate(0, -1, 0)
+	}
+	return c
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rschio/rinha/internal/web"
+)
+
+// SequenceLedger appends the committed transactions not yet in the ledger to
+// it and returns how many were appended.
+//
+// The Seqs are not assigned when the transactions are added, as concurrent
+// transactions commit in any order and a reader of the ledger could pass the
+// Seq of a transaction that commits later. The ledger is locked while the
+// Seqs are assigned, so they only increase, and a transaction committed
+// after a call is appended by the next one.
+func (c *Core) SequenceLedger(ctx context.Context) (int, error) {
+	const batch = 1000
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.SequenceLedger")
+	defer span.End()
+
+	var n int
+	for {
+		count, err := c.sequenceLedger(ctx, batch)
+		n += count
+		if err != nil || count < batch {
+			return n, err
+		}
+	}
+}
+
+// sequenceLedger appends up to limit transactions to the ledger in a single
+// transaction.
+func (c *Core) sequenceLedger(ctx context.Context, limit int) (int, error) {
+	var n int
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.SequenceLedger.Tx.Inside")
+		defer span.End()
+
+		seq, err := tx.QueryLedgerSeq(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query ledger seq: %w", err)
+		}
+
+		ts, err := tx.QueryUnsequencedTransactions(ctx, limit)
+		if err != nil {
+			return fmt.Errorf("failed to query unsequenced transactions: %w", err)
+		}
+		if len(ts) == 0 {
+			return nil
+		}
+
+		es := make([]LedgerEntry, len(ts))
+		for i, t := range ts {
+			es[i] = LedgerEntry{Seq: seq + int64(i) + 1, Transaction: t}
+		}
+		if err := tx.AddLedgerEntries(ctx, es); err != nil {
+			return fmt.Errorf("failed to add ledger entries: %w", err)
+		}
+		n = len(es)
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.SequenceLedger.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Feed returns up to limit transactions of all clients from the ledger with
+// a Seq greater than after. The Seqs are stable, so the Next cursor of the
+// feed can be stored by the reader and used after restarts.
+func (c *Core) Feed(ctx context.Context, after int64, limit int) (Feed, error) {
+	const maxLimit = 1000
+	if after < 0 || limit < 1 || limit > maxLimit {
+		return Feed{}, ErrInvalidArgument
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Feed")
+	defer span.End()
+
+	es, err := c.store.QueryLedger(ctx, after, limit)
+	if err != nil {
+		return Feed{}, fmt.Errorf("failed to query ledger: %w", err)
+	}
+
+	f := Feed{Entries: es, Next: after}
+	if len(es) > 0 {
+		f.Next = es[len(es)-1].Seq
+	}
+
+	return f, nil
+}
@@ -6,30 +6,396 @@ import (
 	"github.com/google/uuid"
 )
 
+// Client is an account and the profile of its owner. The Document is the
+// CPF or CNPJ with only its digits and is unique among the clients.
 type Client struct {
-	ID      int
-	Limit   int
-	Balance int
+	ID          int
+	Currency    string
+	Limit       int
+	Balance     int
+	Reserved    int
+	ClosingDay  int
+	Status      string
+	Name        string
+	Document    string
+	Email       string
+	DateCreated time.Time
 }
 
+// Available returns the balance left after the active holds.
+func (c Client) Available() int {
+	return c.Balance - c.Reserved
+}
+
+// NewClient is a client to be created. An empty Currency is the
+// DefaultCurrency and a zero ClosingDay is the DefaultClosingDay. The
+// Document may be formatted, like 123.456.789-09, and the profile fields
+// may be empty.
+type NewClient struct {
+	ID         int
+	Currency   string
+	Limit      int
+	ClosingDay int
+	Name       string
+	Document   string
+	Email      string
+}
+
+// NewTransaction is a transaction requested by a client. An empty Currency
+// is the client's currency.
 type NewTransaction struct {
 	Value       int
 	Type        string
 	Description string
+
+	// Omitted when empty, so the request hashes of the idempotency keys
+	// don't change for requests without a currency.
+	Currency string `json:",omitempty"`
 }
 
+// Transaction is a transaction posted to a client, its Value is in the
+// client's currency. Transactions requested in another currency keep the
+// requested Currency and OriginalValue and the FXRate used to convert them.
+// BalanceAfter is the client's balance right after the transaction.
 type Transaction struct {
-	ID          uuid.UUID
-	ClientID    int
+	ID            uuid.UUID
+	ClientID      int
+	Value         int
+	Type          string
+	Description   string
+	Date          time.Time
+	ReversalOf    uuid.UUID
+	ReversedBy    uuid.UUID
+	TransferID    uuid.UUID
+	Currency      string
+	OriginalValue int
+	FXRate        int
+	BalanceAfter  int
+}
+
+// SpendingLimits are the limits of the client's debits. A zero limit is not
+// enforced.
+type SpendingLimits struct {
+	ClientID            int
+	MaxDebitsPerMinute  int
+	MaxDailyDebit       int
+	MaxTransactionValue int
+	DateUpdated         time.Time
+}
+
+// TransactionFlag records a transaction flagged by a rule.
+type TransactionFlag struct {
+	ID            uuid.UUID
+	ClientID      int
+	TransactionID uuid.UUID
+	Rule          string
+	Reason        string
+	Date          time.Time
+}
+
+// DebitTotals are the number and the total value of a client's debits.
+type DebitTotals struct {
+	Count int
+	Total int
+}
+
+type Statement struct {
+	Transactions []Transaction
+	NextCursor   string
+}
+
+type NewTransfer struct {
+	FromID      int
+	ToID        int
+	Value       int
+	Description string
+}
+
+type Transfer struct {
+	ID   uuid.UUID
+	From Client
+	To   Client
+	Date time.Time
+}
+
+// Set of client account status.
+const (
+	StatusActive = "active"
+	StatusFrozen = "frozen"
+	StatusClosed = "closed"
+)
+
+// StatusChange records a transition of a client's account status.
+type StatusChange struct {
+	ID        uuid.UUID
+	ClientID  int
+	OldStatus string
+	NewStatus string
+	Reason    string
+	TraceID   string
+	Date      time.Time
+}
+
+// Set of hold status.
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+type NewHold struct {
 	Value       int
-	Type        string
 	Description string
+}
+
+type Hold struct {
+	ID            uuid.UUID
+	ClientID      int
+	Value         int
+	Description   string
+	Status        string
+	CapturedValue int
+	TransactionID uuid.UUID
+	Date          time.Time
+	DateUpdated   time.Time
+	ExpiresAt     time.Time
+}
+
+// Set of scheduled transaction status.
+const (
+	ScheduledPending  = "pending"
+	ScheduledPosted   = "posted"
+	ScheduledDenied   = "denied"
+	ScheduledCanceled = "canceled"
+)
+
+type NewScheduledTransaction struct {
+	Value        int
+	Type         string
+	Description  string
+	Currency     string
+	ScheduledFor time.Time
+}
+
+// ScheduledTransaction is a transaction to be posted at a future date. Once
+// processed it references the posted transaction or records the reason it
+// was denied.
+type ScheduledTransaction struct {
+	ID            uuid.UUID
+	ClientID      int
+	Value         int
+	Type          string
+	Description   string
+	Currency      string
+	ScheduledFor  time.Time
+	Status        string
+	TransactionID uuid.UUID
+	Reason        string
+	Date          time.Time
+	DateUpdated   time.Time
+}
+
+// RateScale is the scale of FXRate.Rate, rates are stored as integers with
+// six decimal places.
+const RateScale = 1_000_000
+
+type NewFXRate struct {
+	From string
+	To   string
+	Rate int
+}
+
+// FXRate is the amount of To currency bought by one unit of From currency,
+// scaled by RateScale.
+type FXRate struct {
+	ID   uuid.UUID
+	From string
+	To   string
+	Rate int
+	Date time.Time
+}
+
+// InterestAccrual is the overdraft interest charged to a client on a day.
+// Override is set when the interest was posted beyond the client's limit.
+type InterestAccrual struct {
+	ClientID      int
+	Day           time.Time
+	Balance       int
+	Rate          int
+	Value         int
+	TransactionID uuid.UUID
+	Override      bool
+	Date          time.Time
+}
+
+// Invoice is the closed billing cycle of a client. It holds the transactions
+// posted from PeriodStart, inclusive, to PeriodEnd, exclusive.
+type Invoice struct {
+	ID             uuid.UUID
+	ClientID       int
+	PeriodStart    time.Time
+	PeriodEnd      time.Time
+	OpeningBalance int
+	ClosingBalance int
+	DueDate        time.Time
+	Transactions   []Transaction
+	Date           time.Time
+}
+
+type LimitChange struct {
+	ID       uuid.UUID
+	ClientID int
+	OldLimit int
+	NewLimit int
+	Reason   string
+	TraceID  string
+	Date     time.Time
+}
+
+// BalanceAdjustment records a change of a client's balance made without a
+// transaction, to repair a drift of the balance.
+type BalanceAdjustment struct {
+	ID         uuid.UUID
+	ClientID   int
+	OldBalance int
+	NewBalance int
+	Reason     string
+	Date       time.Time
+}
+
+// Reconciliation is the result of the reconciliation of a client. Balance is
+// the stored balance, before any fix, and Computed is the balance computed
+// from the client's transactions.
+type Reconciliation struct {
+	ClientID int
+	Balance  int
+	Computed int
+	Limit    int
+	Fixed    bool
+}
+
+// Drift returns how much the stored balance differs from the computed one.
+func (r Reconciliation) Drift() int {
+	return r.Balance - r.Computed
+}
+
+// OverLimit reports whether the computed balance is below the client's limit.
+func (r Reconciliation) OverLimit() bool {
+	return r.Computed < -r.Limit
+}
+
+// Set of outbox event status.
+const (
+	OutboxPending   = "pending"
+	OutboxPublished = "published"
+	OutboxDead      = "dead"
+)
+
+// OutboxEvent is an event written to the outbox in the same transaction as
+// the change it records. The events are published in Seq order, ID
+// identifies the event to the consumers. Dead events failed to be published
+// too many times, LastError is the error of the last attempt.
+type OutboxEvent struct {
+	ID          uuid.UUID
+	Seq         int64
+	Type        string
+	ClientID    int
+	Payload     []byte
+	Status      string
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	Date        time.Time
+	DateUpdated time.Time
+}
+
+type NewWebhook struct {
+	URL string
+}
+
+// Webhook is an endpoint registered by a client to be notified about its
+// transactions. The notifications are signed with the Secret.
+type Webhook struct {
+	ID       uuid.UUID
+	ClientID int
+	URL      string
+	Secret   string
+	Date     time.Time
+}
+
+// Set of webhook events.
+const (
+	WebhookTransactionPosted = "transacao.efetivada"
+	WebhookTransactionDenied = "transacao.negada"
+)
+
+// Set of webhook delivery status.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is a notification of an event sent to a webhook. The
+// redeliveries of an event are new deliveries with the same EventID.
+// ResponseStatus and LastError are the results of the last attempt.
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	WebhookID      uuid.UUID
+	ClientID       int
+	EventID        uuid.UUID
+	Event          string
+	Payload        []byte
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	LastError      string
+	NextAttempt    time.Time
+	Date           time.Time
+	DateUpdated    time.Time
+}
+
+// LedgerEntry is a transaction in the ledger of all clients. Seq is assigned
+// after the transaction commits, so the entries are ordered by the time they
+// were sequenced and the Seqs have no gaps.
+type LedgerEntry struct {
+	Seq         int64
+	Transaction Transaction
+}
+
+// Feed is a page of the ledger. Next is the cursor of the following page, it
+// is the requested cursor when there are no new entries.
+type Feed struct {
+	Entries []LedgerEntry
+	Next    int64
+}
+
+// ClientUpdate is a change of a client streamed to its subscribers. Seq
+// orders the updates of each client and Data is the JSON encoded change.
+type ClientUpdate struct {
+	ClientID int
+	Seq      int64
+	Type     string
+	Data     []byte
+	Date     time.Time
+}
+
+type IdempotencyKey struct {
+	ClientID    int
+	Key         string
+	RequestHash string
+	Response    []byte
 	Date        time.Time
+	ExpiresAt   time.Time
 }
 
 type Billing struct {
+	Currency         string
 	Balance          int
+	Reserved         int
 	Limit            int
 	Date             time.Time
 	LastTransactions []Transaction
+	LimitChanges     []LimitChange
 }
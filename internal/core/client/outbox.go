@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// TransactionCreated is the type of the outbox events of the posted
+// transactions.
+const TransactionCreated = "TransactionCreated"
+
+// Publisher publishes the outbox events to the downstream systems. An event
+// may be published more than once, the consumers must discard the events
+// with an ID they already received.
+type Publisher interface {
+	Publish(ctx context.Context, e OutboxEvent) error
+}
+
+// WithPublisher sets the publisher of the outbox events. Without a
+// publisher no events are added to the outbox.
+func WithPublisher(p Publisher) Option {
+	return func(c *Core) {
+		c.publisher = p
+	}
+}
+
+// WithOutboxMaxAttempts sets how many times the publication of an outbox
+// event is attempted before it is dead-lettered. The default is 10.
+func WithOutboxMaxAttempts(n int) Option {
+	return func(c *Core) {
+		c.outboxAttempts = n
+	}
+}
+
+// WithOutboxRetention sets for how long the published and dead outbox
+// events are kept. The default is 7 days.
+func WithOutboxRetention(d time.Duration) Option {
+	return func(c *Core) {
+		c.outboxRetain = d
+	}
+}
+
+// Backoff of the failed publications, doubled after each attempt.
+const (
+	outboxBackoff    = time.Second
+	outboxMaxBackoff = 10 * time.Minute
+)
+
+// DispatchOutbox publishes the pending outbox events and returns how many
+// were published. The order is only kept per client: the Seq is assigned
+// when the event is added, not when it's committed, but the events of a
+// client are added under the client's lock. A failed event is retried with
+// exponential backoff and the client's events after it wait. After the
+// maximum attempts the event is marked as dead and the next ones proceed.
+func (c *Core) DispatchOutbox(ctx context.Context) (int, error) {
+	const batch = 100
+
+	if c.publisher == nil {
+		return 0, nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.DispatchOutbox")
+	defer span.End()
+
+	var (
+		n       int
+		after   int64
+		waiting = make(map[int]bool)
+	)
+	for {
+		es, err := c.store.QueryPendingOutbox(ctx, after, batch)
+		if err != nil {
+			return n, fmt.Errorf("failed to query pending outbox: %w", err)
+		}
+
+		for _, e := range es {
+			after = e.Seq
+			if waiting[e.ClientID] {
+				continue
+			}
+
+			now := time.Now().UTC().Round(time.Microsecond)
+			if e.NextAttempt.After(now) {
+				waiting[e.ClientID] = true
+				continue
+			}
+
+			err := c.publisher.Publish(ctx, e)
+
+			e.Attempts++
+			e.DateUpdated = now
+			switch {
+			case err == nil:
+				e.Status = OutboxPublished
+				e.LastError = ""
+			case e.Attempts >= c.outboxAttempts:
+				e.Status = OutboxDead
+				e.LastError = err.Error()
+			default:
+				e.LastError = err.Error()
+				e.NextAttempt = now.Add(backoff(e.Attempts, outboxBackoff, outboxMaxBackoff))
+			}
+
+			if err := c.store.UpdateOutboxEvent(ctx, e); err != nil {
+				return n, fmt.Errorf("failed to update outbox event[%s]: %w", e.ID, err)
+			}
+
+			switch e.Status {
+			case OutboxPublished:
+				n++
+			case OutboxPending:
+				waiting[e.ClientID] = true
+			}
+		}
+
+		if len(es) < batch {
+			return n, nil
+		}
+	}
+}
+
+// PurgeOutbox deletes the published and dead outbox events older than the
+// retention and returns how many were deleted.
+func (c *Core) PurgeOutbox(ctx context.Context) (int, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.PurgeOutbox")
+	defer span.End()
+
+	return c.store.DeleteOutboxEvents(ctx, time.Now().UTC().Add(-c.outboxRetain))
+}
+
+// transactionPayload is the JSON representation of a posted transaction in
+// the outbox events and in the client updates.
+type transactionPayload struct {
+	ID           uuid.UUID `json:"id"`
+	ClientID     int       `json:"cliente_id"`
+	Value        int       `json:"valor"`
+	Type         string    `json:"tipo"`
+	Description  string    `json:"descricao"`
+	Date         time.Time `json:"realizada_em"`
+	BalanceAfter int       `json:"saldo_apos"`
+}
+
+func toTransactionPayload(t Transaction) transactionPayload {
+	return transactionPayload{
+		ID:           t.ID,
+		ClientID:     t.ClientID,
+		Value:        t.Value,
+		Type:         t.Type,
+		Description:  t.Description,
+		Date:         t.Date,
+		BalanceAfter: t.BalanceAfter,
+	}
+}
+
+// transactionCreated returns the outbox event of the posted transaction.
+func transactionCreated(t Transaction) (OutboxEvent, error) {
+	data, err := json.Marshal(toTransactionPayload(t))
+	if err != nil {
+		return OutboxEvent{}, fmt.Errorf("failed to encode outbox event: %w", err)
+	}
+
+	e := OutboxEvent{
+		ID:          uuid.New(),
+		Type:        TransactionCreated,
+		ClientID:    t.ClientID,
+		Payload:     data,
+		Status:      OutboxPending,
+		NextAttempt: t.Date,
+		Date:        t.Date,
+		DateUpdated: t.Date,
+	}
+
+	return e, nil
+}
+
+// backoff returns the delay before the next attempt, base doubled after
+// each of the attempts made, up to limit.
+func backoff(attempts int, base, limit time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// ReconcileReason is the reason of the balance adjustments made by Reconcile.
+const ReconcileReason = "reconcile"
+
+// Reconcile computes the balance of each client from its transactions and
+// returns the clients whose stored balance drifted from the computed one or
+// whose computed balance is below the limit. With fix, the drifted balances
+// are replaced by the computed ones and each adjustment is recorded. Clients
+// below the limit are only reported.
+func (c *Core) Reconcile(ctx context.Context, fix bool) ([]Reconciliation, error) {
+	const batch = 100
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Reconcile")
+	defer span.End()
+
+	var rs []Reconciliation
+	for page := 1; ; page++ {
+		cs, err := c.store.QueryClients(ctx, page, batch)
+		if err != nil {
+			return rs, fmt.Errorf("failed to query clients: %w", err)
+		}
+
+		for _, cl := range cs {
+			var r Reconciliation
+			err := c.store.ExecUnderTx(ctx, func(tx Store) error {
+				var err error
+				r, err = reconcile(ctx, tx, cl.ID, fix)
+				return err
+			})
+			if err != nil {
+				return rs, fmt.Errorf("failed to reconcile client[%d]: %w", cl.ID, err)
+			}
+			if r.Drift() != 0 || r.OverLimit() {
+				rs = append(rs, r)
+			}
+		}
+
+		if len(cs) < batch {
+			return rs, nil
+		}
+	}
+}
+
+// reconcile reconciles the client's balance. The client is locked, so the
+// balance and the transactions don't change while they are compared.
+func reconcile(ctx context.Context, tx Store, clientID int, fix bool) (Reconciliation, error) {
+	client, err := tx.QueryByID(ctx, clientID)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+
+	sum, err := tx.SumTransactions(ctx, clientID)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to sum transactions: %w", err)
+	}
+
+	r := Reconciliation{
+		ClientID: clientID,
+		Balance:  client.Balance,
+		Computed: sum,
+		Limit:    client.Limit,
+	}
+	if !fix || r.Drift() == 0 {
+		return r, nil
+	}
+
+	a := BalanceAdjustment{
+		ID:         uuid.New(),
+		ClientID:   clientID,
+		OldBalance: r.Balance,
+		NewBalance: r.Computed,
+		Reason:     ReconcileReason,
+		Date:       time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := tx.AddBalanceAdjustment(ctx, a); err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to add balance adjustment: %w", err)
+	}
+
+	client, err = tx.UpdateClientBalance(ctx, clientID, r.Computed)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to update balance: %w", err)
+	}
+	if err := addBalanceUpdate(ctx, tx, UpdateBalance, client); err != nil {
+		return Reconciliation{}, err
+	}
+	r.Fixed = true
+
+	return r, nil
+}
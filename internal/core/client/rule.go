@@ -0,0 +1,322 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// Set of names of the built-in rules.
+const (
+	RuleCreditLimit         = "credit_limit"
+	RuleMaxTransactionValue = "max_transaction_value"
+	RuleDebitsPerMinute     = "debits_per_minute"
+	RuleDailyDebit          = "daily_debit"
+	RuleValueCap            = "value_cap"
+	RuleBlockedDescription  = "blocked_description"
+)
+
+// Rule approves, denies or flags the transactions posted to the clients.
+type Rule interface {
+	// Name identifies the rule in the denials and in the flags.
+	Name() string
+
+	// Evaluate decides about the transaction t proposed to the client. It
+	// is called inside the transaction that posts t, with the client
+	// locked.
+	Evaluate(ctx context.Context, s RuleStore, client Client, t Transaction) (Verdict, error)
+}
+
+// RuleStore is the read access to the store given to the rules.
+type RuleStore interface {
+	QueryTransactions(ctx context.Context, clientID int, filter TransactionFilter, pageNumber, rowsPerPage int) ([]Transaction, error)
+	QuerySpendingLimits(ctx context.Context, clientID int) (SpendingLimits, error)
+	QueryDebitTotals(ctx context.Context, clientID int, since time.Time) (DebitTotals, error)
+}
+
+// Action is the decision of a rule.
+type Action string
+
+// Set of actions of the rules.
+const (
+	Approve Action = "approve"
+	Deny    Action = "deny"
+	Flag    Action = "flag"
+)
+
+// Verdict is the result of a rule. Denials and flags have a reason.
+type Verdict struct {
+	Action Action
+	Reason string
+}
+
+// Approved is the verdict of the rules that approve the transaction.
+var Approved = Verdict{Action: Approve}
+
+// RuleError is returned when a rule denies a transaction. It wraps
+// ErrTransactionDenied.
+type RuleError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s: %s", ErrTransactionDenied, e.Rule)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrTransactionDenied, e.Rule, e.Reason)
+}
+
+func (e *RuleError) Unwrap() error {
+	return ErrTransactionDenied
+}
+
+// WithRules sets the rules evaluated, in order, before a transaction is
+// posted. The first denial stops the evaluation. The default is
+// DefaultRules.
+func WithRules(rules ...Rule) Option {
+	return func(c *Core) {
+		c.rules = rules
+	}
+}
+
+// DefaultRules returns the client's credit limit and spending limits rules.
+func DefaultRules() []Rule {
+	return []Rule{
+		CreditLimitRule{},
+		MaxTransactionValueRule{},
+		DebitsPerMinuteRule{},
+		DailyDebitRule{},
+	}
+}
+
+// ListTransactionFlags returns a page of the client's flagged transactions.
+func (c *Core) ListTransactionFlags(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]TransactionFlag, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ListTransactionFlags")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return nil, err
+	}
+
+	return c.store.QueryTransactionFlags(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+// evaluate evaluates the rules in order, except the ones named in skip, and
+// returns the flags raised by the transaction t. A denial is returned as a
+// RuleError.
+func (c *Core) evaluate(ctx context.Context, tx Store, client Client, t Transaction, skip ...string) ([]TransactionFlag, error) {
+	s := &ruleStore{RuleStore: tx}
+
+	var flags []TransactionFlag
+	for _, r := range c.rules {
+		if slices.Contains(skip, r.Name()) {
+			continue
+		}
+
+		v, err := r.Evaluate(ctx, s, client, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate rule %s: %w", r.Name(), err)
+		}
+
+		switch v.Action {
+		case Approve:
+		case Deny:
+			return nil, &RuleError{Rule: r.Name(), Reason: v.Reason}
+		case Flag:
+			flags = append(flags, TransactionFlag{
+				ID:            uuid.New(),
+				ClientID:      t.ClientID,
+				TransactionID: t.ID,
+				Rule:          r.Name(),
+				Reason:        v.Reason,
+				Date:          t.Date,
+			})
+		default:
+			return nil, fmt.Errorf("rule %s: unknown action %q", r.Name(), v.Action)
+		}
+	}
+
+	return flags, nil
+}
+
+// ruleStore is the RuleStore given to the rules of a transaction. The
+// spending limits are queried once and shared by the rules.
+type ruleStore struct {
+	RuleStore
+	limits map[int]SpendingLimits
+}
+
+func (s *ruleStore) QuerySpendingLimits(ctx context.Context, clientID int) (SpendingLimits, error) {
+	if l, ok := s.limits[clientID]; ok {
+		return l, nil
+	}
+
+	l, err := s.RuleStore.QuerySpendingLimits(ctx, clientID)
+	if err != nil {
+		return SpendingLimits{}, err
+	}
+
+	if s.limits == nil {
+		s.limits = make(map[int]SpendingLimits)
+	}
+	s.limits[clientID] = l
+
+	return l, nil
+}
+
+// =============================================================================
+
+// CreditLimitRule denies the debits that would leave the available balance
+// below the client's limit. Credits are always approved, they only raise the
+// balance, even if it stays below the limit after an interest charge.
+type CreditLimitRule struct{}
+
+func (CreditLimitRule) Name() string { return RuleCreditLimit }
+
+func (CreditLimitRule) Evaluate(ctx context.Context, s RuleStore, client Client, t Transaction) (Verdict, error) {
+	if t.Type == "c" {
+		return Approved, nil
+	}
+	if client.Available()-t.Value < -client.Limit {
+		return Verdict{Action: Deny, Reason: "insufficient limit"}, nil
+	}
+	return Approved, nil
+}
+
+// ValueCapRule denies, or flags, the transactions of all clients with a
+// value above Max. The zero Action denies.
+type ValueCapRule struct {
+	Max    int
+	Action Action
+}
+
+func (ValueCapRule) Name() string { return RuleValueCap }
+
+func (r ValueCapRule) Evaluate(ctx context.Context, s RuleStore, client Client, t Transaction) (Verdict, error) {
+	if t.Value > r.Max {
+		return Verdict{Action: orDeny(r.Action), Reason: fmt.Sprintf("value %d above %d", t.Value, r.Max)}, nil
+	}
+	return Approved, nil
+}
+
+// BlockedDescriptionsRule denies, or flags, the transactions with one of the
+// Descriptions. Descriptions are compared ignoring the case. The zero Action
+// denies.
+type BlockedDescriptionsRule struct {
+	Descriptions []string
+	Action       Action
+}
+
+func (BlockedDescriptionsRule) Name() string { return RuleBlockedDescription }
+
+func (r BlockedDescriptionsRule) Evaluate(ctx context.Context, s RuleStore, client Client, t Transaction) (Verdict, error) {
+	for _, d := range r.Descriptions {
+		if strings.EqualFold(d, t.Description) {
+			return Verdict{Action: orDeny(r.Action), Reason: fmt.Sprintf("description %q is blocked", t.Description)}, nil
+		}
+	}
+	return Approved, nil
+}
+
+// orDeny returns Deny for the zero action.
+func orDeny(a Action) Action {
+	if a == "" {
+		return Deny
+	}
+	return a
+}
+
+// =============================================================================
+
+// ruleConfig is the configuration of a rule in the rules file.
+type ruleConfig struct {
+	Type         string   `json:"type"`
+	Action       Action   `json:"action"`
+	Max          int      `json:"max"`
+	Descriptions []string `json:"descriptions"`
+}
+
+// ParseRules parses the rules file, a JSON object with the list of rules in
+// the order they are evaluated:
+//
+//	{"rules": [
+//		{"type": "credit_limit"},
+//		{"type": "value_cap", "max": 100000, "action": "flag"},
+//		{"type": "blocked_description", "descriptions": ["golpe"]}
+//	]}
+//
+// The action of the value_cap and blocked_description rules defaults to deny.
+// The rules of the DefaultRules enforce the client's credit limit and
+// spending limits, so they are required.
+func ParseRules(data []byte) ([]Rule, error) {
+	var file struct {
+		Rules []ruleConfig `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decoding rules: %w", err)
+	}
+
+	rules := make([]Rule, len(file.Rules))
+	names := make(map[string]bool)
+	for i, rc := range file.Rules {
+		r, err := rc.rule()
+		if err != nil {
+			return nil, fmt.Errorf("rule[%d]: %w", i, err)
+		}
+		rules[i] = r
+		names[r.Name()] = true
+	}
+
+	for _, r := range DefaultRules() {
+		if !names[r.Name()] {
+			return nil, fmt.Errorf("missing required rule %q", r.Name())
+		}
+	}
+
+	return rules, nil
+}
+
+func (rc ruleConfig) rule() (Rule, error) {
+	action := orDeny(rc.Action)
+
+	switch rc.Type {
+	case RuleCreditLimit, RuleMaxTransactionValue, RuleDebitsPerMinute, RuleDailyDebit:
+		if rc.Action != "" {
+			return nil, fmt.Errorf("%s: action is not configurable", rc.Type)
+		}
+	case RuleValueCap, RuleBlockedDescription:
+		if action != Deny && action != Flag {
+			return nil, fmt.Errorf("%s: invalid action %q", rc.Type, rc.Action)
+		}
+	}
+
+	switch rc.Type {
+	case RuleCreditLimit:
+		return CreditLimitRule{}, nil
+	case RuleMaxTransactionValue:
+		return MaxTransactionValueRule{}, nil
+	case RuleDebitsPerMinute:
+		return DebitsPerMinuteRule{}, nil
+	case RuleDailyDebit:
+		return DailyDebitRule{}, nil
+	case RuleValueCap:
+		if rc.Max < 1 {
+			return nil, fmt.Errorf("%s: max must be positive", rc.Type)
+		}
+		return ValueCapRule{Max: rc.Max, Action: action}, nil
+	case RuleBlockedDescription:
+		if len(rc.Descriptions) == 0 {
+			return nil, fmt.Errorf("%s: descriptions are required", rc.Type)
+		}
+		return BlockedDescriptionsRule{Descriptions: rc.Descriptions, Action: action}, nil
+	}
+
+	return nil, fmt.Errorf("unknown rule type %q", rc.Type)
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// Schedule schedules a transaction to be posted at a future date.
+func (c *Core) Schedule(ctx context.Context, clientID int, ns NewScheduledTransaction) (ScheduledTransaction, error) {
+	now := time.Now().UTC().Round(time.Microsecond)
+
+	st := ScheduledTransaction{
+		ID:           uuid.New(),
+		ClientID:     clientID,
+		Value:        ns.Value,
+		Type:         ns.Type,
+		Description:  ns.Description,
+		Currency:     ns.Currency,
+		ScheduledFor: ns.ScheduledFor.UTC().Round(time.Microsecond),
+		Status:       ScheduledPending,
+		Date:         now,
+		DateUpdated:  now,
+	}
+
+	t := Transaction{
+		ClientID:    st.ClientID,
+		Value:       st.Value,
+		Type:        st.Type,
+		Description: st.Description,
+	}
+	if err := t.validate(); err != nil {
+		return ScheduledTransaction{}, err
+	}
+	if st.Currency != "" && !validCurrency(st.Currency) {
+		return ScheduledTransaction{}, ErrInvalidArgument
+	}
+	if !st.ScheduledFor.After(now) {
+		return ScheduledTransaction{}, fmt.Errorf("scheduled date must be in the future: %w", ErrInvalidArgument)
+	}
+
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Schedule.Tx.Inside")
+		defer span.End()
+
+		if _, err := tx.QueryByID(ctx, clientID); err != nil {
+			return err
+		}
+
+		if err := tx.AddScheduled(ctx, st); err != nil {
+			return fmt.Errorf("failed to add scheduled transaction: %w", err)
+		}
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Schedule.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return ScheduledTransaction{}, err
+	}
+
+	return st, nil
+}
+
+// CancelScheduled cancels a pending scheduled transaction.
+func (c *Core) CancelScheduled(ctx context.Context, clientID int, scheduledID uuid.UUID) (ScheduledTransaction, error) {
+	var st ScheduledTransaction
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.CancelScheduled.Tx.Inside")
+		defer span.End()
+
+		var err error
+		st, err = tx.QueryScheduledByID(ctx, clientID, scheduledID)
+		if err != nil {
+			return err
+		}
+		if st.Status != ScheduledPending {
+			return ErrScheduledClosed
+		}
+
+		st.Status = ScheduledCanceled
+		st.DateUpdated = time.Now().UTC().Round(time.Microsecond)
+
+		if err := tx.UpdateScheduled(ctx, st); err != nil {
+			return fmt.Errorf("failed to update scheduled transaction: %w", err)
+		}
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.CancelScheduled.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return ScheduledTransaction{}, err
+	}
+
+	return st, nil
+}
+
+// ListScheduled returns a page of the client's scheduled transactions.
+func (c *Core) ListScheduled(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]ScheduledTransaction, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ListScheduled")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return nil, err
+	}
+
+	return c.store.QueryScheduled(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+// ExecuteScheduled posts the scheduled transactions that are due and returns
+// how many were processed. Transactions denied by the rules, or that can't
+// be converted from their currency, are recorded as denied and are not
+// retried.
+func (c *Core) ExecuteScheduled(ctx context.Context) (int, error) {
+	const batch = 100
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ExecuteScheduled")
+	defer span.End()
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	sts, err := c.store.QueryDueScheduled(ctx, now, batch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query due scheduled transactions: %w", err)
+	}
+
+	var n int
+	for _, st := range sts {
+		err := c.store.ExecUnderTx(ctx, func(tx Store) error {
+			return c.executeScheduled(ctx, tx, st.ClientID, st.ID)
+		})
+		switch {
+		case err == nil:
+			n++
+		case errors.Is(err, ErrScheduledClosed):
+			// Canceled or executed by another call after the query.
+		default:
+			return n, fmt.Errorf("failed to execute scheduled transaction[%s]: %w", st.ID, err)
+		}
+	}
+
+	return n, nil
+}
+
+// executeScheduled posts the scheduled transaction the same way
+// AddTransaction does and records the result.
+func (c *Core) executeScheduled(ctx context.Context, tx Store, clientID int, scheduledID uuid.UUID) error {
+	client, err := tx.QueryByID(ctx, clientID)
+	if err != nil {
+		return err
+	}
+
+	st, err := tx.QueryScheduledByID(ctx, clientID, scheduledID)
+	if err != nil {
+		return err
+	}
+	if st.Status != ScheduledPending {
+		return ErrScheduledClosed
+	}
+
+	t := Transaction{
+		ID:          uuid.New(),
+		ClientID:    clientID,
+		Value:       st.Value,
+		Type:        st.Type,
+		Description: st.Description,
+	}
+
+	_, t, err = c.addTransaction(ctx, tx, client, t, st.Currency)
+	switch {
+	case err == nil:
+		st.Status = ScheduledPosted
+		st.TransactionID = t.ID
+	case errors.Is(err, ErrTransactionDenied):
+		st.Status = ScheduledDenied
+		st.Reason = err.Error()
+
+		// Nothing was written by the denied posting, so the
+		// notifications are committed with the status.
+		if err := enqueueDenied(ctx, tx, t, err); err != nil {
+			return err
+		}
+	case errors.Is(err, ErrFXRateNotFound), errors.Is(err, ErrInvalidArgument):
+		st.Status = ScheduledDenied
+		st.Reason = err.Error()
+	default:
+		return err
+	}
+	st.DateUpdated = t.Date
+
+	if err := tx.UpdateScheduled(ctx, st); err != nil {
+		return fmt.Errorf("failed to update scheduled transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rschio/rinha/internal/web"
+)
+
+// SetSpendingLimits replaces the client's spending limits. The limits apply
+// to the following debits.
+func (c *Core) SetSpendingLimits(ctx context.Context, clientID int, l SpendingLimits) (SpendingLimits, error) {
+	l.ClientID = clientID
+	l.DateUpdated = time.Now().UTC().Round(time.Microsecond)
+	if err := l.validate(); err != nil {
+		return SpendingLimits{}, err
+	}
+
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.SetSpendingLimits.Tx.Inside")
+		defer span.End()
+
+		if _, err := tx.QueryByID(ctx, clientID); err != nil {
+			return err
+		}
+
+		if err := tx.SaveSpendingLimits(ctx, l); err != nil {
+			return fmt.Errorf("failed to save spending limits: %w", err)
+		}
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.SetSpendingLimits.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return SpendingLimits{}, err
+	}
+
+	return l, nil
+}
+
+// QuerySpendingLimits returns the client's spending limits.
+func (c *Core) QuerySpendingLimits(ctx context.Context, clientID int) (SpendingLimits, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.QuerySpendingLimits")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return SpendingLimits{}, err
+	}
+
+	return c.store.QuerySpendingLimits(ctx, clientID)
+}
+
+// MaxTransactionValueRule denies the debits above the client's maximum
+// transaction value.
+type MaxTransactionValueRule struct{}
+
+func (MaxTransactionValueRule) Name() string { return RuleMaxTransactionValue }
+
+func (MaxTransactionValueRule) Evaluate(ctx context.Context, s RuleStore, client Client, t Transaction) (Verdict, error) {
+	if t.Type != "d" {
+		return Approved, nil
+	}
+
+	l, err := s.QuerySpendingLimits(ctx, t.ClientID)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to query spending limits: %w", err)
+	}
+
+	if l.MaxTransactionValue > 0 && t.Value > l.MaxTransactionValue {
+		return Verdict{Action: Deny, Reason: fmt.Sprintf("value above %d", l.MaxTransactionValue)}, nil
+	}
+	return Approved, nil
+}
+
+// DebitsPerMinuteRule denies the debits above the client's maximum number of
+// debits in the minute before the debit.
+type DebitsPerMinuteRule struct{}
+
+func (DebitsPerMinuteRule) Name() string { return RuleDebitsPerMinute }
+
+func (DebitsPerMinuteRule) Evaluate(ctx context.Context, s RuleStore, client Client, t Transaction) (Verdict, error) {
+	if t.Type != "d" {
+		return Approved, nil
+	}
+
+	l, err := s.QuerySpendingLimits(ctx, t.ClientID)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to query spending limits: %w", err)
+	}
+	if l.MaxDebitsPerMinute == 0 {
+		return Approved, nil
+	}
+
+	d, err := s.QueryDebitTotals(ctx, t.ClientID, t.Date.Add(-time.Minute))
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to query debits: %w", err)
+	}
+	if d.Count+1 > l.MaxDebitsPerMinute {
+		return Verdict{Action: Deny, Reason: fmt.Sprintf("more than %d debits per minute", l.MaxDebitsPerMinute)}, nil
+	}
+	return Approved, nil
+}
+
+// DailyDebitRule denies the debits above the client's maximum total debit in
+// the UTC day of the debit.
+type DailyDebitRule struct{}
+
+func (DailyDebitRule) Name() string { return RuleDailyDebit }
+
+func (DailyDebitRule) Evaluate(ctx context.Context, s RuleStore, client Client, t Transaction) (Verdict, error) {
+	if t.Type != "d" {
+		return Approved, nil
+	}
+
+	l, err := s.QuerySpendingLimits(ctx, t.ClientID)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to query spending limits: %w", err)
+	}
+	if l.MaxDailyDebit == 0 {
+		return Approved, nil
+	}
+
+	d, err := s.QueryDebitTotals(ctx, t.ClientID, t.Date.UTC().Truncate(24*time.Hour))
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to query debits: %w", err)
+	}
+	if d.Total+t.Value > l.MaxDailyDebit {
+		return Verdict{Action: Deny, Reason: fmt.Sprintf("daily debit above %d", l.MaxDailyDebit)}, nil
+	}
+	return Approved, nil
+}
+
+func (l SpendingLimits) validate() error {
+	switch {
+	case l.ClientID < 1:
+		return ErrNotFound
+	case l.MaxDebitsPerMinute < 0, l.MaxDailyDebit < 0, l.MaxTransactionValue < 0:
+		return ErrInvalidArgument
+	}
+
+	return nil
+}
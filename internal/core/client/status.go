@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// UpdateStatus is the type of the client updates of the account status.
+const UpdateStatus = "status"
+
+// StatusError is returned when the client's account status doesn't allow an
+// operation. It wraps ErrAccountStatus and ErrTransactionDenied, so the
+// transactions it rejects are handled as denied.
+type StatusError struct {
+	ClientID int
+	Status   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: client[%d] is %s", ErrAccountStatus, e.ClientID, e.Status)
+}
+
+func (e *StatusError) Unwrap() []error {
+	return []error{ErrAccountStatus, ErrTransactionDenied}
+}
+
+// WithFrozenCredits sets whether frozen accounts receive credits. The
+// default is true, debits are always rejected.
+func WithFrozenCredits(allow bool) Option {
+	return func(c *Core) {
+		c.frozenCredits = allow
+	}
+}
+
+// transitions are the status each status can change to.
+var transitions = map[string][]string{
+	StatusActive: {StatusFrozen, StatusClosed},
+	StatusFrozen: {StatusActive},
+}
+
+// ChangeStatus changes the client's account status and records the
+// transition. Active accounts can be frozen or closed and frozen accounts
+// can be activated again. Closed accounts are final, and an account is only
+// closed without balance and reserved funds.
+func (c *Core) ChangeStatus(ctx context.Context, clientID int, status, reason string) (Client, error) {
+	sc := StatusChange{
+		ID:        uuid.New(),
+		ClientID:  clientID,
+		NewStatus: status,
+		Reason:    reason,
+		TraceID:   web.GetTraceID(ctx),
+	}
+	if err := sc.validate(); err != nil {
+		return Client{}, err
+	}
+
+	var client Client
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ChangeStatus.Tx.Inside")
+		defer span.End()
+
+		sc.Date = time.Now().UTC().Round(time.Microsecond)
+
+		var err error
+		client, err = tx.QueryByID(ctx, clientID)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(transitions[client.Status], status) {
+			return fmt.Errorf("%s to %s: %w", client.Status, status, ErrStatusTransition)
+		}
+		if status == StatusClosed && (client.Balance != 0 || client.Reserved != 0) {
+			return fmt.Errorf("closing with balance %d and reserved %d: %w", client.Balance, client.Reserved, ErrStatusTransition)
+		}
+		sc.OldStatus = client.Status
+
+		if err := tx.AddStatusChange(ctx, sc); err != nil {
+			return fmt.Errorf("failed to add status change: %w", err)
+		}
+
+		client, err = tx.UpdateClientStatus(ctx, clientID, status)
+		if err != nil {
+			return fmt.Errorf("failed to update status: %w", err)
+		}
+
+		return addUpdate(ctx, tx, UpdateStatus, clientID, statusPayload{Status: status, Reason: reason})
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ChangeStatus.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// ListStatusChanges returns a page of the transitions of the client's
+// account status, the most recent first.
+func (c *Core) ListStatusChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]StatusChange, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ListStatusChanges")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return nil, err
+	}
+
+	return c.store.QueryStatusChanges(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+// checkStatus returns a StatusError if the client's account status doesn't
+// allow transactions of the type: frozen accounts don't allow debits, and
+// credits unless configured, closed accounts don't allow any.
+func (c *Core) checkStatus(client Client, typ string) error {
+	switch {
+	case client.Status == StatusClosed,
+		client.Status == StatusFrozen && (typ == "d" || !c.frozenCredits):
+		return &StatusError{ClientID: client.ID, Status: client.Status}
+	}
+
+	return nil
+}
+
+// statusPayload is the JSON representation of the account status in the
+// client updates.
+type statusPayload struct {
+	Status string `json:"status"`
+	Reason string `json:"motivo"`
+}
+
+func (sc StatusChange) validate() error {
+	switch {
+	case sc.ClientID < 1:
+		return ErrNotFound
+	case sc.NewStatus != StatusActive && sc.NewStatus != StatusFrozen && sc.NewStatus != StatusClosed:
+		return ErrInvalidArgument
+	case len(sc.Reason) < 1 || len(sc.Reason) > 100:
+		return ErrInvalidArgument
+	}
+
+	return nil
+}
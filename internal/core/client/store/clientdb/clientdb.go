@@ -1,12 +1,14 @@
 package clientdb
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rschio/rinha/internal/core/client"
 	db "github.com/rschio/rinha/internal/data/dbsql/pgx"
 	"github.com/rschio/rinha/internal/web"
@@ -38,6 +40,86 @@ func (s *Store) ExecUnderTx(ctx context.Context, fn func(txStore client.Store) e
 	return tx.Commit(ctx)
 }
 
+func (s *Store) CreateClient(ctx context.Context, c client.Client) error {
+	now := web.GetTime(ctx).Round(time.Microsecond)
+	data := struct {
+		ID          int       `db:"id"`
+		Currency    string    `db:"currency"`
+		Limit       int       `db:"credit_limit"`
+		Balance     int       `db:"balance"`
+		ClosingDay  int       `db:"closing_day"`
+		Status      string    `db:"status"`
+		Name        string    `db:"name"`
+		Document    string    `db:"document,mask"`
+		Email       string    `db:"email"`
+		DateCreated time.Time `db:"date_created"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		ID:          c.ID,
+		Currency:    c.Currency,
+		Limit:       c.Limit,
+		Balance:     c.Balance,
+		ClosingDay:  c.ClosingDay,
+		Status:      c.Status,
+		Name:        c.Name,
+		Document:    c.Document,
+		Email:       c.Email,
+		DateCreated: c.DateCreated,
+		DateUpdated: now,
+	}
+	if data.Status == "" {
+		data.Status = client.StatusActive
+	}
+	if data.DateCreated.IsZero() {
+		data.DateCreated = now
+	}
+
+	const q = `
+	INSERT INTO clients(
+		id,
+		currency,
+		credit_limit,
+		balance,
+		closing_day,
+		status,
+		name,
+		document,
+		email,
+		date_created,
+		date_updated)
+	VALUES (
+		@id,
+		@currency,
+		@credit_limit,
+		@balance,
+		@closing_day,
+		@status,
+		@name,
+		@document,
+		@email,
+		@date_created,
+		@date_updated)
+	ON CONFLICT (id) DO NOTHING
+	RETURNING
+		id`
+
+	// A client with the same ID inserts no row, so the unique violation is
+	// of the document.
+	if _, err := db.NamedQueryStruct[struct {
+		ID int `db:"id"`
+	}](ctx, s.log, s.db, q, data); err != nil {
+		switch {
+		case errors.Is(err, db.ErrDBNotFound):
+			return client.ErrAlreadyExists
+		case errors.Is(err, db.ErrDBDuplicatedEntry):
+			return client.ErrDocumentExists
+		}
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return nil
+}
+
 func (s *Store) QueryByID(ctx context.Context, clientID int) (client.Client, error) {
 	data := struct {
 		ID int `db:"id"`
@@ -48,8 +130,16 @@ func (s *Store) QueryByID(ctx context.Context, clientID int) (client.Client, err
 	const q = `
 	SELECT
 		c.id,
+		c.currency,
 		c.credit_limit,
-		c.balance
+		c.balance,
+		c.reserved,
+		c.closing_day,
+		c.status,
+		c.name,
+		c.document,
+		c.email,
+		c.date_created
 	FROM
 		clients AS c
 	WHERE
@@ -67,29 +157,139 @@ func (s *Store) QueryByID(ctx context.Context, clientID int) (client.Client, err
 	return toClient(c), nil
 }
 
-func (s *Store) QueryTransactions(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.Transaction, error) {
+func (s *Store) QueryByDocument(ctx context.Context, document string) (client.Client, error) {
+	data := struct {
+		Document string `db:"document,mask"`
+	}{
+		Document: document,
+	}
+
+	const q = `
+	SELECT
+		c.id,
+		c.currency,
+		c.credit_limit,
+		c.balance,
+		c.reserved,
+		c.closing_day,
+		c.status,
+		c.name,
+		c.document,
+		c.email,
+		c.date_created
+	FROM
+		clients AS c
+	WHERE
+		c.document = @document`
+
+	c, err := db.NamedQueryStruct[dbClient](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Client{}, client.ErrNotFound
+		}
+		return client.Client{}, err
+	}
+
+	return toClient(c), nil
+}
+
+func (s *Store) QueryClients(ctx context.Context, pageNumber, rowsPerPage int) ([]client.Client, error) {
 	data := struct {
-		ID          int `db:"id"`
 		Offset      int `db:"offset"`
 		RowsPerPage int `db:"rows_per_page"`
 	}{
-		ID:          clientID,
 		Offset:      (pageNumber - 1) * rowsPerPage,
 		RowsPerPage: rowsPerPage,
 	}
 
+	const q = `
+	SELECT
+		c.id,
+		c.currency,
+		c.credit_limit,
+		c.balance,
+		c.reserved,
+		c.closing_day,
+		c.status,
+		c.name,
+		c.document,
+		c.email,
+		c.date_created
+	FROM
+		clients AS c
+	ORDER BY
+		c.id
+	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
+
+	cs, err := db.NamedQuerySlice[dbClient](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toClients(cs), nil
+}
+
+func (s *Store) QueryTransactions(ctx context.Context, clientID int, filter client.TransactionFilter, pageNumber, rowsPerPage int) ([]client.Transaction, error) {
+	data := toDBTransactionQuery(clientID, filter)
+	data.Offset = (pageNumber - 1) * rowsPerPage
+	data.RowsPerPage = rowsPerPage
+
 	const q = `
 	SELECT
 		*
 	FROM
 		transactions t
 	WHERE
-		t.client_id = @id
+		t.client_id = @id`
+
+	const qOrder = `
 	ORDER BY
 		date_created DESC
 	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
 
-	dbTs, err := db.NamedQuerySlice[dbTransaction](ctx, s.log, s.db, q, data)
+	buf := bytes.NewBufferString(q)
+	applyTransactionFilter(filter, buf)
+	buf.WriteString(qOrder)
+
+	dbTs, err := db.NamedQuerySlice[dbTransaction](ctx, s.log, s.db, buf.String(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toTransactions(dbTs), nil
+}
+
+func (s *Store) QueryTransactionsAfter(ctx context.Context, clientID int, filter client.TransactionFilter, after client.Cursor, limit int) ([]client.Transaction, error) {
+	data := toDBTransactionQuery(clientID, filter)
+	data.AfterDate = after.Date
+	data.AfterID = after.ID.String()
+	data.RowsPerPage = limit
+
+	const q = `
+	SELECT
+		*
+	FROM
+		transactions t
+	WHERE
+		t.client_id = @id`
+
+	const qAfter = `
+		AND (t.date_created, t.id COLLATE "C") < (@after_date, @after_id)`
+
+	const qOrder = `
+	ORDER BY
+		t.date_created DESC,
+		t.id COLLATE "C" DESC
+	FETCH FIRST @rows_per_page ROWS ONLY`
+
+	buf := bytes.NewBufferString(q)
+	applyTransactionFilter(filter, buf)
+	if !after.IsZero() {
+		buf.WriteString(qAfter)
+	}
+	buf.WriteString(qOrder)
+
+	dbTs, err := db.NamedQuerySlice[dbTransaction](ctx, s.log, s.db, buf.String(), data)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +297,31 @@ func (s *Store) QueryTransactions(ctx context.Context, clientID, pageNumber, row
 	return toTransactions(dbTs), nil
 }
 
+func (s *Store) StreamTransactions(ctx context.Context, clientID int, filter client.TransactionFilter, fn func(client.Transaction) error) error {
+	data := toDBTransactionQuery(clientID, filter)
+
+	const q = `
+	SELECT
+		*
+	FROM
+		transactions t
+	WHERE
+		t.client_id = @id`
+
+	const qOrder = `
+	ORDER BY
+		t.date_created,
+		t.id COLLATE "C"`
+
+	buf := bytes.NewBufferString(q)
+	applyTransactionFilter(filter, buf)
+	buf.WriteString(qOrder)
+
+	return db.NamedQueryEach(ctx, s.log, s.db, buf.String(), data, func(t dbTransaction) error {
+		return fn(toTransaction(t))
+	})
+}
+
 func (s *Store) UpdateClientBalance(ctx context.Context, clientID, balance int) (client.Client, error) {
 	data := struct {
 		ID          int       `db:"id"`
@@ -117,10 +342,43 @@ func (s *Store) UpdateClientBalance(ctx context.Context, clientID, balance int)
 	WHERE
 		id = @id
 	RETURNING
-		id, credit_limit, balance`
+		id, currency, credit_limit, balance, reserved, closing_day, status, name, document, email, date_created`
+
+	c, err := db.NamedQueryStruct[dbClient](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return toClient(c), nil
+}
+
+func (s *Store) UpdateClientReserved(ctx context.Context, clientID, reserved int) (client.Client, error) {
+	data := struct {
+		ID          int       `db:"id"`
+		Reserved    int       `db:"reserved"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		ID:          clientID,
+		Reserved:    reserved,
+		DateUpdated: web.GetTime(ctx).Round(time.Microsecond),
+	}
+
+	const q = `
+	UPDATE
+		clients
+	SET
+		reserved = @reserved,
+		date_updated = @date_updated
+	WHERE
+		id = @id
+	RETURNING
+		id, currency, credit_limit, balance, reserved, closing_day, status, name, document, email, date_created`
 
 	c, err := db.NamedQueryStruct[dbClient](ctx, s.log, s.db, q, data)
 	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Client{}, client.ErrNotFound
+		}
 		return client.Client{}, err
 	}
 
@@ -129,24 +387,1756 @@ func (s *Store) UpdateClientBalance(ctx context.Context, clientID, balance int)
 
 func (s *Store) AddTransaction(ctx context.Context, t client.Transaction) error {
 	const q = `
-	INSERT INTO transactions(
+	WITH t AS (
+		INSERT INTO transactions(
+			id,
+			client_id,
+			value,
+			type,
+			description,
+			date_created,
+			reversal_of,
+			reversed_by,
+			transfer_id,
+			currency,
+			original_value,
+			fx_rate,
+			balance_after)
+		VALUES (
+			@id,
+			@client_id,
+			@value,
+			@type,
+			@description,
+			@date_created,
+			@reversal_of,
+			@reversed_by,
+			@transfer_id,
+			@currency,
+			@original_value,
+			@fx_rate,
+			@balance_after)
+		RETURNING id
+	)
+	INSERT INTO ledger_unsequenced(transaction_id)
+	SELECT
+		t.id
+	FROM
+		t`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBTransaction(t)); err != nil {
+		return fmt.Errorf("failed to add transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryTransactionByID(ctx context.Context, clientID int, transactionID uuid.UUID) (client.Transaction, error) {
+	data := struct {
+		ID       uuid.UUID `db:"id"`
+		ClientID int       `db:"client_id"`
+	}{
+		ID:       transactionID,
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		transactions t
+	WHERE
+		t.id = @id AND
+		t.client_id = @client_id
+	FOR UPDATE`
+
+	t, err := db.NamedQueryStruct[dbTransaction](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Transaction{}, client.ErrTransactionNotFound
+		}
+		return client.Transaction{}, err
+	}
+
+	return toTransaction(t), nil
+}
+
+func (s *Store) UpdateTransactionReversedBy(ctx context.Context, transactionID, reversalID uuid.UUID) error {
+	data := struct {
+		ID         uuid.UUID `db:"id"`
+		ReversedBy uuid.UUID `db:"reversed_by"`
+	}{
+		ID:         transactionID,
+		ReversedBy: reversalID,
+	}
+
+	const q = `
+	UPDATE
+		transactions
+	SET
+		reversed_by = @reversed_by
+	WHERE
+		id = @id`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("failed to update transaction reversal: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateClientLimit(ctx context.Context, clientID, limit int) (client.Client, error) {
+	data := struct {
+		ID          int       `db:"id"`
+		Limit       int       `db:"credit_limit"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		ID:          clientID,
+		Limit:       limit,
+		DateUpdated: web.GetTime(ctx).Round(time.Microsecond),
+	}
+
+	const q = `
+	UPDATE
+		clients
+	SET
+		credit_limit = @credit_limit,
+		date_updated = @date_updated
+	WHERE
+		id = @id
+	RETURNING
+		id, currency, credit_limit, balance, reserved, closing_day, status, name, document, email, date_created`
+
+	c, err := db.NamedQueryStruct[dbClient](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Client{}, client.ErrNotFound
+		}
+		return client.Client{}, err
+	}
+
+	return toClient(c), nil
+}
+
+func (s *Store) AddLimitChange(ctx context.Context, lc client.LimitChange) error {
+	const q = `
+	INSERT INTO limit_changes(
 		id,
 		client_id,
-		value,
-		type,
-		description,
+		old_limit,
+		new_limit,
+		reason,
+		trace_id,
 		date_created)
 	VALUES (
 		@id,
 		@client_id,
-		@value,
-		@type,
-		@description,
+		@old_limit,
+		@new_limit,
+		@reason,
+		@trace_id,
 		@date_created);`
 
-	if err := db.NamedExec(ctx, s.log, s.db, q, toDBTransaction(t)); err != nil {
-		return fmt.Errorf("failed to add transaction: %w", err)
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBLimitChange(lc)); err != nil {
+		return fmt.Errorf("failed to add limit change: %w", err)
 	}
 
 	return nil
 }
+
+func (s *Store) QueryLimitChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.LimitChange, error) {
+	data := struct {
+		ID          int `db:"id"`
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		ID:          clientID,
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		limit_changes l
+	WHERE
+		l.client_id = @id
+	ORDER BY
+		date_created DESC
+	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
+
+	lcs, err := db.NamedQuerySlice[dbLimitChange](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toLimitChanges(lcs), nil
+}
+
+func (s *Store) UpdateClientStatus(ctx context.Context, clientID int, status string) (client.Client, error) {
+	data := struct {
+		ID          int       `db:"id"`
+		Status      string    `db:"status"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		ID:          clientID,
+		Status:      status,
+		DateUpdated: web.GetTime(ctx).Round(time.Microsecond),
+	}
+
+	const q = `
+	UPDATE
+		clients
+	SET
+		status = @status,
+		date_updated = @date_updated
+	WHERE
+		id = @id
+	RETURNING
+		id, currency, credit_limit, balance, reserved, closing_day, status, name, document, email, date_created`
+
+	c, err := db.NamedQueryStruct[dbClient](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Client{}, client.ErrNotFound
+		}
+		return client.Client{}, err
+	}
+
+	return toClient(c), nil
+}
+
+func (s *Store) AddStatusChange(ctx context.Context, sc client.StatusChange) error {
+	const q = `
+	INSERT INTO status_changes(
+		id,
+		client_id,
+		old_status,
+		new_status,
+		reason,
+		trace_id,
+		date_created)
+	VALUES (
+		@id,
+		@client_id,
+		@old_status,
+		@new_status,
+		@reason,
+		@trace_id,
+		@date_created);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBStatusChange(sc)); err != nil {
+		return fmt.Errorf("failed to add status change: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryStatusChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.StatusChange, error) {
+	data := struct {
+		ID          int `db:"id"`
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		ID:          clientID,
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		status_changes s
+	WHERE
+		s.client_id = @id
+	ORDER BY
+		date_created DESC
+	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
+
+	scs, err := db.NamedQuerySlice[dbStatusChange](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toStatusChanges(scs), nil
+}
+
+func (s *Store) QueryIdempotencyKey(ctx context.Context, clientID int, key string) (client.IdempotencyKey, error) {
+	data := struct {
+		ClientID int    `db:"client_id"`
+		Key      string `db:"key"`
+	}{
+		ClientID: clientID,
+		Key:      key,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		idempotency_keys k
+	WHERE
+		k.client_id = @client_id AND
+		k.key = @key`
+
+	k, err := db.NamedQueryStruct[dbIdempotencyKey](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.IdempotencyKey{}, client.ErrIdempotencyKeyNotFound
+		}
+		return client.IdempotencyKey{}, err
+	}
+
+	return client.IdempotencyKey(k), nil
+}
+
+func (s *Store) SaveIdempotencyKey(ctx context.Context, k client.IdempotencyKey) error {
+	const q = `
+	INSERT INTO idempotency_keys(
+		client_id,
+		key,
+		request_hash,
+		response,
+		date_created,
+		expires_at)
+	VALUES (
+		@client_id,
+		@key,
+		@request_hash,
+		@response,
+		@date_created,
+		@expires_at)
+	ON CONFLICT (client_id, key) DO UPDATE SET
+		request_hash = EXCLUDED.request_hash,
+		response = EXCLUDED.response,
+		date_created = EXCLUDED.date_created,
+		expires_at = EXCLUDED.expires_at`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, dbIdempotencyKey(k)); err != nil {
+		return fmt.Errorf("failed to save idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteIdempotencyKeys(ctx context.Context, date time.Time) (int, error) {
+	data := struct {
+		Date time.Time `db:"date"`
+	}{
+		Date: date,
+	}
+
+	const q = `
+	WITH deleted AS (
+		DELETE FROM
+			idempotency_keys
+		WHERE
+			expires_at <= @date
+		RETURNING
+			1
+	)
+	SELECT
+		COUNT(*) AS count
+	FROM
+		deleted`
+
+	ret, err := db.NamedQueryStruct[dbCount](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete idempotency keys: %w", err)
+	}
+
+	return ret.Count, nil
+}
+
+func (s *Store) AddHold(ctx context.Context, h client.Hold) error {
+	const q = `
+	INSERT INTO holds(
+		id,
+		client_id,
+		value,
+		description,
+		status,
+		captured_value,
+		transaction_id,
+		date_created,
+		date_updated,
+		expires_at)
+	VALUES (
+		@id,
+		@client_id,
+		@value,
+		@description,
+		@status,
+		@captured_value,
+		@transaction_id,
+		@date_created,
+		@date_updated,
+		@expires_at);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBHold(h)); err != nil {
+		return fmt.Errorf("failed to add hold: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryHoldByID(ctx context.Context, clientID int, holdID uuid.UUID) (client.Hold, error) {
+	data := struct {
+		ID       uuid.UUID `db:"id"`
+		ClientID int       `db:"client_id"`
+	}{
+		ID:       holdID,
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		holds h
+	WHERE
+		h.id = @id AND
+		h.client_id = @client_id
+	FOR UPDATE`
+
+	h, err := db.NamedQueryStruct[dbHold](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Hold{}, client.ErrHoldNotFound
+		}
+		return client.Hold{}, err
+	}
+
+	return toHold(h), nil
+}
+
+func (s *Store) QueryExpiredHolds(ctx context.Context, date time.Time, limit int) ([]client.Hold, error) {
+	data := struct {
+		Status string    `db:"status"`
+		Date   time.Time `db:"date"`
+		Limit  int       `db:"limit"`
+	}{
+		Status: client.HoldActive,
+		Date:   date,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		holds h
+	WHERE
+		h.status = @status AND
+		h.expires_at <= @date
+	ORDER BY
+		h.expires_at
+	LIMIT @limit`
+
+	hs, err := db.NamedQuerySlice[dbHold](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toHolds(hs), nil
+}
+
+func (s *Store) UpdateHold(ctx context.Context, h client.Hold) error {
+	const q = `
+	UPDATE
+		holds
+	SET
+		status = @status,
+		captured_value = @captured_value,
+		transaction_id = @transaction_id,
+		date_updated = @date_updated
+	WHERE
+		id = @id`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBHold(h)); err != nil {
+		return fmt.Errorf("failed to update hold: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) AddScheduled(ctx context.Context, st client.ScheduledTransaction) error {
+	const q = `
+	INSERT INTO scheduled_transactions(
+		id,
+		client_id,
+		value,
+		type,
+		description,
+		currency,
+		scheduled_for,
+		status,
+		transaction_id,
+		reason,
+		date_created,
+		date_updated)
+	VALUES (
+		@id,
+		@client_id,
+		@value,
+		@type,
+		@description,
+		@currency,
+		@scheduled_for,
+		@status,
+		@transaction_id,
+		@reason,
+		@date_created,
+		@date_updated);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBScheduledTransaction(st)); err != nil {
+		return fmt.Errorf("failed to add scheduled transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryScheduledByID(ctx context.Context, clientID int, scheduledID uuid.UUID) (client.ScheduledTransaction, error) {
+	data := struct {
+		ID       uuid.UUID `db:"id"`
+		ClientID int       `db:"client_id"`
+	}{
+		ID:       scheduledID,
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		scheduled_transactions s
+	WHERE
+		s.id = @id AND
+		s.client_id = @client_id
+	FOR UPDATE`
+
+	st, err := db.NamedQueryStruct[dbScheduledTransaction](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.ScheduledTransaction{}, client.ErrScheduledNotFound
+		}
+		return client.ScheduledTransaction{}, err
+	}
+
+	return toScheduledTransaction(st), nil
+}
+
+func (s *Store) QueryScheduled(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.ScheduledTransaction, error) {
+	data := struct {
+		ClientID    int `db:"client_id"`
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		ClientID:    clientID,
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		scheduled_transactions s
+	WHERE
+		s.client_id = @client_id
+	ORDER BY
+		s.scheduled_for DESC
+	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
+
+	sts, err := db.NamedQuerySlice[dbScheduledTransaction](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toScheduledTransactions(sts), nil
+}
+
+func (s *Store) QueryDueScheduled(ctx context.Context, date time.Time, limit int) ([]client.ScheduledTransaction, error) {
+	data := struct {
+		Status string    `db:"status"`
+		Date   time.Time `db:"date"`
+		Limit  int       `db:"limit"`
+	}{
+		Status: client.ScheduledPending,
+		Date:   date,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		scheduled_transactions s
+	WHERE
+		s.status = @status AND
+		s.scheduled_for <= @date
+	ORDER BY
+		s.scheduled_for
+	LIMIT @limit`
+
+	sts, err := db.NamedQuerySlice[dbScheduledTransaction](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toScheduledTransactions(sts), nil
+}
+
+func (s *Store) UpdateScheduled(ctx context.Context, st client.ScheduledTransaction) error {
+	const q = `
+	UPDATE
+		scheduled_transactions
+	SET
+		status = @status,
+		transaction_id = @transaction_id,
+		reason = @reason,
+		date_updated = @date_updated
+	WHERE
+		id = @id`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBScheduledTransaction(st)); err != nil {
+		return fmt.Errorf("failed to update scheduled transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) AddFXRate(ctx context.Context, r client.FXRate) error {
+	const q = `
+	INSERT INTO fx_rates(
+		id,
+		currency_from,
+		currency_to,
+		rate,
+		date_created)
+	VALUES (
+		@id,
+		@currency_from,
+		@currency_to,
+		@rate,
+		@date_created);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, dbFXRate(r)); err != nil {
+		return fmt.Errorf("failed to add fx rate: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryFXRate(ctx context.Context, from, to string) (client.FXRate, error) {
+	data := struct {
+		From string `db:"currency_from"`
+		To   string `db:"currency_to"`
+	}{
+		From: from,
+		To:   to,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		fx_rates r
+	WHERE
+		r.currency_from = @currency_from AND
+		r.currency_to = @currency_to
+	ORDER BY
+		r.date_created DESC
+	LIMIT 1`
+
+	r, err := db.NamedQueryStruct[dbFXRate](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.FXRate{}, client.ErrFXRateNotFound
+		}
+		return client.FXRate{}, err
+	}
+
+	return client.FXRate(r), nil
+}
+
+func (s *Store) QueryFXRates(ctx context.Context) ([]client.FXRate, error) {
+	const q = `
+	SELECT DISTINCT ON (r.currency_from, r.currency_to)
+		*
+	FROM
+		fx_rates r
+	ORDER BY
+		r.currency_from, r.currency_to, r.date_created DESC`
+
+	rs, err := db.NamedQuerySlice[dbFXRate](ctx, s.log, s.db, q, struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	return toFXRates(rs), nil
+}
+
+func (s *Store) QueryOverdrawnClients(ctx context.Context, after, limit int) ([]client.Client, error) {
+	data := struct {
+		After int `db:"after"`
+		Limit int `db:"limit"`
+	}{
+		After: after,
+		Limit: limit,
+	}
+
+	const q = `
+	SELECT
+		c.id,
+		c.currency,
+		c.credit_limit,
+		c.balance,
+		c.reserved,
+		c.closing_day,
+		c.status,
+		c.name,
+		c.document,
+		c.email,
+		c.date_created
+	FROM
+		clients AS c
+	WHERE
+		c.balance < 0 AND
+		c.id > @after
+	ORDER BY
+		c.id
+	LIMIT @limit`
+
+	cs, err := db.NamedQuerySlice[dbClient](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toClients(cs), nil
+}
+
+func (s *Store) AddInterestAccrual(ctx context.Context, a client.InterestAccrual) error {
+	const q = `
+	INSERT INTO interest_accruals(
+		client_id,
+		day,
+		balance,
+		rate,
+		value,
+		transaction_id,
+		override,
+		date_created)
+	VALUES (
+		@client_id,
+		@day,
+		@balance,
+		@rate,
+		@value,
+		@transaction_id,
+		@override,
+		@date_created);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, dbInterestAccrual(a)); err != nil {
+		return fmt.Errorf("failed to add interest accrual: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryInterestAccrual(ctx context.Context, clientID int, day time.Time) (client.InterestAccrual, error) {
+	data := struct {
+		ClientID int       `db:"client_id"`
+		Day      time.Time `db:"day"`
+	}{
+		ClientID: clientID,
+		Day:      day,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		interest_accruals a
+	WHERE
+		a.client_id = @client_id AND
+		a.day = @day`
+
+	a, err := db.NamedQueryStruct[dbInterestAccrual](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.InterestAccrual{}, client.ErrAccrualNotFound
+		}
+		return client.InterestAccrual{}, err
+	}
+
+	return client.InterestAccrual(a), nil
+}
+
+func (s *Store) QueryBalanceAt(ctx context.Context, clientID int, date time.Time) (int, error) {
+	data := struct {
+		ClientID int       `db:"client_id"`
+		Date     time.Time `db:"date"`
+	}{
+		ClientID: clientID,
+		Date:     date,
+	}
+
+	const q = `
+	SELECT
+		t.balance_after AS sum
+	FROM
+		transactions t
+	WHERE
+		t.client_id = @client_id AND
+		t.date_created <= @date
+	ORDER BY
+		t.date_created DESC, t.id COLLATE "C" DESC
+	LIMIT 1`
+
+	ret, err := db.NamedQueryStruct[dbSum](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return ret.Sum, nil
+}
+
+func (s *Store) QuerySpendingLimits(ctx context.Context, clientID int) (client.SpendingLimits, error) {
+	data := struct {
+		ClientID int `db:"client_id"`
+	}{
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		spending_limits l
+	WHERE
+		l.client_id = @client_id`
+
+	l, err := db.NamedQueryStruct[dbSpendingLimits](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.SpendingLimits{ClientID: clientID}, nil
+		}
+		return client.SpendingLimits{}, err
+	}
+
+	return client.SpendingLimits(l), nil
+}
+
+func (s *Store) SaveSpendingLimits(ctx context.Context, l client.SpendingLimits) error {
+	const q = `
+	INSERT INTO spending_limits(
+		client_id,
+		max_debits_per_minute,
+		max_daily_debit,
+		max_transaction_value,
+		date_updated)
+	VALUES (
+		@client_id,
+		@max_debits_per_minute,
+		@max_daily_debit,
+		@max_transaction_value,
+		@date_updated)
+	ON CONFLICT (client_id) DO UPDATE SET
+		max_debits_per_minute = EXCLUDED.max_debits_per_minute,
+		max_daily_debit = EXCLUDED.max_daily_debit,
+		max_transaction_value = EXCLUDED.max_transaction_value,
+		date_updated = EXCLUDED.date_updated`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, dbSpendingLimits(l)); err != nil {
+		return fmt.Errorf("failed to save spending limits: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryDebitTotals(ctx context.Context, clientID int, since time.Time) (client.DebitTotals, error) {
+	data := struct {
+		ClientID int       `db:"client_id"`
+		Since    time.Time `db:"since"`
+	}{
+		ClientID: clientID,
+		Since:    since,
+	}
+
+	const q = `
+	SELECT
+		COUNT(*) AS count,
+		COALESCE(SUM(-t.value), 0) AS total
+	FROM
+		transactions t
+	WHERE
+		t.client_id = @client_id AND
+		t.type = 'd' AND
+		t.date_created >= @since`
+
+	d, err := db.NamedQueryStruct[dbDebitTotals](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return client.DebitTotals{}, err
+	}
+
+	return client.DebitTotals(d), nil
+}
+
+func (s *Store) SumTransactions(ctx context.Context, clientID int) (int, error) {
+	data := struct {
+		ClientID int `db:"client_id"`
+	}{
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		COALESCE(SUM(t.value), 0) AS sum
+	FROM
+		transactions t
+	WHERE
+		t.client_id = @client_id`
+
+	ret, err := db.NamedQueryStruct[dbSum](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return ret.Sum, nil
+}
+
+func (s *Store) AddBalanceAdjustment(ctx context.Context, a client.BalanceAdjustment) error {
+	const q = `
+	INSERT INTO balance_adjustments(
+		id,
+		client_id,
+		old_balance,
+		new_balance,
+		reason,
+		date_created)
+	VALUES (
+		@id,
+		@client_id,
+		@old_balance,
+		@new_balance,
+		@reason,
+		@date_created);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, dbBalanceAdjustment(a)); err != nil {
+		return fmt.Errorf("failed to add balance adjustment: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) AddTransactionFlag(ctx context.Context, f client.TransactionFlag) error {
+	const q = `
+	INSERT INTO transaction_flags(
+		id,
+		client_id,
+		transaction_id,
+		rule,
+		reason,
+		date_created)
+	VALUES (
+		@id,
+		@client_id,
+		@transaction_id,
+		@rule,
+		@reason,
+		@date_created);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, dbTransactionFlag(f)); err != nil {
+		return fmt.Errorf("failed to add transaction flag: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryTransactionFlags(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.TransactionFlag, error) {
+	data := struct {
+		ClientID    int `db:"client_id"`
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		ClientID:    clientID,
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		transaction_flags f
+	WHERE
+		f.client_id = @client_id
+	ORDER BY
+		f.date_created DESC
+	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
+
+	fs, err := db.NamedQuerySlice[dbTransactionFlag](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toTransactionFlags(fs), nil
+}
+
+func (s *Store) AddInvoice(ctx context.Context, inv client.Invoice) error {
+	dbInv, err := toDBInvoice(inv)
+	if err != nil {
+		return fmt.Errorf("failed to encode invoice: %w", err)
+	}
+
+	const q = `
+	INSERT INTO invoices(
+		id,
+		client_id,
+		period_start,
+		period_end,
+		opening_balance,
+		closing_balance,
+		due_date,
+		transactions,
+		date_created)
+	VALUES (
+		@id,
+		@client_id,
+		@period_start,
+		@period_end,
+		@opening_balance,
+		@closing_balance,
+		@due_date,
+		@transactions,
+		@date_created);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, dbInv); err != nil {
+		return fmt.Errorf("failed to add invoice: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryLastInvoice(ctx context.Context, clientID int) (client.Invoice, error) {
+	data := struct {
+		ClientID int `db:"client_id"`
+	}{
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		i.id,
+		i.client_id,
+		i.period_start,
+		i.period_end,
+		i.opening_balance,
+		i.closing_balance,
+		i.due_date,
+		NULL::JSONB AS transactions,
+		i.date_created
+	FROM
+		invoices i
+	WHERE
+		i.client_id = @client_id
+	ORDER BY
+		i.period_end DESC
+	LIMIT 1`
+
+	inv, err := db.NamedQueryStruct[dbInvoice](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Invoice{}, client.ErrInvoiceNotFound
+		}
+		return client.Invoice{}, err
+	}
+
+	return toInvoice(inv)
+}
+
+func (s *Store) QueryInvoiceByID(ctx context.Context, clientID int, invoiceID uuid.UUID) (client.Invoice, error) {
+	data := struct {
+		ID       uuid.UUID `db:"id"`
+		ClientID int       `db:"client_id"`
+	}{
+		ID:       invoiceID,
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		invoices i
+	WHERE
+		i.id = @id AND
+		i.client_id = @client_id`
+
+	inv, err := db.NamedQueryStruct[dbInvoice](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Invoice{}, client.ErrInvoiceNotFound
+		}
+		return client.Invoice{}, err
+	}
+
+	return toInvoice(inv)
+}
+
+func (s *Store) QueryInvoices(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.Invoice, error) {
+	data := struct {
+		ClientID    int `db:"client_id"`
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		ClientID:    clientID,
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `
+	SELECT
+		i.id,
+		i.client_id,
+		i.period_start,
+		i.period_end,
+		i.opening_balance,
+		i.closing_balance,
+		i.due_date,
+		NULL::JSONB AS transactions,
+		i.date_created
+	FROM
+		invoices i
+	WHERE
+		i.client_id = @client_id
+	ORDER BY
+		i.period_end DESC
+	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
+
+	invs, err := db.NamedQuerySlice[dbInvoice](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toInvoices(invs)
+}
+
+func (s *Store) AddOutboxEvent(ctx context.Context, e client.OutboxEvent) error {
+	const q = `
+	INSERT INTO outbox(
+		id,
+		type,
+		client_id,
+		payload,
+		status,
+		attempts,
+		last_error,
+		next_attempt,
+		date_created,
+		date_updated)
+	VALUES (
+		@id,
+		@type,
+		@client_id,
+		@payload,
+		@status,
+		@attempts,
+		@last_error,
+		@next_attempt,
+		@date_created,
+		@date_updated);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBOutboxEvent(e)); err != nil {
+		return fmt.Errorf("failed to add outbox event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryPendingOutbox(ctx context.Context, after int64, limit int) ([]client.OutboxEvent, error) {
+	data := struct {
+		Status string `db:"status"`
+		After  int64  `db:"after"`
+		Limit  int    `db:"limit"`
+	}{
+		Status: client.OutboxPending,
+		After:  after,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		outbox o
+	WHERE
+		o.status = @status AND
+		o.seq > @after
+	ORDER BY
+		o.seq
+	LIMIT @limit`
+
+	es, err := db.NamedQuerySlice[dbOutboxEvent](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toOutboxEvents(es), nil
+}
+
+func (s *Store) UpdateOutboxEvent(ctx context.Context, e client.OutboxEvent) error {
+	const q = `
+	UPDATE
+		outbox
+	SET
+		status = @status,
+		attempts = @attempts,
+		last_error = @last_error,
+		next_attempt = @next_attempt,
+		date_updated = @date_updated
+	WHERE
+		id = @id`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBOutboxEvent(e)); err != nil {
+		return fmt.Errorf("failed to update outbox event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteOutboxEvents(ctx context.Context, date time.Time) (int, error) {
+	data := struct {
+		Status string    `db:"status"`
+		Date   time.Time `db:"date"`
+	}{
+		Status: client.OutboxPending,
+		Date:   date,
+	}
+
+	const q = `
+	WITH deleted AS (
+		DELETE FROM
+			outbox
+		WHERE
+			status <> @status AND
+			date_updated < @date
+		RETURNING
+			1
+	)
+	SELECT
+		COUNT(*) AS count
+	FROM
+		deleted`
+
+	ret, err := db.NamedQueryStruct[dbCount](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete outbox events: %w", err)
+	}
+
+	return ret.Count, nil
+}
+
+func (s *Store) AddWebhook(ctx context.Context, w client.Webhook) error {
+	const q = `
+	INSERT INTO webhooks(
+		id,
+		client_id,
+		url,
+		secret,
+		date_created)
+	VALUES (
+		@id,
+		@client_id,
+		@url,
+		@secret,
+		@date_created);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBWebhook(w)); err != nil {
+		return fmt.Errorf("failed to add webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryWebhooks(ctx context.Context, clientID int) ([]client.Webhook, error) {
+	data := struct {
+		ClientID int `db:"client_id"`
+	}{
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		webhooks w
+	WHERE
+		w.client_id = @client_id
+	ORDER BY
+		w.date_created`
+
+	ws, err := db.NamedQuerySlice[dbWebhook](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toWebhooks(ws), nil
+}
+
+func (s *Store) QueryWebhookByID(ctx context.Context, clientID int, webhookID uuid.UUID) (client.Webhook, error) {
+	data := struct {
+		ID       uuid.UUID `db:"id"`
+		ClientID int       `db:"client_id"`
+	}{
+		ID:       webhookID,
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		webhooks w
+	WHERE
+		w.id = @id AND
+		w.client_id = @client_id`
+
+	w, err := db.NamedQueryStruct[dbWebhook](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.Webhook{}, client.ErrWebhookNotFound
+		}
+		return client.Webhook{}, err
+	}
+
+	return toWebhook(w), nil
+}
+
+func (s *Store) AddWebhookDelivery(ctx context.Context, d client.WebhookDelivery) error {
+	const q = `
+	INSERT INTO webhook_deliveries(
+		id,
+		webhook_id,
+		client_id,
+		event_id,
+		event,
+		payload,
+		status,
+		attempts,
+		response_status,
+		last_error,
+		next_attempt,
+		date_created,
+		date_updated)
+	VALUES (
+		@id,
+		@webhook_id,
+		@client_id,
+		@event_id,
+		@event,
+		@payload,
+		@status,
+		@attempts,
+		@response_status,
+		@last_error,
+		@next_attempt,
+		@date_created,
+		@date_updated);`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBWebhookDelivery(d)); err != nil {
+		return fmt.Errorf("failed to add webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryWebhookDeliveryByID(ctx context.Context, clientID int, deliveryID uuid.UUID) (client.WebhookDelivery, error) {
+	data := struct {
+		ID       uuid.UUID `db:"id"`
+		ClientID int       `db:"client_id"`
+	}{
+		ID:       deliveryID,
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		webhook_deliveries d
+	WHERE
+		d.id = @id AND
+		d.client_id = @client_id`
+
+	d, err := db.NamedQueryStruct[dbWebhookDelivery](ctx, s.log, s.db, q, data)
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return client.WebhookDelivery{}, client.ErrDeliveryNotFound
+		}
+		return client.WebhookDelivery{}, err
+	}
+
+	return toWebhookDelivery(d), nil
+}
+
+func (s *Store) QueryWebhookDeliveries(ctx context.Context, clientID int, webhookID uuid.UUID, pageNumber, rowsPerPage int) ([]client.WebhookDelivery, error) {
+	data := struct {
+		ClientID    int       `db:"client_id"`
+		WebhookID   uuid.UUID `db:"webhook_id"`
+		Offset      int       `db:"offset"`
+		RowsPerPage int       `db:"rows_per_page"`
+	}{
+		ClientID:    clientID,
+		WebhookID:   webhookID,
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		webhook_deliveries d
+	WHERE
+		d.client_id = @client_id AND
+		d.webhook_id = @webhook_id
+	ORDER BY
+		d.date_created DESC
+	OFFSET @offset ROWS FETCH NEXT @rows_per_page ROWS ONLY`
+
+	ds, err := db.NamedQuerySlice[dbWebhookDelivery](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toWebhookDeliveries(ds), nil
+}
+
+func (s *Store) QueryDueWebhookDeliveries(ctx context.Context, date time.Time, limit int) ([]client.WebhookDelivery, error) {
+	data := struct {
+		Status string    `db:"status"`
+		Date   time.Time `db:"date"`
+		Limit  int       `db:"limit"`
+	}{
+		Status: client.DeliveryPending,
+		Date:   date,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		webhook_deliveries d
+	WHERE
+		d.status = @status AND
+		d.next_attempt <= @date
+	ORDER BY
+		d.next_attempt
+	LIMIT @limit`
+
+	ds, err := db.NamedQuerySlice[dbWebhookDelivery](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toWebhookDeliveries(ds), nil
+}
+
+func (s *Store) UpdateWebhookDelivery(ctx context.Context, d client.WebhookDelivery) error {
+	const q = `
+	UPDATE
+		webhook_deliveries
+	SET
+		status = @status,
+		attempts = @attempts,
+		response_status = @response_status,
+		last_error = @last_error,
+		next_attempt = @next_attempt,
+		date_updated = @date_updated
+	WHERE
+		id = @id`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBWebhookDelivery(d)); err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteWebhookDeliveries(ctx context.Context, date time.Time) (int, error) {
+	data := struct {
+		Status string    `db:"status"`
+		Date   time.Time `db:"date"`
+	}{
+		Status: client.DeliveryPending,
+		Date:   date,
+	}
+
+	const q = `
+	WITH deleted AS (
+		DELETE FROM
+			webhook_deliveries
+		WHERE
+			status <> @status AND
+			date_updated < @date
+		RETURNING
+			1
+	)
+	SELECT
+		COUNT(*) AS count
+	FROM
+		deleted`
+
+	ret, err := db.NamedQueryStruct[dbCount](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+
+	return ret.Count, nil
+}
+
+// AddClientUpdate takes the next Seq of the client from client_update_seqs,
+// which locks the client's row until the end of the transaction, so the
+// updates of a client are committed in Seq order. The listeners are
+// notified by NOTIFY when the transaction commits.
+func (s *Store) AddClientUpdate(ctx context.Context, u client.ClientUpdate) error {
+	const q = `
+	WITH s AS (
+		INSERT INTO client_update_seqs AS s (client_id, seq)
+		VALUES (@client_id, 1)
+		ON CONFLICT (client_id) DO UPDATE SET seq = s.seq + 1
+		RETURNING s.client_id, s.seq
+	), u AS (
+		INSERT INTO client_updates(
+			client_id,
+			seq,
+			type,
+			data,
+			date_created)
+		SELECT
+			s.client_id,
+			s.seq,
+			@type,
+			@data,
+			@date_created
+		FROM
+			s
+		RETURNING client_id
+	)
+	SELECT
+		pg_notify('` + updatesChannel + `', u.client_id::TEXT)
+	FROM
+		u`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, toDBClientUpdate(u)); err != nil {
+		return fmt.Errorf("failed to add client update: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryClientUpdates(ctx context.Context, clientID int, after int64, limit int) ([]client.ClientUpdate, error) {
+	data := struct {
+		ClientID int   `db:"client_id"`
+		After    int64 `db:"after"`
+		Limit    int   `db:"limit"`
+	}{
+		ClientID: clientID,
+		After:    after,
+		Limit:    limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		client_updates u
+	WHERE
+		u.client_id = @client_id AND
+		u.seq > @after
+	ORDER BY
+		u.seq
+	LIMIT @limit`
+
+	us, err := db.NamedQuerySlice[dbClientUpdate](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return toClientUpdates(us), nil
+}
+
+func (s *Store) QueryClientUpdateSeq(ctx context.Context, clientID int) (int64, error) {
+	data := struct {
+		ClientID int `db:"client_id"`
+	}{
+		ClientID: clientID,
+	}
+
+	const q = `
+	SELECT
+		COALESCE(MAX(s.seq), 0) AS seq
+	FROM
+		client_update_seqs s
+	WHERE
+		s.client_id = @client_id`
+
+	ret, err := db.NamedQueryStruct[dbSeq](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return ret.Seq, nil
+}
+
+// DeleteClientUpdates keeps the client_update_seqs, so the Seqs of the
+// deleted updates are not reused.
+func (s *Store) DeleteClientUpdates(ctx context.Context, date time.Time) (int, error) {
+	data := struct {
+		Date time.Time `db:"date"`
+	}{
+		Date: date,
+	}
+
+	const q = `
+	WITH deleted AS (
+		DELETE FROM
+			client_updates
+		WHERE
+			date_created < @date
+		RETURNING
+			1
+	)
+	SELECT
+		COUNT(*) AS count
+	FROM
+		deleted`
+
+	ret, err := db.NamedQueryStruct[dbCount](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete client updates: %w", err)
+	}
+
+	return ret.Count, nil
+}
+
+func (s *Store) QueryLedgerSeq(ctx context.Context) (int64, error) {
+	const q = `
+	SELECT
+		l.seq
+	FROM
+		ledger_seq l
+	FOR UPDATE`
+
+	ret, err := db.NamedQueryStruct[dbSeq](ctx, s.log, s.db, q, struct{}{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query ledger seq: %w", err)
+	}
+
+	return ret.Seq, nil
+}
+
+func (s *Store) QueryUnsequencedTransactions(ctx context.Context, limit int) ([]client.Transaction, error) {
+	data := struct {
+		Limit int `db:"limit"`
+	}{
+		Limit: limit,
+	}
+
+	const q = `
+	SELECT
+		t.*
+	FROM
+		ledger_unsequenced u
+	JOIN
+		transactions t ON t.id = u.transaction_id
+	ORDER BY
+		u.pos
+	LIMIT @limit`
+
+	ts, err := db.NamedQuerySlice[dbTransaction](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unsequenced transactions: %w", err)
+	}
+
+	return toTransactions(ts), nil
+}
+
+func (s *Store) AddLedgerEntries(ctx context.Context, es []client.LedgerEntry) error {
+	if len(es) == 0 {
+		return nil
+	}
+
+	data := struct {
+		Seqs           []int64  `db:"seqs"`
+		TransactionIDs []string `db:"transaction_ids"`
+	}{
+		Seqs:           make([]int64, len(es)),
+		TransactionIDs: make([]string, len(es)),
+	}
+	for i, e := range es {
+		data.Seqs[i] = e.Seq
+		data.TransactionIDs[i] = e.Transaction.ID.String()
+	}
+
+	const q = `
+	WITH u AS (
+		DELETE FROM ledger_unsequenced
+		WHERE transaction_id = ANY(@transaction_ids::TEXT[])
+	), l AS (
+		INSERT INTO ledger(seq, transaction_id)
+		SELECT
+			e.seq,
+			e.transaction_id
+		FROM
+			UNNEST(@seqs::BIGINT[], @transaction_ids::TEXT[]) AS e(seq, transaction_id)
+		RETURNING seq
+	)
+	UPDATE ledger_seq SET
+		seq = (SELECT MAX(l.seq) FROM l)`
+
+	if err := db.NamedExec(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("failed to add ledger entries: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) QueryLedger(ctx context.Context, after int64, limit int) ([]client.LedgerEntry, error) {
+	data := struct {
+		After int64 `db:"after"`
+		Limit int   `db:"limit"`
+	}{
+		After: after,
+		Limit: limit,
+	}
+
+	const q = `
+	SELECT
+		l.seq,
+		t.*
+	FROM
+		ledger l
+	JOIN
+		transactions t ON t.id = l.transaction_id
+	WHERE
+		l.seq > @after
+	ORDER BY
+		l.seq
+	LIMIT @limit`
+
+	es, err := db.NamedQuerySlice[dbLedgerEntry](ctx, s.log, s.db, q, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger: %w", err)
+	}
+
+	return toLedgerEntries(es), nil
+}
@@ -2,9 +2,11 @@ package clientdb
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 	"github.com/rschio/rinha/internal/core/client"
 	"github.com/rschio/rinha/internal/data/dbtest"
@@ -31,6 +33,103 @@ func TestQueryByID(t *testing.T) {
 	if c.Balance != 0 {
 		t.Errorf("wrong balance, got %d want %v", c.Balance, 0)
 	}
+	if c.Currency != client.DefaultCurrency {
+		t.Errorf("wrong currency, got %q want %q", c.Currency, client.DefaultCurrency)
+	}
+}
+
+func TestQueryTransactionsAfter(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	// Transactions with the same date are ordered by ID.
+	clientID := 3
+	date := time.Now().UTC().Round(time.Microsecond)
+	for range 25 {
+		tr := genTransaction(clientID)
+		tr.Date = date
+		if err := store.AddTransaction(ctx, tr); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	var after client.Cursor
+	for {
+		ts, err := store.QueryTransactionsAfter(ctx, clientID, client.TransactionFilter{}, after, 10)
+		if err != nil {
+			t.Fatalf("failed to query transactions: %v", err)
+		}
+		if len(ts) == 0 {
+			break
+		}
+
+		for _, tr := range ts {
+			if seen[tr.ID] {
+				t.Fatalf("transaction %v returned twice", tr.ID)
+			}
+			seen[tr.ID] = true
+		}
+
+		last := ts[len(ts)-1]
+		after = client.Cursor{Date: last.Date, ID: last.ID}
+	}
+
+	if len(seen) != 25 {
+		t.Errorf("got %d transactions, want %d", len(seen), 25)
+	}
+}
+
+func TestCreateClient(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	c := client.Client{
+		ID:          6,
+		Currency:    "USD",
+		Limit:       5000,
+		ClosingDay:  15,
+		Status:      client.StatusActive,
+		Name:        "Maria",
+		Document:    "52998224725",
+		Email:       "maria@example.com",
+		DateCreated: time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := store.CreateClient(ctx, c); err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := store.CreateClient(ctx, c); !errors.Is(err, client.ErrAlreadyExists) {
+		t.Fatalf("got err %v want %v", err, client.ErrAlreadyExists)
+	}
+	dup := client.Client{ID: 7, Document: c.Document}
+	if err := store.CreateClient(ctx, dup); !errors.Is(err, client.ErrDocumentExists) {
+		t.Fatalf("got err %v want %v", err, client.ErrDocumentExists)
+	}
+
+	got, err := store.QueryByDocument(ctx, c.Document)
+	if err != nil {
+		t.Fatalf("failed to query client by document: %v", err)
+	}
+	if got != c {
+		t.Errorf("got client %+v want %+v", got, c)
+	}
+
+	cs, err := store.QueryClients(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query clients: %v", err)
+	}
+	if len(cs) != 6 {
+		t.Fatalf("got %d clients, want %d", len(cs), 6)
+	}
+	if cs[5] != c {
+		t.Errorf("got client %+v want %+v", cs[5], c)
+	}
 }
 
 func TestQueryTransactions(t *testing.T) {
@@ -47,7 +146,7 @@ func TestQueryTransactions(t *testing.T) {
 		}
 	}
 
-	ts, err := store.QueryTransactions(ctx, clientID, 1, 10)
+	ts, err := store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 10)
 	if err != nil {
 		t.Fatalf("failed to query transactions: %v", err)
 	}
@@ -62,7 +161,7 @@ func TestQueryTransactions(t *testing.T) {
 	}
 
 	clientID = 1
-	ts, err = store.QueryTransactions(ctx, clientID, 1, 10)
+	ts, err = store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 10)
 	if err != nil {
 		t.Fatalf("failed to query transactions: %v", err)
 	}
@@ -71,6 +170,134 @@ func TestQueryTransactions(t *testing.T) {
 	}
 }
 
+func TestTransactionReversal(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 3
+	orig := genTransaction(clientID)
+	rev := genTransaction(clientID)
+	rev.Type = "c"
+	rev.ReversalOf = orig.ID
+
+	for _, tr := range []client.Transaction{orig, rev} {
+		if err := store.AddTransaction(ctx, tr); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+	if err := store.UpdateTransactionReversedBy(ctx, orig.ID, rev.ID); err != nil {
+		t.Fatalf("failed to mark transaction as reversed: %v", err)
+	}
+
+	got, err := store.QueryTransactionByID(ctx, clientID, orig.ID)
+	if err != nil {
+		t.Fatalf("failed to query transaction: %v", err)
+	}
+	if got.ReversedBy != rev.ID {
+		t.Errorf("wrong reversed by, got %v want %v", got.ReversedBy, rev.ID)
+	}
+	if got.Value != orig.Value {
+		t.Errorf("wrong value, got %d want %d", got.Value, orig.Value)
+	}
+
+	got, err = store.QueryTransactionByID(ctx, clientID, rev.ID)
+	if err != nil {
+		t.Fatalf("failed to query transaction: %v", err)
+	}
+	if got.ReversalOf != orig.ID {
+		t.Errorf("wrong reversal of, got %v want %v", got.ReversalOf, orig.ID)
+	}
+
+	if _, err := store.QueryTransactionByID(ctx, 1, orig.ID); !errors.Is(err, client.ErrTransactionNotFound) {
+		t.Errorf("got err %v want %v", err, client.ErrTransactionNotFound)
+	}
+}
+
+func TestLimitChanges(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 4
+	lc := client.LimitChange{
+		ID:       uuid.New(),
+		ClientID: clientID,
+		OldLimit: 10000000,
+		NewLimit: 500,
+		Reason:   "reason",
+		TraceID:  "trace",
+		Date:     time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := store.AddLimitChange(ctx, lc); err != nil {
+		t.Fatalf("failed to add limit change: %v", err)
+	}
+
+	c, err := store.UpdateClientLimit(ctx, clientID, lc.NewLimit)
+	if err != nil {
+		t.Fatalf("failed to update limit: %v", err)
+	}
+	if c.Limit != lc.NewLimit {
+		t.Errorf("wrong limit, got %d want %d", c.Limit, lc.NewLimit)
+	}
+
+	lcs, err := store.QueryLimitChanges(ctx, clientID, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query limit changes: %v", err)
+	}
+	if len(lcs) != 1 {
+		t.Fatalf("got %d limit changes, want %d", len(lcs), 1)
+	}
+	if lcs[0] != lc {
+		t.Errorf("got limit change %+v want %+v", lcs[0], lc)
+	}
+}
+
+func TestStatusChanges(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 4
+	sc := client.StatusChange{
+		ID:        uuid.New(),
+		ClientID:  clientID,
+		OldStatus: client.StatusActive,
+		NewStatus: client.StatusFrozen,
+		Reason:    "reason",
+		TraceID:   "trace",
+		Date:      time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := store.AddStatusChange(ctx, sc); err != nil {
+		t.Fatalf("failed to add status change: %v", err)
+	}
+
+	c, err := store.UpdateClientStatus(ctx, clientID, sc.NewStatus)
+	if err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	if c.Status != sc.NewStatus {
+		t.Errorf("wrong status, got %q want %q", c.Status, sc.NewStatus)
+	}
+
+	scs, err := store.QueryStatusChanges(ctx, clientID, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query status changes: %v", err)
+	}
+	if len(scs) != 1 {
+		t.Fatalf("got %d status changes, want %d", len(scs), 1)
+	}
+	if scs[0] != sc {
+		t.Errorf("got status change %+v want %+v", scs[0], sc)
+	}
+}
+
 func genTransaction(clientID int) client.Transaction {
 	return client.Transaction{
 		ID:          uuid.New(),
@@ -81,3 +308,777 @@ func genTransaction(clientID int) client.Transaction {
 		Date:        time.Now(),
 	}
 }
+
+func TestHolds(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 5
+	now := time.Now().UTC().Round(time.Microsecond)
+	h := client.Hold{
+		ID:          uuid.New(),
+		ClientID:    clientID,
+		Value:       1000,
+		Description: "hold",
+		Status:      client.HoldActive,
+		Date:        now,
+		DateUpdated: now,
+		ExpiresAt:   now.Add(time.Minute),
+	}
+	if err := store.AddHold(ctx, h); err != nil {
+		t.Fatalf("failed to add hold: %v", err)
+	}
+
+	c, err := store.UpdateClientReserved(ctx, clientID, h.Value)
+	if err != nil {
+		t.Fatalf("failed to update reserved: %v", err)
+	}
+	if c.Reserved != h.Value {
+		t.Errorf("wrong reserved, got %d want %d", c.Reserved, h.Value)
+	}
+
+	hs, err := store.QueryExpiredHolds(ctx, now.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("failed to query expired holds: %v", err)
+	}
+	if len(hs) != 1 || hs[0].ID != h.ID {
+		t.Fatalf("got wrong expired holds: %+v", hs)
+	}
+
+	tr := genTransaction(clientID)
+	if err := store.AddTransaction(ctx, tr); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	h.Status = client.HoldCaptured
+	h.CapturedValue = tr.Value
+	h.TransactionID = tr.ID
+	if err := store.UpdateHold(ctx, h); err != nil {
+		t.Fatalf("failed to update hold: %v", err)
+	}
+
+	got, err := store.QueryHoldByID(ctx, clientID, h.ID)
+	if err != nil {
+		t.Fatalf("failed to query hold: %v", err)
+	}
+	if got != h {
+		t.Errorf("got hold %+v want %+v", got, h)
+	}
+
+	if _, err := store.QueryHoldByID(ctx, 1, h.ID); !errors.Is(err, client.ErrHoldNotFound) {
+		t.Errorf("got err %v want %v", err, client.ErrHoldNotFound)
+	}
+}
+
+func TestScheduled(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 2
+	now := time.Now().UTC().Round(time.Microsecond)
+	st := client.ScheduledTransaction{
+		ID:           uuid.New(),
+		ClientID:     clientID,
+		Value:        1000,
+		Type:         "d",
+		Description:  "scheduled",
+		ScheduledFor: now.Add(time.Minute),
+		Status:       client.ScheduledPending,
+		Date:         now,
+		DateUpdated:  now,
+	}
+	if err := store.AddScheduled(ctx, st); err != nil {
+		t.Fatalf("failed to add scheduled transaction: %v", err)
+	}
+
+	due, err := store.QueryDueScheduled(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("failed to query due scheduled transactions: %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("got %d due scheduled transactions want %d", len(due), 0)
+	}
+
+	due, err = store.QueryDueScheduled(ctx, st.ScheduledFor, 10)
+	if err != nil {
+		t.Fatalf("failed to query due scheduled transactions: %v", err)
+	}
+	if len(due) != 1 || due[0] != st {
+		t.Fatalf("got wrong due scheduled transactions: %+v", due)
+	}
+
+	st.Status = client.ScheduledDenied
+	st.Reason = "denied"
+	if err := store.UpdateScheduled(ctx, st); err != nil {
+		t.Fatalf("failed to update scheduled transaction: %v", err)
+	}
+
+	got, err := store.QueryScheduledByID(ctx, clientID, st.ID)
+	if err != nil {
+		t.Fatalf("failed to query scheduled transaction: %v", err)
+	}
+	if got != st {
+		t.Errorf("got scheduled transaction %+v want %+v", got, st)
+	}
+
+	if _, err := store.QueryScheduledByID(ctx, 1, st.ID); !errors.Is(err, client.ErrScheduledNotFound) {
+		t.Errorf("got err %v want %v", err, client.ErrScheduledNotFound)
+	}
+}
+
+func TestFXRates(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	if _, err := store.QueryFXRate(ctx, "USD", "BRL"); !errors.Is(err, client.ErrFXRateNotFound) {
+		t.Fatalf("got err %v want %v", err, client.ErrFXRateNotFound)
+	}
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	rs := []client.FXRate{
+		{ID: uuid.New(), From: "USD", To: "BRL", Rate: 4_000_000, Date: now.Add(-time.Hour)},
+		{ID: uuid.New(), From: "USD", To: "BRL", Rate: 5_000_000, Date: now},
+		{ID: uuid.New(), From: "EUR", To: "BRL", Rate: 6_000_000, Date: now},
+	}
+	for _, r := range rs {
+		if err := store.AddFXRate(ctx, r); err != nil {
+			t.Fatalf("failed to add fx rate: %v", err)
+		}
+	}
+
+	r, err := store.QueryFXRate(ctx, "USD", "BRL")
+	if err != nil {
+		t.Fatalf("failed to query fx rate: %v", err)
+	}
+	if r != rs[1] {
+		t.Errorf("got rate %+v want %+v", r, rs[1])
+	}
+
+	got, err := store.QueryFXRates(ctx)
+	if err != nil {
+		t.Fatalf("failed to query fx rates: %v", err)
+	}
+	if len(got) != 2 || got[0] != rs[2] || got[1] != rs[1] {
+		t.Errorf("got wrong rates: %+v", got)
+	}
+
+	tr := genTransaction(1)
+	tr.Currency = "USD"
+	tr.OriginalValue = tr.Value
+	tr.FXRate = r.Rate
+	if err := store.AddTransaction(ctx, tr); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	gotTr, err := store.QueryTransactionByID(ctx, 1, tr.ID)
+	if err != nil {
+		t.Fatalf("failed to query transaction: %v", err)
+	}
+	if gotTr.Currency != tr.Currency || gotTr.OriginalValue != tr.OriginalValue || gotTr.FXRate != tr.FXRate {
+		t.Errorf("got transaction %+v want %+v", gotTr, tr)
+	}
+}
+
+func TestInterestAccruals(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 4
+	tr := genTransaction(clientID)
+	if err := store.AddTransaction(ctx, tr); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	if _, err := store.UpdateClientBalance(ctx, clientID, -tr.Value); err != nil {
+		t.Fatalf("failed to update balance: %v", err)
+	}
+
+	cs, err := store.QueryOverdrawnClients(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("failed to query overdrawn clients: %v", err)
+	}
+	if len(cs) != 1 || cs[0].ID != clientID {
+		t.Fatalf("got wrong overdrawn clients: %+v", cs)
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	if _, err := store.QueryInterestAccrual(ctx, clientID, day); !errors.Is(err, client.ErrAccrualNotFound) {
+		t.Fatalf("got err %v want %v", err, client.ErrAccrualNotFound)
+	}
+
+	a := client.InterestAccrual{
+		ClientID:      clientID,
+		Day:           day,
+		Balance:       -tr.Value,
+		Rate:          1000,
+		Value:         1,
+		TransactionID: tr.ID,
+		Date:          time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := store.AddInterestAccrual(ctx, a); err != nil {
+		t.Fatalf("failed to add interest accrual: %v", err)
+	}
+
+	got, err := store.QueryInterestAccrual(ctx, clientID, day)
+	if err != nil {
+		t.Fatalf("failed to query interest accrual: %v", err)
+	}
+	if got != a {
+		t.Errorf("got accrual %+v want %+v", got, a)
+	}
+}
+
+func TestInvoices(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 3
+	if _, err := store.QueryLastInvoice(ctx, clientID); !errors.Is(err, client.ErrInvoiceNotFound) {
+		t.Fatalf("got err %v want %v", err, client.ErrInvoiceNotFound)
+	}
+
+	tr := genTransaction(clientID)
+	tr.Date = tr.Date.UTC().Round(time.Microsecond)
+	end := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	invs := []client.Invoice{
+		{
+			ID:             uuid.New(),
+			ClientID:       clientID,
+			PeriodStart:    end.AddDate(0, -2, 0),
+			PeriodEnd:      end.AddDate(0, -1, 0),
+			OpeningBalance: 0,
+			ClosingBalance: 0,
+			DueDate:        end.AddDate(0, -1, 10),
+			Transactions:   []client.Transaction{},
+			Date:           end.AddDate(0, -1, 0),
+		},
+		{
+			ID:             uuid.New(),
+			ClientID:       clientID,
+			PeriodStart:    end.AddDate(0, -1, 0),
+			PeriodEnd:      end,
+			OpeningBalance: 0,
+			ClosingBalance: -tr.Value,
+			DueDate:        end.AddDate(0, 0, 10),
+			Transactions:   []client.Transaction{tr},
+			Date:           end,
+		},
+	}
+	for _, inv := range invs {
+		if err := store.AddInvoice(ctx, inv); err != nil {
+			t.Fatalf("failed to add invoice: %v", err)
+		}
+	}
+
+	dup := invs[1]
+	dup.ID = uuid.New()
+	if err := store.AddInvoice(ctx, dup); err == nil {
+		t.Fatalf("added invoice with duplicated period")
+	}
+
+	got, err := store.QueryInvoiceByID(ctx, clientID, invs[1].ID)
+	if err != nil {
+		t.Fatalf("failed to query invoice: %v", err)
+	}
+	if diff := cmp.Diff(invs[1], got); diff != "" {
+		t.Fatalf("got different invoice: %s", diff)
+	}
+
+	if _, err := store.QueryInvoiceByID(ctx, clientID+1, invs[1].ID); !errors.Is(err, client.ErrInvoiceNotFound) {
+		t.Fatalf("got err %v want %v", err, client.ErrInvoiceNotFound)
+	}
+
+	last, err := store.QueryLastInvoice(ctx, clientID)
+	if err != nil {
+		t.Fatalf("failed to query last invoice: %v", err)
+	}
+	if last.ID != invs[1].ID || last.Transactions != nil {
+		t.Fatalf("got wrong last invoice: %+v", last)
+	}
+
+	list, err := store.QueryInvoices(ctx, clientID, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query invoices: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != invs[1].ID || list[1].ID != invs[0].ID {
+		t.Fatalf("got wrong invoices: %+v", list)
+	}
+}
+
+func TestSpendingLimits(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 2
+	l, err := store.QuerySpendingLimits(ctx, clientID)
+	if err != nil {
+		t.Fatalf("failed to query spending limits: %v", err)
+	}
+	if l != (client.SpendingLimits{ClientID: clientID}) {
+		t.Fatalf("got limits %+v want zero limits", l)
+	}
+
+	want := client.SpendingLimits{
+		ClientID:            clientID,
+		MaxDebitsPerMinute:  3,
+		MaxDailyDebit:       1000,
+		MaxTransactionValue: 500,
+		DateUpdated:         time.Now().UTC().Round(time.Microsecond),
+	}
+	for range 2 {
+		if err := store.SaveSpendingLimits(ctx, want); err != nil {
+			t.Fatalf("failed to save spending limits: %v", err)
+		}
+	}
+
+	l, err = store.QuerySpendingLimits(ctx, clientID)
+	if err != nil {
+		t.Fatalf("failed to query spending limits: %v", err)
+	}
+	if l != want {
+		t.Fatalf("got limits %+v want %+v", l, want)
+	}
+
+	since := time.Now().Add(-time.Minute)
+	credit := genTransaction(clientID)
+	credit.Type = "c"
+	for _, tr := range []client.Transaction{genTransaction(clientID), genTransaction(clientID), credit} {
+		if err := store.AddTransaction(ctx, tr); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	d, err := store.QueryDebitTotals(ctx, clientID, since)
+	if err != nil {
+		t.Fatalf("failed to query debit totals: %v", err)
+	}
+	if want := (client.DebitTotals{Count: 2, Total: 1500}); d != want {
+		t.Fatalf("got debit totals %+v want %+v", d, want)
+	}
+}
+
+func TestTransactionFlags(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 1
+	tr := genTransaction(clientID)
+	if err := store.AddTransaction(ctx, tr); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	f := client.TransactionFlag{
+		ID:            uuid.New(),
+		ClientID:      clientID,
+		TransactionID: tr.ID,
+		Rule:          client.RuleValueCap,
+		Reason:        "value 750 above 500",
+		Date:          time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := store.AddTransactionFlag(ctx, f); err != nil {
+		t.Fatalf("failed to add transaction flag: %v", err)
+	}
+
+	fs, err := store.QueryTransactionFlags(ctx, clientID, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query transaction flags: %v", err)
+	}
+	if len(fs) != 1 || fs[0] != f {
+		t.Fatalf("got flags %+v want %+v", fs, f)
+	}
+}
+
+func TestSumTransactions(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 5
+	credit := genTransaction(clientID)
+	credit.Type, credit.Value = "c", 1000
+	for _, tr := range []client.Transaction{genTransaction(clientID), credit} {
+		if err := store.AddTransaction(ctx, tr); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	sum, err := store.SumTransactions(ctx, clientID)
+	if err != nil {
+		t.Fatalf("failed to sum transactions: %v", err)
+	}
+	if sum != 250 {
+		t.Fatalf("got sum %d want %d", sum, 250)
+	}
+
+	a := client.BalanceAdjustment{
+		ID:         uuid.New(),
+		ClientID:   clientID,
+		OldBalance: 0,
+		NewBalance: sum,
+		Reason:     client.ReconcileReason,
+		Date:       time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := store.AddBalanceAdjustment(ctx, a); err != nil {
+		t.Fatalf("failed to add balance adjustment: %v", err)
+	}
+}
+
+func TestQueryBalanceAt(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	clientID := 4
+	start := time.Now().UTC().Round(time.Microsecond)
+
+	var ts []client.Transaction
+	for i, balance := range []int{-750, -1500} {
+		tr := genTransaction(clientID)
+		tr.Date = start.Add(time.Duration(i+1) * time.Second)
+		tr.BalanceAfter = balance
+		if err := store.AddTransaction(ctx, tr); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+		ts = append(ts, tr)
+	}
+
+	tests := []struct {
+		date time.Time
+		want int
+	}{
+		{start, 0},
+		{ts[0].Date, -750},
+		{ts[1].Date.Add(-time.Microsecond), -750},
+		{ts[1].Date, -1500},
+	}
+	for _, tt := range tests {
+		got, err := store.QueryBalanceAt(ctx, clientID, tt.date)
+		if err != nil {
+			t.Fatalf("failed to query balance: %v", err)
+		}
+		if got != tt.want {
+			t.Fatalf("at %v: got balance %d want %d", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	var ids []uuid.UUID
+	for range 3 {
+		e := client.OutboxEvent{
+			ID:          uuid.New(),
+			Type:        client.TransactionCreated,
+			ClientID:    1,
+			Payload:     []byte(`{"valor":1}`),
+			Status:      client.OutboxPending,
+			NextAttempt: now,
+			Date:        now,
+			DateUpdated: now,
+		}
+		if err := store.AddOutboxEvent(ctx, e); err != nil {
+			t.Fatalf("failed to add outbox event: %v", err)
+		}
+		ids = append(ids, e.ID)
+	}
+
+	es, err := store.QueryPendingOutbox(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("failed to query pending outbox: %v", err)
+	}
+	if len(es) != 3 {
+		t.Fatalf("got %d events want %d", len(es), 3)
+	}
+	for i, e := range es {
+		if e.ID != ids[i] {
+			t.Fatalf("event[%d]: got %s want %s", i, e.ID, ids[i])
+		}
+	}
+
+	e := es[0]
+	e.Status = client.OutboxDead
+	e.Attempts = 10
+	e.LastError = "unavailable"
+	if err := store.UpdateOutboxEvent(ctx, e); err != nil {
+		t.Fatalf("failed to update outbox event: %v", err)
+	}
+
+	es, err = store.QueryPendingOutbox(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("failed to query pending outbox: %v", err)
+	}
+	if len(es) != 2 || es[0].ID != ids[1] {
+		t.Fatalf("got pending events %+v", es)
+	}
+
+	es, err = store.QueryPendingOutbox(ctx, es[0].Seq, 10)
+	if err != nil {
+		t.Fatalf("failed to query pending outbox: %v", err)
+	}
+	if len(es) != 1 || es[0].ID != ids[2] {
+		t.Fatalf("got pending events after the cursor %+v", es)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+	core := client.NewCore(store)
+
+	// A transaction that commits after a later one was sequenced.
+	added, commit, done := make(chan struct{}), make(chan struct{}), make(chan error, 1)
+	late := client.Transaction{
+		ID:          uuid.New(),
+		ClientID:    1,
+		Value:       7,
+		Type:        "c",
+		Description: "late",
+		Date:        time.Now().UTC().Round(time.Microsecond),
+		Currency:    client.DefaultCurrency,
+	}
+	go func() {
+		done <- store.ExecUnderTx(ctx, func(tx client.Store) error {
+			if err := tx.AddTransaction(ctx, late); err != nil {
+				return err
+			}
+			close(added)
+			<-commit
+			return nil
+		})
+	}()
+	<-added
+
+	nt := client.NewTransaction{Value: 10, Type: "c", Description: "early"}
+	if _, err := core.AddTransaction(ctx, 2, nt); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	if n, err := core.SequenceLedger(ctx); err != nil || n != 1 {
+		t.Fatalf("failed to sequence ledger: got %d, %v want 1", n, err)
+	}
+
+	close(commit)
+	if err := <-done; err != nil {
+		t.Fatalf("failed to commit late transaction: %v", err)
+	}
+
+	ts, err := store.QueryUnsequencedTransactions(ctx, 10)
+	if err != nil {
+		t.Fatalf("failed to query unsequenced transactions: %v", err)
+	}
+	if len(ts) != 1 || ts[0].ID != late.ID {
+		t.Fatalf("got unsequenced transactions %+v want %s", ts, late.ID)
+	}
+
+	if n, err := core.SequenceLedger(ctx); err != nil || n != 1 {
+		t.Fatalf("failed to sequence ledger: got %d, %v want 1", n, err)
+	}
+	seq, err := store.QueryLedgerSeq(ctx)
+	if err != nil || seq != 2 {
+		t.Fatalf("got ledger seq %d, %v want 2", seq, err)
+	}
+
+	es, err := store.QueryLedger(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("failed to query ledger: %v", err)
+	}
+	if len(es) != 2 || es[0].Transaction.Description != "early" || es[1].Seq != 2 {
+		t.Fatalf("got ledger %+v", es)
+	}
+	if diff := cmp.Diff(late, es[1].Transaction); diff != "" {
+		t.Fatalf("late transaction differs: %s", diff)
+	}
+
+	es, err = store.QueryLedger(ctx, 2, 10)
+	if err != nil || len(es) != 0 {
+		t.Fatalf("got ledger after 2 %+v, %v", es, err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	w := client.Webhook{
+		ID:       uuid.New(),
+		ClientID: 1,
+		URL:      "http://localhost/webhook",
+		Secret:   "secret",
+		Date:     now,
+	}
+	if err := store.AddWebhook(ctx, w); err != nil {
+		t.Fatalf("failed to add webhook: %v", err)
+	}
+
+	got, err := store.QueryWebhookByID(ctx, 1, w.ID)
+	if err != nil {
+		t.Fatalf("failed to query webhook: %v", err)
+	}
+	if diff := cmp.Diff(w, got); diff != "" {
+		t.Fatalf("got different webhook: %s", diff)
+	}
+	if _, err := store.QueryWebhookByID(ctx, 2, w.ID); !errors.Is(err, client.ErrWebhookNotFound) {
+		t.Fatalf("got err %v want %v", err, client.ErrWebhookNotFound)
+	}
+
+	var ds []client.WebhookDelivery
+	for i := range 2 {
+		d := client.WebhookDelivery{
+			ID:          uuid.New(),
+			WebhookID:   w.ID,
+			ClientID:    1,
+			EventID:     uuid.New(),
+			Event:       client.WebhookTransactionPosted,
+			Payload:     []byte(`{"valor": 1}`),
+			Status:      client.DeliveryPending,
+			NextAttempt: now.Add(time.Duration(i) * time.Second),
+			Date:        now.Add(time.Duration(i) * time.Second),
+			DateUpdated: now,
+		}
+		if err := store.AddWebhookDelivery(ctx, d); err != nil {
+			t.Fatalf("failed to add webhook delivery: %v", err)
+		}
+		ds = append(ds, d)
+	}
+
+	due, err := store.QueryDueWebhookDeliveries(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("failed to query due deliveries: %v", err)
+	}
+	if len(due) != 1 || due[0].ID != ds[0].ID {
+		t.Fatalf("got due deliveries %+v", due)
+	}
+
+	d := ds[0]
+	d.Status = client.DeliveryFailed
+	d.Attempts = 8
+	d.ResponseStatus = 503
+	d.LastError = "unavailable"
+	if err := store.UpdateWebhookDelivery(ctx, d); err != nil {
+		t.Fatalf("failed to update webhook delivery: %v", err)
+	}
+
+	gotD, err := store.QueryWebhookDeliveryByID(ctx, 1, d.ID)
+	if err != nil {
+		t.Fatalf("failed to query webhook delivery: %v", err)
+	}
+	if gotD.Status != d.Status || gotD.Attempts != d.Attempts || gotD.ResponseStatus != d.ResponseStatus {
+		t.Fatalf("got delivery %+v want %+v", gotD, d)
+	}
+
+	list, err := store.QueryWebhookDeliveries(ctx, 1, w.ID, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query webhook deliveries: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != ds[1].ID {
+		t.Fatalf("got deliveries %+v", list)
+	}
+}
+
+func TestClientUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	store := NewStore(log, database)
+
+	notified := make(chan int, 10)
+	done := make(chan error)
+	go func() {
+		done <- NewListener(log, database).Listen(ctx, func(clientID int) { notified <- clientID })
+	}()
+
+	// The listener connects asynchronously, add updates until it is
+	// notified.
+	var n int64
+	add := func(clientID int) {
+		u := client.ClientUpdate{
+			ClientID: clientID,
+			Type:     client.UpdateBalance,
+			Data:     []byte(`{"saldo": 1}`),
+			Date:     time.Now().UTC().Round(time.Microsecond),
+		}
+		if err := store.AddClientUpdate(ctx, u); err != nil {
+			t.Fatalf("failed to add client update: %v", err)
+		}
+	}
+	for waiting := true; waiting; {
+		add(1)
+		n++
+		select {
+		case id := <-notified:
+			if id != 1 {
+				t.Fatalf("got notified client %d want %d", id, 1)
+			}
+			waiting = false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// Notifications of the updates added while connecting may still
+	// arrive.
+	add(2)
+	for id := range notified {
+		if id == 2 {
+			break
+		}
+	}
+
+	us, err := store.QueryClientUpdates(ctx, 1, n-1, 10)
+	if err != nil {
+		t.Fatalf("failed to query client updates: %v", err)
+	}
+	if len(us) != 1 || us[0].Seq != n || us[0].Type != client.UpdateBalance {
+		t.Fatalf("got updates %+v want seq %d", us, n)
+	}
+
+	seq, err := store.QueryClientUpdateSeq(ctx, 2)
+	if err != nil {
+		t.Fatalf("failed to query client update seq: %v", err)
+	}
+	if seq != 1 {
+		t.Fatalf("got seq %d want %d", seq, 1)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+}
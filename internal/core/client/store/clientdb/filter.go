@@ -0,0 +1,65 @@
+package clientdb
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/rschio/rinha/internal/core/client"
+)
+
+// dbTransactionQuery holds the arguments of the queries that select a
+// client's transactions.
+type dbTransactionQuery struct {
+	ID          int       `db:"id"`
+	Type        string    `db:"type"`
+	StartDate   time.Time `db:"start_date"`
+	EndDate     time.Time `db:"end_date"`
+	MinValue    int       `db:"min_value"`
+	MaxValue    int       `db:"max_value"`
+	AfterDate   time.Time `db:"after_date"`
+	AfterID     string    `db:"after_id"`
+	Offset      int       `db:"offset"`
+	RowsPerPage int       `db:"rows_per_page"`
+}
+
+func toDBTransactionQuery(clientID int, filter client.TransactionFilter) dbTransactionQuery {
+	data := dbTransactionQuery{ID: clientID}
+	if filter.Type != nil {
+		data.Type = *filter.Type
+	}
+	if filter.StartDate != nil {
+		data.StartDate = filter.StartDate.UTC()
+	}
+	if filter.EndDate != nil {
+		data.EndDate = filter.EndDate.UTC()
+	}
+	if filter.MinValue != nil {
+		data.MinValue = *filter.MinValue
+	}
+	if filter.MaxValue != nil {
+		data.MaxValue = *filter.MaxValue
+	}
+
+	return data
+}
+
+// applyTransactionFilter appends the filter conditions to a query over the
+// transactions table aliased as t. Debits are stored as negative values, so
+// the values are compared by their absolute value.
+func applyTransactionFilter(filter client.TransactionFilter, buf *bytes.Buffer) {
+	if filter.Type != nil {
+		buf.WriteString(" AND t.type = @type")
+	}
+	if filter.StartDate != nil {
+		buf.WriteString(" AND t.date_created >= @start_date")
+	}
+	if filter.EndDate != nil {
+		buf.WriteString(" AND t.date_created < @end_date")
+	}
+	if filter.MinValue != nil {
+		buf.WriteString(" AND ABS(t.value) >= @min_value")
+	}
+	if filter.MaxValue != nil {
+		buf.WriteString(" AND ABS(t.value) <= @max_value")
+	}
+}
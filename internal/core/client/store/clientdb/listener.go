@@ -0,0 +1,61 @@
+package clientdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// updatesChannel is the channel notified of the client updates committed.
+const updatesChannel = "client_updates"
+
+// Listener is a client.Listener of the updates committed by any instance
+// that shares the database.
+type Listener struct {
+	log  *slog.Logger
+	pool *pgxpool.Pool
+}
+
+// NewListener creates a listener that takes its connection from the pool.
+func NewListener(log *slog.Logger, pool *pgxpool.Pool) *Listener {
+	return &Listener{
+		log:  log,
+		pool: pool,
+	}
+}
+
+// Listen calls fn with the client of each update committed until the ctx is
+// done. The connection is taken out of the pool, as it keeps listening until
+// it is closed.
+func (l *Listener) Listen(ctx context.Context, fn func(clientID int)) error {
+	pc, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	conn := pc.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+updatesChannel); err != nil {
+		return fmt.Errorf("listening to %s: %w", updatesChannel, err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("waiting for notification: %w", err)
+		}
+
+		clientID, err := strconv.Atoi(n.Payload)
+		if err != nil {
+			l.log.Error("listener", "channel", n.Channel, "payload", n.Payload, "ERROR", err)
+			continue
+		}
+		fn(clientID)
+	}
+}
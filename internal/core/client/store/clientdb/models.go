@@ -1,37 +1,92 @@
 package clientdb
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rschio/rinha/internal/core/client"
 )
 
+type dbCount struct {
+	Count int `db:"count"`
+}
+
+type dbSum struct {
+	Sum int `db:"sum"`
+}
+
 type dbClient struct {
-	ID      int `db:"id"`
-	Limit   int `db:"credit_limit"`
-	Balance int `db:"balance"`
+	ID          int       `db:"id"`
+	Currency    string    `db:"currency"`
+	Limit       int       `db:"credit_limit"`
+	Balance     int       `db:"balance"`
+	Reserved    int       `db:"reserved"`
+	ClosingDay  int       `db:"closing_day"`
+	Status      string    `db:"status"`
+	Name        string    `db:"name"`
+	Document    string    `db:"document,mask"`
+	Email       string    `db:"email"`
+	DateCreated time.Time `db:"date_created"`
 }
 
 func toClient(c dbClient) client.Client {
 	return client.Client{
-		ID:      c.ID,
-		Limit:   c.Limit,
-		Balance: c.Balance,
+		ID:          c.ID,
+		Currency:    c.Currency,
+		Limit:       c.Limit,
+		Balance:     c.Balance,
+		Reserved:    c.Reserved,
+		ClosingDay:  c.ClosingDay,
+		Status:      c.Status,
+		Name:        c.Name,
+		Document:    c.Document,
+		Email:       c.Email,
+		DateCreated: c.DateCreated,
+	}
+}
+
+func toClients(cs []dbClient) []client.Client {
+	slice := make([]client.Client, len(cs))
+	for i, c := range cs {
+		slice[i] = toClient(c)
 	}
+	return slice
 }
 
 type dbTransaction struct {
-	ID          uuid.UUID `db:"id"`
-	ClientID    int       `db:"client_id"`
-	Value       int       `db:"value"`
-	Type        string    `db:"type"`
-	Description string    `db:"description"`
-	Date        time.Time `db:"date_created"`
+	ID            uuid.UUID     `db:"id"`
+	ClientID      int           `db:"client_id"`
+	Value         int           `db:"value"`
+	Type          string        `db:"type"`
+	Description   string        `db:"description"`
+	Date          time.Time     `db:"date_created"`
+	ReversalOf    uuid.NullUUID `db:"reversal_of"`
+	ReversedBy    uuid.NullUUID `db:"reversed_by"`
+	TransferID    uuid.NullUUID `db:"transfer_id"`
+	Currency      string        `db:"currency"`
+	OriginalValue int           `db:"original_value"`
+	FXRate        int           `db:"fx_rate"`
+	BalanceAfter  int           `db:"balance_after"`
 }
 
 func toDBTransaction(t client.Transaction) dbTransaction {
-	dbt := dbTransaction(t)
+	dbt := dbTransaction{
+		ID:            t.ID,
+		ClientID:      t.ClientID,
+		Value:         t.Value,
+		Type:          t.Type,
+		Description:   t.Description,
+		Date:          t.Date,
+		ReversalOf:    toNullUUID(t.ReversalOf),
+		ReversedBy:    toNullUUID(t.ReversedBy),
+		TransferID:    toNullUUID(t.TransferID),
+		Currency:      t.Currency,
+		OriginalValue: t.OriginalValue,
+		FXRate:        t.FXRate,
+		BalanceAfter:  t.BalanceAfter,
+	}
 
 	// Store debit as negative values to make
 	// database SUM operations easier.
@@ -51,7 +106,21 @@ func toTransactions(ts []dbTransaction) []client.Transaction {
 }
 
 func toTransaction(t dbTransaction) client.Transaction {
-	ct := client.Transaction(t)
+	ct := client.Transaction{
+		ID:            t.ID,
+		ClientID:      t.ClientID,
+		Value:         t.Value,
+		Type:          t.Type,
+		Description:   t.Description,
+		Date:          t.Date,
+		ReversalOf:    t.ReversalOf.UUID,
+		ReversedBy:    t.ReversedBy.UUID,
+		TransferID:    t.TransferID.UUID,
+		Currency:      t.Currency,
+		OriginalValue: t.OriginalValue,
+		FXRate:        t.FXRate,
+		BalanceAfter:  t.BalanceAfter,
+	}
 
 	// Client transactions are always positive.
 	// The transaction type is used as signal.
@@ -61,3 +130,559 @@ func toTransaction(t dbTransaction) client.Transaction {
 
 	return ct
 }
+
+// toNullUUID converts the zero UUID to NULL.
+func toNullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: id != uuid.Nil}
+}
+
+type dbHold struct {
+	ID            uuid.UUID     `db:"id"`
+	ClientID      int           `db:"client_id"`
+	Value         int           `db:"value"`
+	Description   string        `db:"description"`
+	Status        string        `db:"status"`
+	CapturedValue int           `db:"captured_value"`
+	TransactionID uuid.NullUUID `db:"transaction_id"`
+	Date          time.Time     `db:"date_created"`
+	DateUpdated   time.Time     `db:"date_updated"`
+	ExpiresAt     time.Time     `db:"expires_at"`
+}
+
+func toDBHold(h client.Hold) dbHold {
+	return dbHold{
+		ID:            h.ID,
+		ClientID:      h.ClientID,
+		Value:         h.Value,
+		Description:   h.Description,
+		Status:        h.Status,
+		CapturedValue: h.CapturedValue,
+		TransactionID: toNullUUID(h.TransactionID),
+		Date:          h.Date,
+		DateUpdated:   h.DateUpdated,
+		ExpiresAt:     h.ExpiresAt,
+	}
+}
+
+func toHold(h dbHold) client.Hold {
+	return client.Hold{
+		ID:            h.ID,
+		ClientID:      h.ClientID,
+		Value:         h.Value,
+		Description:   h.Description,
+		Status:        h.Status,
+		CapturedValue: h.CapturedValue,
+		TransactionID: h.TransactionID.UUID,
+		Date:          h.Date,
+		DateUpdated:   h.DateUpdated,
+		ExpiresAt:     h.ExpiresAt,
+	}
+}
+
+func toHolds(hs []dbHold) []client.Hold {
+	slice := make([]client.Hold, len(hs))
+	for i, h := range hs {
+		slice[i] = toHold(h)
+	}
+	return slice
+}
+
+type dbScheduledTransaction struct {
+	ID            uuid.UUID     `db:"id"`
+	ClientID      int           `db:"client_id"`
+	Value         int           `db:"value"`
+	Type          string        `db:"type"`
+	Description   string        `db:"description"`
+	Currency      string        `db:"currency"`
+	ScheduledFor  time.Time     `db:"scheduled_for"`
+	Status        string        `db:"status"`
+	TransactionID uuid.NullUUID `db:"transaction_id"`
+	Reason        string        `db:"reason"`
+	Date          time.Time     `db:"date_created"`
+	DateUpdated   time.Time     `db:"date_updated"`
+}
+
+func toDBScheduledTransaction(st client.ScheduledTransaction) dbScheduledTransaction {
+	return dbScheduledTransaction{
+		ID:            st.ID,
+		ClientID:      st.ClientID,
+		Value:         st.Value,
+		Type:          st.Type,
+		Description:   st.Description,
+		Currency:      st.Currency,
+		ScheduledFor:  st.ScheduledFor,
+		Status:        st.Status,
+		TransactionID: toNullUUID(st.TransactionID),
+		Reason:        st.Reason,
+		Date:          st.Date,
+		DateUpdated:   st.DateUpdated,
+	}
+}
+
+func toScheduledTransaction(st dbScheduledTransaction) client.ScheduledTransaction {
+	return client.ScheduledTransaction{
+		ID:            st.ID,
+		ClientID:      st.ClientID,
+		Value:         st.Value,
+		Type:          st.Type,
+		Description:   st.Description,
+		Currency:      st.Currency,
+		ScheduledFor:  st.ScheduledFor,
+		Status:        st.Status,
+		TransactionID: st.TransactionID.UUID,
+		Reason:        st.Reason,
+		Date:          st.Date,
+		DateUpdated:   st.DateUpdated,
+	}
+}
+
+func toScheduledTransactions(sts []dbScheduledTransaction) []client.ScheduledTransaction {
+	slice := make([]client.ScheduledTransaction, len(sts))
+	for i, st := range sts {
+		slice[i] = toScheduledTransaction(st)
+	}
+	return slice
+}
+
+type dbFXRate struct {
+	ID   uuid.UUID `db:"id"`
+	From string    `db:"currency_from"`
+	To   string    `db:"currency_to"`
+	Rate int       `db:"rate"`
+	Date time.Time `db:"date_created"`
+}
+
+func toFXRates(rs []dbFXRate) []client.FXRate {
+	slice := make([]client.FXRate, len(rs))
+	for i, r := range rs {
+		slice[i] = client.FXRate(r)
+	}
+	return slice
+}
+
+type dbInterestAccrual struct {
+	ClientID      int       `db:"client_id"`
+	Day           time.Time `db:"day"`
+	Balance       int       `db:"balance"`
+	Rate          int       `db:"rate"`
+	Value         int       `db:"value"`
+	TransactionID uuid.UUID `db:"transaction_id"`
+	Override      bool      `db:"override"`
+	Date          time.Time `db:"date_created"`
+}
+
+type dbSpendingLimits struct {
+	ClientID            int       `db:"client_id"`
+	MaxDebitsPerMinute  int       `db:"max_debits_per_minute"`
+	MaxDailyDebit       int       `db:"max_daily_debit"`
+	MaxTransactionValue int       `db:"max_transaction_value"`
+	DateUpdated         time.Time `db:"date_updated"`
+}
+
+type dbBalanceAdjustment struct {
+	ID         uuid.UUID `db:"id"`
+	ClientID   int       `db:"client_id"`
+	OldBalance int       `db:"old_balance"`
+	NewBalance int       `db:"new_balance"`
+	Reason     string    `db:"reason"`
+	Date       time.Time `db:"date_created"`
+}
+
+type dbTransactionFlag struct {
+	ID            uuid.UUID `db:"id"`
+	ClientID      int       `db:"client_id"`
+	TransactionID uuid.UUID `db:"transaction_id"`
+	Rule          string    `db:"rule"`
+	Reason        string    `db:"reason"`
+	Date          time.Time `db:"date_created"`
+}
+
+func toTransactionFlags(dbFlags []dbTransactionFlag) []client.TransactionFlag {
+	slice := make([]client.TransactionFlag, len(dbFlags))
+	for i, f := range dbFlags {
+		slice[i] = client.TransactionFlag(f)
+	}
+	return slice
+}
+
+type dbDebitTotals struct {
+	Count int `db:"count"`
+	Total int `db:"total"`
+}
+
+type dbInvoice struct {
+	ID             uuid.UUID `db:"id"`
+	ClientID       int       `db:"client_id"`
+	PeriodStart    time.Time `db:"period_start"`
+	PeriodEnd      time.Time `db:"period_end"`
+	OpeningBalance int       `db:"opening_balance"`
+	ClosingBalance int       `db:"closing_balance"`
+	DueDate        time.Time `db:"due_date"`
+	Transactions   []byte    `db:"transactions"`
+	Date           time.Time `db:"date_created"`
+}
+
+// dbInvoiceTransaction is the snapshot of a transaction stored in the
+// invoice. Unlike the transactions table, debits are positive values.
+type dbInvoiceTransaction struct {
+	ID            uuid.UUID `json:"id"`
+	Value         int       `json:"value"`
+	Type          string    `json:"type"`
+	Description   string    `json:"description"`
+	Date          time.Time `json:"date_created"`
+	ReversalOf    uuid.UUID `json:"reversal_of"`
+	ReversedBy    uuid.UUID `json:"reversed_by"`
+	TransferID    uuid.UUID `json:"transfer_id"`
+	Currency      string    `json:"currency,omitempty"`
+	OriginalValue int       `json:"original_value,omitempty"`
+	FXRate        int       `json:"fx_rate,omitempty"`
+	BalanceAfter  int       `json:"balance_after"`
+}
+
+func toDBInvoice(inv client.Invoice) (dbInvoice, error) {
+	ts := make([]dbInvoiceTransaction, len(inv.Transactions))
+	for i, t := range inv.Transactions {
+		ts[i] = dbInvoiceTransaction{
+			ID:            t.ID,
+			Value:         t.Value,
+			Type:          t.Type,
+			Description:   t.Description,
+			Date:          t.Date,
+			ReversalOf:    t.ReversalOf,
+			ReversedBy:    t.ReversedBy,
+			TransferID:    t.TransferID,
+			Currency:      t.Currency,
+			OriginalValue: t.OriginalValue,
+			FXRate:        t.FXRate,
+			BalanceAfter:  t.BalanceAfter,
+		}
+	}
+
+	bs, err := json.Marshal(ts)
+	if err != nil {
+		return dbInvoice{}, err
+	}
+
+	return dbInvoice{
+		ID:             inv.ID,
+		ClientID:       inv.ClientID,
+		PeriodStart:    inv.PeriodStart,
+		PeriodEnd:      inv.PeriodEnd,
+		OpeningBalance: inv.OpeningBalance,
+		ClosingBalance: inv.ClosingBalance,
+		DueDate:        inv.DueDate,
+		Transactions:   bs,
+		Date:           inv.Date,
+	}, nil
+}
+
+// toInvoice converts the invoice. Invoices queried without the transactions
+// have nil Transactions.
+func toInvoice(dbInv dbInvoice) (client.Invoice, error) {
+	inv := client.Invoice{
+		ID:             dbInv.ID,
+		ClientID:       dbInv.ClientID,
+		PeriodStart:    dbInv.PeriodStart,
+		PeriodEnd:      dbInv.PeriodEnd,
+		OpeningBalance: dbInv.OpeningBalance,
+		ClosingBalance: dbInv.ClosingBalance,
+		DueDate:        dbInv.DueDate,
+		Date:           dbInv.Date,
+	}
+	if dbInv.Transactions == nil {
+		return inv, nil
+	}
+
+	var ts []dbInvoiceTransaction
+	if err := json.Unmarshal(dbInv.Transactions, &ts); err != nil {
+		return client.Invoice{}, fmt.Errorf("failed to decode invoice transactions: %w", err)
+	}
+
+	inv.Transactions = make([]client.Transaction, len(ts))
+	for i, t := range ts {
+		inv.Transactions[i] = client.Transaction{
+			ID:            t.ID,
+			ClientID:      dbInv.ClientID,
+			Value:         t.Value,
+			Type:          t.Type,
+			Description:   t.Description,
+			Date:          t.Date,
+			ReversalOf:    t.ReversalOf,
+			ReversedBy:    t.ReversedBy,
+			TransferID:    t.TransferID,
+			Currency:      t.Currency,
+			OriginalValue: t.OriginalValue,
+			FXRate:        t.FXRate,
+			BalanceAfter:  t.BalanceAfter,
+		}
+	}
+
+	return inv, nil
+}
+
+func toInvoices(dbInvs []dbInvoice) ([]client.Invoice, error) {
+	slice := make([]client.Invoice, len(dbInvs))
+	for i, dbInv := range dbInvs {
+		inv, err := toInvoice(dbInv)
+		if err != nil {
+			return nil, err
+		}
+		slice[i] = inv
+	}
+	return slice, nil
+}
+
+type dbLimitChange struct {
+	ID       uuid.UUID `db:"id"`
+	ClientID int       `db:"client_id"`
+	OldLimit int       `db:"old_limit"`
+	NewLimit int       `db:"new_limit"`
+	Reason   string    `db:"reason"`
+	TraceID  string    `db:"trace_id"`
+	Date     time.Time `db:"date_created"`
+}
+
+func toDBLimitChange(lc client.LimitChange) dbLimitChange {
+	return dbLimitChange(lc)
+}
+
+func toLimitChanges(lcs []dbLimitChange) []client.LimitChange {
+	slice := make([]client.LimitChange, len(lcs))
+	for i, lc := range lcs {
+		slice[i] = client.LimitChange(lc)
+	}
+	return slice
+}
+
+type dbStatusChange struct {
+	ID        uuid.UUID `db:"id"`
+	ClientID  int       `db:"client_id"`
+	OldStatus string    `db:"old_status"`
+	NewStatus string    `db:"new_status"`
+	Reason    string    `db:"reason"`
+	TraceID   string    `db:"trace_id"`
+	Date      time.Time `db:"date_created"`
+}
+
+func toDBStatusChange(sc client.StatusChange) dbStatusChange {
+	return dbStatusChange(sc)
+}
+
+func toStatusChanges(scs []dbStatusChange) []client.StatusChange {
+	slice := make([]client.StatusChange, len(scs))
+	for i, sc := range scs {
+		slice[i] = client.StatusChange(sc)
+	}
+	return slice
+}
+
+type dbIdempotencyKey struct {
+	ClientID    int       `db:"client_id"`
+	Key         string    `db:"key"`
+	RequestHash string    `db:"request_hash"`
+	Response    []byte    `db:"response"`
+	Date        time.Time `db:"date_created"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+type dbOutboxEvent struct {
+	ID          uuid.UUID `db:"id"`
+	Seq         int64     `db:"seq"`
+	Type        string    `db:"type"`
+	ClientID    int       `db:"client_id"`
+	Payload     []byte    `db:"payload"`
+	Status      string    `db:"status"`
+	Attempts    int       `db:"attempts"`
+	LastError   string    `db:"last_error"`
+	NextAttempt time.Time `db:"next_attempt"`
+	Date        time.Time `db:"date_created"`
+	DateUpdated time.Time `db:"date_updated"`
+}
+
+func toDBOutboxEvent(e client.OutboxEvent) dbOutboxEvent {
+	return dbOutboxEvent{
+		ID:          e.ID,
+		Seq:         e.Seq,
+		Type:        e.Type,
+		ClientID:    e.ClientID,
+		Payload:     e.Payload,
+		Status:      e.Status,
+		Attempts:    e.Attempts,
+		LastError:   e.LastError,
+		NextAttempt: e.NextAttempt,
+		Date:        e.Date,
+		DateUpdated: e.DateUpdated,
+	}
+}
+
+func toOutboxEvent(e dbOutboxEvent) client.OutboxEvent {
+	return client.OutboxEvent{
+		ID:          e.ID,
+		Seq:         e.Seq,
+		Type:        e.Type,
+		ClientID:    e.ClientID,
+		Payload:     e.Payload,
+		Status:      e.Status,
+		Attempts:    e.Attempts,
+		LastError:   e.LastError,
+		NextAttempt: e.NextAttempt,
+		Date:        e.Date,
+		DateUpdated: e.DateUpdated,
+	}
+}
+
+func toOutboxEvents(es []dbOutboxEvent) []client.OutboxEvent {
+	slice := make([]client.OutboxEvent, len(es))
+	for i, e := range es {
+		slice[i] = toOutboxEvent(e)
+	}
+	return slice
+}
+
+type dbWebhook struct {
+	ID       uuid.UUID `db:"id"`
+	ClientID int       `db:"client_id"`
+	URL      string    `db:"url"`
+	Secret   string    `db:"secret"`
+	Date     time.Time `db:"date_created"`
+}
+
+func toDBWebhook(w client.Webhook) dbWebhook {
+	return dbWebhook{
+		ID:       w.ID,
+		ClientID: w.ClientID,
+		URL:      w.URL,
+		Secret:   w.Secret,
+		Date:     w.Date,
+	}
+}
+
+func toWebhook(w dbWebhook) client.Webhook {
+	return client.Webhook{
+		ID:       w.ID,
+		ClientID: w.ClientID,
+		URL:      w.URL,
+		Secret:   w.Secret,
+		Date:     w.Date,
+	}
+}
+
+func toWebhooks(ws []dbWebhook) []client.Webhook {
+	slice := make([]client.Webhook, len(ws))
+	for i, w := range ws {
+		slice[i] = toWebhook(w)
+	}
+	return slice
+}
+
+type dbWebhookDelivery struct {
+	ID             uuid.UUID `db:"id"`
+	WebhookID      uuid.UUID `db:"webhook_id"`
+	ClientID       int       `db:"client_id"`
+	EventID        uuid.UUID `db:"event_id"`
+	Event          string    `db:"event"`
+	Payload        []byte    `db:"payload"`
+	Status         string    `db:"status"`
+	Attempts       int       `db:"attempts"`
+	ResponseStatus int       `db:"response_status"`
+	LastError      string    `db:"last_error"`
+	NextAttempt    time.Time `db:"next_attempt"`
+	Date           time.Time `db:"date_created"`
+	DateUpdated    time.Time `db:"date_updated"`
+}
+
+func toDBWebhookDelivery(d client.WebhookDelivery) dbWebhookDelivery {
+	return dbWebhookDelivery{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		ClientID:       d.ClientID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		NextAttempt:    d.NextAttempt,
+		Date:           d.Date,
+		DateUpdated:    d.DateUpdated,
+	}
+}
+
+func toWebhookDelivery(d dbWebhookDelivery) client.WebhookDelivery {
+	return client.WebhookDelivery{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		ClientID:       d.ClientID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		NextAttempt:    d.NextAttempt,
+		Date:           d.Date,
+		DateUpdated:    d.DateUpdated,
+	}
+}
+
+func toWebhookDeliveries(ds []dbWebhookDelivery) []client.WebhookDelivery {
+	slice := make([]client.WebhookDelivery, len(ds))
+	for i, d := range ds {
+		slice[i] = toWebhookDelivery(d)
+	}
+	return slice
+}
+
+type dbSeq struct {
+	Seq int64 `db:"seq"`
+}
+
+type dbClientUpdate struct {
+	ClientID int       `db:"client_id"`
+	Seq      int64     `db:"seq"`
+	Type     string    `db:"type"`
+	Data     []byte    `db:"data"`
+	Date     time.Time `db:"date_created"`
+}
+
+func toDBClientUpdate(u client.ClientUpdate) dbClientUpdate {
+	return dbClientUpdate{
+		ClientID: u.ClientID,
+		Seq:      u.Seq,
+		Type:     u.Type,
+		Data:     u.Data,
+		Date:     u.Date,
+	}
+}
+
+func toClientUpdates(us []dbClientUpdate) []client.ClientUpdate {
+	slice := make([]client.ClientUpdate, len(us))
+	for i, u := range us {
+		slice[i] = client.ClientUpdate{
+			ClientID: u.ClientID,
+			Seq:      u.Seq,
+			Type:     u.Type,
+			Data:     u.Data,
+			Date:     u.Date,
+		}
+	}
+	return slice
+}
+
+type dbLedgerEntry struct {
+	Seq int64 `db:"seq"`
+	dbTransaction
+}
+
+func toLedgerEntries(es []dbLedgerEntry) []client.LedgerEntry {
+	slice := make([]client.LedgerEntry, len(es))
+	for i, e := range es {
+		slice[i] = client.LedgerEntry{
+			Seq:         e.Seq,
+			Transaction: toTransaction(e.dbTransaction),
+		}
+	}
+	return slice
+}
@@ -0,0 +1,177 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	db "github.com/rschio/rinha/internal/data/dbsql/pgx"
+)
+
+// DBLog is a Log stored in the client_events and client_snapshots tables.
+type DBLog struct {
+	log *slog.Logger
+	db  db.DB
+}
+
+// NewDBLog creates a log stored in the database.
+func NewDBLog(log *slog.Logger, database db.DB) *DBLog {
+	return &DBLog{
+		log: log,
+		db:  database,
+	}
+}
+
+// The data of the events and the snapshots is masked in the logs, as it
+// has the documents of the clients.
+type dbEvent struct {
+	Seq         int64     `db:"seq"`
+	ClientID    int       `db:"client_id"`
+	Type        string    `db:"type"`
+	Data        []byte    `db:"data,mask"`
+	DateCreated time.Time `db:"date_created"`
+}
+
+type dbSnapshot struct {
+	Seq         int64     `db:"seq"`
+	Data        []byte    `db:"data,mask"`
+	DateCreated time.Time `db:"date_created"`
+}
+
+func (l *DBLog) Append(ctx context.Context, events []Event) error {
+	tx, err := l.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+	INSERT INTO client_events(
+		client_id,
+		type,
+		data,
+		date_created)
+	VALUES (
+		@client_id,
+		@type,
+		@data,
+		@date_created)
+	RETURNING
+		seq,
+		client_id,
+		type,
+		data,
+		date_created;`
+
+	for i, e := range events {
+		data := dbEvent{
+			ClientID:    e.ClientID,
+			Type:        string(e.Type),
+			Data:        e.Data,
+			DateCreated: e.Date,
+		}
+		dbE, err := db.NamedQueryStruct[dbEvent](ctx, l.log, tx, q, data)
+		if err != nil {
+			return fmt.Errorf("failed to append event: %w", err)
+		}
+		events[i].Seq = dbE.Seq
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (l *DBLog) Read(ctx context.Context, after int64, fn func(Event) error) error {
+	data := struct {
+		After int64 `db:"after"`
+	}{
+		After: after,
+	}
+
+	const q = `
+	SELECT
+		seq,
+		client_id,
+		type,
+		data,
+		date_created
+	FROM
+		client_events
+	WHERE
+		seq > @after
+	ORDER BY
+		seq;`
+
+	return db.NamedQueryEach(ctx, l.log, l.db, q, data, func(dbE dbEvent) error {
+		return fn(Event{
+			Seq:      dbE.Seq,
+			ClientID: dbE.ClientID,
+			Type:     Type(dbE.Type),
+			Data:     dbE.Data,
+			Date:     dbE.DateCreated,
+		})
+	})
+}
+
+func (l *DBLog) SaveSnapshot(ctx context.Context, snap Snapshot) error {
+	state, err := json.Marshal(snap.State)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	data := dbSnapshot{
+		Seq:         snap.Seq,
+		Data:        state,
+		DateCreated: snap.Date,
+	}
+
+	const q = `
+	INSERT INTO client_snapshots(
+		seq,
+		data,
+		date_created)
+	VALUES (
+		@seq,
+		@data,
+		@date_created)
+	ON CONFLICT (seq) DO NOTHING;`
+
+	if err := db.NamedExec(ctx, l.log, l.db, q, data); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	return nil
+}
+
+func (l *DBLog) LatestSnapshot(ctx context.Context) (Snapshot, error) {
+	const q = `
+	SELECT
+		seq,
+		data,
+		date_created
+	FROM
+		client_snapshots
+	ORDER BY
+		seq DESC
+	LIMIT 1;`
+
+	dbSnap, err := db.NamedQueryStruct[dbSnapshot](ctx, l.log, l.db, q, struct{}{})
+	if err != nil {
+		if errors.Is(err, db.ErrDBNotFound) {
+			return Snapshot{}, ErrNoSnapshot
+		}
+		return Snapshot{}, fmt.Errorf("failed to query snapshot: %w", err)
+	}
+
+	snap := Snapshot{
+		Seq:  dbSnap.Seq,
+		Date: dbSnap.DateCreated,
+	}
+	if err := json.Unmarshal(dbSnap.Data, &snap.State); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	return snap, nil
+}
@@ -0,0 +1,270 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
+)
+
+// Type is the type of an event.
+type Type string
+
+// Set of event types, one for each change of the store.
+const (
+	ClientCreated           Type = "ClientCreated"
+	TransactionPosted       Type = "TransactionPosted"
+	TransactionReversed     Type = "TransactionReversed"
+	BalanceChanged          Type = "BalanceChanged"
+	ReservedChanged         Type = "ReservedChanged"
+	LimitChanged            Type = "LimitChanged"
+	LimitChangeRecorded     Type = "LimitChangeRecorded"
+	StatusChanged           Type = "StatusChanged"
+	StatusChangeRecorded    Type = "StatusChangeRecorded"
+	HoldPlaced              Type = "HoldPlaced"
+	HoldUpdated             Type = "HoldUpdated"
+	TransactionScheduled    Type = "TransactionScheduled"
+	ScheduledUpdated        Type = "ScheduledUpdated"
+	FXRateSet               Type = "FXRateSet"
+	InterestAccrued         Type = "InterestAccrued"
+	InvoiceClosed           Type = "InvoiceClosed"
+	SpendingLimitsSet       Type = "SpendingLimitsSet"
+	BalanceAdjusted         Type = "BalanceAdjusted"
+	TransactionFlagged      Type = "TransactionFlagged"
+	IdempotencyKeySaved     Type = "IdempotencyKeySaved"
+	IdempotencyKeysPurged   Type = "IdempotencyKeysPurged"
+	OutboxEventAdded        Type = "OutboxEventAdded"
+	OutboxEventUpdated      Type = "OutboxEventUpdated"
+	OutboxEventsPurged      Type = "OutboxEventsPurged"
+	WebhookRegistered       Type = "WebhookRegistered"
+	WebhookDeliveryAdded    Type = "WebhookDeliveryAdded"
+	WebhookDeliveryUpdated  Type = "WebhookDeliveryUpdated"
+	WebhookDeliveriesPurged Type = "WebhookDeliveriesPurged"
+	ClientUpdated           Type = "ClientUpdated"
+	ClientUpdatesPurged     Type = "ClientUpdatesPurged"
+	LedgerSequenced         Type = "LedgerSequenced"
+)
+
+// Event is an entry of the log. Seq is assigned by the log when the event is
+// appended, ClientID is zero for the events that don't belong to a client
+// and Data is the JSON encoded payload of the event type.
+type Event struct {
+	Seq      int64
+	ClientID int
+	Type     Type
+	Data     json.RawMessage
+	Date     time.Time
+}
+
+// Payloads of the events that don't carry a client model.
+type (
+	transactionReversed struct {
+		TransactionID uuid.UUID
+		ReversalID    uuid.UUID
+	}
+
+	balanceChanged struct {
+		Balance int
+	}
+
+	reservedChanged struct {
+		Reserved int
+	}
+
+	limitChanged struct {
+		Limit int
+	}
+
+	statusChanged struct {
+		Status string
+	}
+
+	// purged is the payload of the deletions of the rows older than the
+	// Date.
+	purged struct {
+		Date time.Time
+	}
+
+	ledgerSequenced struct {
+		Entries []memstore.LedgerEntry
+	}
+)
+
+// newEvent returns an event of the client with the payload encoded.
+func newEvent(typ Type, clientID int, payload any) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("encoding %s event: %w", typ, err)
+	}
+
+	e := Event{
+		ClientID: clientID,
+		Type:     typ,
+		Data:     data,
+		Date:     time.Now().UTC().Round(time.Microsecond),
+	}
+
+	return e, nil
+}
+
+// apply applies the change recorded by the event to the projections.
+func apply(ctx context.Context, s client.Store, e Event) error {
+	switch e.Type {
+	case ClientCreated:
+		return applyPayload(e, func(c client.Client) error {
+			return s.CreateClient(ctx, c)
+		})
+	case TransactionPosted:
+		return applyPayload(e, func(t client.Transaction) error {
+			return s.AddTransaction(ctx, t)
+		})
+	case TransactionReversed:
+		return applyPayload(e, func(p transactionReversed) error {
+			return s.UpdateTransactionReversedBy(ctx, p.TransactionID, p.ReversalID)
+		})
+	case BalanceChanged:
+		return applyPayload(e, func(p balanceChanged) error {
+			_, err := s.UpdateClientBalance(ctx, e.ClientID, p.Balance)
+			return err
+		})
+	case ReservedChanged:
+		return applyPayload(e, func(p reservedChanged) error {
+			_, err := s.UpdateClientReserved(ctx, e.ClientID, p.Reserved)
+			return err
+		})
+	case LimitChanged:
+		return applyPayload(e, func(p limitChanged) error {
+			_, err := s.UpdateClientLimit(ctx, e.ClientID, p.Limit)
+			return err
+		})
+	case LimitChangeRecorded:
+		return applyPayload(e, func(lc client.LimitChange) error {
+			return s.AddLimitChange(ctx, lc)
+		})
+	case StatusChanged:
+		return applyPayload(e, func(p statusChanged) error {
+			_, err := s.UpdateClientStatus(ctx, e.ClientID, p.Status)
+			return err
+		})
+	case StatusChangeRecorded:
+		return applyPayload(e, func(sc client.StatusChange) error {
+			return s.AddStatusChange(ctx, sc)
+		})
+	case HoldPlaced:
+		return applyPayload(e, func(h client.Hold) error {
+			return s.AddHold(ctx, h)
+		})
+	case HoldUpdated:
+		return applyPayload(e, func(h client.Hold) error {
+			return s.UpdateHold(ctx, h)
+		})
+	case TransactionScheduled:
+		return applyPayload(e, func(st client.ScheduledTransaction) error {
+			return s.AddScheduled(ctx, st)
+		})
+	case ScheduledUpdated:
+		return applyPayload(e, func(st client.ScheduledTransaction) error {
+			return s.UpdateScheduled(ctx, st)
+		})
+	case FXRateSet:
+		return applyPayload(e, func(r client.FXRate) error {
+			return s.AddFXRate(ctx, r)
+		})
+	case InterestAccrued:
+		return applyPayload(e, func(a client.InterestAccrual) error {
+			return s.AddInterestAccrual(ctx, a)
+		})
+	case InvoiceClosed:
+		return applyPayload(e, func(inv client.Invoice) error {
+			return s.AddInvoice(ctx, inv)
+		})
+	case SpendingLimitsSet:
+		return applyPayload(e, func(l client.SpendingLimits) error {
+			return s.SaveSpendingLimits(ctx, l)
+		})
+	case BalanceAdjusted:
+		return applyPayload(e, func(a client.BalanceAdjustment) error {
+			return s.AddBalanceAdjustment(ctx, a)
+		})
+	case TransactionFlagged:
+		return applyPayload(e, func(f client.TransactionFlag) error {
+			return s.AddTransactionFlag(ctx, f)
+		})
+	case IdempotencyKeySaved:
+		return applyPayload(e, func(k client.IdempotencyKey) error {
+			return s.SaveIdempotencyKey(ctx, k)
+		})
+	case IdempotencyKeysPurged:
+		return applyPayload(e, func(p purged) error {
+			_, err := s.DeleteIdempotencyKeys(ctx, p.Date)
+			return err
+		})
+	case OutboxEventAdded:
+		return applyPayload(e, func(oe client.OutboxEvent) error {
+			return s.AddOutboxEvent(ctx, oe)
+		})
+	case OutboxEventUpdated:
+		return applyPayload(e, func(oe client.OutboxEvent) error {
+			return s.UpdateOutboxEvent(ctx, oe)
+		})
+	case OutboxEventsPurged:
+		return applyPayload(e, func(p purged) error {
+			_, err := s.DeleteOutboxEvents(ctx, p.Date)
+			return err
+		})
+	case WebhookRegistered:
+		return applyPayload(e, func(w client.Webhook) error {
+			return s.AddWebhook(ctx, w)
+		})
+	case WebhookDeliveryAdded:
+		return applyPayload(e, func(d client.WebhookDelivery) error {
+			return s.AddWebhookDelivery(ctx, d)
+		})
+	case WebhookDeliveryUpdated:
+		return applyPayload(e, func(d client.WebhookDelivery) error {
+			return s.UpdateWebhookDelivery(ctx, d)
+		})
+	case WebhookDeliveriesPurged:
+		return applyPayload(e, func(p purged) error {
+			_, err := s.DeleteWebhookDeliveries(ctx, p.Date)
+			return err
+		})
+	case ClientUpdated:
+		return applyPayload(e, func(u client.ClientUpdate) error {
+			return s.AddClientUpdate(ctx, u)
+		})
+	case ClientUpdatesPurged:
+		return applyPayload(e, func(p purged) error {
+			_, err := s.DeleteClientUpdates(ctx, p.Date)
+			return err
+		})
+	case LedgerSequenced:
+		return applyPayload(e, func(p ledgerSequenced) error {
+			es := make([]client.LedgerEntry, len(p.Entries))
+			for i, le := range p.Entries {
+				es[i] = client.LedgerEntry{Seq: le.Seq, Transaction: client.Transaction{ID: le.TransactionID}}
+			}
+			return s.AddLedgerEntries(ctx, es)
+		})
+	}
+
+	return fmt.Errorf("event[%d]: unknown type %q", e.Seq, e.Type)
+}
+
+// applyPayload decodes the payload of the event and calls fn with it.
+func applyPayload[T any](e Event, fn func(T) error) error {
+	var p T
+	if err := json.Unmarshal(e.Data, &p); err != nil {
+		return fmt.Errorf("decoding %s event[%d]: %w", e.Type, e.Seq, err)
+	}
+
+	if err := fn(p); err != nil {
+		return fmt.Errorf("applying %s event[%d]: %w", e.Type, e.Seq, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,640 @@
+// Package eventstore provides an event-sourced implementation of
+// client.Store.
+//
+// The source of truth is an append-only Log of events, each recording one
+// change of a client: a transaction posted, a balance changed, a limit
+// changed and so on. The balances and the other rows read by the core are
+// projections of the log kept in memory, rebuilt at startup from the latest
+// snapshot and the events appended after it.
+//
+// The projections are held by the process, so a log must be used by a single
+// instance of the API. The API takes a database advisory lock at startup to
+// enforce it.
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
+)
+
+// Store is an event-sourced implementation of client.Store.
+//
+// Changes made inside ExecUnderTx are applied to the projections and
+// buffered, the events are appended to the log when the transaction commits.
+// If the append fails the transaction is rolled back.
+type Store struct {
+	state *state
+	proj  client.Store
+	tx    *tx
+}
+
+type state struct {
+	log  Log
+	proj *memstore.Store
+
+	// mu is held for reading while a transaction appends its events and
+	// commits the projections, and for writing while a snapshot is taken,
+	// so snapshots only see whole transactions.
+	mu  sync.RWMutex
+	seq atomic.Int64
+}
+
+// tx holds the events of a transaction and the clients of the transactions
+// it posted.
+type tx struct {
+	events []Event
+	posted map[uuid.UUID]int
+}
+
+// NewStore creates a store with the projections of the log. The projections
+// are restored from the latest snapshot and the events appended after it are
+// applied over them. The clients not found in the log are created, the ones
+// without a currency use the client.DefaultCurrency, the ones without a
+// closing day use the client.DefaultClosingDay and the ones without a status
+// are active.
+func NewStore(ctx context.Context, log Log, clients ...client.Client) (*Store, error) {
+	st := state{
+		log:  log,
+		proj: memstore.NewStore(),
+	}
+
+	snap, err := log.LatestSnapshot(ctx)
+	switch {
+	case err == nil:
+		st.proj = memstore.NewStoreFromSnapshot(snap.State)
+		st.seq.Store(snap.Seq)
+	case !errors.Is(err, ErrNoSnapshot):
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	err = log.Read(ctx, st.seq.Load(), func(e Event) error {
+		if err := apply(ctx, st.proj, e); err != nil {
+			return err
+		}
+		st.seq.Store(e.Seq)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay events: %w", err)
+	}
+
+	s := Store{state: &st, proj: st.proj}
+
+	for _, c := range clients {
+		if c.Currency == "" {
+			c.Currency = client.DefaultCurrency
+		}
+		if c.ClosingDay == 0 {
+			c.ClosingDay = client.DefaultClosingDay
+		}
+		if c.Status == "" {
+			c.Status = client.StatusActive
+		}
+
+		err := s.CreateClient(ctx, c)
+		if err != nil && !errors.Is(err, client.ErrAlreadyExists) {
+			return nil, fmt.Errorf("failed to create client[%d]: %w", c.ID, err)
+		}
+	}
+
+	return &s, nil
+}
+
+// Snapshot saves a snapshot of the projections to the log, so the next
+// NewStore only replays the events appended after it.
+func (s *Store) Snapshot(ctx context.Context) error {
+	s.state.mu.Lock()
+	snap := Snapshot{
+		Seq:   s.state.seq.Load(),
+		State: s.state.proj.Snapshot(),
+		Date:  time.Now().UTC().Round(time.Microsecond),
+	}
+	s.state.mu.Unlock()
+
+	if err := s.state.log.SaveSnapshot(ctx, snap); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) ExecUnderTx(ctx context.Context, fn func(txStore client.Store) error) error {
+	// Nested transactions work as savepoints.
+	if s.tx != nil {
+		n := len(s.tx.events)
+		err := s.proj.ExecUnderTx(ctx, func(proj client.Store) error {
+			return fn(&Store{state: s.state, proj: proj, tx: s.tx})
+		})
+		if err != nil {
+			s.tx.events = s.tx.events[:n]
+		}
+		return err
+	}
+
+	var (
+		t      = tx{posted: make(map[uuid.UUID]int)}
+		locked bool
+	)
+	err := s.state.proj.ExecUnderTx(ctx, func(proj client.Store) error {
+		if err := fn(&Store{state: s.state, proj: proj, tx: &t}); err != nil {
+			return err
+		}
+		if len(t.events) == 0 {
+			return nil
+		}
+
+		// The projections are committed after this function returns, the
+		// lock is released after that.
+		s.state.mu.RLock()
+		locked = true
+
+		return s.state.append(ctx, t.events)
+	})
+	if locked {
+		s.state.mu.RUnlock()
+	}
+
+	return err
+}
+
+// append appends the events to the log and records the greatest sequence
+// number appended.
+func (st *state) append(ctx context.Context, events []Event) error {
+	if err := st.log.Append(ctx, events); err != nil {
+		return fmt.Errorf("failed to append events: %w", err)
+	}
+
+	last := events[len(events)-1].Seq
+	for {
+		seq := st.seq.Load()
+		if last <= seq || st.seq.CompareAndSwap(seq, last) {
+			return nil
+		}
+	}
+}
+
+// =============================================================================
+
+func (s *Store) CreateClient(ctx context.Context, c client.Client) error {
+	return s.write(ctx, ClientCreated, c.ID, c, func(tx *Store) error {
+		return tx.proj.CreateClient(ctx, c)
+	})
+}
+
+func (s *Store) AddTransaction(ctx context.Context, t client.Transaction) error {
+	return s.write(ctx, TransactionPosted, t.ClientID, t, func(tx *Store) error {
+		if err := tx.proj.AddTransaction(ctx, t); err != nil {
+			return err
+		}
+		tx.tx.posted[t.ID] = t.ClientID
+		return nil
+	})
+}
+
+func (s *Store) UpdateTransactionReversedBy(ctx context.Context, transactionID, reversalID uuid.UUID) error {
+	// The event belongs to the client of the reversal, posted by the same
+	// transaction.
+	var clientID int
+	if s.tx != nil {
+		clientID = s.tx.posted[reversalID]
+	}
+
+	p := transactionReversed{TransactionID: transactionID, ReversalID: reversalID}
+	return s.write(ctx, TransactionReversed, clientID, p, func(tx *Store) error {
+		return tx.proj.UpdateTransactionReversedBy(ctx, transactionID, reversalID)
+	})
+}
+
+func (s *Store) UpdateClientBalance(ctx context.Context, clientID, balance int) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, BalanceChanged, clientID, balanceChanged{Balance: balance}, func(tx *Store) error {
+		var err error
+		c, err = tx.proj.UpdateClientBalance(ctx, clientID, balance)
+		return err
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) UpdateClientReserved(ctx context.Context, clientID, reserved int) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, ReservedChanged, clientID, reservedChanged{Reserved: reserved}, func(tx *Store) error {
+		var err error
+		c, err = tx.proj.UpdateClientReserved(ctx, clientID, reserved)
+		return err
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) UpdateClientLimit(ctx context.Context, clientID, limit int) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, LimitChanged, clientID, limitChanged{Limit: limit}, func(tx *Store) error {
+		var err error
+		c, err = tx.proj.UpdateClientLimit(ctx, clientID, limit)
+		return err
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) AddLimitChange(ctx context.Context, lc client.LimitChange) error {
+	return s.write(ctx, LimitChangeRecorded, lc.ClientID, lc, func(tx *Store) error {
+		return tx.proj.AddLimitChange(ctx, lc)
+	})
+}
+
+func (s *Store) UpdateClientStatus(ctx context.Context, clientID int, status string) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, StatusChanged, clientID, statusChanged{Status: status}, func(tx *Store) error {
+		var err error
+		c, err = tx.proj.UpdateClientStatus(ctx, clientID, status)
+		return err
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) AddStatusChange(ctx context.Context, sc client.StatusChange) error {
+	return s.write(ctx, StatusChangeRecorded, sc.ClientID, sc, func(tx *Store) error {
+		return tx.proj.AddStatusChange(ctx, sc)
+	})
+}
+
+func (s *Store) AddHold(ctx context.Context, h client.Hold) error {
+	return s.write(ctx, HoldPlaced, h.ClientID, h, func(tx *Store) error {
+		return tx.proj.AddHold(ctx, h)
+	})
+}
+
+func (s *Store) UpdateHold(ctx context.Context, h client.Hold) error {
+	return s.write(ctx, HoldUpdated, h.ClientID, h, func(tx *Store) error {
+		return tx.proj.UpdateHold(ctx, h)
+	})
+}
+
+func (s *Store) AddScheduled(ctx context.Context, st client.ScheduledTransaction) error {
+	return s.write(ctx, TransactionScheduled, st.ClientID, st, func(tx *Store) error {
+		return tx.proj.AddScheduled(ctx, st)
+	})
+}
+
+func (s *Store) UpdateScheduled(ctx context.Context, st client.ScheduledTransaction) error {
+	return s.write(ctx, ScheduledUpdated, st.ClientID, st, func(tx *Store) error {
+		return tx.proj.UpdateScheduled(ctx, st)
+	})
+}
+
+func (s *Store) AddFXRate(ctx context.Context, r client.FXRate) error {
+	return s.write(ctx, FXRateSet, 0, r, func(tx *Store) error {
+		return tx.proj.AddFXRate(ctx, r)
+	})
+}
+
+func (s *Store) AddInterestAccrual(ctx context.Context, a client.InterestAccrual) error {
+	return s.write(ctx, InterestAccrued, a.ClientID, a, func(tx *Store) error {
+		return tx.proj.AddInterestAccrual(ctx, a)
+	})
+}
+
+func (s *Store) AddInvoice(ctx context.Context, inv client.Invoice) error {
+	return s.write(ctx, InvoiceClosed, inv.ClientID, inv, func(tx *Store) error {
+		return tx.proj.AddInvoice(ctx, inv)
+	})
+}
+
+func (s *Store) SaveSpendingLimits(ctx context.Context, l client.SpendingLimits) error {
+	return s.write(ctx, SpendingLimitsSet, l.ClientID, l, func(tx *Store) error {
+		return tx.proj.SaveSpendingLimits(ctx, l)
+	})
+}
+
+func (s *Store) AddBalanceAdjustment(ctx context.Context, a client.BalanceAdjustment) error {
+	return s.write(ctx, BalanceAdjusted, a.ClientID, a, func(tx *Store) error {
+		return tx.proj.AddBalanceAdjustment(ctx, a)
+	})
+}
+
+func (s *Store) AddTransactionFlag(ctx context.Context, f client.TransactionFlag) error {
+	return s.write(ctx, TransactionFlagged, f.ClientID, f, func(tx *Store) error {
+		return tx.proj.AddTransactionFlag(ctx, f)
+	})
+}
+
+func (s *Store) SaveIdempotencyKey(ctx context.Context, k client.IdempotencyKey) error {
+	return s.write(ctx, IdempotencyKeySaved, k.ClientID, k, func(tx *Store) error {
+		return tx.proj.SaveIdempotencyKey(ctx, k)
+	})
+}
+
+func (s *Store) DeleteIdempotencyKeys(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, IdempotencyKeysPurged, 0, purged{Date: date}, func(tx *Store) error {
+		var err error
+		n, err = tx.proj.DeleteIdempotencyKeys(ctx, date)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) AddOutboxEvent(ctx context.Context, e client.OutboxEvent) error {
+	return s.write(ctx, OutboxEventAdded, e.ClientID, e, func(tx *Store) error {
+		return tx.proj.AddOutboxEvent(ctx, e)
+	})
+}
+
+func (s *Store) UpdateOutboxEvent(ctx context.Context, e client.OutboxEvent) error {
+	return s.write(ctx, OutboxEventUpdated, e.ClientID, e, func(tx *Store) error {
+		return tx.proj.UpdateOutboxEvent(ctx, e)
+	})
+}
+
+func (s *Store) DeleteOutboxEvents(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, OutboxEventsPurged, 0, purged{Date: date}, func(tx *Store) error {
+		var err error
+		n, err = tx.proj.DeleteOutboxEvents(ctx, date)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) AddWebhook(ctx context.Context, w client.Webhook) error {
+	return s.write(ctx, WebhookRegistered, w.ClientID, w, func(tx *Store) error {
+		return tx.proj.AddWebhook(ctx, w)
+	})
+}
+
+func (s *Store) AddWebhookDelivery(ctx context.Context, d client.WebhookDelivery) error {
+	return s.write(ctx, WebhookDeliveryAdded, d.ClientID, d, func(tx *Store) error {
+		return tx.proj.AddWebhookDelivery(ctx, d)
+	})
+}
+
+func (s *Store) UpdateWebhookDelivery(ctx context.Context, d client.WebhookDelivery) error {
+	return s.write(ctx, WebhookDeliveryUpdated, d.ClientID, d, func(tx *Store) error {
+		return tx.proj.UpdateWebhookDelivery(ctx, d)
+	})
+}
+
+func (s *Store) DeleteWebhookDeliveries(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, WebhookDeliveriesPurged, 0, purged{Date: date}, func(tx *Store) error {
+		var err error
+		n, err = tx.proj.DeleteWebhookDeliveries(ctx, date)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// AddClientUpdate records the update without its Seq, the projections
+// assign it again when the event is replayed.
+func (s *Store) AddClientUpdate(ctx context.Context, u client.ClientUpdate) error {
+	return s.write(ctx, ClientUpdated, u.ClientID, u, func(tx *Store) error {
+		return tx.proj.AddClientUpdate(ctx, u)
+	})
+}
+
+func (s *Store) DeleteClientUpdates(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, ClientUpdatesPurged, 0, purged{Date: date}, func(tx *Store) error {
+		var err error
+		n, err = tx.proj.DeleteClientUpdates(ctx, date)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// AddLedgerEntries records the Seqs of the entries, the transactions are
+// already in the log.
+func (s *Store) AddLedgerEntries(ctx context.Context, es []client.LedgerEntry) error {
+	p := ledgerSequenced{Entries: make([]memstore.LedgerEntry, len(es))}
+	for i, e := range es {
+		p.Entries[i] = memstore.LedgerEntry{Seq: e.Seq, TransactionID: e.Transaction.ID}
+	}
+
+	return s.write(ctx, LedgerSequenced, 0, p, func(tx *Store) error {
+		return tx.proj.AddLedgerEntries(ctx, es)
+	})
+}
+
+// =============================================================================
+
+func (s *Store) QueryByID(ctx context.Context, clientID int) (client.Client, error) {
+	return s.proj.QueryByID(ctx, clientID)
+}
+
+func (s *Store) QueryByDocument(ctx context.Context, document string) (client.Client, error) {
+	return s.proj.QueryByDocument(ctx, document)
+}
+
+func (s *Store) QueryClients(ctx context.Context, pageNumber, rowsPerPage int) ([]client.Client, error) {
+	return s.proj.QueryClients(ctx, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryTransactions(ctx context.Context, clientID int, filter client.TransactionFilter, pageNumber, rowsPerPage int) ([]client.Transaction, error) {
+	return s.proj.QueryTransactions(ctx, clientID, filter, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryTransactionsAfter(ctx context.Context, clientID int, filter client.TransactionFilter, after client.Cursor, limit int) ([]client.Transaction, error) {
+	return s.proj.QueryTransactionsAfter(ctx, clientID, filter, after, limit)
+}
+
+func (s *Store) StreamTransactions(ctx context.Context, clientID int, filter client.TransactionFilter, fn func(client.Transaction) error) error {
+	return s.proj.StreamTransactions(ctx, clientID, filter, fn)
+}
+
+func (s *Store) QueryTransactionByID(ctx context.Context, clientID int, transactionID uuid.UUID) (client.Transaction, error) {
+	return s.proj.QueryTransactionByID(ctx, clientID, transactionID)
+}
+
+func (s *Store) QueryLimitChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.LimitChange, error) {
+	return s.proj.QueryLimitChanges(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryStatusChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.StatusChange, error) {
+	return s.proj.QueryStatusChanges(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryHoldByID(ctx context.Context, clientID int, holdID uuid.UUID) (client.Hold, error) {
+	return s.proj.QueryHoldByID(ctx, clientID, holdID)
+}
+
+func (s *Store) QueryExpiredHolds(ctx context.Context, date time.Time, limit int) ([]client.Hold, error) {
+	return s.proj.QueryExpiredHolds(ctx, date, limit)
+}
+
+func (s *Store) QueryScheduledByID(ctx context.Context, clientID int, scheduledID uuid.UUID) (client.ScheduledTransaction, error) {
+	return s.proj.QueryScheduledByID(ctx, clientID, scheduledID)
+}
+
+func (s *Store) QueryScheduled(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.ScheduledTransaction, error) {
+	return s.proj.QueryScheduled(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryDueScheduled(ctx context.Context, date time.Time, limit int) ([]client.ScheduledTransaction, error) {
+	return s.proj.QueryDueScheduled(ctx, date, limit)
+}
+
+func (s *Store) QueryFXRate(ctx context.Context, from, to string) (client.FXRate, error) {
+	return s.proj.QueryFXRate(ctx, from, to)
+}
+
+func (s *Store) QueryFXRates(ctx context.Context) ([]client.FXRate, error) {
+	return s.proj.QueryFXRates(ctx)
+}
+
+func (s *Store) QueryOverdrawnClients(ctx context.Context, after, limit int) ([]client.Client, error) {
+	return s.proj.QueryOverdrawnClients(ctx, after, limit)
+}
+
+func (s *Store) QueryInterestAccrual(ctx context.Context, clientID int, day time.Time) (client.InterestAccrual, error) {
+	return s.proj.QueryInterestAccrual(ctx, clientID, day)
+}
+
+func (s *Store) QueryLastInvoice(ctx context.Context, clientID int) (client.Invoice, error) {
+	return s.proj.QueryLastInvoice(ctx, clientID)
+}
+
+func (s *Store) QueryInvoiceByID(ctx context.Context, clientID int, invoiceID uuid.UUID) (client.Invoice, error) {
+	return s.proj.QueryInvoiceByID(ctx, clientID, invoiceID)
+}
+
+func (s *Store) QueryInvoices(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.Invoice, error) {
+	return s.proj.QueryInvoices(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryBalanceAt(ctx context.Context, clientID int, date time.Time) (int, error) {
+	return s.proj.QueryBalanceAt(ctx, clientID, date)
+}
+
+func (s *Store) QuerySpendingLimits(ctx context.Context, clientID int) (client.SpendingLimits, error) {
+	return s.proj.QuerySpendingLimits(ctx, clientID)
+}
+
+func (s *Store) QueryDebitTotals(ctx context.Context, clientID int, since time.Time) (client.DebitTotals, error) {
+	return s.proj.QueryDebitTotals(ctx, clientID, since)
+}
+
+func (s *Store) SumTransactions(ctx context.Context, clientID int) (int, error) {
+	return s.proj.SumTransactions(ctx, clientID)
+}
+
+func (s *Store) QueryTransactionFlags(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.TransactionFlag, error) {
+	return s.proj.QueryTransactionFlags(ctx, clientID, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryIdempotencyKey(ctx context.Context, clientID int, key string) (client.IdempotencyKey, error) {
+	return s.proj.QueryIdempotencyKey(ctx, clientID, key)
+}
+
+func (s *Store) QueryPendingOutbox(ctx context.Context, after int64, limit int) ([]client.OutboxEvent, error) {
+	return s.proj.QueryPendingOutbox(ctx, after, limit)
+}
+
+func (s *Store) QueryWebhooks(ctx context.Context, clientID int) ([]client.Webhook, error) {
+	return s.proj.QueryWebhooks(ctx, clientID)
+}
+
+func (s *Store) QueryWebhookByID(ctx context.Context, clientID int, webhookID uuid.UUID) (client.Webhook, error) {
+	return s.proj.QueryWebhookByID(ctx, clientID, webhookID)
+}
+
+func (s *Store) QueryWebhookDeliveryByID(ctx context.Context, clientID int, deliveryID uuid.UUID) (client.WebhookDelivery, error) {
+	return s.proj.QueryWebhookDeliveryByID(ctx, clientID, deliveryID)
+}
+
+func (s *Store) QueryWebhookDeliveries(ctx context.Context, clientID int, webhookID uuid.UUID, pageNumber, rowsPerPage int) ([]client.WebhookDelivery, error) {
+	return s.proj.QueryWebhookDeliveries(ctx, clientID, webhookID, pageNumber, rowsPerPage)
+}
+
+func (s *Store) QueryDueWebhookDeliveries(ctx context.Context, date time.Time, limit int) ([]client.WebhookDelivery, error) {
+	return s.proj.QueryDueWebhookDeliveries(ctx, date, limit)
+}
+
+func (s *Store) QueryClientUpdates(ctx context.Context, clientID int, after int64, limit int) ([]client.ClientUpdate, error) {
+	return s.proj.QueryClientUpdates(ctx, clientID, after, limit)
+}
+
+func (s *Store) QueryClientUpdateSeq(ctx context.Context, clientID int) (int64, error) {
+	return s.proj.QueryClientUpdateSeq(ctx, clientID)
+}
+
+func (s *Store) QueryLedgerSeq(ctx context.Context) (int64, error) {
+	return s.proj.QueryLedgerSeq(ctx)
+}
+
+func (s *Store) QueryUnsequencedTransactions(ctx context.Context, limit int) ([]client.Transaction, error) {
+	return s.proj.QueryUnsequencedTransactions(ctx, limit)
+}
+
+func (s *Store) QueryLedger(ctx context.Context, after int64, limit int) ([]client.LedgerEntry, error) {
+	return s.proj.QueryLedger(ctx, after, limit)
+}
+
+// Listen calls fn with the client of each update committed to the store
+// until the ctx is done.
+func (s *Store) Listen(ctx context.Context, fn func(clientID int)) error {
+	return s.state.proj.Listen(ctx, fn)
+}
+
+// =============================================================================
+
+// write applies a change to the projections with fn and records its event,
+// under the current transaction or under a new one if the store is not in a
+// transaction. The event is not recorded if fn fails.
+func (s *Store) write(ctx context.Context, typ Type, clientID int, payload any, fn func(tx *Store) error) error {
+	if s.tx == nil {
+		return s.ExecUnderTx(ctx, func(tx client.Store) error {
+			return tx.(*Store).write(ctx, typ, clientID, payload, fn)
+		})
+	}
+
+	e, err := newEvent(typ, clientID, payload)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(s); err != nil {
+		return err
+	}
+	s.tx.events = append(s.tx.events, e)
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
+	"github.com/rschio/rinha/internal/data/dbtest"
+)
+
+// post posts changes of every kind to the client.
+func post(t *testing.T, core *client.Core, clientID int) {
+	t.Helper()
+	ctx := context.Background()
+
+	nt := client.NewTransaction{Value: 1000, Type: "d", Description: "debit"}
+	if _, err := core.AddTransaction(ctx, clientID, nt); err != nil {
+		t.Fatalf("adding transaction: %v", err)
+	}
+
+	render := func(c client.Client) ([]byte, error) { return []byte("ok"), nil }
+	nt = client.NewTransaction{Value: 500, Type: "c", Description: "credit"}
+	if _, err := core.AddTransactionIdempotent(ctx, clientID, uuid.NewString(), nt, render); err != nil {
+		t.Fatalf("adding idempotent transaction: %v", err)
+	}
+
+	b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+	if err != nil {
+		t.Fatalf("querying billing: %v", err)
+	}
+	if _, err := core.ReverseTransaction(ctx, clientID, b.LastTransactions[0].ID); err != nil {
+		t.Fatalf("reversing transaction: %v", err)
+	}
+
+	if _, err := core.ChangeLimit(ctx, clientID, 200000, "raise"); err != nil {
+		t.Fatalf("changing limit: %v", err)
+	}
+
+	h, _, err := core.Authorize(ctx, clientID, client.NewHold{Value: 300, Description: "hold"})
+	if err != nil {
+		t.Fatalf("authorizing hold: %v", err)
+	}
+	if _, err := core.Capture(ctx, clientID, h.ID, 100); err != nil {
+		t.Fatalf("capturing hold: %v", err)
+	}
+
+	ns := client.NewScheduledTransaction{Value: 10, Type: "d", Description: "later", ScheduledFor: time.Now().Add(time.Hour)}
+	if _, err := core.Schedule(ctx, clientID, ns); err != nil {
+		t.Fatalf("scheduling transaction: %v", err)
+	}
+
+	if _, err := core.SetSpendingLimits(ctx, clientID, client.SpendingLimits{MaxDailyDebit: 100000}); err != nil {
+		t.Fatalf("setting spending limits: %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	log := NewMemLog()
+
+	store, err := NewStore(ctx, log, memstore.DefaultClients()...)
+	if err != nil {
+		t.Fatalf("creating store: %v", err)
+	}
+	core := client.NewCore(store)
+
+	post(t, core, 1)
+	if _, err := core.SetFXRate(ctx, client.NewFXRate{From: "USD", To: "BRL", Rate: 5 * client.RateScale}); err != nil {
+		t.Fatalf("setting fx rate: %v", err)
+	}
+	if _, err := core.SequenceLedger(ctx); err != nil {
+		t.Fatalf("sequencing ledger: %v", err)
+	}
+
+	replayed, err := NewStore(ctx, log, memstore.DefaultClients()...)
+	if err != nil {
+		t.Fatalf("replaying store: %v", err)
+	}
+	if diff := cmp.Diff(store.state.proj.Snapshot(), replayed.state.proj.Snapshot()); diff != "" {
+		t.Fatalf("replayed projections differ: %s", diff)
+	}
+
+	// The next store starts from the snapshot and replays the events
+	// appended after it.
+	if err := store.Snapshot(ctx); err != nil {
+		t.Fatalf("taking snapshot: %v", err)
+	}
+	post(t, core, 2)
+	if _, err := core.SequenceLedger(ctx); err != nil {
+		t.Fatalf("sequencing ledger: %v", err)
+	}
+
+	snap, err := log.LatestSnapshot(ctx)
+	if err != nil {
+		t.Fatalf("loading snapshot: %v", err)
+	}
+	var replays int
+	err = log.Read(ctx, snap.Seq, func(Event) error {
+		replays++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reading log: %v", err)
+	}
+	if replays == 0 || int64(len(log.events)) != snap.Seq+int64(replays) {
+		t.Fatalf("got %d events after snapshot[%d] of %d", replays, snap.Seq, len(log.events))
+	}
+
+	replayed, err = NewStore(ctx, log, memstore.DefaultClients()...)
+	if err != nil {
+		t.Fatalf("replaying store: %v", err)
+	}
+	if diff := cmp.Diff(store.state.proj.Snapshot(), replayed.state.proj.Snapshot()); diff != "" {
+		t.Fatalf("replayed projections differ: %s", diff)
+	}
+	if got, want := replayed.state.seq.Load(), int64(len(log.events)); got != want {
+		t.Fatalf("got seq %d want %d", got, want)
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	ctx := context.Background()
+	log := NewMemLog()
+
+	store, err := NewStore(ctx, log, client.Client{ID: 1, Limit: 1000})
+	if err != nil {
+		t.Fatalf("creating store: %v", err)
+	}
+	core := client.NewCore(store)
+
+	nt := client.NewTransaction{Value: 100, Type: "c", Description: "credit"}
+	if _, err := core.AddTransaction(ctx, 1, nt); err != nil {
+		t.Fatalf("adding transaction: %v", err)
+	}
+	nt = client.NewTransaction{Value: 2000, Type: "d", Description: "denied"}
+	if _, err := core.AddTransaction(ctx, 1, nt); !errors.Is(err, client.ErrTransactionDenied) {
+		t.Fatalf("got err %v want %v", err, client.ErrTransactionDenied)
+	}
+
+	// Denied transactions don't append events. Without a publisher there
+	// are no outbox events.
+	want := []Type{ClientCreated, TransactionPosted, BalanceChanged, ClientUpdated, ClientUpdated}
+	var got []Type
+	for _, e := range log.events {
+		if e.ClientID != 1 {
+			t.Fatalf("event[%d]: got client %d want %d", e.Seq, e.ClientID, 1)
+		}
+		got = append(got, e.Type)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("got different events: %s", diff)
+	}
+}
+
+// failingLog is a Log that fails to append.
+type failingLog struct {
+	*MemLog
+}
+
+var errAppend = errors.New("append failed")
+
+func (failingLog) Append(ctx context.Context, events []Event) error {
+	return errAppend
+}
+
+func TestAppendFailure(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewStore(ctx, NewMemLog(), client.Client{ID: 1, Limit: 1000})
+	if err != nil {
+		t.Fatalf("creating store: %v", err)
+	}
+	store.state.log = failingLog{NewMemLog()}
+	core := client.NewCore(store)
+
+	nt := client.NewTransaction{Value: 100, Type: "c", Description: "credit"}
+	if _, err := core.AddTransaction(ctx, 1, nt); !errors.Is(err, errAppend) {
+		t.Fatalf("got err %v want %v", err, errAppend)
+	}
+
+	c, err := core.QueryByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("querying client: %v", err)
+	}
+	if c.Balance != 0 {
+		t.Fatalf("got balance %d want %d", c.Balance, 0)
+	}
+	b, err := core.Billing(ctx, 1, client.TransactionFilter{})
+	if err != nil {
+		t.Fatalf("querying billing: %v", err)
+	}
+	if len(b.LastTransactions) != 0 {
+		t.Fatalf("got %d transactions want none", len(b.LastTransactions))
+	}
+}
+
+func TestDBLog(t *testing.T) {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	dbLog := NewDBLog(log, database)
+
+	if _, err := dbLog.LatestSnapshot(ctx); !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("got err %v want %v", err, ErrNoSnapshot)
+	}
+
+	store, err := NewStore(ctx, dbLog, memstore.DefaultClients()...)
+	if err != nil {
+		t.Fatalf("creating store: %v", err)
+	}
+	post(t, client.NewCore(store), 1)
+
+	if err := store.Snapshot(ctx); err != nil {
+		t.Fatalf("taking snapshot: %v", err)
+	}
+	post(t, client.NewCore(store), 2)
+
+	replayed, err := NewStore(ctx, dbLog, memstore.DefaultClients()...)
+	if err != nil {
+		t.Fatalf("replaying store: %v", err)
+	}
+	if diff := cmp.Diff(store.state.proj.Snapshot(), replayed.state.proj.Snapshot()); diff != "" {
+		t.Fatalf("replayed projections differ: %s", diff)
+	}
+}
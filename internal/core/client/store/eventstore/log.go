@@ -0,0 +1,110 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
+)
+
+// ErrNoSnapshot is returned when the log has no snapshot.
+var ErrNoSnapshot = errors.New("eventstore: no snapshot")
+
+// Snapshot is the state of the projections after the event Seq.
+type Snapshot struct {
+	Seq   int64
+	State memstore.Snapshot
+	Date  time.Time
+}
+
+// Log is an append-only log of events with the snapshots of the projections
+// built from it.
+type Log interface {
+	// Append appends the events atomically, in order, and sets their
+	// sequence numbers.
+	Append(ctx context.Context, events []Event) error
+
+	// Read calls fn for each event with a sequence number greater than
+	// after, in order. If fn returns an error the iteration stops and the
+	// error is returned.
+	Read(ctx context.Context, after int64, fn func(Event) error) error
+
+	// SaveSnapshot saves a snapshot of the projections.
+	SaveSnapshot(ctx context.Context, snap Snapshot) error
+
+	// LatestSnapshot returns the snapshot with the greatest sequence
+	// number. It returns ErrNoSnapshot if there is none.
+	LatestSnapshot(ctx context.Context) (Snapshot, error)
+}
+
+// MemLog is an in-memory Log.
+type MemLog struct {
+	mu        sync.RWMutex
+	events    []Event
+	snapshots []Snapshot
+}
+
+// NewMemLog creates an empty in-memory log.
+func NewMemLog() *MemLog {
+	return &MemLog{}
+}
+
+func (l *MemLog) Append(ctx context.Context, events []Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range events {
+		events[i].Seq = int64(len(l.events)) + 1
+		l.events = append(l.events, events[i])
+	}
+
+	return nil
+}
+
+func (l *MemLog) Read(ctx context.Context, after int64, fn func(Event) error) error {
+	l.mu.RLock()
+	var events []Event
+	if after < int64(len(l.events)) {
+		events = l.events[max(after, 0):]
+	}
+	l.mu.RUnlock()
+
+	for _, e := range events {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *MemLog) SaveSnapshot(ctx context.Context, snap Snapshot) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.snapshots = append(l.snapshots, snap)
+
+	return nil
+}
+
+func (l *MemLog) LatestSnapshot(ctx context.Context) (Snapshot, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var (
+		latest Snapshot
+		found  bool
+	)
+	for _, snap := range l.snapshots {
+		if !found || snap.Seq > latest.Seq {
+			latest, found = snap, true
+		}
+	}
+	if !found {
+		return Snapshot{}, ErrNoSnapshot
+	}
+
+	return latest, nil
+}
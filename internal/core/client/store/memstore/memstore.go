@@ -0,0 +1,1662 @@
+// Package memstore provides an in-memory implementation of client.Store.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+)
+
+// Store is an in-memory implementation of client.Store.
+//
+// Writes made inside ExecUnderTx are staged and only become visible to other
+// callers when the transaction commits. Rows are locked the same way
+// PostgreSQL locks them on SELECT ... FOR UPDATE, UPDATE and INSERT, and the
+// locks are held until the end of the transaction.
+type Store struct {
+	db *database
+	tx *tx
+}
+
+type database struct {
+	mu     sync.RWMutex
+	tables tables
+	locks  lockManager
+
+	// outboxSeq is the last Seq assigned to an outbox event. Like a
+	// sequence, values taken by transactions rolled back are not reused.
+	outboxSeq atomic.Int64
+
+	listeners listeners
+}
+
+// listeners are the functions called with the clients of the updates
+// committed to the store.
+type listeners struct {
+	mu   sync.Mutex
+	next int
+	fns  map[int]func(clientID int)
+}
+
+// tables are the tables of the store.
+type tables struct {
+	clients      *table[int, client.Client]
+	transactions *table[uuid.UUID, client.Transaction]
+	limitChanges *table[uuid.UUID, client.LimitChange]
+	idempotency  *table[idempotencyKey, client.IdempotencyKey]
+	holds        *table[uuid.UUID, client.Hold]
+	scheduled    *table[uuid.UUID, client.ScheduledTransaction]
+	fxRates      *table[uuid.UUID, client.FXRate]
+	accruals     *table[accrualKey, client.InterestAccrual]
+	invoices     *table[uuid.UUID, client.Invoice]
+	limits       *table[int, client.SpendingLimits]
+	flags        *table[uuid.UUID, client.TransactionFlag]
+	adjustments  *table[uuid.UUID, client.BalanceAdjustment]
+	outbox       *table[uuid.UUID, client.OutboxEvent]
+	webhooks     *table[uuid.UUID, client.Webhook]
+	deliveries   *table[uuid.UUID, client.WebhookDelivery]
+	updates      *table[updateKey, client.ClientUpdate]
+	updateSeqs   *table[int, int64]
+	unsequenced  *table[uuid.UUID, uuid.UUID]
+	ledger       *table[int64, uuid.UUID]
+	ledgerSeq    *table[struct{}, int64]
+	statuses     *table[uuid.UUID, client.StatusChange]
+}
+
+type accrualKey struct {
+	clientID int
+	day      time.Time
+}
+
+type invoiceKey struct {
+	clientID  int
+	periodEnd time.Time
+}
+
+type updateKey struct {
+	clientID int
+	seq      int64
+}
+
+type idempotencyKey struct {
+	clientID int
+	key      string
+}
+
+func newTables() tables {
+	return tables{
+		clients:      newTable[int, client.Client](),
+		transactions: newTable[uuid.UUID, client.Transaction](),
+		limitChanges: newTable[uuid.UUID, client.LimitChange](),
+		idempotency:  newTable[idempotencyKey, client.IdempotencyKey](),
+		holds:        newTable[uuid.UUID, client.Hold](),
+		scheduled:    newTable[uuid.UUID, client.ScheduledTransaction](),
+		fxRates:      newTable[uuid.UUID, client.FXRate](),
+		accruals:     newTable[accrualKey, client.InterestAccrual](),
+		invoices:     newTable[uuid.UUID, client.Invoice](),
+		limits:       newTable[int, client.SpendingLimits](),
+		flags:        newTable[uuid.UUID, client.TransactionFlag](),
+		adjustments:  newTable[uuid.UUID, client.BalanceAdjustment](),
+		outbox:       newTable[uuid.UUID, client.OutboxEvent](),
+		webhooks:     newTable[uuid.UUID, client.Webhook](),
+		deliveries:   newTable[uuid.UUID, client.WebhookDelivery](),
+		updates:      newTable[updateKey, client.ClientUpdate](),
+		updateSeqs:   newTable[int, int64](),
+		unsequenced:  newTable[uuid.UUID, uuid.UUID](),
+		ledger:       newTable[int64, uuid.UUID](),
+		ledgerSeq:    newTable[struct{}, int64](),
+		statuses:     newTable[uuid.UUID, client.StatusChange](),
+	}
+}
+
+func (t tables) clone() tables {
+	return tables{
+		clients:      t.clients.clone(),
+		transactions: t.transactions.clone(),
+		limitChanges: t.limitChanges.clone(),
+		idempotency:  t.idempotency.clone(),
+		holds:        t.holds.clone(),
+		scheduled:    t.scheduled.clone(),
+		fxRates:      t.fxRates.clone(),
+		accruals:     t.accruals.clone(),
+		invoices:     t.invoices.clone(),
+		limits:       t.limits.clone(),
+		flags:        t.flags.clone(),
+		adjustments:  t.adjustments.clone(),
+		outbox:       t.outbox.clone(),
+		webhooks:     t.webhooks.clone(),
+		deliveries:   t.deliveries.clone(),
+		updates:      t.updates.clone(),
+		updateSeqs:   t.updateSeqs.clone(),
+		unsequenced:  t.unsequenced.clone(),
+		ledger:       t.ledger.clone(),
+		ledgerSeq:    t.ledgerSeq.clone(),
+		statuses:     t.statuses.clone(),
+	}
+}
+
+func (t tables) merge(staged tables) {
+	t.clients.merge(staged.clients)
+	t.transactions.merge(staged.transactions)
+	t.limitChanges.merge(staged.limitChanges)
+	t.idempotency.merge(staged.idempotency)
+	t.holds.merge(staged.holds)
+	t.scheduled.merge(staged.scheduled)
+	t.fxRates.merge(staged.fxRates)
+	t.accruals.merge(staged.accruals)
+	t.invoices.merge(staged.invoices)
+	t.limits.merge(staged.limits)
+	t.flags.merge(staged.flags)
+	t.adjustments.merge(staged.adjustments)
+	t.outbox.merge(staged.outbox)
+	t.webhooks.merge(staged.webhooks)
+	t.deliveries.merge(staged.deliveries)
+	t.updates.merge(staged.updates)
+	t.updateSeqs.merge(staged.updateSeqs)
+	t.unsequenced.merge(staged.unsequenced)
+	t.ledger.merge(staged.ledger)
+	t.ledgerSeq.merge(staged.ledgerSeq)
+	t.statuses.merge(staged.statuses)
+}
+
+// tx holds the state of a transaction.
+type tx struct {
+	staged tables
+	locked map[lockKey]bool
+}
+
+// NewStore creates an in-memory store with the clients. Clients without a
+// currency use the client.DefaultCurrency, clients without a closing day use
+// the client.DefaultClosingDay and clients without a status are active.
+func NewStore(clients ...client.Client) *Store {
+	db := database{tables: newTables()}
+	for _, c := range clients {
+		if c.Currency == "" {
+			c.Currency = client.DefaultCurrency
+		}
+		if c.ClosingDay == 0 {
+			c.ClosingDay = client.DefaultClosingDay
+		}
+		if c.Status == "" {
+			c.Status = client.StatusActive
+		}
+		db.tables.clients.put(c.ID, c)
+	}
+
+	return &Store{db: &db}
+}
+
+// DefaultClients returns the clients inserted by the database migrations.
+func DefaultClients() []client.Client {
+	return []client.Client{
+		{ID: 1, Limit: 100000},
+		{ID: 2, Limit: 80000},
+		{ID: 3, Limit: 1000000},
+		{ID: 4, Limit: 10000000},
+		{ID: 5, Limit: 500000},
+	}
+}
+
+func (s *Store) ExecUnderTx(ctx context.Context, fn func(txStore client.Store) error) error {
+	// Nested transactions work as savepoints.
+	if s.tx != nil {
+		savepoint := s.tx.staged.clone()
+		if err := fn(s); err != nil {
+			s.tx.staged = savepoint
+			return err
+		}
+		return nil
+	}
+
+	t := tx{
+		staged: newTables(),
+		locked: make(map[lockKey]bool),
+	}
+	defer func() {
+		for k := range t.locked {
+			s.db.locks.release(k)
+		}
+	}()
+
+	if err := fn(&Store{db: s.db, tx: &t}); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	s.db.tables.merge(t.staged)
+	s.db.mu.Unlock()
+
+	// The row locks are still held, so the listeners are called in the
+	// order the updates of each client were committed.
+	for _, k := range t.staged.updateSeqs.keys {
+		s.db.listeners.call(k)
+	}
+
+	return nil
+}
+
+func (s *Store) CreateClient(ctx context.Context, c client.Client) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "clients", c.ID); err != nil {
+			return err
+		}
+		if _, ok := tx.lookupClient(c.ID); ok {
+			return client.ErrAlreadyExists
+		}
+
+		// Like the unique index of the non-empty documents.
+		if c.Document != "" {
+			if err := tx.lock(ctx, "documents", c.Document); err != nil {
+				return err
+			}
+			if _, err := tx.QueryByDocument(ctx, c.Document); err == nil {
+				return client.ErrDocumentExists
+			}
+		}
+
+		// Like the column default of PostgreSQL.
+		if c.Status == "" {
+			c.Status = client.StatusActive
+		}
+		tx.tx.staged.clients.put(c.ID, c)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryByID(ctx context.Context, clientID int) (client.Client, error) {
+	if _, ok := s.lookupClient(clientID); !ok {
+		return client.Client{}, client.ErrNotFound
+	}
+
+	// SELECT ... FOR UPDATE waits for the row lock even outside
+	// a transaction.
+	if err := s.lock(ctx, "clients", clientID); err != nil {
+		return client.Client{}, err
+	}
+	if s.tx == nil {
+		s.db.locks.release(lockKey{"clients", clientID})
+	}
+
+	c, ok := s.lookupClient(clientID)
+	if !ok {
+		return client.Client{}, client.ErrNotFound
+	}
+
+	return c, nil
+}
+
+func (s *Store) QueryByDocument(ctx context.Context, document string) (client.Client, error) {
+	s.db.mu.RLock()
+	cs := scan(s.db.tables.clients, s.staged().clients)
+	s.db.mu.RUnlock()
+
+	for _, c := range cs {
+		if c.Document == document {
+			return c, nil
+		}
+	}
+
+	return client.Client{}, client.ErrNotFound
+}
+
+func (s *Store) QueryClients(ctx context.Context, pageNumber, rowsPerPage int) ([]client.Client, error) {
+	s.db.mu.RLock()
+	cs := scan(s.db.tables.clients, s.staged().clients)
+	s.db.mu.RUnlock()
+
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].ID < cs[j].ID
+	})
+
+	return paginate(cs, pageNumber, rowsPerPage), nil
+}
+
+func (s *Store) QueryTransactions(ctx context.Context, clientID int, filter client.TransactionFilter, pageNumber, rowsPerPage int) ([]client.Transaction, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.transactions, s.staged().transactions)
+	s.db.mu.RUnlock()
+
+	var ts []client.Transaction
+	for _, t := range all {
+		if t.ClientID == clientID && filter.Match(t) {
+			ts = append(ts, t)
+		}
+	}
+	newestFirst(ts, func(t client.Transaction) time.Time { return t.Date })
+
+	return paginate(ts, pageNumber, rowsPerPage), nil
+}
+
+func (s *Store) QueryTransactionsAfter(ctx context.Context, clientID int, filter client.TransactionFilter, after client.Cursor, limit int) ([]client.Transaction, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.transactions, s.staged().transactions)
+	s.db.mu.RUnlock()
+
+	var ts []client.Transaction
+	for _, t := range all {
+		if t.ClientID == clientID && filter.Match(t) && (after.IsZero() || after.Precedes(t)) {
+			ts = append(ts, t)
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		if !ts[i].Date.Equal(ts[j].Date) {
+			return ts[i].Date.After(ts[j].Date)
+		}
+		return ts[i].ID.String() > ts[j].ID.String()
+	})
+
+	return paginate(ts, 1, limit), nil
+}
+
+func (s *Store) StreamTransactions(ctx context.Context, clientID int, filter client.TransactionFilter, fn func(client.Transaction) error) error {
+	ts, err := s.QueryTransactionsAfter(ctx, clientID, filter, client.Cursor{}, math.MaxInt)
+	if err != nil {
+		return err
+	}
+
+	for i := len(ts) - 1; i >= 0; i-- {
+		if err := fn(ts[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateClientBalance(ctx context.Context, clientID, balance int) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "clients", clientID); err != nil {
+			return err
+		}
+
+		var ok bool
+		c, ok = tx.lookupClient(clientID)
+		if !ok {
+			return client.ErrNotFound
+		}
+
+		c.Balance = balance
+		tx.tx.staged.clients.put(c.ID, c)
+
+		return nil
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) UpdateClientReserved(ctx context.Context, clientID, reserved int) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "clients", clientID); err != nil {
+			return err
+		}
+
+		var ok bool
+		c, ok = tx.lookupClient(clientID)
+		if !ok {
+			return client.ErrNotFound
+		}
+
+		c.Reserved = reserved
+		tx.tx.staged.clients.put(c.ID, c)
+
+		return nil
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) AddTransaction(ctx context.Context, t client.Transaction) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(t.ClientID); !ok {
+			return fmt.Errorf("failed to add transaction: %w", client.ErrNotFound)
+		}
+
+		if err := tx.lock(ctx, "transactions", t.ID); err != nil {
+			return err
+		}
+
+		if _, exists := tx.lookupTransaction(t.ID); exists {
+			return fmt.Errorf("failed to add transaction: duplicated id[%s]", t.ID)
+		}
+
+		tx.tx.staged.transactions.put(t.ID, t)
+		tx.tx.staged.unsequenced.put(t.ID, t.ID)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryTransactionByID(ctx context.Context, clientID int, transactionID uuid.UUID) (client.Transaction, error) {
+	if _, ok := s.lookupTransaction(transactionID); !ok {
+		return client.Transaction{}, client.ErrTransactionNotFound
+	}
+
+	if err := s.lock(ctx, "transactions", transactionID); err != nil {
+		return client.Transaction{}, err
+	}
+	if s.tx == nil {
+		s.db.locks.release(lockKey{"transactions", transactionID})
+	}
+
+	t, ok := s.lookupTransaction(transactionID)
+	if !ok || t.ClientID != clientID {
+		return client.Transaction{}, client.ErrTransactionNotFound
+	}
+
+	return t, nil
+}
+
+func (s *Store) UpdateTransactionReversedBy(ctx context.Context, transactionID, reversalID uuid.UUID) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "transactions", transactionID); err != nil {
+			return err
+		}
+
+		t, ok := tx.lookupTransaction(transactionID)
+		if !ok {
+			return nil
+		}
+
+		t.ReversedBy = reversalID
+		tx.tx.staged.transactions.put(t.ID, t)
+
+		return nil
+	})
+}
+
+func (s *Store) UpdateClientLimit(ctx context.Context, clientID, limit int) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "clients", clientID); err != nil {
+			return err
+		}
+
+		var ok bool
+		c, ok = tx.lookupClient(clientID)
+		if !ok {
+			return client.ErrNotFound
+		}
+
+		c.Limit = limit
+		tx.tx.staged.clients.put(c.ID, c)
+
+		return nil
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) AddLimitChange(ctx context.Context, lc client.LimitChange) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(lc.ClientID); !ok {
+			return fmt.Errorf("failed to add limit change: %w", client.ErrNotFound)
+		}
+
+		tx.tx.staged.limitChanges.put(lc.ID, lc)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryLimitChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.LimitChange, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.limitChanges, s.staged().limitChanges)
+	s.db.mu.RUnlock()
+
+	var lcs []client.LimitChange
+	for _, lc := range all {
+		if lc.ClientID == clientID {
+			lcs = append(lcs, lc)
+		}
+	}
+	newestFirst(lcs, func(lc client.LimitChange) time.Time { return lc.Date })
+
+	return paginate(lcs, pageNumber, rowsPerPage), nil
+}
+
+func (s *Store) UpdateClientStatus(ctx context.Context, clientID int, status string) (client.Client, error) {
+	var c client.Client
+	err := s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "clients", clientID); err != nil {
+			return err
+		}
+
+		var ok bool
+		c, ok = tx.lookupClient(clientID)
+		if !ok {
+			return client.ErrNotFound
+		}
+
+		c.Status = status
+		tx.tx.staged.clients.put(c.ID, c)
+
+		return nil
+	})
+	if err != nil {
+		return client.Client{}, err
+	}
+
+	return c, nil
+}
+
+func (s *Store) AddStatusChange(ctx context.Context, sc client.StatusChange) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(sc.ClientID); !ok {
+			return fmt.Errorf("failed to add status change: %w", client.ErrNotFound)
+		}
+
+		tx.tx.staged.statuses.put(sc.ID, sc)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryStatusChanges(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.StatusChange, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.statuses, s.staged().statuses)
+	s.db.mu.RUnlock()
+
+	var scs []client.StatusChange
+	for _, sc := range all {
+		if sc.ClientID == clientID {
+			scs = append(scs, sc)
+		}
+	}
+	newestFirst(scs, func(sc client.StatusChange) time.Time { return sc.Date })
+
+	return paginate(scs, pageNumber, rowsPerPage), nil
+}
+
+func (s *Store) AddHold(ctx context.Context, h client.Hold) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(h.ClientID); !ok {
+			return fmt.Errorf("failed to add hold: %w", client.ErrNotFound)
+		}
+
+		if err := tx.lock(ctx, "holds", h.ID); err != nil {
+			return err
+		}
+
+		if _, exists := tx.lookupHold(h.ID); exists {
+			return fmt.Errorf("failed to add hold: duplicated id[%s]", h.ID)
+		}
+
+		tx.tx.staged.holds.put(h.ID, h)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryHoldByID(ctx context.Context, clientID int, holdID uuid.UUID) (client.Hold, error) {
+	if _, ok := s.lookupHold(holdID); !ok {
+		return client.Hold{}, client.ErrHoldNotFound
+	}
+
+	if err := s.lock(ctx, "holds", holdID); err != nil {
+		return client.Hold{}, err
+	}
+	if s.tx == nil {
+		s.db.locks.release(lockKey{"holds", holdID})
+	}
+
+	h, ok := s.lookupHold(holdID)
+	if !ok || h.ClientID != clientID {
+		return client.Hold{}, client.ErrHoldNotFound
+	}
+
+	return h, nil
+}
+
+func (s *Store) QueryExpiredHolds(ctx context.Context, date time.Time, limit int) ([]client.Hold, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.holds, s.staged().holds)
+	s.db.mu.RUnlock()
+
+	var hs []client.Hold
+	for _, h := range all {
+		if h.Status == client.HoldActive && !h.ExpiresAt.After(date) {
+			hs = append(hs, h)
+		}
+	}
+	sort.SliceStable(hs, func(i, j int) bool {
+		return hs[i].ExpiresAt.Before(hs[j].ExpiresAt)
+	})
+
+	return paginate(hs, 1, limit), nil
+}
+
+func (s *Store) UpdateHold(ctx context.Context, h client.Hold) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "holds", h.ID); err != nil {
+			return err
+		}
+
+		old, ok := tx.lookupHold(h.ID)
+		if !ok {
+			return nil
+		}
+
+		old.Status = h.Status
+		old.CapturedValue = h.CapturedValue
+		old.TransactionID = h.TransactionID
+		old.DateUpdated = h.DateUpdated
+		tx.tx.staged.holds.put(old.ID, old)
+
+		return nil
+	})
+}
+
+func (s *Store) AddScheduled(ctx context.Context, st client.ScheduledTransaction) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(st.ClientID); !ok {
+			return fmt.Errorf("failed to add scheduled transaction: %w", client.ErrNotFound)
+		}
+
+		if err := tx.lock(ctx, "scheduled_transactions", st.ID); err != nil {
+			return err
+		}
+
+		if _, exists := tx.lookupScheduled(st.ID); exists {
+			return fmt.Errorf("failed to add scheduled transaction: duplicated id[%s]", st.ID)
+		}
+
+		tx.tx.staged.scheduled.put(st.ID, st)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryScheduledByID(ctx context.Context, clientID int, scheduledID uuid.UUID) (client.ScheduledTransaction, error) {
+	if _, ok := s.lookupScheduled(scheduledID); !ok {
+		return client.ScheduledTransaction{}, client.ErrScheduledNotFound
+	}
+
+	if err := s.lock(ctx, "scheduled_transactions", scheduledID); err != nil {
+		return client.ScheduledTransaction{}, err
+	}
+	if s.tx == nil {
+		s.db.locks.release(lockKey{"scheduled_transactions", scheduledID})
+	}
+
+	st, ok := s.lookupScheduled(scheduledID)
+	if !ok || st.ClientID != clientID {
+		return client.ScheduledTransaction{}, client.ErrScheduledNotFound
+	}
+
+	return st, nil
+}
+
+func (s *Store) QueryScheduled(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.ScheduledTransaction, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.scheduled, s.staged().scheduled)
+	s.db.mu.RUnlock()
+
+	var sts []client.ScheduledTransaction
+	for _, st := range all {
+		if st.ClientID == clientID {
+			sts = append(sts, st)
+		}
+	}
+	newestFirst(sts, func(st client.ScheduledTransaction) time.Time { return st.ScheduledFor })
+
+	return paginate(sts, pageNumber, rowsPerPage), nil
+}
+
+func (s *Store) QueryDueScheduled(ctx context.Context, date time.Time, limit int) ([]client.ScheduledTransaction, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.scheduled, s.staged().scheduled)
+	s.db.mu.RUnlock()
+
+	var sts []client.ScheduledTransaction
+	for _, st := range all {
+		if st.Status == client.ScheduledPending && !st.ScheduledFor.After(date) {
+			sts = append(sts, st)
+		}
+	}
+	sort.SliceStable(sts, func(i, j int) bool {
+		return sts[i].ScheduledFor.Before(sts[j].ScheduledFor)
+	})
+
+	return paginate(sts, 1, limit), nil
+}
+
+func (s *Store) UpdateScheduled(ctx context.Context, st client.ScheduledTransaction) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "scheduled_transactions", st.ID); err != nil {
+			return err
+		}
+
+		old, ok := tx.lookupScheduled(st.ID)
+		if !ok {
+			return nil
+		}
+
+		old.Status = st.Status
+		old.TransactionID = st.TransactionID
+		old.Reason = st.Reason
+		old.DateUpdated = st.DateUpdated
+		tx.tx.staged.scheduled.put(old.ID, old)
+
+		return nil
+	})
+}
+
+func (s *Store) AddFXRate(ctx context.Context, r client.FXRate) error {
+	return s.write(ctx, func(tx *Store) error {
+		tx.tx.staged.fxRates.put(r.ID, r)
+		return nil
+	})
+}
+
+func (s *Store) QueryFXRate(ctx context.Context, from, to string) (client.FXRate, error) {
+	for _, r := range s.latestFXRates() {
+		if r.From == from && r.To == to {
+			return r, nil
+		}
+	}
+
+	return client.FXRate{}, client.ErrFXRateNotFound
+}
+
+func (s *Store) QueryFXRates(ctx context.Context) ([]client.FXRate, error) {
+	rs := s.latestFXRates()
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].From != rs[j].From {
+			return rs[i].From < rs[j].From
+		}
+		return rs[i].To < rs[j].To
+	})
+
+	return rs, nil
+}
+
+// latestFXRates returns the most recent rate of each pair of currencies.
+func (s *Store) latestFXRates() []client.FXRate {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.fxRates, s.staged().fxRates)
+	s.db.mu.RUnlock()
+
+	type pair struct{ from, to string }
+	latest := make(map[pair]int)
+	rs := []client.FXRate{}
+	for _, r := range all {
+		p := pair{r.From, r.To}
+		i, ok := latest[p]
+		switch {
+		case !ok:
+			latest[p] = len(rs)
+			rs = append(rs, r)
+		case !r.Date.Before(rs[i].Date):
+			rs[i] = r
+		}
+	}
+
+	return rs
+}
+
+func (s *Store) QueryOverdrawnClients(ctx context.Context, after, limit int) ([]client.Client, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.clients, s.staged().clients)
+	s.db.mu.RUnlock()
+
+	var cs []client.Client
+	for _, c := range all {
+		if c.Balance < 0 && c.ID > after {
+			cs = append(cs, c)
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].ID < cs[j].ID
+	})
+
+	return paginate(cs, 1, limit), nil
+}
+
+func (s *Store) AddInterestAccrual(ctx context.Context, a client.InterestAccrual) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(a.ClientID); !ok {
+			return fmt.Errorf("failed to add interest accrual: %w", client.ErrNotFound)
+		}
+
+		pk := accrualKey{a.ClientID, a.Day.UTC()}
+		if err := tx.lock(ctx, "interest_accruals", pk); err != nil {
+			return err
+		}
+
+		tx.db.mu.RLock()
+		_, exists := lookup(tx.db.tables.accruals, tx.tx.staged.accruals, pk)
+		tx.db.mu.RUnlock()
+		if exists {
+			return fmt.Errorf("failed to add interest accrual: duplicated day[%s]", a.Day)
+		}
+
+		tx.tx.staged.accruals.put(pk, a)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryInterestAccrual(ctx context.Context, clientID int, day time.Time) (client.InterestAccrual, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	a, ok := lookup(s.db.tables.accruals, s.staged().accruals, accrualKey{clientID, day.UTC()})
+	if !ok {
+		return client.InterestAccrual{}, client.ErrAccrualNotFound
+	}
+
+	return a, nil
+}
+
+func (s *Store) AddInvoice(ctx context.Context, inv client.Invoice) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(inv.ClientID); !ok {
+			return fmt.Errorf("failed to add invoice: %w", client.ErrNotFound)
+		}
+
+		// Lock the period the same way the unique index does.
+		if err := tx.lock(ctx, "invoices", invoiceKey{inv.ClientID, inv.PeriodEnd.UTC()}); err != nil {
+			return err
+		}
+
+		for _, old := range tx.clientInvoices(inv.ClientID) {
+			if old.ID == inv.ID || old.PeriodEnd.Equal(inv.PeriodEnd) {
+				return fmt.Errorf("failed to add invoice: duplicated period[%s]", inv.PeriodEnd)
+			}
+		}
+
+		tx.tx.staged.invoices.put(inv.ID, inv)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryLastInvoice(ctx context.Context, clientID int) (client.Invoice, error) {
+	invs := s.clientInvoices(clientID)
+	if len(invs) == 0 {
+		return client.Invoice{}, client.ErrInvoiceNotFound
+	}
+
+	inv := invs[0]
+	inv.Transactions = nil
+
+	return inv, nil
+}
+
+func (s *Store) QueryInvoiceByID(ctx context.Context, clientID int, invoiceID uuid.UUID) (client.Invoice, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	inv, ok := lookup(s.db.tables.invoices, s.staged().invoices, invoiceID)
+	if !ok || inv.ClientID != clientID {
+		return client.Invoice{}, client.ErrInvoiceNotFound
+	}
+
+	return inv, nil
+}
+
+func (s *Store) QueryInvoices(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.Invoice, error) {
+	invs := paginate(s.clientInvoices(clientID), pageNumber, rowsPerPage)
+	for i := range invs {
+		invs[i].Transactions = nil
+	}
+
+	return invs, nil
+}
+
+// clientInvoices returns the client's invoices, most recent period first.
+func (s *Store) clientInvoices(clientID int) []client.Invoice {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.invoices, s.staged().invoices)
+	s.db.mu.RUnlock()
+
+	var invs []client.Invoice
+	for _, inv := range all {
+		if inv.ClientID == clientID {
+			invs = append(invs, inv)
+		}
+	}
+	newestFirst(invs, func(inv client.Invoice) time.Time { return inv.PeriodEnd })
+
+	return invs
+}
+
+func (s *Store) QueryBalanceAt(ctx context.Context, clientID int, date time.Time) (int, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.transactions, s.staged().transactions)
+	s.db.mu.RUnlock()
+
+	var ts []client.Transaction
+	for _, t := range all {
+		if t.ClientID == clientID && !t.Date.After(date) {
+			ts = append(ts, t)
+		}
+	}
+	if len(ts) == 0 {
+		return 0, nil
+	}
+	newestFirst(ts, func(t client.Transaction) time.Time { return t.Date })
+
+	return ts[0].BalanceAfter, nil
+}
+
+func (s *Store) QuerySpendingLimits(ctx context.Context, clientID int) (client.SpendingLimits, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	l, ok := lookup(s.db.tables.limits, s.staged().limits, clientID)
+	if !ok {
+		return client.SpendingLimits{ClientID: clientID}, nil
+	}
+
+	return l, nil
+}
+
+func (s *Store) SaveSpendingLimits(ctx context.Context, l client.SpendingLimits) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(l.ClientID); !ok {
+			return fmt.Errorf("failed to save spending limits: %w", client.ErrNotFound)
+		}
+
+		if err := tx.lock(ctx, "spending_limits", l.ClientID); err != nil {
+			return err
+		}
+
+		tx.tx.staged.limits.put(l.ClientID, l)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryDebitTotals(ctx context.Context, clientID int, since time.Time) (client.DebitTotals, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.transactions, s.staged().transactions)
+	s.db.mu.RUnlock()
+
+	var d client.DebitTotals
+	for _, t := range all {
+		if t.ClientID == clientID && t.Type == "d" && !t.Date.Before(since) {
+			d.Count++
+			d.Total += t.Value
+		}
+	}
+
+	return d, nil
+}
+
+func (s *Store) SumTransactions(ctx context.Context, clientID int) (int, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.transactions, s.staged().transactions)
+	s.db.mu.RUnlock()
+
+	var sum int
+	for _, t := range all {
+		if t.ClientID != clientID {
+			continue
+		}
+		if t.Type == "d" {
+			sum -= t.Value
+		} else {
+			sum += t.Value
+		}
+	}
+
+	return sum, nil
+}
+
+func (s *Store) AddBalanceAdjustment(ctx context.Context, a client.BalanceAdjustment) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(a.ClientID); !ok {
+			return fmt.Errorf("failed to add balance adjustment: %w", client.ErrNotFound)
+		}
+
+		tx.tx.staged.adjustments.put(a.ID, a)
+
+		return nil
+	})
+}
+
+func (s *Store) AddTransactionFlag(ctx context.Context, f client.TransactionFlag) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupTransaction(f.TransactionID); !ok {
+			return fmt.Errorf("failed to add transaction flag: %w", client.ErrTransactionNotFound)
+		}
+
+		tx.tx.staged.flags.put(f.ID, f)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryTransactionFlags(ctx context.Context, clientID, pageNumber, rowsPerPage int) ([]client.TransactionFlag, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.flags, s.staged().flags)
+	s.db.mu.RUnlock()
+
+	var fs []client.TransactionFlag
+	for _, f := range all {
+		if f.ClientID == clientID {
+			fs = append(fs, f)
+		}
+	}
+	newestFirst(fs, func(f client.TransactionFlag) time.Time { return f.Date })
+
+	return paginate(fs, pageNumber, rowsPerPage), nil
+}
+
+func (s *Store) QueryIdempotencyKey(ctx context.Context, clientID int, key string) (client.IdempotencyKey, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	k, ok := lookup(s.db.tables.idempotency, s.staged().idempotency, idempotencyKey{clientID, key})
+	if !ok {
+		return client.IdempotencyKey{}, client.ErrIdempotencyKeyNotFound
+	}
+
+	return k, nil
+}
+
+func (s *Store) SaveIdempotencyKey(ctx context.Context, k client.IdempotencyKey) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(k.ClientID); !ok {
+			return fmt.Errorf("failed to save idempotency key: %w", client.ErrNotFound)
+		}
+
+		pk := idempotencyKey{k.ClientID, k.Key}
+		if err := tx.lock(ctx, "idempotency_keys", pk); err != nil {
+			return err
+		}
+
+		tx.tx.staged.idempotency.put(pk, k)
+
+		return nil
+	})
+}
+
+func (s *Store) DeleteIdempotencyKeys(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(tx *Store) error {
+		tx.db.mu.RLock()
+		all := scan(tx.db.tables.idempotency, tx.tx.staged.idempotency)
+		tx.db.mu.RUnlock()
+
+		for _, k := range all {
+			if k.ExpiresAt.After(date) {
+				continue
+			}
+
+			pk := idempotencyKey{k.ClientID, k.Key}
+			if err := tx.lock(ctx, "idempotency_keys", pk); err != nil {
+				return err
+			}
+
+			// The key may have been replaced while waiting for the lock.
+			tx.db.mu.RLock()
+			k, ok := lookup(tx.db.tables.idempotency, tx.tx.staged.idempotency, pk)
+			tx.db.mu.RUnlock()
+			if !ok || k.ExpiresAt.After(date) {
+				continue
+			}
+
+			tx.tx.staged.idempotency.del(pk)
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) AddOutboxEvent(ctx context.Context, e client.OutboxEvent) error {
+	return s.write(ctx, func(tx *Store) error {
+		e.Seq = tx.db.outboxSeq.Add(1)
+		tx.tx.staged.outbox.put(e.ID, e)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryPendingOutbox(ctx context.Context, after int64, limit int) ([]client.OutboxEvent, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.outbox, s.staged().outbox)
+	s.db.mu.RUnlock()
+
+	var es []client.OutboxEvent
+	for _, e := range all {
+		if e.Status == client.OutboxPending && e.Seq > after {
+			es = append(es, e)
+		}
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].Seq < es[j].Seq
+	})
+
+	return paginate(es, 1, limit), nil
+}
+
+func (s *Store) UpdateOutboxEvent(ctx context.Context, e client.OutboxEvent) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "outbox", e.ID); err != nil {
+			return err
+		}
+
+		tx.db.mu.RLock()
+		old, ok := lookup(tx.db.tables.outbox, tx.tx.staged.outbox, e.ID)
+		tx.db.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("outbox event[%s] not found", e.ID)
+		}
+
+		old.Status = e.Status
+		old.Attempts = e.Attempts
+		old.LastError = e.LastError
+		old.NextAttempt = e.NextAttempt
+		old.DateUpdated = e.DateUpdated
+		tx.tx.staged.outbox.put(old.ID, old)
+
+		return nil
+	})
+}
+
+func (s *Store) DeleteOutboxEvents(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(tx *Store) error {
+		tx.db.mu.RLock()
+		all := scan(tx.db.tables.outbox, tx.tx.staged.outbox)
+		tx.db.mu.RUnlock()
+
+		for _, e := range all {
+			if e.Status == client.OutboxPending || !e.DateUpdated.Before(date) {
+				continue
+			}
+
+			if err := tx.lock(ctx, "outbox", e.ID); err != nil {
+				return err
+			}
+
+			// The event may have been updated while waiting for the lock.
+			tx.db.mu.RLock()
+			e, ok := lookup(tx.db.tables.outbox, tx.tx.staged.outbox, e.ID)
+			tx.db.mu.RUnlock()
+			if !ok || e.Status == client.OutboxPending || !e.DateUpdated.Before(date) {
+				continue
+			}
+
+			tx.tx.staged.outbox.del(e.ID)
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) AddWebhook(ctx context.Context, w client.Webhook) error {
+	return s.write(ctx, func(tx *Store) error {
+		if _, ok := tx.lookupClient(w.ClientID); !ok {
+			return fmt.Errorf("failed to add webhook: %w", client.ErrNotFound)
+		}
+
+		tx.tx.staged.webhooks.put(w.ID, w)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryWebhooks(ctx context.Context, clientID int) ([]client.Webhook, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.webhooks, s.staged().webhooks)
+	s.db.mu.RUnlock()
+
+	var ws []client.Webhook
+	for _, w := range all {
+		if w.ClientID == clientID {
+			ws = append(ws, w)
+		}
+	}
+
+	return ws, nil
+}
+
+func (s *Store) QueryWebhookByID(ctx context.Context, clientID int, webhookID uuid.UUID) (client.Webhook, error) {
+	s.db.mu.RLock()
+	w, ok := lookup(s.db.tables.webhooks, s.staged().webhooks, webhookID)
+	s.db.mu.RUnlock()
+	if !ok || w.ClientID != clientID {
+		return client.Webhook{}, client.ErrWebhookNotFound
+	}
+
+	return w, nil
+}
+
+func (s *Store) AddWebhookDelivery(ctx context.Context, d client.WebhookDelivery) error {
+	return s.write(ctx, func(tx *Store) error {
+		tx.db.mu.RLock()
+		_, ok := lookup(tx.db.tables.webhooks, tx.tx.staged.webhooks, d.WebhookID)
+		tx.db.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("failed to add webhook delivery: %w", client.ErrWebhookNotFound)
+		}
+
+		tx.tx.staged.deliveries.put(d.ID, d)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryWebhookDeliveryByID(ctx context.Context, clientID int, deliveryID uuid.UUID) (client.WebhookDelivery, error) {
+	s.db.mu.RLock()
+	d, ok := lookup(s.db.tables.deliveries, s.staged().deliveries, deliveryID)
+	s.db.mu.RUnlock()
+	if !ok || d.ClientID != clientID {
+		return client.WebhookDelivery{}, client.ErrDeliveryNotFound
+	}
+
+	return d, nil
+}
+
+func (s *Store) QueryWebhookDeliveries(ctx context.Context, clientID int, webhookID uuid.UUID, pageNumber, rowsPerPage int) ([]client.WebhookDelivery, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.deliveries, s.staged().deliveries)
+	s.db.mu.RUnlock()
+
+	var ds []client.WebhookDelivery
+	for _, d := range all {
+		if d.ClientID == clientID && d.WebhookID == webhookID {
+			ds = append(ds, d)
+		}
+	}
+	newestFirst(ds, func(d client.WebhookDelivery) time.Time { return d.Date })
+
+	return paginate(ds, pageNumber, rowsPerPage), nil
+}
+
+func (s *Store) QueryDueWebhookDeliveries(ctx context.Context, date time.Time, limit int) ([]client.WebhookDelivery, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.deliveries, s.staged().deliveries)
+	s.db.mu.RUnlock()
+
+	var ds []client.WebhookDelivery
+	for _, d := range all {
+		if d.Status == client.DeliveryPending && !d.NextAttempt.After(date) {
+			ds = append(ds, d)
+		}
+	}
+	sort.SliceStable(ds, func(i, j int) bool {
+		return ds[i].NextAttempt.Before(ds[j].NextAttempt)
+	})
+
+	return paginate(ds, 1, limit), nil
+}
+
+func (s *Store) UpdateWebhookDelivery(ctx context.Context, d client.WebhookDelivery) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "webhook_deliveries", d.ID); err != nil {
+			return err
+		}
+
+		tx.db.mu.RLock()
+		old, ok := lookup(tx.db.tables.deliveries, tx.tx.staged.deliveries, d.ID)
+		tx.db.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("webhook delivery[%s] not found", d.ID)
+		}
+
+		old.Status = d.Status
+		old.Attempts = d.Attempts
+		old.ResponseStatus = d.ResponseStatus
+		old.LastError = d.LastError
+		old.NextAttempt = d.NextAttempt
+		old.DateUpdated = d.DateUpdated
+		tx.tx.staged.deliveries.put(old.ID, old)
+
+		return nil
+	})
+}
+
+func (s *Store) DeleteWebhookDeliveries(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(tx *Store) error {
+		tx.db.mu.RLock()
+		all := scan(tx.db.tables.deliveries, tx.tx.staged.deliveries)
+		tx.db.mu.RUnlock()
+
+		for _, d := range all {
+			if d.Status == client.DeliveryPending || !d.DateUpdated.Before(date) {
+				continue
+			}
+
+			if err := tx.lock(ctx, "webhook_deliveries", d.ID); err != nil {
+				return err
+			}
+
+			// The delivery may have been updated while waiting for the
+			// lock.
+			tx.db.mu.RLock()
+			d, ok := lookup(tx.db.tables.deliveries, tx.tx.staged.deliveries, d.ID)
+			tx.db.mu.RUnlock()
+			if !ok || d.Status == client.DeliveryPending || !d.DateUpdated.Before(date) {
+				continue
+			}
+
+			tx.tx.staged.deliveries.del(d.ID)
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) AddClientUpdate(ctx context.Context, u client.ClientUpdate) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "client_update_seqs", u.ClientID); err != nil {
+			return err
+		}
+
+		tx.db.mu.RLock()
+		seq, _ := lookup(tx.db.tables.updateSeqs, tx.tx.staged.updateSeqs, u.ClientID)
+		tx.db.mu.RUnlock()
+
+		u.Seq = seq + 1
+		tx.tx.staged.updates.put(updateKey{u.ClientID, u.Seq}, u)
+		tx.tx.staged.updateSeqs.put(u.ClientID, u.Seq)
+
+		return nil
+	})
+}
+
+func (s *Store) QueryClientUpdates(ctx context.Context, clientID int, after int64, limit int) ([]client.ClientUpdate, error) {
+	s.db.mu.RLock()
+	all := scan(s.db.tables.updates, s.staged().updates)
+	s.db.mu.RUnlock()
+
+	var us []client.ClientUpdate
+	for _, u := range all {
+		if u.ClientID == clientID && u.Seq > after {
+			us = append(us, u)
+		}
+	}
+	sort.Slice(us, func(i, j int) bool {
+		return us[i].Seq < us[j].Seq
+	})
+
+	return paginate(us, 1, limit), nil
+}
+
+func (s *Store) QueryClientUpdateSeq(ctx context.Context, clientID int) (int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	seq, _ := lookup(s.db.tables.updateSeqs, s.staged().updateSeqs, clientID)
+	return seq, nil
+}
+
+func (s *Store) DeleteClientUpdates(ctx context.Context, date time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(tx *Store) error {
+		tx.db.mu.RLock()
+		all := scan(tx.db.tables.updates, tx.tx.staged.updates)
+		tx.db.mu.RUnlock()
+
+		for _, u := range all {
+			if !u.Date.Before(date) {
+				continue
+			}
+
+			pk := updateKey{u.ClientID, u.Seq}
+			if err := tx.lock(ctx, "client_updates", pk); err != nil {
+				return err
+			}
+
+			tx.tx.staged.updates.del(pk)
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) QueryLedgerSeq(ctx context.Context) (int64, error) {
+	if err := s.lock(ctx, "ledger_seq", struct{}{}); err != nil {
+		return 0, err
+	}
+	if s.tx == nil {
+		s.db.locks.release(lockKey{"ledger_seq", struct{}{}})
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	seq, _ := lookup(s.db.tables.ledgerSeq, s.staged().ledgerSeq, struct{}{})
+	return seq, nil
+}
+
+func (s *Store) QueryUnsequencedTransactions(ctx context.Context, limit int) ([]client.Transaction, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	// The committed rows are in the order their transactions committed.
+	ids := scan(s.db.tables.unsequenced, s.staged().unsequenced)
+	ids = paginate(ids, 1, limit)
+
+	ts := make([]client.Transaction, 0, len(ids))
+	for _, id := range ids {
+		t, ok := lookup(s.db.tables.transactions, s.staged().transactions, id)
+		if !ok {
+			return nil, fmt.Errorf("transaction[%s] not found", id)
+		}
+		ts = append(ts, t)
+	}
+
+	return ts, nil
+}
+
+func (s *Store) AddLedgerEntries(ctx context.Context, es []client.LedgerEntry) error {
+	return s.write(ctx, func(tx *Store) error {
+		if err := tx.lock(ctx, "ledger_seq", struct{}{}); err != nil {
+			return err
+		}
+
+		for _, e := range es {
+			if _, ok := tx.lookupTransaction(e.Transaction.ID); !ok {
+				return fmt.Errorf("failed to add ledger entry: %w", client.ErrTransactionNotFound)
+			}
+
+			tx.tx.staged.ledger.put(e.Seq, e.Transaction.ID)
+			tx.tx.staged.unsequenced.del(e.Transaction.ID)
+			tx.tx.staged.ledgerSeq.put(struct{}{}, e.Seq)
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) QueryLedger(ctx context.Context, after int64, limit int) ([]client.LedgerEntry, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	seq, _ := lookup(s.db.tables.ledgerSeq, s.staged().ledgerSeq, struct{}{})
+
+	var es []client.LedgerEntry
+	for next := after + 1; next <= seq && len(es) < limit; next++ {
+		id, ok := lookup(s.db.tables.ledger, s.staged().ledger, next)
+		if !ok {
+			return nil, fmt.Errorf("ledger entry[%d] not found", next)
+		}
+		t, ok := lookup(s.db.tables.transactions, s.staged().transactions, id)
+		if !ok {
+			return nil, fmt.Errorf("transaction[%s] not found", id)
+		}
+		es = append(es, client.LedgerEntry{Seq: next, Transaction: t})
+	}
+
+	return es, nil
+}
+
+// Listen calls fn with the client of each update committed to the store
+// until the ctx is done.
+func (s *Store) Listen(ctx context.Context, fn func(clientID int)) error {
+	id := s.db.listeners.add(fn)
+	defer s.db.listeners.remove(id)
+
+	<-ctx.Done()
+	return nil
+}
+
+func (l *listeners) add(fn func(clientID int)) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fns == nil {
+		l.fns = make(map[int]func(clientID int))
+	}
+	l.next++
+	l.fns[l.next] = fn
+
+	return l.next
+}
+
+func (l *listeners) remove(id int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.fns, id)
+}
+
+func (l *listeners) call(clientID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, fn := range l.fns {
+		fn(clientID)
+	}
+}
+
+// =============================================================================
+
+// write executes fn under the current transaction, or under a new one if the
+// store is not in a transaction.
+func (s *Store) write(ctx context.Context, fn func(tx *Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	return s.ExecUnderTx(ctx, func(tx client.Store) error {
+		return fn(tx.(*Store))
+	})
+}
+
+// lock acquires the lock of a row. If the store is in a transaction the lock
+// is held until the transaction ends, otherwise the caller must release it.
+func (s *Store) lock(ctx context.Context, table string, key any) error {
+	k := lockKey{table: table, key: key}
+	if s.tx != nil && s.tx.locked[k] {
+		return nil
+	}
+
+	if err := s.db.locks.acquire(ctx, k); err != nil {
+		return err
+	}
+	if s.tx != nil {
+		s.tx.locked[k] = true
+	}
+
+	return nil
+}
+
+// staged returns the tables staged by the transaction. Outside a transaction
+// the tables are nil.
+func (s *Store) staged() tables {
+	if s.tx == nil {
+		return tables{}
+	}
+	return s.tx.staged
+}
+
+func (s *Store) lookupClient(clientID int) (client.Client, bool) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return lookup(s.db.tables.clients, s.staged().clients, clientID)
+}
+
+// newestFirst sorts the rows by date, most recent first. Rows with the same
+// date keep the most recently inserted first.
+func newestFirst[T any](rows []T, date func(T) time.Time) {
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return date(rows[i]).After(date(rows[j]))
+	})
+}
+
+func (s *Store) lookupTransaction(transactionID uuid.UUID) (client.Transaction, bool) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return lookup(s.db.tables.transactions, s.staged().transactions, transactionID)
+}
+
+func (s *Store) lookupHold(holdID uuid.UUID) (client.Hold, bool) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return lookup(s.db.tables.holds, s.staged().holds, holdID)
+}
+
+func (s *Store) lookupScheduled(scheduledID uuid.UUID) (client.ScheduledTransaction, bool) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return lookup(s.db.tables.scheduled, s.staged().scheduled, scheduledID)
+}
+
+func paginate[T any](s []T, pageNumber, rowsPerPage int) []T {
+	offset := (pageNumber - 1) * rowsPerPage
+	if offset < 0 || rowsPerPage < 0 || offset >= len(s) {
+		return []T{}
+	}
+
+	end := min(offset+rowsPerPage, len(s))
+	out := make([]T, end-offset)
+	copy(out, s[offset:end])
+	return out
+}
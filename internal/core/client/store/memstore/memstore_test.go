@@ -0,0 +1,369 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+)
+
+func TestQueryByID(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(DefaultClients()...)
+
+	c, err := store.QueryByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to query client by id[%d]: %v", 1, err)
+	}
+
+	if c.ID != 1 {
+		t.Errorf("wrong id, got %d want %v", c.ID, 1)
+	}
+	if c.Limit != 100000 {
+		t.Errorf("wrong limit, got %d want %v", c.Limit, 100000)
+	}
+	if c.Balance != 0 {
+		t.Errorf("wrong balance, got %d want %v", c.Balance, 0)
+	}
+
+	if _, err := store.QueryByID(ctx, 6); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("got err %v want %v", err, client.ErrNotFound)
+	}
+}
+
+func TestQueryTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(DefaultClients()...)
+
+	clientID := 3
+	for i := range 25 {
+		tr := genTransaction(clientID)
+		tr.Value = i
+		if err := store.AddTransaction(ctx, tr); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	ts, err := store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query transactions: %v", err)
+	}
+	if len(ts) != 10 {
+		t.Fatalf("got %d transactions, want %d", len(ts), 10)
+	}
+	if ts[0].Value != 24 {
+		t.Errorf("wrong value got %d want %d", ts[0].Value, 24)
+	}
+
+	ts, err = store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 3, 10)
+	if err != nil {
+		t.Fatalf("failed to query transactions: %v", err)
+	}
+	if len(ts) != 5 {
+		t.Fatalf("got %d transactions, want %d", len(ts), 5)
+	}
+
+	clientID = 1
+	ts, err = store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query transactions: %v", err)
+	}
+	if len(ts) != 0 {
+		t.Errorf("got %d should return 0 transactions", len(ts))
+	}
+}
+
+func TestExecUnderTxRollback(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(DefaultClients()...)
+
+	clientID := 1
+	errRollback := errors.New("rollback")
+	err := store.ExecUnderTx(ctx, func(tx client.Store) error {
+		if err := tx.AddTransaction(ctx, genTransaction(clientID)); err != nil {
+			return err
+		}
+		if _, err := tx.UpdateClientBalance(ctx, clientID, -750); err != nil {
+			return err
+		}
+
+		c, err := tx.QueryByID(ctx, clientID)
+		if err != nil {
+			return err
+		}
+		if c.Balance != -750 {
+			t.Errorf("transaction should see its own writes, got balance %d", c.Balance)
+		}
+
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got err %v want %v", err, errRollback)
+	}
+
+	c, err := store.QueryByID(ctx, clientID)
+	if err != nil {
+		t.Fatalf("failed to query client: %v", err)
+	}
+	if c.Balance != 0 {
+		t.Errorf("balance should be rolled back, got %d", c.Balance)
+	}
+
+	ts, err := store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query transactions: %v", err)
+	}
+	if len(ts) != 0 {
+		t.Errorf("transactions should be rolled back, got %d", len(ts))
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(DefaultClients()...)
+
+	clientID := 2
+	for range 3 {
+		if err := store.AddTransaction(ctx, genTransaction(clientID)); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	// Staged rows are not part of the snapshot.
+	errRollback := errors.New("rollback")
+	var snap Snapshot
+	err := store.ExecUnderTx(ctx, func(tx client.Store) error {
+		if _, err := tx.UpdateClientBalance(ctx, clientID, -2250); err != nil {
+			return err
+		}
+		snap = store.Snapshot()
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got err %v want %v", err, errRollback)
+	}
+
+	restored := NewStoreFromSnapshot(snap)
+
+	c, err := restored.QueryByID(ctx, clientID)
+	if err != nil {
+		t.Fatalf("failed to query client: %v", err)
+	}
+	if c.Balance != 0 {
+		t.Errorf("got balance %d want %d", c.Balance, 0)
+	}
+
+	want, err := store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query transactions: %v", err)
+	}
+	got, err := restored.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to query transactions: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transactions want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Errorf("transaction[%d]: got %s want %s", i, got[i].ID, want[i].ID)
+		}
+	}
+}
+
+func TestClientUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewStore(DefaultClients()...)
+
+	notified := make(chan int, 10)
+	done := make(chan error)
+	go func() {
+		done <- store.Listen(ctx, func(clientID int) { notified <- clientID })
+	}()
+	for {
+		store.db.listeners.mu.Lock()
+		n := len(store.db.listeners.fns)
+		store.db.listeners.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	add := func(tx client.Store, clientID int) error {
+		return tx.AddClientUpdate(ctx, client.ClientUpdate{ClientID: clientID, Type: client.UpdateBalance, Data: []byte("{}")})
+	}
+
+	// Rolled back updates are not notified and their Seq is reused.
+	errRollback := errors.New("rollback")
+	err := store.ExecUnderTx(ctx, func(tx client.Store) error {
+		if err := add(tx, 1); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("got err %v want %v", err, errRollback)
+	}
+
+	err = store.ExecUnderTx(ctx, func(tx client.Store) error {
+		for _, id := range []int{1, 2, 1} {
+			if err := add(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("adding updates: %v", err)
+	}
+
+	for _, want := range []int{1, 2} {
+		if got := <-notified; got != want {
+			t.Fatalf("got notified client %d want %d", got, want)
+		}
+	}
+	select {
+	case id := <-notified:
+		t.Fatalf("got unexpected notification of client %d", id)
+	default:
+	}
+
+	us, err := store.QueryClientUpdates(ctx, 1, 0, 10)
+	if err != nil {
+		t.Fatalf("querying updates: %v", err)
+	}
+	if len(us) != 2 || us[0].Seq != 1 || us[1].Seq != 2 {
+		t.Fatalf("got updates %+v", us)
+	}
+	if seq, err := store.QueryClientUpdateSeq(ctx, 2); err != nil || seq != 1 {
+		t.Fatalf("got seq %d, %v want 1", seq, err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+}
+
+func TestExecUnderTxLock(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(DefaultClients()...)
+
+	clientID := 2
+	locked := make(chan struct{})
+	commit := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- store.ExecUnderTx(ctx, func(tx client.Store) error {
+			if _, err := tx.QueryByID(ctx, clientID); err != nil {
+				return err
+			}
+			close(locked)
+			<-commit
+			_, err := tx.UpdateClientBalance(ctx, clientID, 100)
+			return err
+		})
+	}()
+
+	<-locked
+	ctxTimeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := store.QueryByID(ctxTimeout, clientID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("query should wait for the lock, got err %v", err)
+	}
+
+	close(commit)
+	if err := <-done; err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+
+	c, err := store.QueryByID(ctx, clientID)
+	if err != nil {
+		t.Fatalf("failed to query client: %v", err)
+	}
+	if c.Balance != 100 {
+		t.Errorf("got %d balance want %d", c.Balance, 100)
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(DefaultClients()...)
+	core := client.NewCore(store)
+
+	var wg sync.WaitGroup
+	for range 1000 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			clientID := rand.N(5) + 1
+			nt := client.NewTransaction{
+				Value:       rand.N(5000) * 100,
+				Type:        []string{"c", "d"}[rand.N(2)],
+				Description: "some",
+			}
+
+			c, err := core.AddTransaction(ctx, clientID, nt)
+			if err != nil && !errors.Is(err, client.ErrTransactionDenied) {
+				t.Errorf("transaction err: %v", err)
+			}
+			if c.Balance < -c.Limit {
+				t.Errorf("insconsistency found on AddTransaction: %+v", c)
+			}
+
+			b, err := core.Billing(ctx, clientID, client.TransactionFilter{})
+			if err != nil {
+				t.Errorf("billing error: %v", err)
+			}
+			if b.Balance < -b.Limit {
+				t.Errorf("insconsistency found on Billing: %+v", b)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for clientID := 1; clientID <= 5; clientID++ {
+		c, err := store.QueryByID(ctx, clientID)
+		if err != nil {
+			t.Fatalf("failed to query clientID[%d]: %v", clientID, err)
+		}
+
+		ts, err := store.QueryTransactions(ctx, clientID, client.TransactionFilter{}, 1, 1000)
+		if err != nil {
+			t.Fatalf("failed to get transactions from clientID[%d]: %v", clientID, err)
+		}
+
+		total := 0
+		for _, t := range ts {
+			if t.Type == "d" {
+				total -= t.Value
+				continue
+			}
+			total += t.Value
+		}
+
+		if c.Balance != total {
+			t.Fatalf("inconsistency between balance and transactions: balance[%d] calculated total[%d]", c.Balance, total)
+		}
+	}
+}
+
+func genTransaction(clientID int) client.Transaction {
+	return client.Transaction{
+		ID:          uuid.New(),
+		ClientID:    clientID,
+		Value:       750,
+		Type:        "d",
+		Description: "desc",
+		Date:        time.Now(),
+	}
+}
@@ -0,0 +1,154 @@
+package memstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+)
+
+// Snapshot is a copy of the committed rows of a Store, in insertion order.
+type Snapshot struct {
+	Clients      []client.Client
+	Transactions []client.Transaction
+	LimitChanges []client.LimitChange
+	Idempotency  []client.IdempotencyKey
+	Holds        []client.Hold
+	Scheduled    []client.ScheduledTransaction
+	FXRates      []client.FXRate
+	Accruals     []client.InterestAccrual
+	Invoices     []client.Invoice
+	Limits       []client.SpendingLimits
+	Flags        []client.TransactionFlag
+	Adjustments  []client.BalanceAdjustment
+	Outbox       []client.OutboxEvent
+	Webhooks     []client.Webhook
+	Deliveries   []client.WebhookDelivery
+	Updates      []client.ClientUpdate
+	Unsequenced  []uuid.UUID
+	Ledger       []LedgerEntry
+	Statuses     []client.StatusChange
+}
+
+// LedgerEntry is an entry of the ledger, the transaction with the Seq.
+type LedgerEntry struct {
+	Seq           int64
+	TransactionID uuid.UUID
+}
+
+// Snapshot returns a copy of the rows committed to the store. Rows staged by
+// transactions in progress are not included.
+func (s *Store) Snapshot() Snapshot {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	t := s.db.tables
+	return Snapshot{
+		Clients:      scan(t.clients, nil),
+		Transactions: scan(t.transactions, nil),
+		LimitChanges: scan(t.limitChanges, nil),
+		Idempotency:  scan(t.idempotency, nil),
+		Holds:        scan(t.holds, nil),
+		Scheduled:    scan(t.scheduled, nil),
+		FXRates:      scan(t.fxRates, nil),
+		Accruals:     scan(t.accruals, nil),
+		Invoices:     scan(t.invoices, nil),
+		Limits:       scan(t.limits, nil),
+		Flags:        scan(t.flags, nil),
+		Adjustments:  scan(t.adjustments, nil),
+		Outbox:       scan(t.outbox, nil),
+		Webhooks:     scan(t.webhooks, nil),
+		Deliveries:   scan(t.deliveries, nil),
+		Updates:      scan(t.updates, nil),
+		Unsequenced:  scan(t.unsequenced, nil),
+		Ledger:       ledgerEntries(t.ledger),
+		Statuses:     scan(t.statuses, nil),
+	}
+}
+
+func ledgerEntries(t *table[int64, uuid.UUID]) []LedgerEntry {
+	es := make([]LedgerEntry, 0, len(t.keys))
+	for _, seq := range t.keys {
+		id, _ := lookup(t, nil, seq)
+		es = append(es, LedgerEntry{Seq: seq, TransactionID: id})
+	}
+	return es
+}
+
+// NewStoreFromSnapshot creates an in-memory store with the rows of the
+// snapshot.
+func NewStoreFromSnapshot(snap Snapshot) *Store {
+	db := database{tables: newTables()}
+
+	t := db.tables
+	for _, c := range snap.Clients {
+		// Snapshots taken before the clients had a status.
+		if c.Status == "" {
+			c.Status = client.StatusActive
+		}
+		t.clients.put(c.ID, c)
+	}
+	for _, tr := range snap.Transactions {
+		t.transactions.put(tr.ID, tr)
+	}
+	for _, lc := range snap.LimitChanges {
+		t.limitChanges.put(lc.ID, lc)
+	}
+	for _, k := range snap.Idempotency {
+		t.idempotency.put(idempotencyKey{k.ClientID, k.Key}, k)
+	}
+	for _, h := range snap.Holds {
+		t.holds.put(h.ID, h)
+	}
+	for _, st := range snap.Scheduled {
+		t.scheduled.put(st.ID, st)
+	}
+	for _, r := range snap.FXRates {
+		t.fxRates.put(r.ID, r)
+	}
+	for _, a := range snap.Accruals {
+		t.accruals.put(accrualKey{a.ClientID, a.Day.UTC()}, a)
+	}
+	for _, inv := range snap.Invoices {
+		t.invoices.put(inv.ID, inv)
+	}
+	for _, l := range snap.Limits {
+		t.limits.put(l.ClientID, l)
+	}
+	for _, f := range snap.Flags {
+		t.flags.put(f.ID, f)
+	}
+	for _, a := range snap.Adjustments {
+		t.adjustments.put(a.ID, a)
+	}
+	for _, e := range snap.Outbox {
+		t.outbox.put(e.ID, e)
+		if e.Seq > db.outboxSeq.Load() {
+			db.outboxSeq.Store(e.Seq)
+		}
+	}
+	for _, w := range snap.Webhooks {
+		t.webhooks.put(w.ID, w)
+	}
+	for _, d := range snap.Deliveries {
+		t.deliveries.put(d.ID, d)
+	}
+	for _, u := range snap.Updates {
+		t.updates.put(updateKey{u.ClientID, u.Seq}, u)
+		if seq, _ := lookup(t.updateSeqs, nil, u.ClientID); u.Seq > seq {
+			t.updateSeqs.put(u.ClientID, u.Seq)
+		}
+	}
+	for _, sc := range snap.Statuses {
+		t.statuses.put(sc.ID, sc)
+	}
+	for _, id := range snap.Unsequenced {
+		t.unsequenced.put(id, id)
+	}
+	for _, e := range snap.Ledger {
+		t.ledger.put(e.Seq, e.TransactionID)
+		if seq, _ := lookup(t.ledgerSeq, nil, struct{}{}); e.Seq > seq {
+			t.ledgerSeq.put(struct{}{}, e.Seq)
+		}
+	}
+
+	return &Store{db: &db}
+}
@@ -0,0 +1,179 @@
+package memstore
+
+import (
+	"context"
+	"sync"
+)
+
+// table is a set of rows indexed by a key that keeps the insertion order.
+// Tables staged by a transaction also record deleted rows, so they can be
+// applied over the committed rows.
+type table[K comparable, V any] struct {
+	rows map[K]entry[V]
+	keys []K
+}
+
+type entry[V any] struct {
+	value   V
+	deleted bool
+}
+
+func newTable[K comparable, V any]() *table[K, V] {
+	return &table[K, V]{rows: make(map[K]entry[V])}
+}
+
+func (t *table[K, V]) get(k K) (entry[V], bool) {
+	if t == nil {
+		return entry[V]{}, false
+	}
+	e, ok := t.rows[k]
+	return e, ok
+}
+
+func (t *table[K, V]) put(k K, v V) {
+	t.set(k, entry[V]{value: v})
+}
+
+func (t *table[K, V]) del(k K) {
+	t.set(k, entry[V]{deleted: true})
+}
+
+func (t *table[K, V]) set(k K, e entry[V]) {
+	if _, ok := t.rows[k]; !ok {
+		t.keys = append(t.keys, k)
+	}
+	t.rows[k] = e
+}
+
+func (t *table[K, V]) clone() *table[K, V] {
+	c := &table[K, V]{
+		rows: make(map[K]entry[V], len(t.rows)),
+		keys: make([]K, len(t.keys)),
+	}
+	for k, e := range t.rows {
+		c.rows[k] = e
+	}
+	copy(c.keys, t.keys)
+	return c
+}
+
+// merge applies the staged rows over t.
+func (t *table[K, V]) merge(staged *table[K, V]) {
+	removed := false
+	for _, k := range staged.keys {
+		e := staged.rows[k]
+		if !e.deleted {
+			t.put(k, e.value)
+			continue
+		}
+		if _, ok := t.rows[k]; ok {
+			delete(t.rows, k)
+			removed = true
+		}
+	}
+
+	if !removed {
+		return
+	}
+	keys := t.keys[:0]
+	for _, k := range t.keys {
+		if _, ok := t.rows[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	t.keys = keys
+}
+
+// lookup returns the row with key k, as seen by a transaction that staged
+// the rows in staged.
+func lookup[K comparable, V any](committed, staged *table[K, V], k K) (V, bool) {
+	if e, ok := staged.get(k); ok {
+		return e.value, !e.deleted
+	}
+	e, ok := committed.get(k)
+	return e.value, ok
+}
+
+// scan returns all rows in insertion order, as seen by a transaction that
+// staged the rows in staged.
+func scan[K comparable, V any](committed, staged *table[K, V]) []V {
+	var vs []V
+	for _, k := range committed.keys {
+		if v, ok := lookup(committed, staged, k); ok {
+			vs = append(vs, v)
+		}
+	}
+	if staged == nil {
+		return vs
+	}
+	for _, k := range staged.keys {
+		if _, ok := committed.rows[k]; ok {
+			continue
+		}
+		if e := staged.rows[k]; !e.deleted {
+			vs = append(vs, e.value)
+		}
+	}
+	return vs
+}
+
+// =============================================================================
+
+// lockKey identifies a row of a table.
+type lockKey struct {
+	table string
+	key   any
+}
+
+type rowLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// lockManager provides row level locks.
+type lockManager struct {
+	mu    sync.Mutex
+	locks map[lockKey]*rowLock
+}
+
+// acquire blocks until the row lock is acquired or the ctx is done.
+func (m *lockManager) acquire(ctx context.Context, k lockKey) error {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[lockKey]*rowLock)
+	}
+	l, ok := m.locks[k]
+	if !ok {
+		l = &rowLock{ch: make(chan struct{}, 1)}
+		m.locks[k] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	select {
+	case l.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		m.unref(k, l)
+		return ctx.Err()
+	}
+}
+
+func (m *lockManager) release(k lockKey) {
+	m.mu.Lock()
+	l := m.locks[k]
+	m.mu.Unlock()
+
+	<-l.ch
+	m.unref(k, l)
+}
+
+func (m *lockManager) unref(k lockKey, l *rowLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, k)
+	}
+}
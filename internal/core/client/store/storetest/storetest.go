@@ -0,0 +1,78 @@
+// Package storetest contains supporting code for running tests against each
+// implementation of client.Store.
+package storetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rschio/rinha/internal/core/client"
+	"github.com/rschio/rinha/internal/core/client/store/clientdb"
+	"github.com/rschio/rinha/internal/core/client/store/eventstore"
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
+	"github.com/rschio/rinha/internal/data/dbtest"
+)
+
+// NewStore creates a store with the clients.
+type NewStore func(t *testing.T, clients ...client.Client) client.Store
+
+// Stores are the implementations of client.Store. The event store keeps its
+// log in memory and clientdb runs against a database inside a Docker
+// container.
+var Stores = []struct {
+	Name string
+	New  NewStore
+}{
+	{"memstore", newMemStore},
+	{"eventstore", newEventStore},
+	{"clientdb", newDBStore},
+}
+
+// Run runs fn as a subtest for each of the Stores.
+func Run(t *testing.T, fn func(t *testing.T, newStore NewStore)) {
+	for _, s := range Stores {
+		t.Run(s.Name, func(t *testing.T) {
+			fn(t, s.New)
+		})
+	}
+}
+
+func newMemStore(t *testing.T, clients ...client.Client) client.Store {
+	return memstore.NewStore(clients...)
+}
+
+func newEventStore(t *testing.T, clients ...client.Client) client.Store {
+	s, err := eventstore.NewStore(context.Background(), eventstore.NewMemLog(), clients...)
+	if err != nil {
+		t.Fatalf("creating event store: %v", err)
+	}
+	return s
+}
+
+func newDBStore(t *testing.T, clients ...client.Client) client.Store {
+	ctx := context.Background()
+	log, database, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
+	t.Cleanup(teardown)
+
+	// The clients inserted by the migrations are replaced by the test ones,
+	// there are no other rows yet.
+	if _, err := database.Exec(ctx, "DELETE FROM clients"); err != nil {
+		t.Fatalf("deleting clients: %v", err)
+	}
+
+	s := clientdb.NewStore(log, database)
+	for _, c := range clients {
+		// Like memstore.NewStore.
+		if c.Currency == "" {
+			c.Currency = client.DefaultCurrency
+		}
+		if c.ClosingDay == 0 {
+			c.ClosingDay = client.DefaultClosingDay
+		}
+		if err := s.CreateClient(ctx, c); err != nil {
+			t.Fatalf("creating client[%d]: %v", c.ID, err)
+		}
+	}
+
+	return s
+}
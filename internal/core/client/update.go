@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rschio/rinha/internal/web"
+)
+
+// Set of types of the client updates.
+const (
+	UpdateTransaction = "transacao"
+	UpdateBalance     = "saldo"
+	UpdateLimit       = "limite"
+)
+
+// Listener listens to the client updates committed to the store, by this
+// and by the other instances that share it.
+type Listener interface {
+	// Listen calls fn with the client of each update committed until the
+	// ctx is done. fn must not block.
+	Listen(ctx context.Context, fn func(clientID int)) error
+}
+
+// WithListener sets the listener that notifies the subscribers of the
+// clients. Without a listener the subscribers only poll the store.
+func WithListener(l Listener) Option {
+	return func(c *Core) {
+		c.listener = l
+	}
+}
+
+// WithUpdatesPoll sets how often the subscribers query the store for
+// updates they were not notified about. The default is 15 seconds.
+func WithUpdatesPoll(d time.Duration) Option {
+	return func(c *Core) {
+		c.updatesPoll = d
+	}
+}
+
+// WithUpdatesRetention sets for how long the client updates are kept, the
+// subscribers can't resume from older updates. The default is 7 days.
+func WithUpdatesRetention(d time.Duration) Option {
+	return func(c *Core) {
+		c.updatesRetain = d
+	}
+}
+
+// ListenUpdates notifies the subscribers of the clients about the updates
+// reported by the listener until the ctx is done or the listener fails.
+func (c *Core) ListenUpdates(ctx context.Context) error {
+	if c.listener == nil {
+		return nil
+	}
+
+	return c.listener.Listen(ctx, c.updates.notify)
+}
+
+// Subscribe calls fn with the updates of the client with a Seq greater than
+// after, in order, as they are committed. A negative after starts from the
+// updates committed after the call. fn is also called without updates at
+// least every poll interval, so the caller can tell the subscriber it is
+// alive. Subscribe returns when the ctx is done or fn fails.
+func (c *Core) Subscribe(ctx context.Context, clientID int, after int64, fn func([]ClientUpdate) error) error {
+	const batch = 100
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.Subscribe")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return err
+	}
+
+	// Subscribe before reading the store, so no update committed after the
+	// read is missed.
+	wake, stop := c.updates.subscribe(clientID)
+	defer stop()
+
+	if after < 0 {
+		seq, err := c.store.QueryClientUpdateSeq(ctx, clientID)
+		if err != nil {
+			return fmt.Errorf("failed to query client update seq: %w", err)
+		}
+		after = seq
+	}
+
+	ticker := time.NewTicker(c.updatesPoll)
+	defer ticker.Stop()
+
+	for {
+		us, err := c.store.QueryClientUpdates(ctx, clientID, after, batch)
+		if err != nil {
+			return fmt.Errorf("failed to query client updates: %w", err)
+		}
+		if err := fn(us); err != nil {
+			return err
+		}
+		if len(us) > 0 {
+			after = us[len(us)-1].Seq
+		}
+		if len(us) == batch {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeClientUpdates deletes the client updates older than the retention and
+// returns how many were deleted.
+func (c *Core) PurgeClientUpdates(ctx context.Context) (int, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.PurgeClientUpdates")
+	defer span.End()
+
+	return c.store.DeleteClientUpdates(ctx, time.Now().UTC().Add(-c.updatesRetain))
+}
+
+// balancePayload is the JSON representation of the balance and the limit of
+// a client in the client updates.
+type balancePayload struct {
+	Balance   int `json:"saldo"`
+	Available int `json:"disponivel"`
+	Limit     int `json:"limite"`
+}
+
+// addUpdate records an update of the client with the payload encoded.
+func addUpdate(ctx context.Context, tx Store, typ string, clientID int, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode client update: %w", err)
+	}
+
+	u := ClientUpdate{
+		ClientID: clientID,
+		Type:     typ,
+		Data:     data,
+		Date:     time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := tx.AddClientUpdate(ctx, u); err != nil {
+		return fmt.Errorf("failed to add client update: %w", err)
+	}
+
+	return nil
+}
+
+// addBalanceUpdate records an update of the client's balance and limit.
+func addBalanceUpdate(ctx context.Context, tx Store, typ string, client Client) error {
+	p := balancePayload{
+		Balance:   client.Balance,
+		Available: client.Balance - client.Reserved,
+		Limit:     client.Limit,
+	}
+
+	return addUpdate(ctx, tx, typ, client.ID, p)
+}
+
+// =============================================================================
+
+// hub wakes the subscribers of the clients.
+type hub struct {
+	mu   sync.Mutex
+	subs map[int]map[chan struct{}]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[int]map[chan struct{}]struct{})}
+}
+
+// subscribe returns a channel that receives a value after the client is
+// notified and a function that stops the notifications. Notifications sent
+// while the subscriber is busy are coalesced.
+func (h *hub) subscribe(clientID int) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs[clientID] == nil {
+		h.subs[clientID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[clientID][ch] = struct{}{}
+
+	stop := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.subs[clientID], ch)
+		if len(h.subs[clientID]) == 0 {
+			delete(h.subs, clientID)
+		}
+	}
+
+	return ch, stop
+}
+
+// notify wakes the subscribers of the client without blocking.
+func (h *hub) notify(clientID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs[clientID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// Set of headers of the webhook notifications.
+const (
+	WebhookSignatureHeader = "X-Rinha-Signature"
+	WebhookEventHeader     = "X-Rinha-Event"
+	WebhookDeliveryHeader  = "X-Rinha-Delivery"
+)
+
+// webhookMaxBackoff is the maximum delay between the attempts of a delivery.
+const webhookMaxBackoff = time.Hour
+
+// webhookLease is for how long a redelivery attempted by RedeliverWebhook is
+// not due to DeliverWebhooks. The attempts are bounded to half of it, so the
+// result of the attempt is recorded before the worker can pick it.
+const webhookLease = time.Minute
+
+// WithWebhookClient sets the HTTP client used to deliver the webhook
+// notifications. The default is NewWebhookClient with a 10 seconds timeout.
+func WithWebhookClient(hc *http.Client) Option {
+	return func(c *Core) {
+		c.webhookClient = hc
+	}
+}
+
+// WithPrivateWebhooks sets if webhooks can be registered to loopback,
+// private and link-local hosts. The default is false, so the clients can't
+// reach the internal network through the notifications. The client of
+// NewWebhookClient refuses these hosts, allowing them requires another one.
+func WithPrivateWebhooks(allow bool) Option {
+	return func(c *Core) {
+		c.webhookPrivate = allow
+	}
+}
+
+// NewWebhookClient returns an HTTP client to deliver the webhook
+// notifications that only connects to public addresses. The addresses are
+// checked when they are dialed, after the host is resolved and on redirects.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	d := net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip, err := netip.ParseAddr(host); err != nil || !publicAddr(ip) {
+				return fmt.Errorf("webhook address %s is not public", address)
+			}
+			return nil
+		},
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = d.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: t}
+}
+
+// WithWebhookRetry sets how many times a webhook delivery is attempted
+// before it fails and the delay after the first failed attempt, doubled
+// after each of the following ones up to an hour. The default is 8 attempts
+// and 10 seconds.
+func WithWebhookRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(c *Core) {
+		c.webhookAttempts = maxAttempts
+		c.webhookBackoff = backoff
+	}
+}
+
+// WithWebhookRetention sets for how long the delivered and failed webhook
+// deliveries are kept. The default is 7 days.
+func WithWebhookRetention(d time.Duration) Option {
+	return func(c *Core) {
+		c.webhookRetain = d
+	}
+}
+
+// SignWebhook returns the signature of a webhook notification sent at the
+// unix timestamp: the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed
+// by the webhook's secret. The notifications carry it in the
+// X-Rinha-Signature header as "t=<timestamp>,v1=<signature>".
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RegisterWebhook registers an endpoint to be notified about the client's
+// posted and denied transactions. The returned webhook has the secret used
+// to sign the notifications.
+func (c *Core) RegisterWebhook(ctx context.Context, clientID int, nw NewWebhook) (Webhook, error) {
+	u, err := url.Parse(nw.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("invalid webhook url %q: %w", nw.URL, ErrInvalidArgument)
+	}
+	if !c.webhookPrivate && !publicHost(u.Hostname()) {
+		return Webhook{}, fmt.Errorf("webhook url %q is not public: %w", nw.URL, ErrInvalidArgument)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Webhook{}, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	w := Webhook{
+		ID:       uuid.New(),
+		ClientID: clientID,
+		URL:      u.String(),
+		Secret:   hex.EncodeToString(secret),
+		Date:     time.Now().UTC().Round(time.Microsecond),
+	}
+
+	fn := func(tx Store) error {
+		ctx, span := web.AddSpan(ctx, "internal.core.client.Core.RegisterWebhook.Tx.Inside")
+		defer span.End()
+
+		if _, err := tx.QueryByID(ctx, clientID); err != nil {
+			return err
+		}
+
+		if err := tx.AddWebhook(ctx, w); err != nil {
+			return fmt.Errorf("failed to add webhook: %w", err)
+		}
+
+		return nil
+	}
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.RegisterWebhook.Tx")
+	defer span.End()
+
+	if err := c.store.ExecUnderTx(ctx, fn); err != nil {
+		return Webhook{}, err
+	}
+
+	return w, nil
+}
+
+// ListWebhooks returns the client's webhooks.
+func (c *Core) ListWebhooks(ctx context.Context, clientID int) ([]Webhook, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ListWebhooks")
+	defer span.End()
+
+	if _, err := c.store.QueryByID(ctx, clientID); err != nil {
+		return nil, err
+	}
+
+	return c.store.QueryWebhooks(ctx, clientID)
+}
+
+// ListWebhookDeliveries returns a page of the deliveries of a client's
+// webhook, the most recent first.
+func (c *Core) ListWebhookDeliveries(ctx context.Context, clientID int, webhookID uuid.UUID, pageNumber, rowsPerPage int) ([]WebhookDelivery, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.ListWebhookDeliveries")
+	defer span.End()
+
+	if _, err := c.store.QueryWebhookByID(ctx, clientID, webhookID); err != nil {
+		return nil, err
+	}
+
+	return c.store.QueryWebhookDeliveries(ctx, clientID, webhookID, pageNumber, rowsPerPage)
+}
+
+// RedeliverWebhook sends the event of a delivery of a client's webhook
+// again, as a new delivery with the same event ID, and returns the new
+// delivery. If the attempt fails the delivery is retried like the others.
+func (c *Core) RedeliverWebhook(ctx context.Context, clientID int, webhookID, deliveryID uuid.UUID) (WebhookDelivery, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.RedeliverWebhook")
+	defer span.End()
+
+	orig, err := c.store.QueryWebhookDeliveryByID(ctx, clientID, deliveryID)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if orig.WebhookID != webhookID {
+		return WebhookDelivery{}, ErrDeliveryNotFound
+	}
+
+	// The first attempt is made here, the delivery is only due to the
+	// worker after the attempt is recorded with its next attempt, or after
+	// the lease if it's never recorded.
+	now := time.Now().UTC().Round(time.Microsecond)
+	d := WebhookDelivery{
+		ID:          uuid.New(),
+		WebhookID:   orig.WebhookID,
+		ClientID:    orig.ClientID,
+		EventID:     orig.EventID,
+		Event:       orig.Event,
+		Payload:     orig.Payload,
+		Status:      DeliveryPending,
+		NextAttempt: now.Add(webhookLease),
+		Date:        now,
+		DateUpdated: now,
+	}
+
+	if err := c.store.AddWebhookDelivery(ctx, d); err != nil {
+		return WebhookDelivery{}, fmt.Errorf("failed to add webhook delivery: %w", err)
+	}
+
+	return c.deliver(ctx, d)
+}
+
+// DeliverWebhooks attempts the pending webhook deliveries that are due and
+// returns how many were delivered. A failed delivery is retried with
+// exponential backoff until the maximum attempts, then it is marked as
+// failed.
+func (c *Core) DeliverWebhooks(ctx context.Context) (int, error) {
+	const batch = 100
+
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.DeliverWebhooks")
+	defer span.End()
+
+	now := time.Now().UTC().Round(time.Microsecond)
+
+	var n int
+	for {
+		ds, err := c.store.QueryDueWebhookDeliveries(ctx, now, batch)
+		if err != nil {
+			return n, fmt.Errorf("failed to query due webhook deliveries: %w", err)
+		}
+
+		for _, d := range ds {
+			d, err := c.deliver(ctx, d)
+			if err != nil {
+				return n, err
+			}
+			if d.Status == DeliveryDelivered {
+				n++
+			}
+		}
+
+		if len(ds) < batch {
+			return n, nil
+		}
+	}
+}
+
+// PurgeWebhookDeliveries deletes the delivered and failed webhook deliveries
+// older than the retention and returns how many were deleted.
+func (c *Core) PurgeWebhookDeliveries(ctx context.Context) (int, error) {
+	ctx, span := web.AddSpan(ctx, "internal.core.client.Core.PurgeWebhookDeliveries")
+	defer span.End()
+
+	return c.store.DeleteWebhookDeliveries(ctx, time.Now().UTC().Add(-c.webhookRetain))
+}
+
+// deliver makes an attempt of the delivery and records its result.
+func (c *Core) deliver(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	w, err := c.store.QueryWebhookByID(ctx, d.ClientID, d.WebhookID)
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("failed to query webhook[%s]: %w", d.WebhookID, err)
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, webhookLease/2)
+	status, err := c.send(sctx, w, d)
+	cancel()
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	d.Attempts++
+	d.ResponseStatus = status
+	d.DateUpdated = now
+	switch {
+	case err == nil:
+		d.Status = DeliveryDelivered
+		d.LastError = ""
+	case d.Attempts >= c.webhookAttempts:
+		d.Status = DeliveryFailed
+		d.LastError = err.Error()
+	default:
+		d.LastError = err.Error()
+		d.NextAttempt = now.Add(backoff(d.Attempts, c.webhookBackoff, webhookMaxBackoff))
+	}
+
+	if err := c.store.UpdateWebhookDelivery(ctx, d); err != nil {
+		return WebhookDelivery{}, fmt.Errorf("failed to update webhook delivery[%s]: %w", d.ID, err)
+	}
+
+	return d, nil
+}
+
+// publicHost reports whether the host of a webhook url can be public. Names
+// other than localhost are only checked when they are dialed.
+func publicHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return true
+	}
+
+	return publicAddr(ip)
+}
+
+// publicAddr reports whether ip is a public unicast address. Loopback,
+// private, link-local, multicast and unspecified addresses are not public.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// send posts the payload of the delivery to the webhook and returns the
+// response status. Responses other than 2xx are errors.
+func (c *Core) send(ctx context.Context, w Webhook, d WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, d.Event)
+	req.Header.Set(WebhookDeliveryHeader, d.ID.String())
+	req.Header.Set(WebhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", ts, SignWebhook(w.Secret, ts, d.Payload)))
+
+	resp, err := c.webhookClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("posting delivery: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("posting delivery: status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// =============================================================================
+
+// webhookPayload is the body of the webhook notifications. The ID identifies
+// the event and is kept by the redeliveries.
+type webhookPayload struct {
+	ID          uuid.UUID          `json:"id"`
+	Event       string             `json:"evento"`
+	ClientID    int                `json:"cliente_id"`
+	Transaction webhookTransaction `json:"transacao"`
+	Rule        string             `json:"regra,omitempty"`
+	Reason      string             `json:"motivo,omitempty"`
+	Date        time.Time          `json:"criado_em"`
+}
+
+// webhookTransaction is the transaction of a notification. Denied
+// transactions have no ID and no balance.
+type webhookTransaction struct {
+	ID           *uuid.UUID `json:"id,omitempty"`
+	Value        int        `json:"valor"`
+	Type         string     `json:"tipo"`
+	Description  string     `json:"descricao"`
+	Date         time.Time  `json:"realizada_em"`
+	BalanceAfter *int       `json:"saldo_apos,omitempty"`
+}
+
+// notifyPosted enqueues the notifications of the transaction t posted to the
+// client under tx.
+func (c *Core) notifyPosted(ctx context.Context, tx Store, client Client, t Transaction) error {
+	p := webhookPayload{
+		Event:    WebhookTransactionPosted,
+		ClientID: t.ClientID,
+		Transaction: webhookTransaction{
+			ID:           &t.ID,
+			Value:        t.Value,
+			Type:         t.Type,
+			Description:  t.Description,
+			Date:         t.Date,
+			BalanceAfter: &client.Balance,
+		},
+	}
+
+	return enqueueWebhooks(ctx, tx, p)
+}
+
+// notifyDenied enqueues the notifications of the transaction t if err is a
+// denial, in a transaction of its own as the one that denied t was rolled
+// back. The transaction is only opened if the client has webhooks. It
+// returns err, wrapped with the failure to enqueue if any.
+func (c *Core) notifyDenied(ctx context.Context, t Transaction, err error) error {
+	if !errors.Is(err, ErrTransactionDenied) {
+		return err
+	}
+
+	ws, qErr := c.store.QueryWebhooks(ctx, t.ClientID)
+	if qErr != nil {
+		return fmt.Errorf("%w: failed to query webhooks: %w", err, qErr)
+	}
+	if len(ws) == 0 {
+		return err
+	}
+
+	fn := func(tx Store) error {
+		return enqueueDenied(ctx, tx, t, err)
+	}
+
+	if txErr := c.store.ExecUnderTx(ctx, fn); txErr != nil {
+		return fmt.Errorf("%w: failed to notify webhooks: %w", err, txErr)
+	}
+
+	return err
+}
+
+// enqueueDenied enqueues the notifications of the transaction t denied by
+// err under tx.
+func enqueueDenied(ctx context.Context, tx Store, t Transaction, err error) error {
+	if t.Date.IsZero() {
+		t.Date = time.Now().UTC().Round(time.Microsecond)
+	}
+
+	p := webhookPayload{
+		Event:    WebhookTransactionDenied,
+		ClientID: t.ClientID,
+		Transaction: webhookTransaction{
+			Value:       t.Value,
+			Type:        t.Type,
+			Description: t.Description,
+			Date:        t.Date,
+		},
+	}
+	var re *RuleError
+	if errors.As(err, &re) {
+		p.Rule = re.Rule
+		p.Reason = re.Reason
+	}
+
+	return enqueueWebhooks(ctx, tx, p)
+}
+
+// enqueueWebhooks adds a pending delivery of the event to each of the
+// client's webhooks.
+func enqueueWebhooks(ctx context.Context, tx Store, p webhookPayload) error {
+	ws, err := tx.QueryWebhooks(ctx, p.ClientID)
+	if err != nil {
+		return fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	if len(ws) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC().Round(time.Microsecond)
+	p.ID = uuid.New()
+	p.Date = now
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	for _, w := range ws {
+		d := WebhookDelivery{
+			ID:          uuid.New(),
+			WebhookID:   w.ID,
+			ClientID:    w.ClientID,
+			EventID:     p.ID,
+			Event:       p.Event,
+			Payload:     data,
+			Status:      DeliveryPending,
+			NextAttempt: now,
+			Date:        now,
+			DateUpdated: now,
+		}
+		if err := tx.AddWebhookDelivery(ctx, d); err != nil {
+			return fmt.Errorf("failed to add webhook delivery: %w", err)
+		}
+	}
+
+	return nil
+}
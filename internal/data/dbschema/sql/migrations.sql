@@ -30,3 +30,339 @@ INSERT INTO clients (id, credit_limit, balance, date_created, date_updated) VALU
 (4, 10000000, 0, NOW(), NOW()),
 (5, 500000, 0, NOW(), NOW())
 ON CONFLICT DO NOTHING;
+
+-- Version: 1.3
+-- Description: Create table limit_changes
+CREATE TABLE IF NOT EXISTS limit_changes(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	old_limit BIGINT NOT NULL,
+	new_limit BIGINT NOT NULL,
+	reason VARCHAR(100) NOT NULL,
+	trace_id TEXT NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX limit_changes_client_date_idx ON limit_changes(client_id, date_created);
+
+-- Version: 1.4
+-- Description: Add reversal references to transactions
+ALTER TABLE transactions
+	ADD COLUMN IF NOT EXISTS reversal_of TEXT NULL REFERENCES transactions(id),
+	ADD COLUMN IF NOT EXISTS reversed_by TEXT NULL REFERENCES transactions(id);
+
+CREATE UNIQUE INDEX transactions_reversal_of_idx ON transactions(reversal_of);
+
+-- Version: 1.5
+-- Description: Create table idempotency_keys
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+	client_id INT REFERENCES clients(id),
+	key VARCHAR(255) NOT NULL,
+	request_hash TEXT NOT NULL,
+	response BYTEA NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (client_id, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys(expires_at);
+
+-- Version: 1.6
+-- Description: Add transfer reference to transactions
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS transfer_id TEXT NULL;
+
+CREATE INDEX transactions_transfer_id_idx ON transactions(transfer_id);
+
+-- Version: 1.7
+-- Description: Add index to paginate transactions by cursor
+CREATE INDEX transactions_client_date_id_idx ON transactions(client_id, date_created DESC, id COLLATE "C" DESC);
+
+-- Version: 1.8
+-- Description: Add indexes to filter transactions by type and value
+CREATE INDEX transactions_client_type_date_idx ON transactions(client_id, type, date_created DESC);
+CREATE INDEX transactions_client_value_idx ON transactions(client_id, ABS(value));
+
+-- Version: 1.9
+-- Description: Create table holds
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS reserved BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS holds(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	value BIGINT NOT NULL,
+	description VARCHAR(10) NOT NULL,
+	status VARCHAR(10) NOT NULL,
+	captured_value BIGINT NOT NULL,
+	transaction_id TEXT NULL REFERENCES transactions(id),
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX holds_client_idx ON holds(client_id);
+CREATE INDEX holds_active_expires_at_idx ON holds(expires_at) WHERE status = 'active';
+
+-- Version: 2.0
+-- Description: Create table scheduled_transactions
+CREATE TABLE IF NOT EXISTS scheduled_transactions(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	value BIGINT NOT NULL,
+	type VARCHAR(1) NOT NULL,
+	description VARCHAR(10) NOT NULL,
+	scheduled_for TIMESTAMP NOT NULL,
+	status VARCHAR(10) NOT NULL,
+	transaction_id TEXT NULL REFERENCES transactions(id),
+	reason TEXT NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL
+);
+
+CREATE INDEX scheduled_transactions_client_idx ON scheduled_transactions(client_id, scheduled_for DESC);
+CREATE INDEX scheduled_transactions_pending_idx ON scheduled_transactions(scheduled_for) WHERE status = 'pending';
+
+-- Version: 2.1
+-- Description: Add currencies and create table fx_rates
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'BRL';
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_value BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fx_rate BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS fx_rates(
+	id TEXT PRIMARY KEY,
+	currency_from VARCHAR(3) NOT NULL,
+	currency_to VARCHAR(3) NOT NULL,
+	rate BIGINT NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX fx_rates_pair_date_idx ON fx_rates(currency_from, currency_to, date_created DESC);
+
+-- Version: 2.2
+-- Description: Create table interest_accruals
+CREATE TABLE IF NOT EXISTS interest_accruals(
+	client_id INT REFERENCES clients(id),
+	day DATE NOT NULL,
+	balance BIGINT NOT NULL,
+	rate BIGINT NOT NULL,
+	value BIGINT NOT NULL,
+	transaction_id TEXT NOT NULL REFERENCES transactions(id),
+	override BOOLEAN NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	PRIMARY KEY (client_id, day)
+);
+
+CREATE INDEX clients_overdrawn_idx ON clients(id) WHERE balance < 0;
+
+-- Version: 2.3
+-- Description: Add closing day and create table invoices
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS closing_day INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS invoices(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	period_start TIMESTAMP NOT NULL,
+	period_end TIMESTAMP NOT NULL,
+	opening_balance BIGINT NOT NULL,
+	closing_balance BIGINT NOT NULL,
+	due_date DATE NOT NULL,
+	transactions JSONB NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	UNIQUE (client_id, period_end)
+);
+
+-- Version: 2.4
+-- Description: Create table spending_limits
+CREATE TABLE IF NOT EXISTS spending_limits(
+	client_id INT PRIMARY KEY REFERENCES clients(id),
+	max_debits_per_minute INT NOT NULL,
+	max_daily_debit BIGINT NOT NULL,
+	max_transaction_value BIGINT NOT NULL,
+	date_updated TIMESTAMP NOT NULL
+);
+
+-- Version: 2.5
+-- Description: Create table transaction_flags
+CREATE TABLE IF NOT EXISTS transaction_flags(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	transaction_id TEXT NOT NULL REFERENCES transactions(id),
+	rule TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX transaction_flags_client_date_idx ON transaction_flags(client_id, date_created DESC);
+
+-- Version: 2.6
+-- Description: Create table balance_adjustments
+CREATE TABLE IF NOT EXISTS balance_adjustments(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	old_balance BIGINT NOT NULL,
+	new_balance BIGINT NOT NULL,
+	reason TEXT NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX balance_adjustments_client_date_idx ON balance_adjustments(client_id, date_created);
+
+-- Version: 2.7
+-- Description: Add the balance after each transaction and backfill it
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS balance_after BIGINT NOT NULL DEFAULT 0;
+
+UPDATE transactions t SET
+	balance_after = b.balance_after
+FROM (
+	SELECT
+		id,
+		SUM(value) OVER (
+			PARTITION BY client_id
+			ORDER BY date_created, id COLLATE "C"
+			ROWS UNBOUNDED PRECEDING
+		) AS balance_after
+	FROM
+		transactions
+) b
+WHERE
+	t.id = b.id;
+
+-- Version: 2.8
+-- Description: Create tables client_events and client_snapshots
+CREATE TABLE IF NOT EXISTS client_events(
+	seq BIGSERIAL PRIMARY KEY,
+	client_id INT NOT NULL,
+	type TEXT NOT NULL,
+	data JSONB NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX client_events_client_seq_idx ON client_events(client_id, seq);
+
+CREATE TABLE IF NOT EXISTS client_snapshots(
+	seq BIGINT PRIMARY KEY,
+	data JSONB NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+-- Version: 2.9
+-- Description: Create table outbox
+CREATE TABLE IF NOT EXISTS outbox(
+	seq BIGSERIAL PRIMARY KEY,
+	id TEXT NOT NULL UNIQUE,
+	type TEXT NOT NULL,
+	client_id INT REFERENCES clients(id),
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL,
+	attempts INT NOT NULL,
+	last_error TEXT NOT NULL,
+	next_attempt TIMESTAMP NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL
+);
+
+CREATE INDEX outbox_pending_idx ON outbox(seq) WHERE status = 'pending';
+
+-- Version: 3.0
+-- Description: Create tables webhooks and webhook_deliveries
+CREATE TABLE IF NOT EXISTS webhooks(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhooks_client_idx ON webhooks(client_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries(
+	id TEXT PRIMARY KEY,
+	webhook_id TEXT NOT NULL REFERENCES webhooks(id),
+	client_id INT REFERENCES clients(id),
+	event_id TEXT NOT NULL,
+	event TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL,
+	attempts INT NOT NULL,
+	response_status INT NOT NULL,
+	last_error TEXT NOT NULL,
+	next_attempt TIMESTAMP NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_webhook_date_idx ON webhook_deliveries(webhook_id, date_created);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries(next_attempt) WHERE status = 'pending';
+
+-- Version: 3.1
+-- Description: Create tables client_updates and client_update_seqs
+CREATE TABLE IF NOT EXISTS client_update_seqs(
+	client_id INT PRIMARY KEY REFERENCES clients(id),
+	seq BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS client_updates(
+	client_id INT REFERENCES clients(id),
+	seq BIGINT NOT NULL,
+	type TEXT NOT NULL,
+	data JSONB NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	PRIMARY KEY (client_id, seq)
+);
+
+-- Version: 3.2
+-- Description: Create tables ledger, ledger_seq and ledger_unsequenced
+CREATE TABLE IF NOT EXISTS ledger_seq(
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	seq BIGINT NOT NULL
+);
+
+INSERT INTO ledger_seq(id, seq) VALUES (TRUE, 0) ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS ledger(
+	seq BIGINT PRIMARY KEY,
+	transaction_id TEXT NOT NULL UNIQUE REFERENCES transactions(id)
+);
+
+CREATE TABLE IF NOT EXISTS ledger_unsequenced(
+	pos BIGSERIAL PRIMARY KEY,
+	transaction_id TEXT NOT NULL UNIQUE REFERENCES transactions(id)
+);
+
+INSERT INTO ledger_unsequenced(transaction_id)
+SELECT id FROM transactions ORDER BY date_created, id;
+
+-- Version: 3.3
+-- Description: Add status to clients and create table status_changes
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+
+CREATE TABLE IF NOT EXISTS status_changes(
+	id TEXT PRIMARY KEY,
+	client_id INT REFERENCES clients(id),
+	old_status TEXT NOT NULL,
+	new_status TEXT NOT NULL,
+	reason VARCHAR(100) NOT NULL,
+	trace_id TEXT NOT NULL,
+	date_created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX status_changes_client_date_idx ON status_changes(client_id, date_created);
+
+-- Version: 3.4
+-- Description: Add the profile to clients
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS document TEXT NOT NULL DEFAULT '';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX clients_document_idx ON clients(document) WHERE document <> '';
+
+-- Version: 3.5
+-- Description: Add the indexes of the outbox, webhook_deliveries and client_updates purges
+CREATE INDEX outbox_purge_idx ON outbox(date_updated) WHERE status <> 'pending';
+CREATE INDEX webhook_deliveries_purge_idx ON webhook_deliveries(date_updated) WHERE status <> 'pending';
+CREATE INDEX client_updates_date_idx ON client_updates(date_created);
+
+-- Version: 3.6
+-- Description: Add currency to scheduled_transactions
+ALTER TABLE scheduled_transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT '';
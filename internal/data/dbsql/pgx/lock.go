@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AdvisoryLock is a PostgreSQL session advisory lock. The lock is held by a
+// connection taken from the pool, only one connection among all instances
+// using the database holds it at a time.
+type AdvisoryLock struct {
+	pool *pgxpool.Pool
+	key  int64
+
+	mu   sync.Mutex
+	conn *pgxpool.Conn
+}
+
+// NewAdvisoryLock creates a lock identified by the name.
+func NewAdvisoryLock(pool *pgxpool.Pool, name string) *AdvisoryLock {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+
+	return &AdvisoryLock{
+		pool: pool,
+		key:  int64(h.Sum64()),
+	}
+}
+
+// IsLeader tries to acquire the lock and reports whether it is held. Once
+// acquired the lock is kept until Release is called or its connection is
+// lost.
+func (l *AdvisoryLock) IsLeader(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		if err := l.conn.Ping(ctx); err == nil {
+			return true, nil
+		}
+
+		// The lock was released with the connection.
+		l.conn.Conn().Close(ctx)
+		l.conn.Release()
+		l.conn = nil
+	}
+
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("acquiring connection: %w", err)
+	}
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&locked); err != nil {
+		conn.Release()
+		return false, fmt.Errorf("trying advisory lock: %w", err)
+	}
+	if !locked {
+		conn.Release()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+// Check returns an error if the lock is not held, like after its connection
+// is lost. Unlike IsLeader it doesn't try to acquire the lock again.
+func (l *AdvisoryLock) Check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return errors.New("advisory lock not held")
+	}
+	if err := l.conn.Ping(ctx); err != nil {
+		return fmt.Errorf("advisory lock connection lost: %w", err)
+	}
+
+	return nil
+}
+
+// Release releases the lock if it is held.
+func (l *AdvisoryLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	defer func() {
+		l.conn.Release()
+		l.conn = nil
+	}()
+
+	if _, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		// Closing the connection releases the lock.
+		l.conn.Conn().Close(ctx)
+		return fmt.Errorf("releasing advisory lock: %w", err)
+	}
+
+	return nil
+}
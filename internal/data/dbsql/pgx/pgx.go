@@ -127,12 +127,12 @@ func namedExec(ctx context.Context, log *slog.Logger, db DB, query string, data
 	ctx, span := web.AddSpan(ctx, "internal.data.dbsql.pgx.namedExec")
 	defer span.End()
 
-	args, err := toNamedArgs(data)
+	args, masked, err := toNamedArgs(data)
 	if err != nil {
 		return fmt.Errorf("failed to parse arguments: %w", err)
 	}
 
-	q := queryString(query, args)
+	q := queryString(query, args, masked)
 	logger.InfocCtx(ctx, log, 4, "db.namedExec", "query", q)
 	span.SetAttributes(attribute.String("query", q))
 
@@ -160,12 +160,12 @@ func NamedQuerySlice[T any](ctx context.Context, log *slog.Logger, db DB, query
 	ctx, span := web.AddSpan(ctx, "internal.data.dbsql.pgx.NamedQuerySlice")
 	defer span.End()
 
-	args, err := toNamedArgs(data)
+	args, masked, err := toNamedArgs(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse arguments: %w", err)
 	}
 
-	q := queryString(query, args)
+	q := queryString(query, args, masked)
 	logger.InfocCtx(ctx, log, 3, "db.NamedQuerySlice", "query", q)
 	span.SetAttributes(attribute.String("query", q))
 
@@ -182,17 +182,58 @@ func NamedQuerySlice[T any](ctx context.Context, log *slog.Logger, db DB, query
 	return pgx.CollectRows(rows, pgx.RowToStructByName[T])
 }
 
+// NamedQueryEach is a helper function for executing queries that return a
+// collection of data where field replacement is necessary. Each row is
+// unmarshalled and passed to fn as it is read, so big results don't need to
+// fit in memory. If fn returns an error the iteration stops and the error is
+// returned.
+func NamedQueryEach[T any](ctx context.Context, log *slog.Logger, db DB, query string, data any, fn func(T) error) error {
+	ctx, span := web.AddSpan(ctx, "internal.data.dbsql.pgx.NamedQueryEach")
+	defer span.End()
+
+	args, masked, err := toNamedArgs(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse arguments: %w", err)
+	}
+
+	q := queryString(query, args, masked)
+	logger.InfocCtx(ctx, log, 3, "db.NamedQueryEach", "query", q)
+	span.SetAttributes(attribute.String("query", q))
+
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		var pgerr *pgconn.PgError
+		if errors.As(err, &pgerr) && pgerr.Code == undefinedTable {
+			return ErrUndefinedTable
+		}
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v, err := pgx.RowToStructByName[T](rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 func NamedQueryStruct[T any](ctx context.Context, log *slog.Logger, db DB, query string, data any) (T, error) {
 	ctx, span := web.AddSpan(ctx, "internal.data.dbsql.pgx.NamedQueryStruct")
 	defer span.End()
 
-	args, err := toNamedArgs(data)
+	args, masked, err := toNamedArgs(data)
 	if err != nil {
 		var zero T
 		return zero, fmt.Errorf("failed to parse arguments: %w", err)
 	}
 
-	q := queryString(query, args)
+	q := queryString(query, args, masked)
 	logger.InfocCtx(ctx, log, 3, "db.NamedQueryStruct", "query", q)
 	span.SetAttributes(attribute.String("query", q))
 
@@ -207,11 +248,16 @@ func NamedQueryStruct[T any](ctx context.Context, log *slog.Logger, db DB, query
 	}
 	defer rows.Close()
 
+	// The errors of the statement are only returned when the rows are read.
 	out, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[T])
 	if err != nil {
 		var zero T
-		if errors.Is(err, pgx.ErrNoRows) {
+		var pgerr *pgconn.PgError
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
 			return zero, ErrDBNotFound
+		case errors.As(err, &pgerr) && pgerr.Code == uniqueViolation:
+			return zero, ErrDBDuplicatedEntry
 		}
 		return zero, err
 	}
@@ -219,22 +265,27 @@ func NamedQueryStruct[T any](ctx context.Context, log *slog.Logger, db DB, query
 	return out, nil
 }
 
-func toNamedArgs(value any) (pgx.NamedArgs, error) {
+// toNamedArgs returns the fields of the struct value as named arguments,
+// named by their db tag. It also returns the arguments of the fields tagged
+// with the mask option, like `db:"document,mask"`, which are masked in the
+// logs.
+func toNamedArgs(value any) (pgx.NamedArgs, map[string]bool, error) {
 	s := reflect.ValueOf(value)
 	if s.Kind() == reflect.Ptr {
 		s = s.Elem()
 	}
 	if s.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("invalid struct")
+		return nil, nil, fmt.Errorf("invalid struct")
 	}
 	typ := s.Type()
 
 	args := make(pgx.NamedArgs)
+	masked := make(map[string]bool)
 
 	for i := 0; i < s.NumField(); i++ {
 		f := s.Field(i)
 		structField := typ.Field(i)
-		fieldTag := structField.Tag.Get("db")
+		fieldTag, opts, _ := strings.Cut(structField.Tag.Get("db"), ",")
 
 		if !structField.IsExported() || fieldTag == "-" {
 			continue
@@ -244,14 +295,17 @@ func toNamedArgs(value any) (pgx.NamedArgs, error) {
 		}
 
 		args[fieldTag] = f.Interface()
+		if opts == "mask" {
+			masked[fieldTag] = true
+		}
 	}
 
-	return args, nil
+	return args, masked, nil
 }
 
 var reDBQueryArg = regexp.MustCompile(`@\w+`)
 
-func queryString(query string, args map[string]any) string {
+func queryString(query string, args map[string]any, masked map[string]bool) string {
 	query = reDBQueryArg.ReplaceAllStringFunc(query, func(s string) string {
 		// skip '@'.
 		key := s[1:]
@@ -259,6 +313,9 @@ func queryString(query string, args map[string]any) string {
 		if !ok {
 			return s
 		}
+		if masked[key] {
+			return fmt.Sprintf("'%s'", mask(fmt.Sprintf("%s", val)))
+		}
 		switch v := val.(type) {
 		case []byte, string:
 			return fmt.Sprintf("'%s'", v)
@@ -270,3 +327,13 @@ func queryString(query string, args map[string]any) string {
 	query = strings.ReplaceAll(query, "\n", " ")
 	return strings.TrimSpace(query)
 }
+
+// mask hides all but the last two characters of s, so the logs can still
+// tell the values apart.
+func mask(s string) string {
+	const visible = 2
+	if len(s) <= 2*visible {
+		return "***"
+	}
+	return "***" + s[len(s)-visible:]
+}
@@ -0,0 +1,36 @@
+package db
+
+import "testing"
+
+func TestQueryString(t *testing.T) {
+	data := struct {
+		ID       int    `db:"id"`
+		Name     string `db:"name"`
+		Document string `db:"document,mask"`
+	}{
+		ID:       6,
+		Name:     "Maria",
+		Document: "52998224725",
+	}
+
+	args, masked, err := toNamedArgs(data)
+	if err != nil {
+		t.Fatalf("parsing arguments: %v", err)
+	}
+
+	q := `
+	SELECT
+		*
+	FROM
+		clients
+	WHERE
+		id = @id AND
+		name = @name AND
+		document = @document`
+
+	got := queryString(q, args, masked)
+	want := "SELECT * FROM clients WHERE id = 6 AND name = 'Maria' AND document = '***25'"
+	if got != want {
+		t.Fatalf("got query %q want %q", got, want)
+	}
+}
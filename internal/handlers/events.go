@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rschio/rinha/internal/core/client"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// Events streams the client's updates as Server-Sent Events, with the Seq of
+// each update as the event id. A client that reconnects with the
+// Last-Event-ID header receives the updates it missed, the others only the
+// updates committed after they connect.
+func (s *Server) Events(w http.ResponseWriter, r *http.Request) {
+	ctx, span := web.AddSpan(r.Context(), "internal.handlers.Server.Events")
+	defer span.End()
+
+	id, err := getID(r)
+	if err != nil {
+		writeError(s, w, fmt.Errorf("invalid id: %w", client.ErrNotFound))
+		return
+	}
+
+	after := int64(-1)
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		after, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || after < 0 {
+			writeError(s, w, fmt.Errorf("invalid Last-Event-ID %q: %w", v, client.ErrInvalidArgument))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(s.streams, cancel)()
+
+	rc := http.NewResponseController(w)
+	started := false
+
+	// The headers are only written with the first call, so the errors that
+	// happen before it can still be reported with a status code.
+	err = s.client.Subscribe(ctx, id, after, func(us []client.ClientUpdate) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		var buf bytes.Buffer
+		for _, u := range us {
+			fmt.Fprintf(&buf, "id: %d\nevent: %s\ndata: %s\n\n", u.Seq, u.Type, u.Data)
+		}
+		if len(us) == 0 {
+			buf.WriteString(": keep-alive\n\n")
+		}
+
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err != nil {
+		if !started {
+			writeError(s, w, err)
+			return
+		}
+		if ctx.Err() == nil {
+			s.log.Error("events", "client", id, "ERROR", err)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rschio/rinha/internal/core/client"
+	"github.com/rschio/rinha/internal/web"
+)
+
+// Set of billing formats.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatOFX  = "ofx"
+)
+
+// billingFormat returns the billing format requested by the formato query
+// parameter or by the Accept header.
+func billingFormat(r *http.Request) (string, error) {
+	if v := r.URL.Query().Get("formato"); v != "" {
+		switch v {
+		case formatJSON, formatCSV, formatOFX:
+			return v, nil
+		}
+		return "", fmt.Errorf("invalid formato %q: %w", v, client.ErrInvalidArgument)
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return formatCSV, nil
+		case "application/x-ofx":
+			return formatOFX, nil
+		}
+	}
+
+	return formatJSON, nil
+}
+
+// billingEncoder writes a billing statement as its transactions are
+// streamed. Nothing is written before the first transaction, so errors
+// that happen before it can still be reported with a status code.
+type billingEncoder interface {
+	Encode(t client.Transaction) error
+	Close(b client.Billing) error
+	Written() bool
+}
+
+// ExportBilling streams all client's transactions that match the filter in
+// the format, followed by the closing balance.
+func (s *Server) ExportBilling(w http.ResponseWriter, r *http.Request, format string) {
+	ctx, span := web.AddSpan(r.Context(), "internal.handlers.Server.ExportBilling")
+	defer span.End()
+
+	id, err := getID(r)
+	if err != nil {
+		writeError(s, w, fmt.Errorf("invalid id: %w", client.ErrNotFound))
+		return
+	}
+
+	filter, err := getTransactionFilter(r)
+	if err != nil {
+		writeError(s, w, err)
+		return
+	}
+
+	c, err := s.client.QueryByID(ctx, id)
+	if err != nil {
+		writeError(s, w, err)
+		return
+	}
+
+	var enc billingEncoder
+	switch format {
+	case formatCSV:
+		enc = newCSVEncoder(w, id)
+	case formatOFX:
+		enc = newOFXEncoder(w, c, filter)
+	}
+
+	b, err := s.client.StreamBilling(ctx, id, filter, enc.Encode)
+	if err == nil {
+		err = enc.Close(b)
+	}
+	if err != nil {
+		if enc.Written() {
+			// The status code was already sent.
+			s.log.Error("exporting billing", "ERROR", err)
+			return
+		}
+		writeError(s, w, err)
+	}
+}
+
+// formatAmount formats an amount in cents.
+func formatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// formatRate formats a rate scaled by client.RateScale.
+func formatRate(rate int) string {
+	return fmt.Sprintf("%d.%06d", rate/client.RateScale, rate%client.RateScale)
+}
+
+// =============================================================================
+
+type csvEncoder struct {
+	w        http.ResponseWriter
+	csv      *csv.Writer
+	clientID int
+	written  bool
+}
+
+func newCSVEncoder(w http.ResponseWriter, clientID int) *csvEncoder {
+	return &csvEncoder{
+		w:        w,
+		csv:      csv.NewWriter(w),
+		clientID: clientID,
+	}
+}
+
+func (e *csvEncoder) begin() error {
+	if e.written {
+		return nil
+	}
+	e.written = true
+
+	e.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="extrato-%d.csv"`, e.clientID))
+	e.w.WriteHeader(http.StatusOK)
+
+	return e.csv.Write([]string{"id", "realizada_em", "tipo", "descricao", "valor", "moeda_original", "valor_original", "taxa_cambio"})
+}
+
+func (e *csvEncoder) Encode(t client.Transaction) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+
+	var original, rate string
+	if t.Currency != "" {
+		original = formatAmount(t.OriginalValue)
+		rate = formatRate(t.FXRate)
+	}
+
+	return e.csv.Write([]string{
+		t.ID.String(),
+		t.Date.Format(time.RFC3339Nano),
+		t.Type,
+		t.Description,
+		formatAmount(t.SignedValue()),
+		t.Currency,
+		original,
+		rate,
+	})
+}
+
+// Close writes the closing balance as the last row.
+func (e *csvEncoder) Close(b client.Billing) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+
+	err := e.csv.Write([]string{
+		"",
+		b.Date.Format(time.RFC3339Nano),
+		"saldo",
+		"saldo final",
+		formatAmount(b.Balance),
+		"",
+		"",
+		"",
+	})
+	if err != nil {
+		return err
+	}
+
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+func (e *csvEncoder) Written() bool {
+	return e.written
+}
+
+// =============================================================================
+
+// ofxEncoder writes the billing as an OFX 2.2 bank statement.
+type ofxEncoder struct {
+	w        http.ResponseWriter
+	clientID int
+	currency string
+	filter   client.TransactionFilter
+	written  bool
+}
+
+func newOFXEncoder(w http.ResponseWriter, c client.Client, filter client.TransactionFilter) *ofxEncoder {
+	return &ofxEncoder{
+		w:        w,
+		clientID: c.ID,
+		currency: c.Currency,
+		filter:   filter,
+	}
+}
+
+const ofxDate = "20060102150405.000"
+
+// begin writes the header. The statement starts at the filter start date or
+// at the first transaction and ends at the filter end date or now.
+func (e *ofxEncoder) begin(first time.Time) error {
+	if e.written {
+		return nil
+	}
+	e.written = true
+
+	now := time.Now().UTC()
+	start, end := first, now
+	if e.filter.StartDate != nil {
+		start = *e.filter.StartDate
+	}
+	if e.filter.EndDate != nil {
+		end = *e.filter.EndDate
+	}
+
+	e.w.Header().Set("Content-Type", "application/x-ofx")
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="extrato-%d.ofx"`, e.clientID))
+	e.w.WriteHeader(http.StatusOK)
+
+	_, err := fmt.Fprintf(e.w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1>
+<SONRS>
+<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<DTSERVER>%s</DTSERVER>
+<LANGUAGE>POR</LANGUAGE>
+</SONRS>
+</SIGNONMSGSRSV1>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>%d</TRNUID>
+<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS>
+<CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>rinha</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>%s</DTSTART>
+<DTEND>%s</DTEND>
+`, now.Format(ofxDate), now.UnixNano(), e.currency, e.clientID, start.UTC().Format(ofxDate), end.UTC().Format(ofxDate))
+
+	return err
+}
+
+func (e *ofxEncoder) Encode(t client.Transaction) error {
+	if err := e.begin(t.Date); err != nil {
+		return err
+	}
+
+	trnType := "CREDIT"
+	if t.Type == "d" {
+		trnType = "DEBIT"
+	}
+
+	// The amount is in the statement currency, the original currency
+	// and the rate used to convert it are informed along.
+	var orig string
+	if t.Currency != "" {
+		orig = fmt.Sprintf("<ORIGCURRENCY><CURRATE>%s</CURRATE><CURSYM>%s</CURSYM></ORIGCURRENCY>", formatRate(t.FXRate), t.Currency)
+	}
+
+	_, err := fmt.Fprintf(e.w, `<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%s</FITID><MEMO>%s</MEMO>%s</STMTTRN>
+`, trnType, t.Date.UTC().Format(ofxDate), formatAmount(t.SignedValue()), t.ID, html.EscapeString(t.Description), orig)
+
+	return err
+}
+
+// Close writes the closing balance and the available balance, which
+// includes the credit limit.
+func (e *ofxEncoder) Close(b client.Billing) error {
+	if err := e.begin(b.Date); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(e.w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+<AVAILBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></AVAILBAL>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`, formatAmount(b.Balance), b.Date.UTC().Format(ofxDate), formatAmount(b.Balance-b.Reserved+b.Limit), b.Date.UTC().Format(ofxDate))
+
+	return err
+}
+
+func (e *ofxEncoder) Written() bool {
+	return e.written
+}
@@ -2,18 +2,57 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 
 	"github.com/rschio/rinha/internal/core/client"
 	"github.com/rschio/rinha/internal/web"
 	"go.opentelemetry.io/otel/trace"
 )
 
-func APIMux(s *Server, tracer trace.Tracer) *http.ServeMux {
+// APIMux returns the routes of the API. The /admin routes require the
+// adminToken as a bearer token, they are not served without one.
+func APIMux(s *Server, tracer trace.Tracer, adminToken string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.Handle("POST /clientes/{id}/transacoes", middlewareWeb(tracer, s.Transactions))
 	mux.Handle("GET /clientes/{id}/extrato", middlewareWeb(tracer, s.Billing))
+	mux.Handle("GET /clientes/{id}/transacoes", middlewareWeb(tracer, s.Statement))
+	mux.Handle("POST /clientes", middlewareWeb(tracer, s.CreateClient))
+	mux.Handle("GET /clientes", middlewareWeb(tracer, s.ListClients))
+	mux.Handle("GET /clientes/{id}", middlewareWeb(tracer, s.QueryClient))
+	mux.Handle("GET /clientes/{id}/perfil", middlewareWeb(tracer, s.Profile))
+	mux.Handle("GET /clientes/{id}/saldo", middlewareWeb(tracer, s.BalanceAt))
+	mux.Handle("GET /clientes/{id}/eventos", middlewareWeb(tracer, s.Events))
+	mux.Handle("PATCH /clientes/{id}/limite", middlewareWeb(tracer, s.ChangeLimit))
+	mux.Handle("PATCH /clientes/{id}/status", middlewareWeb(tracer, s.ChangeStatus))
+	mux.Handle("GET /clientes/{id}/status", middlewareWeb(tracer, s.ListStatusChanges))
+	mux.Handle("GET /clientes/{id}/limites-gasto", middlewareWeb(tracer, s.QuerySpendingLimits))
+	mux.Handle("PUT /clientes/{id}/limites-gasto", middlewareWeb(tracer, s.SetSpendingLimits))
+	mux.Handle("POST /clientes/{id}/transacoes/{tid}/estorno", middlewareWeb(tracer, s.ReverseTransaction))
+	mux.Handle("POST /clientes/{id}/autorizacoes", middlewareWeb(tracer, s.Authorize))
+	mux.Handle("POST /clientes/{id}/autorizacoes/{hid}/captura", middlewareWeb(tracer, s.Capture))
+	mux.Handle("POST /clientes/{id}/autorizacoes/{hid}/cancelamento", middlewareWeb(tracer, s.Void))
+	mux.Handle("POST /clientes/{id}/agendamentos", middlewareWeb(tracer, s.Schedule))
+	mux.Handle("GET /clientes/{id}/agendamentos", middlewareWeb(tracer, s.ListScheduled))
+	mux.Handle("POST /clientes/{id}/agendamentos/{sid}/cancelamento", middlewareWeb(tracer, s.CancelScheduled))
+	mux.Handle("GET /clientes/{id}/sinalizacoes", middlewareWeb(tracer, s.ListTransactionFlags))
+	mux.Handle("GET /clientes/{id}/faturas", middlewareWeb(tracer, s.ListInvoices))
+	mux.Handle("GET /clientes/{id}/faturas/{fid}", middlewareWeb(tracer, s.QueryInvoice))
+	mux.Handle("POST /clientes/{id}/webhooks", middlewareWeb(tracer, s.RegisterWebhook))
+	mux.Handle("GET /clientes/{id}/webhooks", middlewareWeb(tracer, s.ListWebhooks))
+	mux.Handle("GET /clientes/{id}/webhooks/{wid}/entregas", middlewareWeb(tracer, s.ListWebhookDeliveries))
+	mux.Handle("POST /clientes/{id}/webhooks/{wid}/entregas/{did}/reenvio", middlewareWeb(tracer, s.RedeliverWebhook))
+	mux.Handle("POST /transferencias", middlewareWeb(tracer, s.Transfer))
+	mux.Handle("GET /feed", middlewareWeb(tracer, s.Feed))
+
+	if adminToken != "" {
+		mux.Handle("POST /admin/cambio", middlewareAdmin(adminToken, middlewareWeb(tracer, s.SetFXRate)))
+		mux.Handle("GET /admin/cambio", middlewareAdmin(adminToken, middlewareWeb(tracer, s.ListFXRates)))
+	}
 
 	return mux
 }
@@ -21,15 +60,34 @@ func APIMux(s *Server, tracer trace.Tracer) *http.ServeMux {
 type Server struct {
 	log    *slog.Logger
 	client *client.Core
+
+	// streams is canceled to end the event streams.
+	streams      context.Context
+	closeStreams context.CancelFunc
 }
 
 func NewServer(log *slog.Logger, c *client.Core) *Server {
-	return &Server{log: log, client: c}
+	streams, closeStreams := context.WithCancel(context.Background())
+	return &Server{
+		log:          log,
+		client:       c,
+		streams:      streams,
+		closeStreams: closeStreams,
+	}
+}
+
+// CloseStreams ends the open event streams, which would otherwise keep the
+// server from shutting down.
+func (s *Server) CloseStreams() {
+	s.closeStreams()
 }
 
+// Transactions adds a transaction to the client. Requests with an
+// Idempotency-Key header can be safely retried, they return the response of
+// the first request.
 func (s *Server) Transactions(w http.ResponseWriter, r *http.Request) {
 	serveJSON(s, w, r,
-		func(ctx context.Context, id int, req TransactionsReq) (TransactionsResp, error) {
+		func(ctx context.Context, id int, req TransactionsReq) (json.RawMessage, error) {
 			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Transactions")
 			defer span.End()
 
@@ -37,28 +95,481 @@ func (s *Server) Transactions(w http.ResponseWriter, r *http.Request) {
 				Value:       req.Value,
 				Type:        req.Type,
 				Description: req.Description,
+				Currency:    req.Currency,
+			}
+
+			render := func(c client.Client) ([]byte, error) {
+				return json.Marshal(toTransactionsResp(c))
+			}
+
+			if key := r.Header.Get("Idempotency-Key"); key != "" {
+				return s.client.AddTransactionIdempotent(ctx, id, key, nt, render)
 			}
 
 			c, err := s.client.AddTransaction(ctx, id, nt)
+			if err != nil {
+				return nil, err
+			}
+
+			return render(c)
+		},
+	)
+}
+
+func (s *Server) ReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	serveNoBody(s, w, r,
+		func(ctx context.Context, id int) (TransactionsResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ReverseTransaction")
+			defer span.End()
+
+			tid, err := getTransactionID(r)
+			if err != nil {
+				return TransactionsResp{}, fmt.Errorf("invalid transaction id: %w", client.ErrTransactionNotFound)
+			}
+
+			c, err := s.client.ReverseTransaction(ctx, id, tid)
+			if err != nil {
+				return TransactionsResp{}, err
+			}
+
+			return toTransactionsResp(c), nil
+		},
+	)
+}
+
+// Authorize reserves funds of the client to be captured or voided later.
+func (s *Server) Authorize(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusCreated,
+		func(ctx context.Context, r *http.Request, req HoldReq) (HoldResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Authorize")
+			defer span.End()
+
+			id, err := getID(r)
+			if err != nil {
+				return HoldResp{}, fmt.Errorf("invalid id: %w", client.ErrNotFound)
+			}
+
+			nh := client.NewHold{
+				Value:       req.Value,
+				Description: req.Description,
+			}
+
+			h, c, err := s.client.Authorize(ctx, id, nh)
+			if err != nil {
+				return HoldResp{}, err
+			}
+
+			return toHoldResp(h, c), nil
+		},
+	)
+}
+
+// Capture posts the funds reserved by a hold. The valor of the request is
+// optional, without it the whole hold is captured.
+func (s *Server) Capture(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, req CaptureReq) (TransactionsResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Capture")
+			defer span.End()
+
+			hid, err := getHoldID(r)
+			if err != nil {
+				return TransactionsResp{}, fmt.Errorf("invalid hold id: %w", client.ErrHoldNotFound)
+			}
+
+			c, err := s.client.Capture(ctx, id, hid, req.Value)
+			if err != nil {
+				return TransactionsResp{}, err
+			}
+
+			return toTransactionsResp(c), nil
+		},
+	)
+}
+
+// Void releases the funds reserved by a hold.
+func (s *Server) Void(w http.ResponseWriter, r *http.Request) {
+	serveNoBody(s, w, r,
+		func(ctx context.Context, id int) (TransactionsResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Void")
+			defer span.End()
+
+			hid, err := getHoldID(r)
+			if err != nil {
+				return TransactionsResp{}, fmt.Errorf("invalid hold id: %w", client.ErrHoldNotFound)
+			}
+
+			c, err := s.client.Void(ctx, id, hid)
 			if err != nil {
 				return TransactionsResp{}, err
 			}
 
-			return TransactionsResp{
-				Limit:   c.Limit,
-				Balance: c.Balance,
+			return toTransactionsResp(c), nil
+		},
+	)
+}
+
+// Schedule schedules a transaction to be posted at the agendada_para date.
+func (s *Server) Schedule(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusCreated,
+		func(ctx context.Context, r *http.Request, req ScheduledReq) (ScheduledResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Schedule")
+			defer span.End()
+
+			id, err := getID(r)
+			if err != nil {
+				return ScheduledResp{}, fmt.Errorf("invalid id: %w", client.ErrNotFound)
+			}
+
+			ns := client.NewScheduledTransaction{
+				Value:        req.Value,
+				Type:         req.Type,
+				Description:  req.Description,
+				Currency:     req.Currency,
+				ScheduledFor: req.ScheduledFor,
+			}
+
+			st, err := s.client.Schedule(ctx, id, ns)
+			if err != nil {
+				return ScheduledResp{}, err
+			}
+
+			return toScheduledResp(st), nil
+		},
+	)
+}
+
+func (s *Server) ListScheduled(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) ([]ScheduledResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListScheduled")
+			defer span.End()
+
+			page, rows, err := getPage(r)
+			if err != nil {
+				return nil, err
+			}
+
+			sts, err := s.client.ListScheduled(ctx, id, page, rows)
+			if err != nil {
+				return nil, err
+			}
+
+			return toScheduledResps(sts), nil
+		},
+	)
+}
+
+func (s *Server) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	serveNoBody(s, w, r,
+		func(ctx context.Context, id int) (ScheduledResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.CancelScheduled")
+			defer span.End()
+
+			sid, err := getScheduledID(r)
+			if err != nil {
+				return ScheduledResp{}, fmt.Errorf("invalid scheduled transaction id: %w", client.ErrScheduledNotFound)
+			}
+
+			st, err := s.client.CancelScheduled(ctx, id, sid)
+			if err != nil {
+				return ScheduledResp{}, err
+			}
+
+			return toScheduledResp(st), nil
+		},
+	)
+}
+
+// ListTransactionFlags returns a page of the client's transactions flagged
+// by the approval rules, most recent first.
+func (s *Server) ListTransactionFlags(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) ([]TransactionFlag, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListTransactionFlags")
+			defer span.End()
+
+			page, rows, err := getPage(r)
+			if err != nil {
+				return nil, err
+			}
+
+			fs, err := s.client.ListTransactionFlags(ctx, id, page, rows)
+			if err != nil {
+				return nil, err
+			}
+
+			return toTransactionFlags(fs), nil
+		},
+	)
+}
+
+// ListInvoices returns a page of the client's closed invoices, most recent
+// first, without their transactions.
+func (s *Server) ListInvoices(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) ([]InvoiceResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListInvoices")
+			defer span.End()
+
+			page, rows, err := getPage(r)
+			if err != nil {
+				return nil, err
+			}
+
+			invs, err := s.client.ListInvoices(ctx, id, page, rows)
+			if err != nil {
+				return nil, err
+			}
+
+			return toInvoiceResps(invs), nil
+		},
+	)
+}
+
+// QueryInvoice returns the client's invoice with its transactions.
+func (s *Server) QueryInvoice(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) (InvoiceResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.QueryInvoice")
+			defer span.End()
+
+			fid, err := getInvoiceID(r)
+			if err != nil {
+				return InvoiceResp{}, fmt.Errorf("invalid invoice id: %w", client.ErrInvoiceNotFound)
+			}
+
+			inv, err := s.client.QueryInvoice(ctx, id, fid)
+			if err != nil {
+				return InvoiceResp{}, err
+			}
+
+			return toInvoiceResp(inv), nil
+		},
+	)
+}
+
+// RegisterWebhook registers an endpoint to be notified about the client's
+// transactions. The response has the secret used to sign the notifications.
+func (s *Server) RegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusCreated,
+		func(ctx context.Context, r *http.Request, req WebhookReq) (WebhookResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.RegisterWebhook")
+			defer span.End()
+
+			id, err := getID(r)
+			if err != nil {
+				return WebhookResp{}, fmt.Errorf("invalid id: %w", client.ErrNotFound)
+			}
+
+			wh, err := s.client.RegisterWebhook(ctx, id, client.NewWebhook{URL: req.URL})
+			if err != nil {
+				return WebhookResp{}, err
+			}
+
+			return WebhookResp{
+				ID:     wh.ID,
+				URL:    wh.URL,
+				Secret: wh.Secret,
+				Date:   wh.Date,
 			}, nil
 		},
 	)
 }
 
+func (s *Server) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) ([]WebhookResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListWebhooks")
+			defer span.End()
+
+			ws, err := s.client.ListWebhooks(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+
+			return toWebhookResps(ws), nil
+		},
+	)
+}
+
+// ListWebhookDeliveries returns a page of the deliveries of the client's
+// webhook, most recent first.
+func (s *Server) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) ([]WebhookDeliveryResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListWebhookDeliveries")
+			defer span.End()
+
+			wid, err := getWebhookID(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid webhook id: %w", client.ErrWebhookNotFound)
+			}
+
+			page, rows, err := getPage(r)
+			if err != nil {
+				return nil, err
+			}
+
+			ds, err := s.client.ListWebhookDeliveries(ctx, id, wid, page, rows)
+			if err != nil {
+				return nil, err
+			}
+
+			return toWebhookDeliveryResps(ds), nil
+		},
+	)
+}
+
+// RedeliverWebhook sends the event of a delivery again and returns the new
+// delivery.
+func (s *Server) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	serveNoBody(s, w, r,
+		func(ctx context.Context, id int) (WebhookDeliveryResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.RedeliverWebhook")
+			defer span.End()
+
+			wid, err := getWebhookID(r)
+			if err != nil {
+				return WebhookDeliveryResp{}, fmt.Errorf("invalid webhook id: %w", client.ErrWebhookNotFound)
+			}
+			did, err := getDeliveryID(r)
+			if err != nil {
+				return WebhookDeliveryResp{}, fmt.Errorf("invalid delivery id: %w", client.ErrDeliveryNotFound)
+			}
+
+			d, err := s.client.RedeliverWebhook(ctx, id, wid, did)
+			if err != nil {
+				return WebhookDeliveryResp{}, err
+			}
+
+			return toWebhookDeliveryResp(d), nil
+		},
+	)
+}
+
+func (s *Server) Transfer(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusOK,
+		func(ctx context.Context, _ *http.Request, req TransferReq) (TransferResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Transfer")
+			defer span.End()
+
+			ntr := client.NewTransfer{
+				FromID:      req.FromID,
+				ToID:        req.ToID,
+				Value:       req.Value,
+				Description: req.Description,
+			}
+
+			tr, err := s.client.Transfer(ctx, ntr)
+			if err != nil {
+				return TransferResp{}, err
+			}
+
+			return TransferResp{
+				ID:   tr.ID,
+				From: toClientResp(tr.From),
+				To:   toClientResp(tr.To),
+				Date: tr.Date,
+			}, nil
+		},
+	)
+}
+
+// Statement returns a page of the client's transactions. The proximo_cursor
+// of the response is used as the cursor query parameter to get the next page.
+func (s *Server) Statement(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) (StatementResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Statement")
+			defer span.End()
+
+			q := r.URL.Query()
+			limit := 10
+			if v := q.Get("limit"); v != "" {
+				var err error
+				if limit, err = strconv.Atoi(v); err != nil {
+					return StatementResp{}, fmt.Errorf("invalid limit %q: %w", v, client.ErrInvalidArgument)
+				}
+			}
+
+			filter, err := getTransactionFilter(r)
+			if err != nil {
+				return StatementResp{}, err
+			}
+
+			st, err := s.client.Statement(ctx, id, filter, q.Get("cursor"), limit)
+			if err != nil {
+				return StatementResp{}, err
+			}
+
+			return StatementResp{
+				Transactions: toTransactions(st.Transactions),
+				NextCursor:   st.NextCursor,
+			}, nil
+		},
+	)
+}
+
+// Feed returns the transactions of all clients in the order they were added
+// to the ledger. The proximo of the response is used as the after query
+// parameter to get the next page, it can be stored and reused after restarts.
+func (s *Server) Feed(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusOK,
+		func(ctx context.Context, r *http.Request, _ struct{}) (FeedResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Feed")
+			defer span.End()
+
+			q := r.URL.Query()
+			after := int64(0)
+			if v := q.Get("after"); v != "" {
+				var err error
+				if after, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return FeedResp{}, fmt.Errorf("invalid after %q: %w", v, client.ErrInvalidArgument)
+				}
+			}
+			limit := 100
+			if v := q.Get("limit"); v != "" {
+				var err error
+				if limit, err = strconv.Atoi(v); err != nil {
+					return FeedResp{}, fmt.Errorf("invalid limit %q: %w", v, client.ErrInvalidArgument)
+				}
+			}
+
+			f, err := s.client.Feed(ctx, after, limit)
+			if err != nil {
+				return FeedResp{}, err
+			}
+
+			return toFeedResp(f), nil
+		},
+	)
+}
+
+// Billing returns the client's billing. CSV and OFX formats, requested by the
+// Accept header or by the formato query parameter, export all transactions.
 func (s *Server) Billing(w http.ResponseWriter, r *http.Request) {
+	format, err := billingFormat(r)
+	if err != nil {
+		writeError(s, w, err)
+		return
+	}
+	if format != formatJSON {
+		s.ExportBilling(w, r, format)
+		return
+	}
+
 	serveJSON(s, w, r,
 		func(ctx context.Context, id int, _ struct{}) (BillingResp, error) {
 			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Billing")
 			defer span.End()
 
-			b, err := s.client.Billing(ctx, id)
+			filter, err := getTransactionFilter(r)
+			if err != nil {
+				return BillingResp{}, err
+			}
+
+			b, err := s.client.Billing(ctx, id, filter)
 			if err != nil {
 				return BillingResp{}, err
 			}
@@ -67,3 +578,256 @@ func (s *Server) Billing(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 }
+
+func (s *Server) CreateClient(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusCreated,
+		func(ctx context.Context, _ *http.Request, req NewClientReq) (ClientResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.CreateClient")
+			defer span.End()
+
+			nc := client.NewClient{
+				ID:         req.ID,
+				Currency:   req.Currency,
+				Limit:      req.Limit,
+				ClosingDay: req.ClosingDay,
+				Name:       req.Name,
+				Document:   req.Document,
+				Email:      req.Email,
+			}
+
+			c, err := s.client.CreateClient(ctx, nc)
+			if err != nil {
+				return ClientResp{}, err
+			}
+
+			return toClientResp(c), nil
+		},
+	)
+}
+
+// ListClients returns a page of the clients, or the client with the CPF or
+// CNPJ of the documento query parameter.
+func (s *Server) ListClients(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusOK,
+		func(ctx context.Context, r *http.Request, _ struct{}) ([]ClientResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListClients")
+			defer span.End()
+
+			if doc := r.URL.Query().Get("documento"); doc != "" {
+				c, err := s.client.QueryByDocument(ctx, doc)
+				if err != nil {
+					if errors.Is(err, client.ErrNotFound) {
+						return []ClientResp{}, nil
+					}
+					return nil, err
+				}
+				return []ClientResp{toClientResp(c)}, nil
+			}
+
+			page, rows, err := getPage(r)
+			if err != nil {
+				return nil, err
+			}
+
+			cs, err := s.client.ListClients(ctx, page, rows)
+			if err != nil {
+				return nil, err
+			}
+
+			return toClientsResp(cs), nil
+		},
+	)
+}
+
+func (s *Server) QueryClient(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) (ClientResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.QueryClient")
+			defer span.End()
+
+			c, err := s.client.QueryByID(ctx, id)
+			if err != nil {
+				return ClientResp{}, err
+			}
+
+			return toClientResp(c), nil
+		},
+	)
+}
+
+// Profile returns the profile of the client's owner.
+func (s *Server) Profile(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) (ProfileResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.Profile")
+			defer span.End()
+
+			c, err := s.client.QueryByID(ctx, id)
+			if err != nil {
+				return ProfileResp{}, err
+			}
+
+			return toProfileResp(c), nil
+		},
+	)
+}
+
+// BalanceAt returns the client's balance at the date of the em query
+// parameter.
+func (s *Server) BalanceAt(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) (BalanceAtResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.BalanceAt")
+			defer span.End()
+
+			date, err := getBalanceDate(r)
+			if err != nil {
+				return BalanceAtResp{}, err
+			}
+
+			balance, err := s.client.BalanceAt(ctx, id, date)
+			if err != nil {
+				return BalanceAtResp{}, err
+			}
+
+			return BalanceAtResp{Balance: balance, Date: date}, nil
+		},
+	)
+}
+
+func (s *Server) ChangeLimit(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, req LimitReq) (ClientResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ChangeLimit")
+			defer span.End()
+
+			c, err := s.client.ChangeLimit(ctx, id, req.Limit, req.Reason)
+			if err != nil {
+				return ClientResp{}, err
+			}
+
+			return toClientResp(c), nil
+		},
+	)
+}
+
+// ChangeStatus changes the client's account status. Debits to frozen
+// accounts and all transactions to closed accounts are forbidden.
+func (s *Server) ChangeStatus(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, req StatusReq) (ClientResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ChangeStatus")
+			defer span.End()
+
+			c, err := s.client.ChangeStatus(ctx, id, req.Status, req.Reason)
+			if err != nil {
+				return ClientResp{}, err
+			}
+
+			return toClientResp(c), nil
+		},
+	)
+}
+
+// ListStatusChanges returns a page of the transitions of the client's
+// account status, the most recent first.
+func (s *Server) ListStatusChanges(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) ([]StatusChange, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListStatusChanges")
+			defer span.End()
+
+			page, rows, err := getPage(r)
+			if err != nil {
+				return nil, err
+			}
+
+			scs, err := s.client.ListStatusChanges(ctx, id, page, rows)
+			if err != nil {
+				return nil, err
+			}
+
+			return toStatusChanges(scs), nil
+		},
+	)
+}
+
+func (s *Server) QuerySpendingLimits(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, _ struct{}) (SpendingLimits, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.QuerySpendingLimits")
+			defer span.End()
+
+			l, err := s.client.QuerySpendingLimits(ctx, id)
+			if err != nil {
+				return SpendingLimits{}, err
+			}
+
+			return toSpendingLimits(l), nil
+		},
+	)
+}
+
+// SetSpendingLimits replaces the client's spending limits. Zero disables a
+// limit.
+func (s *Server) SetSpendingLimits(w http.ResponseWriter, r *http.Request) {
+	serveJSON(s, w, r,
+		func(ctx context.Context, id int, req SpendingLimits) (SpendingLimits, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.SetSpendingLimits")
+			defer span.End()
+
+			l := client.SpendingLimits{
+				MaxDebitsPerMinute:  req.MaxDebitsPerMinute,
+				MaxDailyDebit:       req.MaxDailyDebit,
+				MaxTransactionValue: req.MaxTransactionValue,
+			}
+
+			l, err := s.client.SetSpendingLimits(ctx, id, l)
+			if err != nil {
+				return SpendingLimits{}, err
+			}
+
+			return toSpendingLimits(l), nil
+		},
+	)
+}
+
+// SetFXRate records the rate used to convert the transactions from one
+// currency to another.
+func (s *Server) SetFXRate(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusCreated,
+		func(ctx context.Context, _ *http.Request, req FXRateReq) (FXRateResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.SetFXRate")
+			defer span.End()
+
+			nr := client.NewFXRate{
+				From: req.From,
+				To:   req.To,
+				Rate: fromRate(req.Rate),
+			}
+
+			rate, err := s.client.SetFXRate(ctx, nr)
+			if err != nil {
+				return FXRateResp{}, err
+			}
+
+			return toFXRateResp(rate), nil
+		},
+	)
+}
+
+func (s *Server) ListFXRates(w http.ResponseWriter, r *http.Request) {
+	serve(s, w, r, http.StatusOK,
+		func(ctx context.Context, _ *http.Request, _ struct{}) ([]FXRateResp, error) {
+			ctx, span := web.AddSpan(ctx, "internal.handlers.Server.ListFXRates")
+			defer span.End()
+
+			rs, err := s.client.ListFXRates(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return toFXRatesResp(rs), nil
+		},
+	)
+}
@@ -1,79 +1,459 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/rschio/rinha/internal/core/client"
-	"github.com/rschio/rinha/internal/core/client/store/clientdb"
-	"github.com/rschio/rinha/internal/data/dbtest"
+	"github.com/rschio/rinha/internal/core/client/store/memstore"
+	"github.com/rschio/rinha/internal/core/client/store/storetest"
 	"go.opentelemetry.io/otel"
 )
 
 func TestTransactions(t *testing.T) {
-	log, db, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
-	t.Cleanup(teardown)
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
 
-	server := NewServer(log, client.NewCore(clientdb.NewStore(log, db)))
-	httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer("")))
-	t.Cleanup(httpServer.Close)
+		id := 1
+		path := httpServer.URL + fmt.Sprintf("/clientes/%d/transacoes", id)
+		data := `{"valor":1000,"tipo":"c","descricao":"descricao"}`
+		contentType := "application/json"
 
-	id := 1
-	path := httpServer.URL + fmt.Sprintf("/clientes/%d/transacoes", id)
-	data := `{"valor":1000,"tipo":"c","descricao":"descricao"}`
-	contentType := "application/json"
+		resp, err := http.Post(path, contentType, strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
 
-	resp, err := http.Post(path, contentType, strings.NewReader(data))
-	if err != nil {
-		t.Fatalf("post: %v", err)
-	}
-	defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got wrong status code: %v", resp.StatusCode)
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("got wrong status code: %v", resp.StatusCode)
-	}
+		var tresp TransactionsResp
+		if err := json.NewDecoder(resp.Body).Decode(&tresp); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
 
-	var tresp TransactionsResp
-	if err := json.NewDecoder(resp.Body).Decode(&tresp); err != nil {
-		t.Fatalf("failed to unmarshal: %v", err)
-	}
-
-	if tresp.Limit == 0 {
-		t.Fatalf("limit should be != 0, got %v", tresp.Limit)
-	}
+		if tresp.Limit == 0 {
+			t.Fatalf("limit should be != 0, got %v", tresp.Limit)
+		}
+	})
 }
 
 func TestTransactionsID(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		tests := []struct {
+			name       string
+			id         string
+			wantedCode int
+		}{
+			{"invalid string", "not_number", 404},
+			{"invalid id", "-1", 404},
+			{"id not found", "6", 404},
+			{"good id", "1", 200},
+		}
+
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				t.Parallel()
+				path := httpServer.URL + fmt.Sprintf("/clientes/%s/transacoes", tt.id)
+				data := `{"valor":1000,"tipo":"c","descricao":"descricao"}`
+				contentType := "application/json"
+
+				resp, err := http.Post(path, contentType, strings.NewReader(data))
+				if err != nil {
+					t.Fatalf("post: %v", err)
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode != tt.wantedCode {
+					t.Fatalf("got wrong status code: %v, want: %v", resp.StatusCode, tt.wantedCode)
+				}
+			})
+		}
+	})
+}
+
+func TestTransactionsIdempotency(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		path := httpServer.URL + "/clientes/1/transacoes"
+		post := func(data string) (int, string) {
+			req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(data))
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", "abc")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			return resp.StatusCode, string(body)
+		}
+
+		data := `{"valor":1000,"tipo":"d","descricao":"descricao"}`
+		code, first := post(data)
+		if code != http.StatusOK {
+			t.Fatalf("got wrong status code: %v", code)
+		}
+
+		code, replay := post(data)
+		if code != http.StatusOK {
+			t.Fatalf("got wrong status code on replay: %v", code)
+		}
+		if replay != first {
+			t.Fatalf("replay got %q want %q", replay, first)
+		}
+		if want := `{"limite":100000,"saldo":-1000,"saldo_disponivel":-1000}`; replay != want {
+			t.Fatalf("got %q want %q", replay, want)
+		}
+
+		code, _ = post(`{"valor":2000,"tipo":"d","descricao":"descricao"}`)
+		if code != http.StatusConflict {
+			t.Fatalf("got wrong status code on conflict: %v", code)
+		}
+	})
+}
+
+func TestBillingFilter(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		tests := []struct {
+			name       string
+			query      string
+			wantedCode int
+		}{
+			{"no filter", "", 200},
+			{"all filters", "?tipo=d&de=2024-01-01&ate=2024-01-31&valor_min=1&valor_max=10", 200},
+			{"timestamps", "?de=2024-01-01T10:00:00Z&ate=2024-01-01T11:00:00-03:00", 200},
+			{"invalid range", "?de=2024-02-01&ate=2024-01-01", 422},
+			{"invalid date", "?de=yesterday", 422},
+			{"invalid type", "?tipo=x", 422},
+			{"invalid value", "?valor_min=10&valor_max=1", 422},
+		}
+		for _, tt := range tests {
+			for _, endpoint := range []string{"extrato", "transacoes"} {
+				resp, err := http.Get(httpServer.URL + "/clientes/1/" + endpoint + tt.query)
+				if err != nil {
+					t.Fatalf("get: %v", err)
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != tt.wantedCode {
+					t.Errorf("%s %s: got wrong status code: %v, want: %v", tt.name, endpoint, resp.StatusCode, tt.wantedCode)
+				}
+			}
+		}
+	})
+}
+
+func TestExportBilling(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		for i := range 15 {
+			data := fmt.Sprintf(`{"valor":%d,"tipo":"d","descricao":"d%d"}`, (i+1)*100, i)
+			resp, err := http.Post(httpServer.URL+"/clientes/1/transacoes", "application/json", strings.NewReader(data))
+			if err != nil {
+				t.Fatalf("post: %v", err)
+			}
+			resp.Body.Close()
+		}
+
+		get := func(path, accept string) (int, string) {
+			req, err := http.NewRequest(http.MethodGet, httpServer.URL+path, nil)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			req.Header.Set("Accept", accept)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			return resp.StatusCode, string(body)
+		}
+
+		code, body := get("/clientes/1/extrato", "text/csv")
+		if code != http.StatusOK {
+			t.Fatalf("got wrong status code: %v", code)
+		}
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		if len(lines) != 17 {
+			t.Fatalf("got %d csv lines want %d:\n%s", len(lines), 17, body)
+		}
+		if !strings.HasSuffix(lines[1], ",d,d0,-1.00,,,") {
+			t.Errorf("first transaction should be the oldest: %q", lines[1])
+		}
+		if !strings.HasSuffix(lines[16], ",saldo,saldo final,-120.00,,,") {
+			t.Errorf("last line should be the closing balance: %q", lines[16])
+		}
+
+		code, body = get("/clientes/1/extrato?formato=ofx", "")
+		if code != http.StatusOK {
+			t.Fatalf("got wrong status code: %v", code)
+		}
+		if n := strings.Count(body, "<STMTTRN>"); n != 15 {
+			t.Errorf("got %d ofx transactions want %d", n, 15)
+		}
+		if !strings.Contains(body, "<LEDGERBAL><BALAMT>-120.00</BALAMT>") {
+			t.Errorf("ofx should have the closing balance:\n%s", body)
+		}
+
+		if code, _ := get("/clientes/6/extrato", "application/x-ofx"); code != http.StatusNotFound {
+			t.Errorf("got wrong status code: %v, want: %v", code, http.StatusNotFound)
+		}
+		if code, _ := get("/clientes/1/extrato?formato=pdf", ""); code != http.StatusUnprocessableEntity {
+			t.Errorf("got wrong status code: %v, want: %v", code, http.StatusUnprocessableEntity)
+		}
+	})
+}
+
+func TestClients(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		path := httpServer.URL + "/clientes"
+		contentType := "application/json"
+
+		tests := []struct {
+			name       string
+			data       string
+			wantedCode int
+		}{
+			{"new client", `{"id":6,"limite":1000,"nome":"Maria","documento":"529.982.247-25","email":"maria@example.com"}`, 201},
+			{"duplicated id", `{"id":6,"limite":1000}`, 409},
+			{"duplicated document", `{"id":7,"limite":1000,"documento":"52998224725"}`, 409},
+			{"invalid limit", `{"id":7,"limite":-1}`, 422},
+			{"invalid document", `{"id":7,"limite":1000,"documento":"529.982.247-26"}`, 422},
+		}
+		for _, tt := range tests {
+			resp, err := http.Post(path, contentType, strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("%s: post: %v", tt.name, err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantedCode {
+				t.Fatalf("%s: got wrong status code: %v, want: %v", tt.name, resp.StatusCode, tt.wantedCode)
+			}
+		}
+
+		resp, err := http.Get(path + "/6")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var c ClientResp
+		if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if want := (ClientResp{ID: 6, Currency: "BRL", Limit: 1000, ClosingDay: 1, Status: client.StatusActive}); c != want {
+			t.Fatalf("got client %+v want %+v", c, want)
+		}
+
+		resp, err = http.Get(path + "?page=2&rows=5")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var cs []ClientResp
+		if err := json.NewDecoder(resp.Body).Decode(&cs); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if len(cs) != 1 || cs[0].ID != 6 {
+			t.Fatalf("got wrong page of clients: %+v", cs)
+		}
+
+		resp, err = http.Get(path + "/6/perfil")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var p ProfileResp
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if p.ID != 6 || p.Name != "Maria" || p.Document != "52998224725" || p.Email != "maria@example.com" || p.DateCreated.IsZero() {
+			t.Fatalf("got wrong profile: %+v", p)
+		}
+
+		search := []struct {
+			name       string
+			query      string
+			wantedCode int
+			wantedIDs  []int
+		}{
+			{"document", "?documento=529.982.247-25", 200, []int{6}},
+			{"unknown document", "?documento=11.222.333/0001-81", 200, []int{}},
+			{"invalid document", "?documento=123", 422, nil},
+		}
+		for _, tt := range search {
+			resp, err := http.Get(path + tt.query)
+			if err != nil {
+				t.Fatalf("%s: get: %v", tt.name, err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantedCode {
+				t.Fatalf("%s: got wrong status code: %v, want: %v", tt.name, resp.StatusCode, tt.wantedCode)
+			}
+			if tt.wantedCode != http.StatusOK {
+				continue
+			}
+
+			var cs []ClientResp
+			if err := json.NewDecoder(resp.Body).Decode(&cs); err != nil {
+				t.Fatalf("%s: failed to unmarshal: %v", tt.name, err)
+			}
+			ids := []int{}
+			for _, c := range cs {
+				ids = append(ids, c.ID)
+			}
+			if !slices.Equal(ids, tt.wantedIDs) {
+				t.Fatalf("%s: got clients %v want %v", tt.name, ids, tt.wantedIDs)
+			}
+		}
+	})
+}
+
+func TestFeed(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		ctx := context.Background()
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		core := client.NewCore(newStore(t, memstore.DefaultClients()...))
+		server := NewServer(log, core)
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		for _, id := range []int{1, 2} {
+			nt := client.NewTransaction{Value: 10, Type: "c", Description: "feed"}
+			if _, err := core.AddTransaction(ctx, id, nt); err != nil {
+				t.Fatalf("adding transaction: %v", err)
+			}
+		}
+		if _, err := core.SequenceLedger(ctx); err != nil {
+			t.Fatalf("sequencing ledger: %v", err)
+		}
+
+		get := func(query string, wantedCode int) FeedResp {
+			t.Helper()
+
+			resp, err := http.Get(httpServer.URL + "/feed" + query)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != wantedCode {
+				t.Fatalf("%s: got wrong status code: %v, want: %v", query, resp.StatusCode, wantedCode)
+			}
+			var f FeedResp
+			if wantedCode == http.StatusOK {
+				if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+					t.Fatalf("failed to unmarshal: %v", err)
+				}
+			}
+			return f
+		}
+
+		f := get("?limit=1", http.StatusOK)
+		if len(f.Entries) != 1 || f.Entries[0].ClientID != 1 || f.Next != 1 {
+			t.Fatalf("got first page %+v", f)
+		}
+		f = get(fmt.Sprintf("?after=%d", f.Next), http.StatusOK)
+		if len(f.Entries) != 1 || f.Entries[0].Seq != 2 || f.Entries[0].ClientID != 2 || f.Next != 2 {
+			t.Fatalf("got second page %+v", f)
+		}
+		if f = get("?after=2", http.StatusOK); len(f.Entries) != 0 || f.Next != 2 {
+			t.Fatalf("got last page %+v", f)
+		}
+
+		get("?after=x", http.StatusUnprocessableEntity)
+		get("?limit=0", http.StatusUnprocessableEntity)
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := NewServer(log, client.NewCore(memstore.NewStore(memstore.DefaultClients()...)))
+	tracer := otel.GetTracerProvider().Tracer("")
+
 	tests := []struct {
 		name       string
-		id         string
+		adminToken string
+		auth       string
 		wantedCode int
 	}{
-		{"invalid string", "not_number", 404},
-		{"invalid id", "-1", 404},
-		{"id not found", "6", 404},
-		{"good id", "1", 200},
+		{name: "valid token", adminToken: "secret", auth: "Bearer secret", wantedCode: http.StatusCreated},
+		{name: "wrong token", adminToken: "secret", auth: "Bearer other", wantedCode: http.StatusUnauthorized},
+		{name: "no token", adminToken: "secret", wantedCode: http.StatusUnauthorized},
+		{name: "admin disabled", auth: "Bearer ", wantedCode: http.StatusNotFound},
 	}
 
-	log, db, teardown := dbtest.NewUnit(t, dbtest.WithMigrations())
-	t.Cleanup(teardown)
-
-	server := NewServer(log, client.NewCore(clientdb.NewStore(log, db)))
-	httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer("")))
-	t.Cleanup(httpServer.Close)
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			path := httpServer.URL + fmt.Sprintf("/clientes/%s/transacoes", tt.id)
-			data := `{"valor":1000,"tipo":"c","descricao":"descricao"}`
-			contentType := "application/json"
+			httpServer := httptest.NewServer(APIMux(server, tracer, tt.adminToken))
+			t.Cleanup(httpServer.Close)
 
-			resp, err := http.Post(path, contentType, strings.NewReader(data))
+			data := `{"de":"USD","para":"BRL","taxa":5.1}`
+			req, err := http.NewRequest(http.MethodPost, httpServer.URL+"/admin/cambio", strings.NewReader(data))
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
 				t.Fatalf("post: %v", err)
 			}
@@ -85,3 +465,200 @@ func TestTransactionsID(t *testing.T) {
 		})
 	}
 }
+
+func TestHolds(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		path := httpServer.URL + "/clientes/1/autorizacoes"
+		contentType := "application/json"
+
+		resp, err := http.Post(path, contentType, strings.NewReader(`{"valor":1000,"descricao":"hold"}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 201 {
+			t.Fatalf("got wrong status code: %v, want: %v", resp.StatusCode, 201)
+		}
+		var h HoldResp
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if h.Status != client.HoldActive || h.Balance != 0 || h.Available != -1000 {
+			t.Fatalf("got wrong hold: %+v", h)
+		}
+
+		tests := []struct {
+			name       string
+			path       string
+			data       string
+			wantedCode int
+		}{
+			{"invalid hold id", path + "/x/captura", `{}`, 404},
+			{"capture", fmt.Sprintf("%s/%s/captura", path, h.ID), `{"valor":400}`, 200},
+			{"capture twice", fmt.Sprintf("%s/%s/captura", path, h.ID), `{}`, 409},
+			{"void captured", fmt.Sprintf("%s/%s/cancelamento", path, h.ID), ``, 409},
+		}
+		for _, tt := range tests {
+			resp, err := http.Post(tt.path, contentType, strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("%s: post: %v", tt.name, err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantedCode {
+				t.Fatalf("%s: got wrong status code: %v, want: %v", tt.name, resp.StatusCode, tt.wantedCode)
+			}
+		}
+
+		resp, err = http.Get(httpServer.URL + "/clientes/1/extrato")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var b BillingResp
+		if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if b.Balance.Total != -400 || b.Balance.Available != -400 {
+			t.Fatalf("got wrong balance: %+v", b.Balance)
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		path := httpServer.URL + "/clientes/1"
+		contentType := "application/json"
+
+		do := func(method, path, data string) *http.Response {
+			t.Helper()
+
+			req, err := http.NewRequest(method, path, strings.NewReader(data))
+			if err != nil {
+				t.Fatalf("creating request: %v", err)
+			}
+			req.Header.Set("Content-Type", contentType)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s: %v", method, err)
+			}
+			return resp
+		}
+
+		tests := []struct {
+			name       string
+			method     string
+			path       string
+			data       string
+			wantedCode int
+		}{
+			{"invalid status", "PATCH", path + "/status", `{"status":"blocked","motivo":"x"}`, 422},
+			{"freeze", "PATCH", path + "/status", `{"status":"frozen","motivo":"fraud"}`, 200},
+			{"freeze twice", "PATCH", path + "/status", `{"status":"frozen","motivo":"fraud"}`, 409},
+			{"debit frozen", "POST", path + "/transacoes", `{"valor":1,"tipo":"d","descricao":"debit"}`, 403},
+			{"credit frozen", "POST", path + "/transacoes", `{"valor":1,"tipo":"c","descricao":"credit"}`, 200},
+			{"close", "PATCH", httpServer.URL + "/clientes/2/status", `{"status":"closed","motivo":"x"}`, 200},
+			{"unknown client", "PATCH", httpServer.URL + "/clientes/6/status", `{"status":"frozen","motivo":"x"}`, 404},
+		}
+		for _, tt := range tests {
+			resp := do(tt.method, tt.path, tt.data)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantedCode {
+				t.Fatalf("%s: got wrong status code: %v, want: %v", tt.name, resp.StatusCode, tt.wantedCode)
+			}
+		}
+
+		resp, err := http.Get(path + "/status")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var scs []StatusChange
+		if err := json.NewDecoder(resp.Body).Decode(&scs); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if len(scs) != 1 || scs[0].OldStatus != client.StatusActive || scs[0].NewStatus != client.StatusFrozen || scs[0].Reason != "fraud" {
+			t.Fatalf("got wrong status changes: %+v", scs)
+		}
+	})
+}
+
+func TestEvents(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, newStore storetest.NewStore) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		server := NewServer(log, client.NewCore(newStore(t, memstore.DefaultClients()...)))
+		httpServer := httptest.NewServer(APIMux(server, otel.GetTracerProvider().Tracer(""), ""))
+		t.Cleanup(httpServer.Close)
+
+		resp, err := http.Post(httpServer.URL+"/clientes/1/transacoes", "application/json",
+			strings.NewReader(`{"valor":10,"tipo":"c","descricao":"credit"}`))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+
+		tests := []struct {
+			name        string
+			path        string
+			lastEventID string
+			wantedCode  int
+		}{
+			{"client not found", "/clientes/99/eventos", "", 404},
+			{"invalid last event id", "/clientes/1/eventos", "x", 422},
+		}
+		for _, tt := range tests {
+			req, _ := http.NewRequest(http.MethodGet, httpServer.URL+tt.path, nil)
+			req.Header.Set("Last-Event-ID", tt.lastEventID)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s: get: %v", tt.name, err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantedCode {
+				t.Fatalf("%s: got wrong status code: %v, want: %v", tt.name, resp.StatusCode, tt.wantedCode)
+			}
+		}
+
+		// Resuming replays the updates after the last event and the stream
+		// ends when the server closes the streams.
+		req, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/clientes/1/eventos", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); resp.StatusCode != 200 || ct != "text/event-stream" {
+			t.Fatalf("got status %d content type %q", resp.StatusCode, ct)
+		}
+		want := "id: 2\nevent: saldo\ndata: {\"saldo\":10,\"disponivel\":10,\"limite\":100000}\n\n"
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(resp.Body, got); err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		if string(got) != want {
+			t.Fatalf("got event %q want %q", got, want)
+		}
+
+		server.CloseStreams()
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			t.Fatalf("reading closed stream: %v", err)
+		}
+	})
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
+	"github.com/google/uuid"
 	"github.com/rschio/rinha/internal/core/client"
 	"github.com/rschio/rinha/internal/web"
 )
@@ -16,17 +18,87 @@ func getID(r *http.Request) (int, error) {
 	return strconv.Atoi(sID)
 }
 
+func getTransactionID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(r.PathValue("tid"))
+}
+
+func getHoldID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(r.PathValue("hid"))
+}
+
+func getScheduledID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(r.PathValue("sid"))
+}
+
+func getInvoiceID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(r.PathValue("fid"))
+}
+
+func getWebhookID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(r.PathValue("wid"))
+}
+
+func getDeliveryID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(r.PathValue("did"))
+}
+
+// serveJSON serves a request to a client resource, the client id is taken
+// from the URL path.
 func serveJSON[Req any, Resp any](
 	s *Server,
 	w http.ResponseWriter,
 	r *http.Request,
 	fn func(ctx context.Context, id int, req Req) (Resp, error),
 ) {
-	ctx, span := web.AddSpan(r.Context(), "internal.handlers.serveJSON")
+	serve(s, w, r, http.StatusOK,
+		func(ctx context.Context, r *http.Request, req Req) (Resp, error) {
+			id, err := getID(r)
+			if err != nil {
+				var zero Resp
+				return zero, fmt.Errorf("invalid id: %w", client.ErrNotFound)
+			}
+
+			return fn(ctx, id, req)
+		},
+	)
+}
+
+// serveNoBody serves a request without a body to a client resource, like
+// the actions on it. The request body is not read and the client id is
+// taken from the URL path.
+func serveNoBody[Resp any](
+	s *Server,
+	w http.ResponseWriter,
+	r *http.Request,
+	fn func(ctx context.Context, id int) (Resp, error),
+) {
+	ctx, span := web.AddSpan(r.Context(), "internal.handlers.serveNoBody")
+	defer span.End()
+
+	id, err := getID(r)
+	if err != nil {
+		writeError(s, w, fmt.Errorf("invalid id: %w", client.ErrNotFound))
+		return
+	}
+
+	resp, err := fn(ctx, id)
+	respond(s, w, http.StatusOK, resp, err)
+}
+
+// serve decodes the JSON request body, calls fn and writes the JSON response
+// with the status code.
+func serve[Req any, Resp any](
+	s *Server,
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	fn func(ctx context.Context, r *http.Request, req Req) (Resp, error),
+) {
+	ctx, span := web.AddSpan(r.Context(), "internal.handlers.serve")
 	defer span.End()
 
 	var req Req
-	if r.Method == http.MethodPost {
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
 		if r.Header.Get("Content-Type") != "application/json" {
 			s.log.Error("request must be a json")
 			http.Error(w, "request must be a json", http.StatusBadRequest)
@@ -44,36 +116,15 @@ func serveJSON[Req any, Resp any](
 		}
 	}
 
-	id, err := getID(r)
-	if err != nil {
-		s.log.Error("getID", "ERROR", err)
-		http.Error(w, "invalid id", http.StatusNotFound)
-		return
-	}
+	resp, err := fn(ctx, r, req)
+	respond(s, w, status, resp, err)
+}
 
-	resp, err := fn(ctx, id, req)
+// respond writes the JSON response with the status code, or the error.
+func respond[Resp any](s *Server, w http.ResponseWriter, status int, resp Resp, err error) {
 	if err != nil {
-		s.log.Error("fn", "ERROR", err)
-		switch {
-		case errors.Is(err, client.ErrNotFound):
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-
-		case errors.Is(err, client.ErrInvalidArgument):
-			// TODO: I think this should return bad request,
-			// but the tests aks for 422.
-			fallthrough
-			//http.Error(w, err.Error(), http.StatusBadRequest)
-			//return
-
-		case errors.Is(err, client.ErrTransactionDenied):
-			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-			return
-
-		default:
-			http.Error(w, "internal error", http.StatusInternalServerError)
-			return
-		}
+		writeError(s, w, err)
+		return
 	}
 
 	bs, err := json.Marshal(resp)
@@ -84,6 +135,49 @@ func serveJSON[Req any, Resp any](
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	w.Write(bs)
 }
+
+// writeError logs the error and writes it with the matching status code.
+func writeError(s *Server, w http.ResponseWriter, err error) {
+	s.log.Error("fn", "ERROR", err)
+	switch {
+	case errors.Is(err, client.ErrNotFound),
+		errors.Is(err, client.ErrTransactionNotFound),
+		errors.Is(err, client.ErrHoldNotFound),
+		errors.Is(err, client.ErrScheduledNotFound),
+		errors.Is(err, client.ErrInvoiceNotFound),
+		errors.Is(err, client.ErrWebhookNotFound),
+		errors.Is(err, client.ErrDeliveryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+	// Before ErrTransactionDenied, which is also wrapped by the
+	// client.StatusError.
+	case errors.Is(err, client.ErrAccountStatus):
+		http.Error(w, err.Error(), http.StatusForbidden)
+
+	case errors.Is(err, client.ErrAlreadyExists),
+		errors.Is(err, client.ErrDocumentExists),
+		errors.Is(err, client.ErrStatusTransition),
+		errors.Is(err, client.ErrTransactionReversed),
+		errors.Is(err, client.ErrIdempotencyConflict),
+		errors.Is(err, client.ErrHoldClosed),
+		errors.Is(err, client.ErrScheduledClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+
+	case errors.Is(err, client.ErrInvalidArgument):
+		// TODO: I think this should return bad request,
+		// but the tests aks for 422.
+		fallthrough
+		//http.Error(w, err.Error(), http.StatusBadRequest)
+
+	case errors.Is(err, client.ErrTransactionDenied),
+		errors.Is(err, client.ErrLimitDenied),
+		errors.Is(err, client.ErrFXRateNotFound):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+
+	default:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/rschio/rinha/internal/web"
@@ -24,3 +26,17 @@ func middlewareWeb(tracer trace.Tracer, h http.HandlerFunc) http.Handler {
 		h(w, r)
 	})
 }
+
+// middlewareAdmin only serves the requests with the token in the
+// Authorization header, as "Bearer <token>".
+func middlewareAdmin(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
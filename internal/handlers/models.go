@@ -1,51 +1,354 @@
 package handlers
 
 import (
+	"encoding/json"
+	"math"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rschio/rinha/internal/core/client"
 )
 
+type NewClientReq struct {
+	ID         int    `json:"id"`
+	Currency   string `json:"moeda"`
+	Limit      int    `json:"limite"`
+	ClosingDay int    `json:"dia_fechamento"`
+	Name       string `json:"nome"`
+	Document   string `json:"documento"`
+	Email      string `json:"email"`
+}
+
+type ClientResp struct {
+	ID         int    `json:"id"`
+	Currency   string `json:"moeda"`
+	Limit      int    `json:"limite"`
+	Balance    int    `json:"saldo"`
+	ClosingDay int    `json:"dia_fechamento"`
+	Status     string `json:"status"`
+}
+
+type ProfileResp struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"nome"`
+	Document    string    `json:"documento"`
+	Email       string    `json:"email"`
+	DateCreated time.Time `json:"criado_em"`
+}
+
+type LimitReq struct {
+	Limit  int    `json:"limite"`
+	Reason string `json:"motivo"`
+}
+
+type StatusReq struct {
+	Status string `json:"status"`
+	Reason string `json:"motivo"`
+}
+
+type StatusChange struct {
+	OldStatus string    `json:"status_anterior"`
+	NewStatus string    `json:"status_novo"`
+	Reason    string    `json:"motivo"`
+	Date      time.Time `json:"realizada_em"`
+}
+
+type SpendingLimits struct {
+	MaxDebitsPerMinute  int `json:"max_debitos_minuto"`
+	MaxDailyDebit       int `json:"max_debito_diario"`
+	MaxTransactionValue int `json:"max_valor_transacao"`
+}
+
 type TransactionsReq struct {
 	Value       int    `json:"valor"`
 	Type        string `json:"tipo"`
 	Description string `json:"descricao"`
+	Currency    string `json:"moeda"`
 }
 
 type TransactionsResp struct {
-	Limit   int `json:"limite"`
-	Balance int `json:"saldo"`
+	Limit     int `json:"limite"`
+	Balance   int `json:"saldo"`
+	Available int `json:"saldo_disponivel"`
+}
+
+type HoldReq struct {
+	Value       int    `json:"valor"`
+	Description string `json:"descricao"`
+}
+
+type CaptureReq struct {
+	Value int `json:"valor"`
+}
+
+type HoldResp struct {
+	ID        uuid.UUID `json:"id"`
+	Value     int       `json:"valor"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expira_em"`
+	Limit     int       `json:"limite"`
+	Balance   int       `json:"saldo"`
+	Available int       `json:"saldo_disponivel"`
+}
+
+type ScheduledReq struct {
+	Value        int       `json:"valor"`
+	Type         string    `json:"tipo"`
+	Description  string    `json:"descricao"`
+	Currency     string    `json:"moeda"`
+	ScheduledFor time.Time `json:"agendada_para"`
+}
+
+type ScheduledResp struct {
+	ID            uuid.UUID  `json:"id"`
+	Value         int        `json:"valor"`
+	Type          string     `json:"tipo"`
+	Description   string     `json:"descricao"`
+	Currency      string     `json:"moeda,omitempty"`
+	ScheduledFor  time.Time  `json:"agendada_para"`
+	Status        string     `json:"status"`
+	TransactionID *uuid.UUID `json:"transacao,omitempty"`
+	Reason        string     `json:"motivo,omitempty"`
+	Date          time.Time  `json:"realizada_em"`
+}
+
+type TransferReq struct {
+	FromID      int    `json:"de"`
+	ToID        int    `json:"para"`
+	Value       int    `json:"valor"`
+	Description string `json:"descricao"`
+}
+
+type TransferResp struct {
+	ID   uuid.UUID  `json:"id"`
+	From ClientResp `json:"de"`
+	To   ClientResp `json:"para"`
+	Date time.Time  `json:"realizada_em"`
 }
 
 type Balance struct {
-	Total int       `json:"total"`
-	Limit int       `json:"limite"`
-	Date  time.Time `json:"data_extrato"`
+	Currency  string    `json:"moeda"`
+	Total     int       `json:"total"`
+	Available int       `json:"disponivel"`
+	Limit     int       `json:"limite"`
+	Date      time.Time `json:"data_extrato"`
+}
+
+type BalanceAtResp struct {
+	Balance int       `json:"saldo"`
+	Date    time.Time `json:"em"`
 }
 
 type BillingResp struct {
 	Balance          Balance       `json:"saldo"`
 	LastTransactions []Transaction `json:"ultimas_transacoes"`
+	LimitChanges     []LimitChange `json:"alteracoes_limite"`
+}
+
+type StatementResp struct {
+	Transactions []Transaction `json:"transacoes"`
+	NextCursor   string        `json:"proximo_cursor,omitempty"`
+}
+
+type FeedResp struct {
+	Entries []FeedEntry `json:"transacoes"`
+	Next    int64       `json:"proximo"`
+}
+
+type FeedEntry struct {
+	Seq      int64 `json:"seq"`
+	ClientID int   `json:"cliente_id"`
+	Transaction
 }
 
 type Transaction struct {
-	Value       int       `json:"valor"`
-	Type        string    `json:"tipo"`
-	Description string    `json:"descricao"`
-	Date        time.Time `json:"realizada_em"`
+	ID           uuid.UUID  `json:"id"`
+	Value        int        `json:"valor"`
+	Type         string     `json:"tipo"`
+	Description  string     `json:"descricao"`
+	Date         time.Time  `json:"realizada_em"`
+	ReversalOf   *uuid.UUID `json:"estorno_de,omitempty"`
+	ReversedBy   *uuid.UUID `json:"estornada_por,omitempty"`
+	TransferID   *uuid.UUID `json:"transferencia,omitempty"`
+	BalanceAfter int        `json:"saldo_apos"`
+
+	Currency      string  `json:"moeda_original,omitempty"`
+	OriginalValue int     `json:"valor_original,omitempty"`
+	FXRate        float64 `json:"taxa_cambio,omitempty"`
+}
+
+type FXRateReq struct {
+	From string  `json:"de"`
+	To   string  `json:"para"`
+	Rate float64 `json:"taxa"`
+}
+
+type FXRateResp struct {
+	From string    `json:"de"`
+	To   string    `json:"para"`
+	Rate float64   `json:"taxa"`
+	Date time.Time `json:"atualizada_em"`
+}
+
+type TransactionFlag struct {
+	TransactionID uuid.UUID `json:"transacao"`
+	Rule          string    `json:"regra"`
+	Reason        string    `json:"motivo"`
+	Date          time.Time `json:"realizada_em"`
+}
+
+type WebhookReq struct {
+	URL string `json:"url"`
+}
+
+// WebhookResp is a webhook. The secret is only returned when the webhook is
+// registered.
+type WebhookResp struct {
+	ID     uuid.UUID `json:"id"`
+	URL    string    `json:"url"`
+	Secret string    `json:"segredo,omitempty"`
+	Date   time.Time `json:"criado_em"`
+}
+
+type WebhookDeliveryResp struct {
+	ID             uuid.UUID       `json:"id"`
+	WebhookID      uuid.UUID       `json:"webhook"`
+	EventID        uuid.UUID       `json:"evento_id"`
+	Event          string          `json:"evento"`
+	Payload        json.RawMessage `json:"dados"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"tentativas"`
+	ResponseStatus int             `json:"status_resposta,omitempty"`
+	LastError      string          `json:"erro,omitempty"`
+	NextAttempt    time.Time       `json:"proxima_tentativa"`
+	Date           time.Time       `json:"criado_em"`
+	DateUpdated    time.Time       `json:"atualizado_em"`
+}
+
+type InvoiceResp struct {
+	ID             uuid.UUID     `json:"id"`
+	PeriodStart    time.Time     `json:"inicio"`
+	PeriodEnd      time.Time     `json:"fim"`
+	OpeningBalance int           `json:"saldo_inicial"`
+	ClosingBalance int           `json:"saldo_final"`
+	DueDate        time.Time     `json:"vencimento"`
+	Date           time.Time     `json:"fechada_em"`
+	Transactions   []Transaction `json:"transacoes,omitempty"`
+}
+
+type LimitChange struct {
+	OldLimit int       `json:"limite_anterior"`
+	NewLimit int       `json:"limite_novo"`
+	Reason   string    `json:"motivo"`
+	Date     time.Time `json:"realizada_em"`
+}
+
+func toClientResp(c client.Client) ClientResp {
+	return ClientResp{
+		ID:         c.ID,
+		Currency:   c.Currency,
+		Limit:      c.Limit,
+		Balance:    c.Balance,
+		ClosingDay: c.ClosingDay,
+		Status:     c.Status,
+	}
+}
+
+func toProfileResp(c client.Client) ProfileResp {
+	return ProfileResp{
+		ID:          c.ID,
+		Name:        c.Name,
+		Document:    c.Document,
+		Email:       c.Email,
+		DateCreated: c.DateCreated,
+	}
+}
+
+func toSpendingLimits(l client.SpendingLimits) SpendingLimits {
+	return SpendingLimits{
+		MaxDebitsPerMinute:  l.MaxDebitsPerMinute,
+		MaxDailyDebit:       l.MaxDailyDebit,
+		MaxTransactionValue: l.MaxTransactionValue,
+	}
+}
+
+func toClientsResp(cs []client.Client) []ClientResp {
+	slice := make([]ClientResp, len(cs))
+	for i, c := range cs {
+		slice[i] = toClientResp(c)
+	}
+	return slice
+}
+
+func toTransactionsResp(c client.Client) TransactionsResp {
+	return TransactionsResp{
+		Limit:     c.Limit,
+		Balance:   c.Balance,
+		Available: c.Available(),
+	}
+}
+
+func toHoldResp(h client.Hold, c client.Client) HoldResp {
+	return HoldResp{
+		ID:        h.ID,
+		Value:     h.Value,
+		Status:    h.Status,
+		ExpiresAt: h.ExpiresAt,
+		Limit:     c.Limit,
+		Balance:   c.Balance,
+		Available: c.Available(),
+	}
+}
+
+func toScheduledResp(st client.ScheduledTransaction) ScheduledResp {
+	return ScheduledResp{
+		ID:            st.ID,
+		Value:         st.Value,
+		Type:          st.Type,
+		Description:   st.Description,
+		Currency:      st.Currency,
+		ScheduledFor:  st.ScheduledFor,
+		Status:        st.Status,
+		TransactionID: toUUIDPtr(st.TransactionID),
+		Reason:        st.Reason,
+		Date:          st.Date,
+	}
+}
+
+func toScheduledResps(sts []client.ScheduledTransaction) []ScheduledResp {
+	slice := make([]ScheduledResp, len(sts))
+	for i, st := range sts {
+		slice[i] = toScheduledResp(st)
+	}
+	return slice
 }
 
 func toBillingResp(b client.Billing) BillingResp {
 	return BillingResp{
 		Balance: Balance{
-			Total: b.Balance,
-			Limit: b.Limit,
-			Date:  b.Date,
+			Currency:  b.Currency,
+			Total:     b.Balance,
+			Available: b.Balance - b.Reserved,
+			Limit:     b.Limit,
+			Date:      b.Date,
 		},
 		LastTransactions: toTransactions(b.LastTransactions),
+		LimitChanges:     toLimitChanges(b.LimitChanges),
 	}
 }
 
+func toFeedResp(f client.Feed) FeedResp {
+	es := make([]FeedEntry, len(f.Entries))
+	for i, e := range f.Entries {
+		es[i] = FeedEntry{
+			Seq:         e.Seq,
+			ClientID:    e.Transaction.ClientID,
+			Transaction: toTransaction(e.Transaction),
+		}
+	}
+	return FeedResp{Entries: es, Next: f.Next}
+}
+
 func toTransactions(ts []client.Transaction) []Transaction {
 	slice := make([]Transaction, len(ts))
 	for i, t := range ts {
@@ -56,9 +359,155 @@ func toTransactions(ts []client.Transaction) []Transaction {
 
 func toTransaction(t client.Transaction) Transaction {
 	return Transaction{
-		Value:       t.Value,
-		Type:        t.Type,
-		Description: t.Description,
-		Date:        t.Date,
+		ID:           t.ID,
+		Value:        t.Value,
+		Type:         t.Type,
+		Description:  t.Description,
+		Date:         t.Date,
+		ReversalOf:   toUUIDPtr(t.ReversalOf),
+		ReversedBy:   toUUIDPtr(t.ReversedBy),
+		TransferID:   toUUIDPtr(t.TransferID),
+		BalanceAfter: t.BalanceAfter,
+
+		Currency:      t.Currency,
+		OriginalValue: t.OriginalValue,
+		FXRate:        toRate(t.FXRate),
+	}
+}
+
+func toWebhookResps(ws []client.Webhook) []WebhookResp {
+	slice := make([]WebhookResp, len(ws))
+	for i, w := range ws {
+		slice[i] = WebhookResp{
+			ID:   w.ID,
+			URL:  w.URL,
+			Date: w.Date,
+		}
+	}
+	return slice
+}
+
+func toWebhookDeliveryResp(d client.WebhookDelivery) WebhookDeliveryResp {
+	return WebhookDeliveryResp{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		NextAttempt:    d.NextAttempt,
+		Date:           d.Date,
+		DateUpdated:    d.DateUpdated,
+	}
+}
+
+func toWebhookDeliveryResps(ds []client.WebhookDelivery) []WebhookDeliveryResp {
+	slice := make([]WebhookDeliveryResp, len(ds))
+	for i, d := range ds {
+		slice[i] = toWebhookDeliveryResp(d)
+	}
+	return slice
+}
+
+func toTransactionFlags(fs []client.TransactionFlag) []TransactionFlag {
+	slice := make([]TransactionFlag, len(fs))
+	for i, f := range fs {
+		slice[i] = TransactionFlag{
+			TransactionID: f.TransactionID,
+			Rule:          f.Rule,
+			Reason:        f.Reason,
+			Date:          f.Date,
+		}
+	}
+	return slice
+}
+
+// toInvoiceResp converts the invoice. The transactions are only present in
+// the invoice detail.
+func toInvoiceResp(inv client.Invoice) InvoiceResp {
+	resp := InvoiceResp{
+		ID:             inv.ID,
+		PeriodStart:    inv.PeriodStart,
+		PeriodEnd:      inv.PeriodEnd,
+		OpeningBalance: inv.OpeningBalance,
+		ClosingBalance: inv.ClosingBalance,
+		DueDate:        inv.DueDate,
+		Date:           inv.Date,
+	}
+	if inv.Transactions != nil {
+		resp.Transactions = toTransactions(inv.Transactions)
+	}
+	return resp
+}
+
+func toInvoiceResps(invs []client.Invoice) []InvoiceResp {
+	slice := make([]InvoiceResp, len(invs))
+	for i, inv := range invs {
+		slice[i] = toInvoiceResp(inv)
+	}
+	return slice
+}
+
+func toFXRateResp(r client.FXRate) FXRateResp {
+	return FXRateResp{
+		From: r.From,
+		To:   r.To,
+		Rate: toRate(r.Rate),
+		Date: r.Date,
+	}
+}
+
+func toFXRatesResp(rs []client.FXRate) []FXRateResp {
+	slice := make([]FXRateResp, len(rs))
+	for i, r := range rs {
+		slice[i] = toFXRateResp(r)
 	}
+	return slice
+}
+
+// toRate converts a rate scaled by client.RateScale to a float.
+func toRate(rate int) float64 {
+	return float64(rate) / client.RateScale
+}
+
+// fromRate converts a float rate to a rate scaled by client.RateScale.
+func fromRate(rate float64) int {
+	return int(math.Round(rate * client.RateScale))
+}
+
+// toUUIDPtr converts the zero UUID to nil.
+func toUUIDPtr(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
+
+func toStatusChanges(scs []client.StatusChange) []StatusChange {
+	slice := make([]StatusChange, len(scs))
+	for i, sc := range scs {
+		slice[i] = StatusChange{
+			OldStatus: sc.OldStatus,
+			NewStatus: sc.NewStatus,
+			Reason:    sc.Reason,
+			Date:      sc.Date,
+		}
+	}
+	return slice
+}
+
+func toLimitChanges(lcs []client.LimitChange) []LimitChange {
+	slice := make([]LimitChange, len(lcs))
+	for i, lc := range lcs {
+		slice[i] = LimitChange{
+			OldLimit: lc.OldLimit,
+			NewLimit: lc.NewLimit,
+			Reason:   lc.Reason,
+			Date:     lc.Date,
+		}
+	}
+	return slice
 }
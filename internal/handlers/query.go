@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rschio/rinha/internal/core/client"
+)
+
+// getPage returns the page number and the rows per page from the URL query.
+func getPage(r *http.Request) (pageNumber, rowsPerPage int, err error) {
+	const maxRowsPerPage = 100
+
+	pageNumber, rowsPerPage = 1, 10
+	q := r.URL.Query()
+
+	if v := q.Get("page"); v != "" {
+		pageNumber, err = strconv.Atoi(v)
+		if err != nil || pageNumber < 1 {
+			return 0, 0, fmt.Errorf("invalid page %q: %w", v, client.ErrInvalidArgument)
+		}
+	}
+
+	if v := q.Get("rows"); v != "" {
+		rowsPerPage, err = strconv.Atoi(v)
+		if err != nil || rowsPerPage < 1 || rowsPerPage > maxRowsPerPage {
+			return 0, 0, fmt.Errorf("invalid rows %q: %w", v, client.ErrInvalidArgument)
+		}
+	}
+
+	return pageNumber, rowsPerPage, nil
+}
+
+// getTransactionFilter returns the transaction filter from the URL query.
+// Dates are RFC 3339 timestamps or YYYY-MM-DD days, the ate day is included
+// in the range.
+func getTransactionFilter(r *http.Request) (client.TransactionFilter, error) {
+	var filter client.TransactionFilter
+	q := r.URL.Query()
+
+	if v := q.Get("tipo"); v != "" {
+		filter.Type = &v
+	}
+
+	if v := q.Get("de"); v != "" {
+		date, _, err := parseDate(v)
+		if err != nil {
+			return client.TransactionFilter{}, fmt.Errorf("invalid de %q: %w", v, client.ErrInvalidArgument)
+		}
+		filter.StartDate = &date
+	}
+
+	if v := q.Get("ate"); v != "" {
+		date, isDay, err := parseDate(v)
+		if err != nil {
+			return client.TransactionFilter{}, fmt.Errorf("invalid ate %q: %w", v, client.ErrInvalidArgument)
+		}
+		if isDay {
+			date = date.AddDate(0, 0, 1)
+		}
+		filter.EndDate = &date
+	}
+
+	if v := q.Get("valor_min"); v != "" {
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return client.TransactionFilter{}, fmt.Errorf("invalid valor_min %q: %w", v, client.ErrInvalidArgument)
+		}
+		filter.MinValue = &value
+	}
+
+	if v := q.Get("valor_max"); v != "" {
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return client.TransactionFilter{}, fmt.Errorf("invalid valor_max %q: %w", v, client.ErrInvalidArgument)
+		}
+		filter.MaxValue = &value
+	}
+
+	return filter, nil
+}
+
+// parseDate parses a RFC 3339 timestamp or a YYYY-MM-DD day.
+func parseDate(s string) (date time.Time, isDay bool, err error) {
+	if date, err := time.Parse(time.DateOnly, s); err == nil {
+		return date, true, nil
+	}
+
+	date, err = time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return date.UTC(), false, nil
+}
+
+// getBalanceDate returns the date of the balance from the em URL query
+// parameter, an RFC 3339 timestamp or a YYYY-MM-DD day. A day is the end of
+// the day and no date is now.
+func getBalanceDate(r *http.Request) (time.Time, error) {
+	v := r.URL.Query().Get("em")
+	if v == "" {
+		return time.Now().UTC().Round(time.Microsecond), nil
+	}
+
+	date, isDay, err := parseDate(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid em %q: %w", v, client.ErrInvalidArgument)
+	}
+	if isDay {
+		date = date.AddDate(0, 0, 1).Add(-time.Microsecond)
+	}
+
+	return date, nil
+}
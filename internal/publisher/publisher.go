@@ -0,0 +1,158 @@
+// Package publisher provides implementations of client.Publisher.
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+)
+
+// message is the representation of an outbox event published to files and
+// HTTP endpoints.
+type message struct {
+	ID       uuid.UUID       `json:"id"`
+	Type     string          `json:"tipo"`
+	ClientID int             `json:"cliente_id"`
+	Data     json.RawMessage `json:"dados"`
+	Date     time.Time       `json:"criado_em"`
+}
+
+func encode(e client.OutboxEvent) ([]byte, error) {
+	m := message{
+		ID:       e.ID,
+		Type:     e.Type,
+		ClientID: e.ClientID,
+		Data:     e.Payload,
+		Date:     e.Date,
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("encoding event[%s]: %w", e.ID, err)
+	}
+
+	return data, nil
+}
+
+// =============================================================================
+
+// Log publishes the events to a logger.
+type Log struct {
+	log *slog.Logger
+}
+
+// NewLog creates a publisher that logs the events.
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log}
+}
+
+func (p *Log) Publish(ctx context.Context, e client.OutboxEvent) error {
+	p.log.InfoContext(ctx, "outbox", "id", e.ID, "type", e.Type, "client", e.ClientID, "payload", string(e.Payload))
+	return nil
+}
+
+// =============================================================================
+
+// File publishes the events to a file, one JSON message per line.
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFile creates a publisher that appends the events to the file at path.
+// The file is created if it doesn't exist.
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+
+	return &File{f: f}, nil
+}
+
+// Publish writes the event and syncs the file, so a published event is not
+// lost if the process crashes.
+func (p *File) Publish(ctx context.Context, e client.OutboxEvent) error {
+	data, err := encode(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.f.Write(data); err != nil {
+		return fmt.Errorf("writing event[%s]: %w", e.ID, err)
+	}
+	if err := p.f.Sync(); err != nil {
+		return fmt.Errorf("syncing event[%s]: %w", e.ID, err)
+	}
+
+	return nil
+}
+
+// Close closes the file.
+func (p *File) Close() error {
+	return p.f.Close()
+}
+
+// =============================================================================
+
+// HTTP publishes the events to an HTTP endpoint.
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP creates a publisher that posts the events to the url. A nil
+// client is a client with a 10 seconds timeout.
+func NewHTTP(url string, client *http.Client) *HTTP {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &HTTP{
+		url:    url,
+		client: client,
+	}
+}
+
+// Publish posts the event as a JSON message with its ID in the X-Event-ID
+// header. Responses other than 2xx are errors.
+func (p *HTTP) Publish(ctx context.Context, e client.OutboxEvent) error {
+	data, err := encode(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", e.ID.String())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting event[%s]: %w", e.ID, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting event[%s]: status %s", e.ID, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rschio/rinha/internal/core/client"
+)
+
+func genEvent() client.OutboxEvent {
+	return client.OutboxEvent{
+		ID:       uuid.New(),
+		Type:     client.TransactionCreated,
+		ClientID: 1,
+		Payload:  []byte(`{"valor":100}`),
+		Date:     time.Now().UTC().Round(time.Microsecond),
+	}
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	p, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("creating publisher: %v", err)
+	}
+	defer p.Close()
+
+	es := []client.OutboxEvent{genEvent(), genEvent()}
+	for _, e := range es {
+		if err := p.Publish(ctx, e); err != nil {
+			t.Fatalf("publishing: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(es) {
+		t.Fatalf("got %d lines want %d", len(lines), len(es))
+	}
+	for i, l := range lines {
+		var m message
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("decoding line[%d]: %v", i, err)
+		}
+		if m.ID != es[i].ID || string(m.Data) != string(es[i].Payload) {
+			t.Fatalf("line[%d]: got %+v want event %+v", i, m, es[i])
+		}
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		status = http.StatusAccepted
+		got    message
+		id     string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = r.Header.Get("X-Event-ID")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p := NewHTTP(srv.URL, srv.Client())
+
+	e := genEvent()
+	if err := p.Publish(ctx, e); err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+	if id != e.ID.String() || got.ID != e.ID || got.Type != e.Type {
+		t.Fatalf("got event %s %+v want %+v", id, got, e)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := p.Publish(ctx, e); err == nil {
+		t.Fatal("want error on status 503")
+	}
+}
@@ -0,0 +1,65 @@
+// Package worker provides support for running background jobs.
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Job is a unit of background work.
+type Job func(ctx context.Context) error
+
+// Run calls the job every interval until the ctx is done. Errors returned by
+// the job are logged and the job is called again in the next interval.
+func Run(ctx context.Context, log *slog.Logger, name string, interval time.Duration, job Job) {
+	log.Info("worker", "status", "started", "name", name, "interval", interval)
+	defer log.Info("worker", "status", "stopped", "name", name)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := job(ctx); err != nil && ctx.Err() == nil {
+			log.Error("worker", "name", name, "ERROR", err)
+		}
+	}
+}
+
+// Elector elects the instance that runs a job when many instances share the
+// same database.
+type Elector interface {
+	IsLeader(ctx context.Context) (bool, error)
+}
+
+// Leader wraps the job so it only runs while the elector elects this
+// instance as the leader.
+func Leader(e Elector, job Job) Job {
+	return func(ctx context.Context) error {
+		ok, err := e.IsLeader(ctx)
+		if err != nil {
+			return fmt.Errorf("electing leader: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		return job(ctx)
+	}
+}
+
+// Always is an Elector that always elects the instance. It is used when the
+// instance doesn't share its store.
+type Always struct{}
+
+// IsLeader implements Elector.
+func (Always) IsLeader(ctx context.Context) (bool, error) {
+	return true, nil
+}